@@ -20,20 +20,28 @@ import (
 	"flag"
 	"net/http"
 	"os"
+	"strings"
 
 	"github.com/gorilla/mux"
 	"github.com/heptiolabs/healthcheck"
 	"github.com/kubeapps/common/datastore"
+	"github.com/kubeapps/kubeapps/pkg/auth"
+	"github.com/kubeapps/kubeapps/pkg/kube"
 	log "github.com/sirupsen/logrus"
 	"github.com/urfave/negroni"
 )
 
 const pathPrefix = "/v1"
 
+const clustersCAFilesPrefix = "/etc/additional-clusters-cafiles"
+
 // TODO(absoludity): Let's not use globals for storing state like this.
 var manager assetManager
 
-func setupRoutes() http.Handler {
+// setupRoutes returns the handler of the routes, whose charts and assets are
+// only served to users allowed by the authGate, if any. The logos are served to
+// anyone as they are embedded as links in the dashboard.
+func setupRoutes(authGate negroni.HandlerFunc) http.Handler {
 	r := mux.NewRouter()
 
 	// Healthcheck
@@ -43,32 +51,66 @@ func setupRoutes() http.Handler {
 
 	// Routes
 	apiv1 := r.PathPrefix(pathPrefix).Subrouter()
-	// TODO: mnelson: Seems we could use path per endpoint handling empty params? Check.
-	apiv1.Methods("GET").Path("/clusters/{cluster}/namespaces/{namespace}/charts").Handler(WithParams(listChartsWithFilters)) // accepts: name, version, appversion, repos, categories, q, page, size
-	apiv1.Methods("GET").Path("/clusters/{cluster}/namespaces/{namespace}/charts/categories").Handler(WithParams(getChartCategories))
-	apiv1.Methods("GET").Path("/clusters/{cluster}/namespaces/{namespace}/charts/{repo}").Handler(WithParams(listChartsWithFilters)) // accepts: name, version, appversion, repos, categories, q, page, size
-	apiv1.Methods("GET").Path("/clusters/{cluster}/namespaces/{namespace}/charts/{repo}/categories").Handler(WithParams(getChartCategories))
-	apiv1.Methods("GET").Path("/clusters/{cluster}/namespaces/{namespace}/charts/{repo}/{chartName}").Handler(WithParams(getChart))
-	apiv1.Methods("GET").Path("/clusters/{cluster}/namespaces/{namespace}/charts/{repo}/{chartName}/versions").Handler(WithParams(listChartVersions))
-	apiv1.Methods("GET").Path("/clusters/{cluster}/namespaces/{namespace}/charts/{repo}/{chartName}/versions/{version}").Handler(WithParams(getChartVersion))
-	apiv1.Methods("GET").Path("/clusters/{cluster}/namespaces/{namespace}/assets/{repo}/{chartName}/versions/{version}/README.md").Handler(WithParams(getChartVersionReadme))
-	apiv1.Methods("GET").Path("/clusters/{cluster}/namespaces/{namespace}/assets/{repo}/{chartName}/versions/{version}/values.yaml").Handler(WithParams(getChartVersionValues))
-	apiv1.Methods("GET").Path("/clusters/{cluster}/namespaces/{namespace}/assets/{repo}/{chartName}/versions/{version}/values.schema.json").Handler(WithParams(getChartVersionSchema))
+	// Logos don't require authentication, so they are matched before the authorized routes.
 	apiv1.Methods("GET").Path("/clusters/{cluster}/namespaces/{namespace}/assets/{repo}/{chartName}/logo").Handler(WithParams(getChartIcon))
-
 	// Leave icon on the non-cluster aware as it is used from a link in the db data :/
 	apiv1.Methods("GET").Path("/ns/{namespace}/assets/{repo}/{chartName}/logo").Handler(WithParams(getChartIcon))
 
+	namespaced := apiv1.PathPrefix("/clusters/{cluster}/namespaces/{namespace}").Subrouter()
+	if authGate != nil {
+		namespaced.Use(authMiddleware(authGate))
+	}
+	// TODO: mnelson: Seems we could use path per endpoint handling empty params? Check.
+	namespaced.Methods("GET").Path("/charts").Handler(WithParams(listChartsWithFilters)) // accepts: name, version, appversion, repos, categories, q, page, size
+	namespaced.Methods("GET").Path("/charts/categories").Handler(WithParams(getChartCategories))
+	namespaced.Methods("GET").Path("/charts/{repo}").Handler(WithParams(listChartsWithFilters)) // accepts: name, version, appversion, repos, categories, q, page, size
+	namespaced.Methods("GET").Path("/charts/{repo}/categories").Handler(WithParams(getChartCategories))
+	namespaced.Methods("GET").Path("/charts/{repo}/{chartName}").Handler(WithParams(getChart))
+	namespaced.Methods("GET").Path("/charts/{repo}/{chartName}/versions").Handler(WithParams(listChartVersions))
+	namespaced.Methods("GET").Path("/charts/{repo}/{chartName}/versions/{version}").Handler(WithParams(getChartVersion))
+	namespaced.Methods("GET").Path("/assets/{repo}/{chartName}/versions/{version}/README.md").Handler(WithParams(getChartVersionReadme))
+	namespaced.Methods("GET").Path("/assets/{repo}/{chartName}/versions/{version}/values.yaml").Handler(WithParams(getChartVersionValues))
+	namespaced.Methods("GET").Path("/assets/{repo}/{chartName}/versions/{version}/values.schema.json").Handler(WithParams(getChartVersionSchema))
+
 	n := negroni.Classic()
 	n.UseHandler(r)
 	return n
 }
 
+// authMiddleware runs the negroni authGate as a mux middleware, so that it is run
+// once the route is matched and its cluster and namespace are known.
+func authMiddleware(authGate negroni.HandlerFunc) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			authGate(w, req, next.ServeHTTP)
+		})
+	}
+}
+
+// stringArray is a flag which can be repeated.
+type stringArray []string
+
+func (s *stringArray) String() string {
+	return strings.Join(*s, ", ")
+}
+
+func (s *stringArray) Set(value string) error {
+	*s = append(*s, value)
+	return nil
+}
+
 func main() {
 	dbURL := flag.String("database-url", "localhost", "Database URL")
 	dbName := flag.String("database-name", "charts", "Database database")
 	dbUsername := flag.String("database-user", "", "Database user")
 	dbPassword := os.Getenv("DB_PASSWORD")
+	enableAuthGate := flag.Bool("enable-auth-gate", false, "Authorize the access to the charts of namespaces, rather than relying on a proxy to do so")
+	clustersConfigPath := flag.String("clusters-config-path", "", "Configuration for clusters, required to authorize the access to the charts of additional clusters")
+	pinnipedProxyURL := flag.String("pinniped-proxy-url", "http://kubeapps-internal-pinniped-proxy.kubeapps:3333", "internal url to be used for requests to clusters configured for credential proxying via pinniped")
+	var authGateActions stringArray
+	flag.Var(&authGateActions, "auth-gate-action", "Action, such as \"list apprepositories.kubeapps.com\", that users should be allowed in a namespace to view its charts. Can be repeated. Defaults to \"get secrets\" unless a ClusterRole is configured")
+	authGateRole := flag.String("auth-gate-cluster-role", "", "Name of a ClusterRole whose rules users should have in a namespace to view its charts")
+	authGateAuditLog := flag.Bool("auth-gate-audit-log", false, "Log every decision of the authorization of the access to the charts of namespaces")
 	flag.Parse()
 
 	dbConfig := datastore.Config{URL: *dbURL, Database: *dbName, Username: *dbUsername, Password: dbPassword}
@@ -86,7 +128,26 @@ func main() {
 	}
 	defer manager.Close()
 
-	n := setupRoutes()
+	var authGate negroni.HandlerFunc
+	if *enableAuthGate {
+		// If there is no clusters config, we default to the previous behaviour of a "default" cluster.
+		clustersConfig := kube.ClustersConfig{KubeappsClusterName: "default"}
+		if *clustersConfigPath != "" {
+			var cleanupCAFiles func()
+			clustersConfig, cleanupCAFiles, err = kube.ParseClusterConfig(*clustersConfigPath, clustersCAFilesPrefix, *pinnipedProxyURL)
+			if err != nil {
+				log.Fatalf("unable to parse additional clusters config: %+v", err)
+			}
+			defer cleanupCAFiles()
+		}
+		authGateConfig, err := auth.NewAuthGateConfig(authGateActions, *authGateRole, *authGateAuditLog)
+		if err != nil {
+			log.Fatalf("Unable to parse the auth gate configuration: %+v", err)
+		}
+		authGate = auth.AuthGate(clustersConfig, kubeappsNamespace, authGateConfig)
+	}
+
+	n := setupRoutes(authGate)
 
 	port := os.Getenv("PORT")
 	if port == "" {
@@ -24,6 +24,7 @@ import (
 	"testing"
 
 	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gorilla/mux"
 	"github.com/kubeapps/kubeapps/pkg/chart/models"
 	"github.com/stretchr/testify/assert"
 )
@@ -33,7 +34,7 @@ func Test_GetLive(t *testing.T) {
 	_, cleanup := setMockManager(t)
 	defer cleanup()
 
-	ts := httptest.NewServer(setupRoutes())
+	ts := httptest.NewServer(setupRoutes(nil))
 	defer ts.Close()
 
 	res, err := http.Get(ts.URL + "/live")
@@ -47,7 +48,7 @@ func Test_GetReady(t *testing.T) {
 	_, cleanup := setMockManager(t)
 	defer cleanup()
 
-	ts := httptest.NewServer(setupRoutes())
+	ts := httptest.NewServer(setupRoutes(nil))
 	defer ts.Close()
 
 	res, err := http.Get(ts.URL + "/ready")
@@ -58,7 +59,7 @@ func Test_GetReady(t *testing.T) {
 
 // tests the GET /{apiVersion}/clusters/default/namespaces/{namespace}/charts endpoint
 func Test_GetCharts(t *testing.T) {
-	ts := httptest.NewServer(setupRoutes())
+	ts := httptest.NewServer(setupRoutes(nil))
 	defer ts.Close()
 
 	tests := []struct {
@@ -113,7 +114,7 @@ func Test_GetCharts(t *testing.T) {
 // tests the GET /{apiVersion}/clusters/default/namespaces/{namespace}/charts/categories endpoint
 // particularly, it just tests that the endpoint is running the expected count query
 func Test_GetChartCategories(t *testing.T) {
-	ts := httptest.NewServer(setupRoutes())
+	ts := httptest.NewServer(setupRoutes(nil))
 	defer ts.Close()
 
 	tests := []struct {
@@ -168,7 +169,7 @@ func Test_GetChartCategories(t *testing.T) {
 // tests the GET /{apiVersion}/clusters/default/namespaces/{namespace}/charts/{repo}/categories endpoint
 // particularly, it just tests that the endpoint is running the expected count query
 func Test_GetChartCategoriesRepo(t *testing.T) {
-	ts := httptest.NewServer(setupRoutes())
+	ts := httptest.NewServer(setupRoutes(nil))
 	defer ts.Close()
 
 	tests := []struct {
@@ -229,7 +230,7 @@ func Test_GetChartCategoriesRepo(t *testing.T) {
 
 // tests the GET /{apiVersion}/clusters/default/namespaces/{namespace}/charts/{repo} endpoint
 func Test_GetChartsInRepo(t *testing.T) {
-	ts := httptest.NewServer(setupRoutes())
+	ts := httptest.NewServer(setupRoutes(nil))
 	defer ts.Close()
 
 	tests := []struct {
@@ -284,7 +285,7 @@ func Test_GetChartsInRepo(t *testing.T) {
 
 // tests the GET /{apiVersion}/clusters/default/namespaces/charts/{repo}/{chartName} endpoint
 func Test_GetChartInRepo(t *testing.T) {
-	ts := httptest.NewServer(setupRoutes())
+	ts := httptest.NewServer(setupRoutes(nil))
 	defer ts.Close()
 
 	tests := []struct {
@@ -342,7 +343,7 @@ func Test_GetChartInRepo(t *testing.T) {
 
 // tests the GET /{apiVersion}/clusters/default/namespaces/charts/{repo}/{chartName}/versions endpoint
 func Test_ListChartVersions(t *testing.T) {
-	ts := httptest.NewServer(setupRoutes())
+	ts := httptest.NewServer(setupRoutes(nil))
 	defer ts.Close()
 
 	tests := []struct {
@@ -400,7 +401,7 @@ func Test_ListChartVersions(t *testing.T) {
 
 // tests the GET /{apiVersion}/clusters/default/namespaces/charts/{repo}/{chartName}/versions/{:version} endpoint
 func Test_GetChartVersion(t *testing.T) {
-	ts := httptest.NewServer(setupRoutes())
+	ts := httptest.NewServer(setupRoutes(nil))
 	defer ts.Close()
 
 	tests := []struct {
@@ -460,7 +461,7 @@ func Test_GetChartVersion(t *testing.T) {
 // and the non-cluster /{apiVersion}/ns/{namespace}/assets/{repo}/{chartName}/logo-160x160-fit.png endpoint
 
 func Test_GetChartIcon(t *testing.T) {
-	ts := httptest.NewServer(setupRoutes())
+	ts := httptest.NewServer(setupRoutes(nil))
 	defer ts.Close()
 
 	tests := []struct {
@@ -519,7 +520,7 @@ func Test_GetChartIcon(t *testing.T) {
 
 // tests the GET /{apiVersion}/clusters/default/namespaces/assets/{repo}/{chartName}/versions/{version}/README.md endpoint
 func Test_GetChartReadme(t *testing.T) {
-	ts := httptest.NewServer(setupRoutes())
+	ts := httptest.NewServer(setupRoutes(nil))
 	defer ts.Close()
 
 	tests := []struct {
@@ -581,7 +582,7 @@ func Test_GetChartReadme(t *testing.T) {
 
 // tests the GET /{apiVersion}/clusters/default/namespaces/assets/{repo}/{chartName}/versions/{version}/values.yaml endpoint
 func Test_GetChartValues(t *testing.T) {
-	ts := httptest.NewServer(setupRoutes())
+	ts := httptest.NewServer(setupRoutes(nil))
 	defer ts.Close()
 
 	tests := []struct {
@@ -643,7 +644,7 @@ func Test_GetChartValues(t *testing.T) {
 
 // tests the GET /{apiVersion}/clusters/default/namespaces/assets/{repo}/{chartName}/versions/{version}/values/schema.json endpoint
 func Test_GetChartSchema(t *testing.T) {
-	ts := httptest.NewServer(setupRoutes())
+	ts := httptest.NewServer(setupRoutes(nil))
 	defer ts.Close()
 
 	tests := []struct {
@@ -702,3 +703,90 @@ func Test_GetChartSchema(t *testing.T) {
 		})
 	}
 }
+
+// tests that the charts are only served to the users allowed by the auth gate
+func Test_AuthGate(t *testing.T) {
+	var gatedVars map[string]string
+	authGate := func(w http.ResponseWriter, req *http.Request, next http.HandlerFunc) {
+		gatedVars = mux.Vars(req)
+		if req.Header.Get("Authorization") == "" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if gatedVars["namespace"] != "my-namespace" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		next(w, req)
+	}
+	ts := httptest.NewServer(setupRoutes(authGate))
+	defer ts.Close()
+
+	tests := []struct {
+		name          string
+		path          string
+		authorization string
+		wantCode      int
+		wantGatedVars map[string]string
+	}{
+		{
+			name:          "it rejects requests without token",
+			path:          "/clusters/default/namespaces/my-namespace/charts/my-repo/my-chart",
+			wantCode:      http.StatusUnauthorized,
+			wantGatedVars: map[string]string{"cluster": "default", "namespace": "my-namespace", "repo": "my-repo", "chartName": "my-chart"},
+		},
+		{
+			name:          "it rejects requests for other namespaces",
+			path:          "/clusters/other-cluster/namespaces/other-namespace/charts/my-repo/my-chart",
+			authorization: "Bearer token",
+			wantCode:      http.StatusForbidden,
+			wantGatedVars: map[string]string{"cluster": "other-cluster", "namespace": "other-namespace", "repo": "my-repo", "chartName": "my-chart"},
+		},
+		{
+			name:          "it serves the allowed requests",
+			path:          "/clusters/default/namespaces/my-namespace/charts/my-repo/my-chart",
+			authorization: "Bearer token",
+			wantCode:      http.StatusOK,
+			wantGatedVars: map[string]string{"cluster": "default", "namespace": "my-namespace", "repo": "my-repo", "chartName": "my-chart"},
+		},
+		{
+			name:     "it serves logos without authentication",
+			path:     "/clusters/default/namespaces/my-namespace/assets/my-repo/my-chart/logo",
+			wantCode: http.StatusOK,
+		},
+		{
+			name:     "it serves the logos of the non-cluster aware route without authentication",
+			path:     "/ns/my-namespace/assets/my-repo/my-chart/logo",
+			wantCode: http.StatusOK,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mock, cleanup := setMockManager(t)
+			defer cleanup()
+			gatedVars = nil
+
+			chartJSON, err := json.Marshal(models.Chart{ID: "my-repo/my-chart", RawIcon: iconBytes()})
+			if err != nil {
+				t.Fatalf("%+v", err)
+			}
+			mock.ExpectQuery("SELECT info FROM charts WHERE *").
+				WillReturnRows(sqlmock.NewRows([]string{"info"}).AddRow(chartJSON))
+
+			req, err := http.NewRequest("GET", ts.URL+pathPrefix+tt.path, nil)
+			if err != nil {
+				t.Fatalf("%+v", err)
+			}
+			if tt.authorization != "" {
+				req.Header.Set("Authorization", tt.authorization)
+			}
+			res, err := http.DefaultClient.Do(req)
+			assert.NoError(t, err)
+			defer res.Body.Close()
+
+			assert.Equal(t, tt.wantCode, res.StatusCode, "http status code should match")
+			assert.Equal(t, tt.wantGatedVars, gatedVars, "the gate should see the vars of the route")
+		})
+	}
+}
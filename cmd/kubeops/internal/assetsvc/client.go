@@ -0,0 +1,108 @@
+// Package assetsvc queries the internal assetsvc for the charts synced from the
+// app repositories.
+package assetsvc
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/kubeapps/kubeapps/pkg/chart/models"
+)
+
+// Chart is a chart available in an app repository together with its latest version.
+type Chart struct {
+	ID               string
+	Name             string
+	Repo             models.Repo
+	LatestVersion    string
+	LatestAppVersion string
+}
+
+// ChartQuery selects the charts of a namespace, which include the ones of the
+// global Kubeapps namespace.
+type ChartQuery struct {
+	Cluster   string
+	Namespace string
+	Name      string
+	// Version and AppVersion, when both set, select only the charts which include that version.
+	Version    string
+	AppVersion string
+}
+
+// Client is a client of the assetsvc.
+type Client struct {
+	url        string
+	httpClient *http.Client
+}
+
+// NewClient returns a client for the assetsvc available at the given URL.
+func NewClient(assetsvcURL string, timeout time.Duration) *Client {
+	return &Client{
+		url:        strings.TrimSuffix(assetsvcURL, "/"),
+		httpClient: &http.Client{Timeout: timeout},
+	}
+}
+
+// chartListResponse is used to unmarshal the response of the chart list endpoint.
+type chartListResponse struct {
+	Data []struct {
+		ID            string       `json:"id"`
+		Attributes    models.Chart `json:"attributes"`
+		Relationships struct {
+			LatestChartVersion struct {
+				Data models.ChartVersion `json:"data"`
+			} `json:"latestChartVersion"`
+		} `json:"relationships"`
+	} `json:"data"`
+}
+
+// FindCharts returns the charts matching the query. The token of the user is
+// forwarded, so that the assetsvc can authorize the access to the charts of the
+// namespace when its auth gate is enabled.
+func (c *Client) FindCharts(token string, query ChartQuery) ([]Chart, error) {
+	params := url.Values{}
+	params.Set("name", query.Name)
+	if query.Version != "" && query.AppVersion != "" {
+		params.Set("version", query.Version)
+		params.Set("appversion", query.AppVersion)
+	}
+	reqURL := fmt.Sprintf("%s/v1/clusters/%s/namespaces/%s/charts?%s", c.url, url.PathEscape(query.Cluster), url.PathEscape(query.Namespace), params.Encode())
+	req, err := http.NewRequest("GET", reqURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to query the assetsvc: %v", err)
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	res, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to query the assetsvc: %v", err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Unable to query the assetsvc: unexpected status code %d", res.StatusCode)
+	}
+
+	list := chartListResponse{}
+	if err := json.NewDecoder(res.Body).Decode(&list); err != nil {
+		return nil, fmt.Errorf("Unable to parse the assetsvc response: %v", err)
+	}
+	charts := make([]Chart, 0, len(list.Data))
+	for _, d := range list.Data {
+		chart := Chart{
+			ID:               d.ID,
+			Name:             d.Attributes.Name,
+			LatestVersion:    d.Relationships.LatestChartVersion.Data.Version,
+			LatestAppVersion: d.Relationships.LatestChartVersion.Data.AppVersion,
+		}
+		if d.Attributes.Repo != nil {
+			chart.Repo = *d.Attributes.Repo
+		}
+		charts = append(charts, chart)
+	}
+	return charts, nil
+}
@@ -0,0 +1,135 @@
+package assetsvc
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/kubeapps/kubeapps/pkg/chart/models"
+)
+
+func TestFindCharts(t *testing.T) {
+	testCases := []struct {
+		name          string
+		query         ChartQuery
+		response      string
+		statusCode    int
+		expectedQuery string
+		expected      []Chart
+		expectedErr   bool
+	}{
+		{
+			name:          "returns the charts with their latest version",
+			query:         ChartQuery{Cluster: "default", Namespace: "dev", Name: "apache", Version: "8.0.0", AppVersion: "2.4.46"},
+			response:      `{"data":[{"id":"bitnami/apache","attributes":{"name":"apache","repo":{"name":"bitnami","namespace":"kubeapps"}},"relationships":{"latestChartVersion":{"data":{"version":"8.2.0","app_version":"2.4.47"}}}}],"meta":{"totalPages":1}}`,
+			statusCode:    http.StatusOK,
+			expectedQuery: "appversion=2.4.46&name=apache&version=8.0.0",
+			expected: []Chart{
+				{
+					ID:               "bitnami/apache",
+					Name:             "apache",
+					Repo:             models.Repo{Name: "bitnami", Namespace: "kubeapps"},
+					LatestVersion:    "8.2.0",
+					LatestAppVersion: "2.4.47",
+				},
+			},
+		},
+		{
+			name:          "ignores the version without an app version",
+			query:         ChartQuery{Cluster: "default", Namespace: "dev", Name: "apache", Version: "8.0.0"},
+			response:      `{"data":[],"meta":{"totalPages":0}}`,
+			statusCode:    http.StatusOK,
+			expectedQuery: "name=apache",
+			expected:      []Chart{},
+		},
+		{
+			name:        "returns an error for an unexpected status code",
+			query:       ChartQuery{Cluster: "default", Namespace: "dev", Name: "apache"},
+			statusCode:  http.StatusInternalServerError,
+			expectedErr: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+				if got, want := req.URL.Path, "/v1/clusters/default/namespaces/dev/charts"; got != want {
+					t.Errorf("got: %q, want: %q", got, want)
+				}
+				if tc.expectedQuery != "" {
+					if got, want := req.URL.RawQuery, tc.expectedQuery; got != want {
+						t.Errorf("got: %q, want: %q", got, want)
+					}
+				}
+				w.WriteHeader(tc.statusCode)
+				w.Write([]byte(tc.response))
+			}))
+			defer server.Close()
+
+			charts, err := NewClient(server.URL+"/", time.Second).FindCharts("", tc.query)
+			if got, want := err != nil, tc.expectedErr; got != want {
+				t.Fatalf("got error: %v, want error: %t", err, want)
+			}
+			if got, want := charts, tc.expected; !cmp.Equal(want, got) {
+				t.Errorf("mismatch (-want +got):\n%s", cmp.Diff(want, got))
+			}
+		})
+	}
+}
+
+func TestFindChartsWithAuthGate(t *testing.T) {
+	testCases := []struct {
+		name         string
+		token        string
+		expectedErr  bool
+		expectedAuth string
+	}{
+		{
+			name:         "forwards the token of the user",
+			token:        "allowed-token",
+			expectedAuth: "Bearer allowed-token",
+		},
+		{
+			name:         "returns an error if the user cannot view the charts",
+			token:        "forbidden-token",
+			expectedErr:  true,
+			expectedAuth: "Bearer forbidden-token",
+		},
+		{
+			name:        "returns an error without a token",
+			expectedErr: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			// The server behaves like the assetsvc with --enable-auth-gate, which
+			// rejects the requests without a token and forbids the unauthorized users.
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+				authHeader := req.Header.Get("Authorization")
+				if got, want := authHeader, tc.expectedAuth; got != want {
+					t.Errorf("got: %q, want: %q", got, want)
+				}
+				switch authHeader {
+				case "":
+					w.WriteHeader(http.StatusUnauthorized)
+				case "Bearer allowed-token":
+					w.Write([]byte(`{"data":[{"id":"bitnami/apache","attributes":{"name":"apache","repo":{"name":"bitnami","namespace":"kubeapps"}},"relationships":{"latestChartVersion":{"data":{"version":"8.2.0","app_version":"2.4.47"}}}}]}`))
+				default:
+					w.WriteHeader(http.StatusForbidden)
+				}
+			}))
+			defer server.Close()
+
+			charts, err := NewClient(server.URL, time.Second).FindCharts(tc.token, ChartQuery{Cluster: "default", Namespace: "dev", Name: "apache"})
+			if got, want := err != nil, tc.expectedErr; got != want {
+				t.Fatalf("got error: %v, want error: %t", err, want)
+			}
+			if !tc.expectedErr && len(charts) != 1 {
+				t.Errorf("got: %d charts, want: 1", len(charts))
+			}
+		})
+	}
+}
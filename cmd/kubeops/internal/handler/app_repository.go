@@ -0,0 +1,236 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/kubeapps/common/response"
+	"github.com/kubeapps/kubeapps/cmd/kubeops/internal/assetsvc"
+	"github.com/kubeapps/kubeapps/pkg/agent"
+	chartUtils "github.com/kubeapps/kubeapps/pkg/chart"
+	"github.com/kubeapps/kubeapps/pkg/handlerutil"
+	"github.com/kubeapps/kubeapps/pkg/proxy"
+	log "github.com/sirupsen/logrus"
+	"helm.sh/helm/v3/pkg/release"
+	k8sErrors "k8s.io/apimachinery/pkg/api/errors"
+)
+
+// appRepositoryCandidate is an app repository containing the chart of a release.
+type appRepositoryCandidate struct {
+	Name          string `json:"name"`
+	Namespace     string `json:"namespace"`
+	ChartID       string `json:"chartID"`
+	LatestVersion string `json:"latestVersion"`
+}
+
+// releaseAppRepository is the app repository associated with a release and the
+// app repositories containing its chart.
+type releaseAppRepository struct {
+	AppRepositoryResourceName      string                   `json:"appRepositoryResourceName,omitempty"`
+	AppRepositoryResourceNamespace string                   `json:"appRepositoryResourceNamespace,omitempty"`
+	Candidates                     []appRepositoryCandidate `json:"candidates,omitempty"`
+}
+
+// parseUpgradeRequest parses the chart details of a request upgrading a release.
+// If the request does not include the app repository, the one associated with the
+// release is used, together with the chart of the release if none is requested.
+func parseUpgradeRequest(cfg Config, req *http.Request, releaseName string) (*chartUtils.Details, error) {
+	chartDetails, err := handlerutil.ParseRequestWithoutAppRepository(req)
+	if err != nil {
+		return nil, err
+	}
+	if chartDetails.AppRepositoryResourceName == "" && chartDetails.AppRepositoryResourceNamespace == "" {
+		rel, err := agent.GetRelease(cfg.ActionConfig, releaseName)
+		if err != nil {
+			return nil, err
+		}
+		appRepo, ok, err := agent.GetAppRepository(cfg.Clientset, rel)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			chartDetails.AppRepositoryResourceName = appRepo.Name
+			chartDetails.AppRepositoryResourceNamespace = appRepo.Namespace
+			if chartDetails.ChartName == "" {
+				chartDetails.ChartName = rel.Chart.Name()
+			}
+		}
+	}
+	if err := chartDetails.ValidateAppRepository(); err != nil {
+		return nil, err
+	}
+	return chartDetails, nil
+}
+
+// findAppRepositoryCandidates returns the app repositories containing the chart
+// version of a release.
+func findAppRepositoryCandidates(cfg Config, rel *release.Release) ([]appRepositoryCandidate, error) {
+	metadata := rel.Chart.Metadata
+	charts, err := cfg.Options.ChartFinder.FindCharts(cfg.Token, chartQueryForRelease(cfg, rel.Namespace, metadata.Name, metadata.Version, metadata.AppVersion))
+	if err != nil {
+		return nil, err
+	}
+	candidates := []appRepositoryCandidate{}
+	for _, c := range charts {
+		candidates = append(candidates, newAppRepositoryCandidate(c))
+	}
+	return candidates, nil
+}
+
+func newAppRepositoryCandidate(c assetsvc.Chart) appRepositoryCandidate {
+	return appRepositoryCandidate{
+		Name:          c.Repo.Name,
+		Namespace:     c.Repo.Namespace,
+		ChartID:       c.ID,
+		LatestVersion: c.LatestVersion,
+	}
+}
+
+// GetReleaseAppRepository returns the app repository associated with a release
+// and the app repositories containing its chart.
+func GetReleaseAppRepository(cfg Config, w http.ResponseWriter, req *http.Request, params handlerutil.Params) {
+	rel, err := agent.GetRelease(cfg.ActionConfig, params[nameParam])
+	if err != nil {
+		returnErrMessage(err, w)
+		return
+	}
+	appRepo, ok, err := agent.GetAppRepository(cfg.Clientset, rel)
+	if err != nil {
+		returnErrMessage(err, w)
+		return
+	}
+	res := releaseAppRepository{}
+	if ok {
+		res.AppRepositoryResourceName = appRepo.Name
+		res.AppRepositoryResourceNamespace = appRepo.Namespace
+	}
+	if cfg.Options.ChartFinder != nil && rel.Chart != nil && rel.Chart.Metadata != nil {
+		res.Candidates, err = findAppRepositoryCandidates(cfg, rel)
+		if err != nil {
+			returnErrMessage(err, w)
+			return
+		}
+	}
+	response.NewDataResponse(res).Write(w)
+}
+
+// SetReleaseAppRepository associates a release with an app repository containing
+// its chart version, so that the release can be upgraded from that repository.
+// The app repository is the one of the request body, if any, otherwise the only
+// app repository containing the chart version.
+func SetReleaseAppRepository(cfg Config, w http.ResponseWriter, req *http.Request, params handlerutil.Params) {
+	if cfg.Options.ChartFinder == nil {
+		response.NewErrorResponse(http.StatusNotImplemented, "Looking up the charts of the releases is not enabled").Write(w)
+		return
+	}
+	releaseName := params[nameParam]
+	requested := releaseAppRepository{}
+	body, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		returnErrMessage(err, w)
+		return
+	}
+	if len(body) > 0 {
+		if err := json.Unmarshal(body, &requested); err != nil {
+			response.NewErrorResponse(http.StatusBadRequest, fmt.Sprintf("Unable to parse request body: %v", err)).Write(w)
+			return
+		}
+	}
+
+	rel, err := agent.GetRelease(cfg.ActionConfig, releaseName)
+	if err != nil {
+		returnErrMessage(err, w)
+		return
+	}
+	if rel.Chart == nil || rel.Chart.Metadata == nil {
+		response.NewErrorResponse(http.StatusUnprocessableEntity, fmt.Sprintf("Release %q has no chart", releaseName)).Write(w)
+		return
+	}
+	chartVersion := fmt.Sprintf("%s %s", rel.Chart.Metadata.Name, rel.Chart.Metadata.Version)
+	candidates, err := findAppRepositoryCandidates(cfg, rel)
+	if err != nil {
+		returnErrMessage(err, w)
+		return
+	}
+
+	var chosen *appRepositoryCandidate
+	if requested.AppRepositoryResourceName != "" {
+		for i, c := range candidates {
+			if c.Name == requested.AppRepositoryResourceName && c.Namespace == requested.AppRepositoryResourceNamespace {
+				chosen = &candidates[i]
+				break
+			}
+		}
+		if chosen == nil {
+			response.NewErrorResponse(http.StatusUnprocessableEntity, fmt.Sprintf("The chart %s was not found in the app repository %s/%s", chartVersion, requested.AppRepositoryResourceNamespace, requested.AppRepositoryResourceName)).Write(w)
+			return
+		}
+	} else {
+		switch len(candidates) {
+		case 0:
+			response.NewErrorResponse(http.StatusNotFound, fmt.Sprintf("The chart %s was not found in any app repository", chartVersion)).Write(w)
+			return
+		case 1:
+			chosen = &candidates[0]
+		default:
+			names := []string{}
+			for _, c := range candidates {
+				names = append(names, c.Namespace+"/"+c.Name)
+			}
+			response.NewErrorResponse(http.StatusConflict, fmt.Sprintf("The chart %s was found in several app repositories, choose one of: %s", chartVersion, strings.Join(names, ", "))).Write(w)
+			return
+		}
+	}
+
+	// Check that the user can access the app repository before recording it.
+	// TODO: currently app repositories are only supported on the cluster on which Kubeapps is installed. #1982
+	_, _, _, err = chartUtils.GetAppRepoAndRelatedSecrets(chosen.Name, chosen.Namespace, cfg.KubeHandler, cfg.Token, cfg.Options.ClustersConfig.KubeappsClusterName, cfg.Options.KubeappsNamespace)
+	if err != nil {
+		returnErrMessage(fmt.Errorf("unable to get app repository %q: %v", chosen.Name, err), w)
+		return
+	}
+	if err := agent.SetAppRepository(cfg.Clientset, rel, agent.AppRepositoryRef{Name: chosen.Name, Namespace: chosen.Namespace}); err != nil {
+		returnErrMessage(err, w)
+		return
+	}
+	response.NewDataResponse(releaseAppRepository{
+		AppRepositoryResourceName:      chosen.Name,
+		AppRepositoryResourceNamespace: chosen.Namespace,
+	}).Write(w)
+}
+
+// addAppRepositories sets the app repository associated with each release of the
+// namespace, or of every namespace if it is empty. The releases are left unchanged
+// if the associations cannot be listed, which is expected for the users who are
+// not allowed to list ConfigMaps.
+func addAppRepositories(cfg Config, namespace string, apps []proxy.AppOverview) {
+	associations, err := agent.ListAppRepositories(cfg.Clientset, namespace)
+	if k8sErrors.IsForbidden(err) {
+		log.Debugf("Not allowed to list the app repositories of the releases: %v", err)
+		return
+	}
+	if err != nil {
+		log.Warningf("Unable to list the app repositories of the releases: %v", err)
+		return
+	}
+	for i := range apps {
+		association, ok := associations[apps[i].Namespace][apps[i].ReleaseName]
+		if ok && association.Matches(apps[i].Chart) {
+			apps[i].AppRepository = association.Name
+			apps[i].AppRepositoryNamespace = association.Namespace
+		}
+	}
+}
+
+// recordAppRepository associates a release with the app repository its chart was
+// installed or upgraded from. The release is already deployed, so that a failure
+// is only logged and the release can be associated again later.
+func recordAppRepository(cfg Config, rel *release.Release, repoName, repoNamespace string) {
+	err := agent.SetAppRepository(cfg.Clientset, rel, agent.AppRepositoryRef{Name: repoName, Namespace: repoNamespace})
+	if err != nil {
+		log.Warningf("Unable to record the app repository of release %q: %v", rel.Name, err)
+	}
+}
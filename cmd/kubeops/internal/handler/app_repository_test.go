@@ -0,0 +1,207 @@
+package handler
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/kubeapps/kubeapps/cmd/kubeops/internal/assetsvc"
+	"github.com/kubeapps/kubeapps/pkg/agent"
+	"github.com/kubeapps/kubeapps/pkg/chart/models"
+	kubefake "helm.sh/helm/v3/pkg/kube/fake"
+	"helm.sh/helm/v3/pkg/release"
+)
+
+func TestSetReleaseAppRepository(t *testing.T) {
+	const releaseName = "my-release"
+	bitnami := assetsvc.Chart{ID: "bitnami/apache", Name: "apache", Repo: models.Repo{Name: "bitnami", Namespace: "default"}, LatestVersion: "8.1.0"}
+	global := assetsvc.Chart{ID: "global/apache", Name: "apache", Repo: models.Repo{Name: "global", Namespace: "kubeapps"}, LatestVersion: "8.1.0"}
+
+	testCases := []struct {
+		name                  string
+		charts                []assetsvc.Chart
+		requestBody           string
+		expectedCode          int
+		expectedResponse      string
+		expectedAppRepository string
+	}{
+		{
+			name:                  "associates the release with the only app repository containing its chart",
+			charts:                []assetsvc.Chart{bitnami},
+			expectedCode:          http.StatusOK,
+			expectedResponse:      `{"data":{"appRepositoryResourceName":"bitnami","appRepositoryResourceNamespace":"default"}}`,
+			expectedAppRepository: "default/bitnami",
+		},
+		{
+			name:                  "associates the release with the requested app repository",
+			charts:                []assetsvc.Chart{global, bitnami},
+			requestBody:           `{"appRepositoryResourceName":"bitnami","appRepositoryResourceNamespace":"default"}`,
+			expectedCode:          http.StatusOK,
+			expectedResponse:      `{"data":{"appRepositoryResourceName":"bitnami","appRepositoryResourceNamespace":"default"}}`,
+			expectedAppRepository: "default/bitnami",
+		},
+		{
+			name:             "returns a 409 when several app repositories contain the chart",
+			charts:           []assetsvc.Chart{global, bitnami},
+			expectedCode:     http.StatusConflict,
+			expectedResponse: `{"code":409,"message":"The chart apache 1.0.0 was found in several app repositories, choose one of: kubeapps/global, default/bitnami"}`,
+		},
+		{
+			name:             "returns a 404 when no app repository contains the chart",
+			expectedCode:     http.StatusNotFound,
+			expectedResponse: `{"code":404,"message":"The chart apache 1.0.0 was not found in any app repository"}`,
+		},
+		{
+			name:             "returns a 422 when the requested app repository does not contain the chart",
+			charts:           []assetsvc.Chart{global},
+			requestBody:      `{"appRepositoryResourceName":"bitnami","appRepositoryResourceNamespace":"default"}`,
+			expectedCode:     http.StatusUnprocessableEntity,
+			expectedResponse: `{"code":422,"message":"The chart apache 1.0.0 was not found in the app repository default/bitnami"}`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			k := &kubefake.FailingKubeClient{PrintingKubeClient: kubefake.PrintingKubeClient{Out: ioutil.Discard}}
+			cfg := newConfigFixture(t, k)
+			cfg.Options.ChartFinder = &fakeChartFinder{charts: map[string][]assetsvc.Chart{"apache": tc.charts}}
+			createExistingReleases(t, cfg, []*release.Release{
+				createReleaseWithChartVersion("apache", "1.0.0", "2.4.46", releaseName, "default"),
+			})
+
+			req := httptest.NewRequest("PUT", "https://example.com/whatever", strings.NewReader(tc.requestBody))
+			response := httptest.NewRecorder()
+			SetReleaseAppRepository(*cfg, response, req, map[string]string{nameParam: releaseName, namespaceParam: "default"})
+
+			if got, want := response.Code, tc.expectedCode; got != want {
+				t.Errorf("got: %d, want: %d", got, want)
+			}
+			if got, want := response.Body.String(), tc.expectedResponse; got != want {
+				t.Errorf("got: %q, want: %q", got, want)
+			}
+
+			rel, err := agent.GetRelease(cfg.ActionConfig, releaseName)
+			if err != nil {
+				t.Fatalf("%+v", err)
+			}
+			appRepo, ok, err := agent.GetAppRepository(cfg.Clientset, rel)
+			if err != nil {
+				t.Fatalf("%+v", err)
+			}
+			appRepository := ""
+			if ok {
+				appRepository = appRepo.Namespace + "/" + appRepo.Name
+			}
+			if got, want := appRepository, tc.expectedAppRepository; got != want {
+				t.Errorf("got: %q, want: %q", got, want)
+			}
+		})
+	}
+}
+
+func TestUpgradeWithAssociatedAppRepository(t *testing.T) {
+	const releaseName = "my-release"
+	testCases := []struct {
+		name             string
+		associated       bool
+		staleAssociation bool
+		expectedCode     int
+		expectedRevision int
+	}{
+		{
+			name:             "upgrades a release from its associated app repository",
+			associated:       true,
+			expectedCode:     http.StatusOK,
+			expectedRevision: 2,
+		},
+		{
+			name:             "requires an app repository for a release without association",
+			expectedCode:     http.StatusInternalServerError,
+			expectedRevision: 1,
+		},
+		{
+			name:             "ignores the association of a former release of another chart",
+			staleAssociation: true,
+			expectedCode:     http.StatusInternalServerError,
+			expectedRevision: 1,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			k := &kubefake.FailingKubeClient{PrintingKubeClient: kubefake.PrintingKubeClient{Out: ioutil.Discard}}
+			cfg := newConfigFixture(t, k)
+			rel := createRelease("apache", releaseName, "default", 1, release.StatusDeployed)
+			if tc.associated {
+				associateAppRepository(t, cfg, rel, "bitnami", "default")
+			}
+			if tc.staleAssociation {
+				associateAppRepository(t, cfg, createRelease("wordpress", releaseName, "default", 1, release.StatusDeployed), "bitnami", "default")
+			}
+			createExistingReleases(t, cfg, []*release.Release{rel})
+
+			req := httptest.NewRequest("PUT", "https://example.com/whatever?action=upgrade", strings.NewReader(`{"version": "1.0.1"}`))
+			response := httptest.NewRecorder()
+			OperateRelease(*cfg, response, req, map[string]string{nameParam: releaseName, namespaceParam: "default"})
+
+			if got, want := response.Code, tc.expectedCode; got != want {
+				t.Fatalf("got: %d, want: %d, body: %s", got, want, response.Body)
+			}
+			latest, err := agent.GetRelease(cfg.ActionConfig, releaseName)
+			if err != nil {
+				t.Fatalf("%+v", err)
+			}
+			if got, want := latest.Version, tc.expectedRevision; got != want {
+				t.Errorf("got: %d, want: %d", got, want)
+			}
+			if !tc.associated {
+				return
+			}
+			if got, want := latest.Chart.Name(), "apache"; got != want {
+				t.Errorf("got: %q, want: %q", got, want)
+			}
+			if _, ok, err := agent.GetAppRepository(cfg.Clientset, latest); err != nil || !ok {
+				t.Errorf("expected the upgraded release to keep its app repository")
+			}
+			if _, ok := latest.Chart.Metadata.Annotations[agent.AppRepositoryNameAnnotation]; ok {
+				t.Errorf("expected the chart metadata of the upgraded release to be left unchanged")
+			}
+		})
+	}
+}
+
+func associateAppRepository(t *testing.T, cfg *Config, rel *release.Release, name, namespace string) {
+	t.Helper()
+	if err := agent.SetAppRepository(cfg.Clientset, rel, agent.AppRepositoryRef{Name: name, Namespace: namespace}); err != nil {
+		t.Fatalf("%+v", err)
+	}
+}
+
+func TestListReleasesWithAppRepository(t *testing.T) {
+	k := &kubefake.FailingKubeClient{PrintingKubeClient: kubefake.PrintingKubeClient{Out: ioutil.Discard}}
+	cfg := newConfigFixture(t, k)
+	associated := createRelease("apache", "associated", "default", 1, release.StatusDeployed)
+	createExistingReleases(t, cfg, []*release.Release{
+		associated,
+		createRelease("apache", "other", "default", 1, release.StatusDeployed),
+		createRelease("apache", "reinstalled", "default", 1, release.StatusDeployed),
+	})
+	associateAppRepository(t, cfg, associated, "bitnami", "default")
+	// The association of a former release of another chart is left behind.
+	associateAppRepository(t, cfg, createRelease("wordpress", "reinstalled", "default", 1, release.StatusDeployed), "bitnami", "default")
+
+	code, body := listReleases(t, cfg, "")
+	if got, want := code, http.StatusOK; got != want {
+		t.Fatalf("got: %d, want: %d", got, want)
+	}
+	appRepositories := map[string]string{}
+	for _, app := range body.Data {
+		appRepositories[app.ReleaseName] = app.AppRepositoryNamespace + "/" + app.AppRepository
+	}
+	if got, want := appRepositories, map[string]string{"associated": "default/bitnami", "other": "/", "reinstalled": "/"}; !cmp.Equal(want, got) {
+		t.Errorf("mismatch (-want +got):\n%s", cmp.Diff(want, got))
+	}
+}
@@ -0,0 +1,169 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/kubeapps/common/response"
+	"github.com/kubeapps/kubeapps/cmd/kubeops/internal/operations"
+	chartUtils "github.com/kubeapps/kubeapps/pkg/chart"
+	"github.com/kubeapps/kubeapps/pkg/handlerutil"
+	"github.com/kubeapps/kubeapps/pkg/oidc"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	asyncParam     = "async"
+	operationParam = "operationID"
+)
+
+// operationRecorder captures the response of a handler run in the background.
+type operationRecorder struct {
+	header http.Header
+	code   int
+	body   bytes.Buffer
+}
+
+func newOperationRecorder() *operationRecorder {
+	return &operationRecorder{header: http.Header{}}
+}
+
+func (r *operationRecorder) Header() http.Header {
+	return r.header
+}
+
+func (r *operationRecorder) Write(b []byte) (int, error) {
+	if r.code == 0 {
+		r.code = http.StatusOK
+	}
+	return r.body.Write(b)
+}
+
+func (r *operationRecorder) WriteHeader(code int) {
+	if r.code == 0 {
+		r.code = code
+	}
+}
+
+// result returns the data of a successful response or the message of an error response.
+func (r *operationRecorder) result() (json.RawMessage, error) {
+	if r.code >= http.StatusBadRequest {
+		errResponse := struct {
+			Message string `json:"message"`
+		}{}
+		if err := json.Unmarshal(r.body.Bytes(), &errResponse); err == nil && errResponse.Message != "" {
+			return nil, errors.New(errResponse.Message)
+		}
+		return nil, errors.New(r.body.String())
+	}
+	dataResponse := struct {
+		Data json.RawMessage `json:"data"`
+	}{}
+	if err := json.Unmarshal(r.body.Bytes(), &dataResponse); err != nil {
+		// Some operations, such as a deletion, do not return any data.
+		return nil, nil
+	}
+	return dataResponse.Data, nil
+}
+
+// runAsync submits the handler to the operation manager and responds straight away
+// with the pending operation. The handler receives a copy of the request which is
+// not cancelled when the client disconnects, but which keeps the identity of the user.
+func runAsync(cfg Config, w http.ResponseWriter, req *http.Request, params handlerutil.Params, action string, f dependentHandler) {
+	if cfg.Options.Operations == nil {
+		response.NewErrorResponse(http.StatusNotImplemented, "Asynchronous operations are not enabled").Write(w)
+		return
+	}
+	body, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		returnErrMessage(err, w)
+		return
+	}
+	asyncCtx := context.Background()
+	if identity, ok := oidc.FromContext(req.Context()); ok {
+		asyncCtx = oidc.NewContext(asyncCtx, identity)
+	}
+	asyncReq := req.Clone(asyncCtx)
+	asyncReq.Body = ioutil.NopCloser(bytes.NewReader(body))
+	query := asyncReq.URL.Query()
+	query.Del(asyncParam)
+	asyncReq.URL.RawQuery = query.Encode()
+
+	releaseName := params[nameParam]
+	if releaseName == "" {
+		// The name of a new release is part of the request body.
+		chartDetails := chartUtils.Details{}
+		if err := json.Unmarshal(body, &chartDetails); err == nil {
+			releaseName = chartDetails.ReleaseName
+		}
+	}
+	op := operations.Operation{
+		Action:      action,
+		Cluster:     cfg.Cluster,
+		Namespace:   params[namespaceParam],
+		ReleaseName: releaseName,
+	}
+	submitOperation(cfg, w, req, op, func(report func(string)) (json.RawMessage, error) {
+		report(fmt.Sprintf("Running the %s of release %q", action, releaseName))
+		recorder := newOperationRecorder()
+		f(cfg, recorder, asyncReq, params)
+		return recorder.result()
+	})
+}
+
+// operationOwner returns the owner of the operations of the user of the request,
+// identified by their verified username if known so that refreshing their token
+// does not hide their running operations.
+func operationOwner(cfg Config, req *http.Request) string {
+	identity, _ := oidc.FromContext(req.Context())
+	return operations.Owner(identity.Username, cfg.Token)
+}
+
+// submitOperation submits the work to the operation manager on behalf of the user
+// of the request and responds with the pending operation.
+func submitOperation(cfg Config, w http.ResponseWriter, req *http.Request, op operations.Operation, fn operations.Func) {
+	if identity, ok := oidc.FromContext(req.Context()); ok {
+		op.Username = identity.Username
+	}
+	op, err := cfg.Options.Operations.Submit(operationOwner(cfg, req), op, fn)
+	if err != nil {
+		if err == operations.ErrQueueFull || err == operations.ErrShutdown {
+			response.NewErrorResponse(http.StatusServiceUnavailable, err.Error()).Write(w)
+			return
+		}
+		returnErrMessage(err, w)
+		return
+	}
+
+	body, err := json.Marshal(map[string]interface{}{"data": op})
+	if err != nil {
+		returnErrMessage(err, w)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Location", fmt.Sprintf("/v1/operations/%s", op.ID))
+	w.WriteHeader(http.StatusAccepted)
+	if _, err := w.Write(body); err != nil {
+		log.Errorf("Unable to write the response of operation %s: %v", op.ID, err)
+	}
+}
+
+// GetOperation returns the status of an operation submitted by the same user.
+func GetOperation(cfg Config, w http.ResponseWriter, req *http.Request, params handlerutil.Params) {
+	id := params[operationParam]
+	if cfg.Options.Operations == nil {
+		response.NewErrorResponse(http.StatusNotFound, fmt.Sprintf("Operation %q not found", id)).Write(w)
+		return
+	}
+	op, ok := cfg.Options.Operations.Get(operationOwner(cfg, req), id)
+	if !ok {
+		response.NewErrorResponse(http.StatusNotFound, fmt.Sprintf("Operation %q not found", id)).Write(w)
+		return
+	}
+	response.NewDataResponse(op).Write(w)
+}
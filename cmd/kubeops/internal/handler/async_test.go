@@ -0,0 +1,168 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/kubeapps/kubeapps/cmd/kubeops/internal/operations"
+	"github.com/kubeapps/kubeapps/pkg/oidc"
+	kubefake "helm.sh/helm/v3/pkg/kube/fake"
+	"helm.sh/helm/v3/pkg/release"
+)
+
+func TestAsyncOperations(t *testing.T) {
+	const releaseName = "my-release"
+	testCases := []struct {
+		name             string
+		existingReleases []*release.Release
+		expectedStatus   operations.Status
+		expectedError    string
+	}{
+		{
+			name: "deletes a release in the background",
+			existingReleases: []*release.Release{
+				createRelease("apache", releaseName, "default", 1, release.StatusDeployed),
+			},
+			expectedStatus: operations.StatusSucceeded,
+		},
+		{
+			name:           "records the error of the operation",
+			expectedStatus: operations.StatusFailed,
+			expectedError:  "uninstall: Release not loaded: my-release: release: not found",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			k := &kubefake.FailingKubeClient{PrintingKubeClient: kubefake.PrintingKubeClient{Out: ioutil.Discard}}
+			cfg := newConfigFixture(t, k)
+			cfg.Token = "token"
+			cfg.Options.Operations = operations.NewManager(1, 1, time.Hour)
+			defer cfg.Options.Operations.Shutdown(context.Background())
+			createExistingReleases(t, cfg, tc.existingReleases)
+			params := map[string]string{nameParam: releaseName, namespaceParam: "default"}
+
+			ctx := oidc.NewContext(context.Background(), oidc.Identity{Username: "jane@example.com"})
+			req := httptest.NewRequest("DELETE", "https://example.com/whatever?async=true&purge=true", nil).WithContext(ctx)
+			response := httptest.NewRecorder()
+			DeleteRelease(*cfg, response, req, params)
+
+			if got, want := response.Code, http.StatusAccepted; got != want {
+				t.Fatalf("got: %d, want: %d", got, want)
+			}
+			accepted := struct {
+				Data operations.Operation `json:"data"`
+			}{}
+			if err := json.Unmarshal(response.Body.Bytes(), &accepted); err != nil {
+				t.Fatalf("%+v", err)
+			}
+			if got, want := response.Header().Get("Location"), "/v1/operations/"+accepted.Data.ID; got != want {
+				t.Errorf("got: %q, want: %q", got, want)
+			}
+
+			// The user keeps access to the operation after refreshing their token.
+			cfg.Token = "refreshed-token"
+			op := waitForOperation(t, cfg, ctx, accepted.Data.ID)
+
+			if got, want := op.Username, "jane@example.com"; got != want {
+				t.Errorf("got: %q, want: %q", got, want)
+			}
+			if got, want := op.Action, "delete"; got != want {
+				t.Errorf("got: %q, want: %q", got, want)
+			}
+			if got, want := op.Status, tc.expectedStatus; got != want {
+				t.Errorf("got: %q, want: %q", got, want)
+			}
+			if got, want := op.Error, tc.expectedError; got != want {
+				t.Errorf("got: %q, want: %q", got, want)
+			}
+		})
+	}
+}
+
+// waitForOperation polls an operation until it finishes, with the identity of the
+// context, if any.
+func waitForOperation(t *testing.T, cfg *Config, ctx context.Context, id string) operations.Operation {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		req := httptest.NewRequest("GET", "https://example.com/whatever", nil).WithContext(ctx)
+		response := httptest.NewRecorder()
+		GetOperation(*cfg, response, req, map[string]string{operationParam: id})
+		if got, want := response.Code, http.StatusOK; got != want {
+			t.Fatalf("got: %d, want: %d", got, want)
+		}
+		polled := struct {
+			Data operations.Operation `json:"data"`
+		}{}
+		if err := json.Unmarshal(response.Body.Bytes(), &polled); err != nil {
+			t.Fatalf("%+v", err)
+		}
+		if polled.Data.FinishedAt != nil {
+			return polled.Data
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for operation %q", id)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestGetOperationOfOtherUser(t *testing.T) {
+	testCases := []struct {
+		name         string
+		owner        string
+		identity     *oidc.Identity
+		expectedCode int
+	}{
+		{
+			name:         "hides the operations submitted with another token",
+			owner:        operations.Owner("", "other-token"),
+			expectedCode: http.StatusNotFound,
+		},
+		{
+			name:         "hides the operations submitted by another user with the same token",
+			owner:        operations.Owner("john@example.com", "token"),
+			identity:     &oidc.Identity{Username: "jane@example.com"},
+			expectedCode: http.StatusNotFound,
+		},
+		{
+			name:         "returns the operations submitted by the same user with another token",
+			owner:        operations.Owner("jane@example.com", "other-token"),
+			identity:     &oidc.Identity{Username: "jane@example.com"},
+			expectedCode: http.StatusOK,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			k := &kubefake.FailingKubeClient{PrintingKubeClient: kubefake.PrintingKubeClient{Out: ioutil.Discard}}
+			cfg := newConfigFixture(t, k)
+			cfg.Options.Operations = operations.NewManager(1, 1, time.Hour)
+			defer cfg.Options.Operations.Shutdown(context.Background())
+			op, err := cfg.Options.Operations.Submit(tc.owner, operations.Operation{}, func(report func(string)) (json.RawMessage, error) {
+				return nil, nil
+			})
+			if err != nil {
+				t.Fatalf("%+v", err)
+			}
+
+			cfg.Token = "token"
+			req := httptest.NewRequest("GET", "https://example.com/whatever", nil)
+			if tc.identity != nil {
+				req = req.WithContext(oidc.NewContext(req.Context(), *tc.identity))
+			}
+			response := httptest.NewRecorder()
+			GetOperation(*cfg, response, req, map[string]string{operationParam: op.ID})
+
+			if got, want := response.Code, tc.expectedCode; got != want {
+				t.Errorf("got: %d, want: %d", got, want)
+			}
+		})
+	}
+}
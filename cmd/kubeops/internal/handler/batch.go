@@ -0,0 +1,327 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"sync/atomic"
+
+	"github.com/kubeapps/common/response"
+	"github.com/kubeapps/kubeapps/cmd/kubeops/internal/operations"
+	"github.com/kubeapps/kubeapps/pkg/agent"
+	chartUtils "github.com/kubeapps/kubeapps/pkg/chart"
+	"github.com/kubeapps/kubeapps/pkg/handlerutil"
+	log "github.com/sirupsen/logrus"
+	helm3chart "helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/chartutil"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	defaultBatchParallelism = 5
+	maxBatchParallelism     = 20
+
+	batchSucceeded = "succeeded"
+	batchFailed    = "failed"
+	batchSkipped   = "skipped"
+)
+
+// batchTarget identifies a release on which a batch action is performed.
+type batchTarget struct {
+	Cluster     string `json:"cluster"`
+	Namespace   string `json:"namespace"`
+	ReleaseName string `json:"releaseName"`
+}
+
+// batchRequest is the body of a batch request.
+type batchRequest struct {
+	// Action is one of upgrade, rollback or delete.
+	Action  string        `json:"action"`
+	Targets []batchTarget `json:"targets"`
+	// Chart is the chart used to upgrade the targets. Its values are a patch
+	// merged on top of the values of each release.
+	Chart *chartUtils.Details `json:"chart,omitempty"`
+	// Revision is the revision used to roll back the targets, the previous one if not set.
+	Revision int `json:"revision,omitempty"`
+	// Purge removes the history of the deleted releases.
+	Purge bool `json:"purge,omitempty"`
+	// Parallelism is the maximum number of targets processed at the same time.
+	Parallelism int `json:"parallelism,omitempty"`
+	// FailureThreshold is the number of failed targets after which the remaining
+	// targets are skipped. Every target is processed if not set.
+	FailureThreshold int `json:"failureThreshold,omitempty"`
+}
+
+// batchResult is the result of the action on a single target.
+type batchResult struct {
+	batchTarget
+	Status   string `json:"status"`
+	Revision int    `json:"revision,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+type batchResponse struct {
+	Succeeded int           `json:"succeeded"`
+	Failed    int           `json:"failed"`
+	Skipped   int           `json:"skipped"`
+	Results   []batchResult `json:"results"`
+}
+
+// batchUpgrade contains what is shared by the upgrade of every target.
+type batchUpgrade struct {
+	chart           *helm3chart.Chart
+	registrySecrets []string
+	// registryNS is also the namespace of the app repository of the chart.
+	registryNS string
+	repoName   string
+	options    chartUtils.ReleaseOptions
+}
+
+func (r *batchRequest) validate() error {
+	switch r.Action {
+	case "upgrade":
+		if r.Chart == nil || r.Chart.AppRepositoryResourceName == "" || r.Chart.AppRepositoryResourceNamespace == "" {
+			return fmt.Errorf("An upgrade requires a chart with an appRepositoryResourceName and an appRepositoryResourceNamespace")
+		}
+		if err := r.Chart.ValidateForUpgrade(); err != nil {
+			return err
+		}
+	case "rollback", "delete":
+	default:
+		return fmt.Errorf("Invalid action %q, it must be one of upgrade, rollback or delete", r.Action)
+	}
+	if len(r.Targets) == 0 {
+		return fmt.Errorf("At least one target is required")
+	}
+	for _, t := range r.Targets {
+		if t.Namespace == "" || t.ReleaseName == "" {
+			return fmt.Errorf("Every target requires a namespace and a releaseName")
+		}
+	}
+	if r.Parallelism == 0 {
+		r.Parallelism = defaultBatchParallelism
+	}
+	if r.Parallelism < 1 || r.Parallelism > maxBatchParallelism {
+		return fmt.Errorf("Invalid parallelism %d, it must be between 1 and %d", r.Parallelism, maxBatchParallelism)
+	}
+	if r.FailureThreshold < 0 {
+		return fmt.Errorf("Invalid failureThreshold %d, it must be a positive number", r.FailureThreshold)
+	}
+	return nil
+}
+
+// BatchOperateReleases upgrades, rolls back or deletes several releases, possibly in
+// different namespaces and clusters. The request is validated straight away, then
+// the batch is run as an asynchronous operation whose result is the result for each
+// release.
+func BatchOperateReleases(cfg Config, w http.ResponseWriter, req *http.Request, params handlerutil.Params) {
+	if cfg.Options.Operations == nil {
+		response.NewErrorResponse(http.StatusNotImplemented, "Asynchronous operations are not enabled").Write(w)
+		return
+	}
+	body, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		returnErrMessage(err, w)
+		return
+	}
+	batch := &batchRequest{}
+	if err := json.Unmarshal(body, batch); err != nil {
+		response.NewErrorResponse(http.StatusBadRequest, fmt.Sprintf("Unable to parse request body: %v", err)).Write(w)
+		return
+	}
+	if err := batch.validate(); err != nil {
+		response.NewErrorResponse(http.StatusUnprocessableEntity, err.Error()).Write(w)
+		return
+	}
+
+	var upgrade *batchUpgrade
+	if batch.Action == "upgrade" {
+		// TODO: currently app repositories are only supported on the cluster on which Kubeapps is installed. #1982
+		appRepo, caCertSecret, authSecret, err := chartUtils.GetAppRepoAndRelatedSecrets(batch.Chart.AppRepositoryResourceName, batch.Chart.AppRepositoryResourceNamespace, cfg.KubeHandler, cfg.Token, cfg.Options.ClustersConfig.KubeappsClusterName, cfg.Options.KubeappsNamespace)
+		if err != nil {
+			returnErrMessage(fmt.Errorf("unable to get app repository %q: %v", batch.Chart.AppRepositoryResourceName, err), w)
+			return
+		}
+		ch, err := handlerutil.GetChart(
+			batch.Chart,
+			appRepo,
+			caCertSecret, authSecret,
+			cfg.Resolver.New(appRepo.Spec.Type, cfg.Options.UserAgent),
+		)
+		if err != nil {
+			returnErrMessage(err, w)
+			return
+		}
+		upgrade = &batchUpgrade{
+			chart:           ch,
+			registrySecrets: appRepo.Spec.DockerRegistrySecrets,
+			registryNS:      appRepo.Namespace,
+			repoName:        appRepo.Name,
+			options:         releaseOptions(cfg, batch.Chart),
+		}
+	}
+
+	op := operations.Operation{
+		Action:  "batch-" + batch.Action,
+		Cluster: cfg.Cluster,
+	}
+	submitOperation(cfg, w, req, op, func(report func(string)) (json.RawMessage, error) {
+		var processed int32
+		results := runBatch(batch, func(target batchTarget) (int, error) {
+			revision, err := operateTarget(cfg, batch, upgrade, target)
+			report(fmt.Sprintf("%d of %d releases processed", atomic.AddInt32(&processed, 1), len(batch.Targets)))
+			return revision, err
+		})
+		res := batchResponse{Results: results}
+		for _, r := range results {
+			switch r.Status {
+			case batchSucceeded:
+				res.Succeeded++
+			case batchFailed:
+				res.Failed++
+			default:
+				res.Skipped++
+			}
+		}
+		result, err := json.Marshal(res)
+		if err != nil {
+			return nil, err
+		}
+		if res.Failed > 0 {
+			return result, fmt.Errorf("The %s of %d of %d releases failed", batch.Action, res.Failed, len(batch.Targets))
+		}
+		return result, nil
+	})
+}
+
+// runBatch runs the operation for every target of the batch with the requested
+// parallelism, skipping the targets not started yet once the failure threshold is reached.
+// The results are returned in the same order as the targets.
+func runBatch(batch *batchRequest, operate func(target batchTarget) (int, error)) []batchResult {
+	results := make([]batchResult, len(batch.Targets))
+	var mu sync.Mutex
+	failures := 0
+	thresholdReached := func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return batch.FailureThreshold > 0 && failures >= batch.FailureThreshold
+	}
+
+	semaphore := make(chan struct{}, batch.Parallelism)
+	var wg sync.WaitGroup
+	for i, target := range batch.Targets {
+		semaphore <- struct{}{}
+		if thresholdReached() {
+			<-semaphore
+			results[i] = batchResult{batchTarget: target, Status: batchSkipped}
+			continue
+		}
+		wg.Add(1)
+		go func(i int, target batchTarget) {
+			defer wg.Done()
+			defer func() { <-semaphore }()
+			revision, err := operate(target)
+			if err != nil {
+				mu.Lock()
+				failures++
+				mu.Unlock()
+				results[i] = batchResult{batchTarget: target, Status: batchFailed, Error: err.Error()}
+				return
+			}
+			results[i] = batchResult{batchTarget: target, Status: batchSucceeded, Revision: revision}
+		}(i, target)
+	}
+	wg.Wait()
+	return results
+}
+
+// operateTarget performs the action of the batch on a single release and returns
+// its new revision, if any.
+func operateTarget(cfg Config, batch *batchRequest, upgrade *batchUpgrade, target batchTarget) (int, error) {
+	cluster := target.Cluster
+	if cluster == "" {
+		cluster = cfg.Options.ClustersConfig.KubeappsClusterName
+	}
+	targetCfg, err := cfg.ConfigForTarget(cluster, target.Namespace)
+	if err != nil {
+		return 0, fmt.Errorf("Unable to configure the access to cluster %q: %v", cluster, err)
+	}
+
+	switch batch.Action {
+	case "upgrade":
+		rel, err := agent.GetRelease(targetCfg.ActionConfig, target.ReleaseName)
+		if err != nil {
+			return 0, err
+		}
+		valuesYaml, err := patchValues(rel.Config, batch.Chart.Values)
+		if err != nil {
+			return 0, err
+		}
+		if err := validateTargetValues(upgrade.chart, valuesYaml); err != nil {
+			return 0, err
+		}
+		registrySecrets, err := chartUtils.RegistrySecretsPerDomain(upgrade.registrySecrets, targetCfg.Cluster, upgrade.registryNS, targetCfg.Token, targetCfg.KubeHandler)
+		if err != nil {
+			return 0, err
+		}
+		transformers, err := releaseTransformers(targetCfg, target.Namespace)
+		if err != nil {
+			return 0, err
+		}
+		rel, err = agent.UpgradeRelease(targetCfg.ActionConfig, target.ReleaseName, valuesYaml, upgrade.chart, registrySecrets, targetCfg.Options.PostRenderers.PodSpecPaths, upgrade.options, transformers...)
+		if err != nil {
+			return 0, err
+		}
+		recordAppRepository(targetCfg, rel, upgrade.repoName, upgrade.registryNS)
+		return rel.Version, nil
+	case "rollback":
+		rel, err := agent.RollbackRelease(targetCfg.ActionConfig, target.ReleaseName, batch.Revision)
+		if err != nil {
+			return 0, err
+		}
+		return rel.Version, nil
+	default:
+		if err := agent.DeleteRelease(targetCfg.ActionConfig, target.ReleaseName, !batch.Purge); err != nil {
+			return 0, err
+		}
+		if batch.Purge {
+			if err := agent.DeleteAppRepository(targetCfg.Clientset, target.Namespace, target.ReleaseName); err != nil {
+				log.Warningf("%v", err)
+			}
+		}
+		return 0, nil
+	}
+}
+
+// validateTargetValues validates the values of a release, merged with the values
+// of the batch, against the schema of the chart of the batch.
+func validateTargetValues(ch *helm3chart.Chart, valuesYaml string) error {
+	schemaErrors, err := agent.ValidateValues(ch, valuesYaml)
+	if err != nil {
+		return err
+	}
+	if len(schemaErrors) == 0 {
+		return nil
+	}
+	body, err := json.Marshal(schemaErrors)
+	if err != nil {
+		return err
+	}
+	return fmt.Errorf("The values do not match the schema of the chart: %s", body)
+}
+
+// patchValues merges a YAML patch on top of the values of a release.
+func patchValues(current map[string]interface{}, patchYaml string) (string, error) {
+	patch, err := chartutil.ReadValues([]byte(patchYaml))
+	if err != nil {
+		return "", fmt.Errorf("Unable to parse the values patch: %v", err)
+	}
+	values := chartutil.CoalesceTables(patch, current)
+	valuesYaml, err := yaml.Marshal(values)
+	if err != nil {
+		return "", err
+	}
+	return string(valuesYaml), nil
+}
@@ -0,0 +1,236 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/kubeapps/kubeapps/cmd/kubeops/internal/operations"
+	"github.com/kubeapps/kubeapps/pkg/agent"
+	chartUtils "github.com/kubeapps/kubeapps/pkg/chart"
+	"helm.sh/helm/v3/pkg/chart"
+	kubefake "helm.sh/helm/v3/pkg/kube/fake"
+	"helm.sh/helm/v3/pkg/release"
+)
+
+func TestBatchOperateReleases(t *testing.T) {
+	testCases := []struct {
+		name             string
+		existingReleases []*release.Release
+		requestBody      string
+		noOperations     bool
+		expectedCode     int
+		expectedError    string
+		expectedResponse *batchResponse
+	}{
+		{
+			name:         "returns a 501 when the asynchronous operations are not enabled",
+			requestBody:  `{"action":"delete","targets":[{"namespace":"default","releaseName":"foo"}]}`,
+			noOperations: true,
+			expectedCode: http.StatusNotImplemented,
+		},
+		{
+			name:         "returns a 422 for an unknown action",
+			requestBody:  `{"action":"install","targets":[{"namespace":"default","releaseName":"foo"}]}`,
+			expectedCode: http.StatusUnprocessableEntity,
+		},
+		{
+			name:         "returns a 422 without targets",
+			requestBody:  `{"action":"delete"}`,
+			expectedCode: http.StatusUnprocessableEntity,
+		},
+		{
+			name:         "returns a 422 for an upgrade without a chart",
+			requestBody:  `{"action":"upgrade","targets":[{"namespace":"default","releaseName":"foo"}]}`,
+			expectedCode: http.StatusUnprocessableEntity,
+		},
+		{
+			name:         "returns a 422 for a parallelism out of bounds",
+			requestBody:  `{"action":"delete","targets":[{"namespace":"default","releaseName":"foo"}],"parallelism":50}`,
+			expectedCode: http.StatusUnprocessableEntity,
+		},
+		{
+			name: "deletes releases and reports the failed ones",
+			existingReleases: []*release.Release{
+				createRelease("apache", "foo", "default", 1, release.StatusDeployed),
+				createRelease("apache", "bar", "default", 1, release.StatusDeployed),
+			},
+			requestBody:   `{"action":"delete","purge":true,"targets":[{"namespace":"default","releaseName":"foo"},{"namespace":"default","releaseName":"missing"},{"namespace":"default","releaseName":"bar"}]}`,
+			expectedCode:  http.StatusAccepted,
+			expectedError: "The delete of 1 of 3 releases failed",
+			expectedResponse: &batchResponse{
+				Succeeded: 2,
+				Failed:    1,
+				Results: []batchResult{
+					{batchTarget: batchTarget{Namespace: "default", ReleaseName: "foo"}, Status: batchSucceeded},
+					{batchTarget: batchTarget{Namespace: "default", ReleaseName: "missing"}, Status: batchFailed, Error: "uninstall: Release not loaded: missing: release: not found"},
+					{batchTarget: batchTarget{Namespace: "default", ReleaseName: "bar"}, Status: batchSucceeded},
+				},
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			k := &kubefake.FailingKubeClient{PrintingKubeClient: kubefake.PrintingKubeClient{Out: ioutil.Discard}}
+			cfg := newConfigFixture(t, k)
+			cfg.Options.ClustersConfig.KubeappsClusterName = "default"
+			cfg.ConfigForTarget = func(cluster, namespace string) (Config, error) {
+				return *cfg, nil
+			}
+			if !tc.noOperations {
+				cfg.Options.Operations = operations.NewManager(1, 1, time.Hour)
+				defer cfg.Options.Operations.Shutdown(context.Background())
+			}
+			createExistingReleases(t, cfg, tc.existingReleases)
+
+			req := httptest.NewRequest("POST", "https://example.com/whatever", strings.NewReader(tc.requestBody))
+			response := httptest.NewRecorder()
+			BatchOperateReleases(*cfg, response, req, map[string]string{})
+
+			if got, want := response.Code, tc.expectedCode; got != want {
+				t.Fatalf("got: %d, want: %d, body: %s", got, want, response.Body)
+			}
+			if tc.expectedResponse == nil {
+				return
+			}
+			accepted := struct {
+				Data operations.Operation `json:"data"`
+			}{}
+			if err := json.Unmarshal(response.Body.Bytes(), &accepted); err != nil {
+				t.Fatalf("%+v", err)
+			}
+			op := waitForOperation(t, cfg, context.Background(), accepted.Data.ID)
+			if got, want := op.Action, "batch-delete"; got != want {
+				t.Errorf("got: %q, want: %q", got, want)
+			}
+			if got, want := op.Error, tc.expectedError; got != want {
+				t.Errorf("got: %q, want: %q", got, want)
+			}
+			body := batchResponse{}
+			if err := json.Unmarshal(op.Result, &body); err != nil {
+				t.Fatalf("%+v", err)
+			}
+			if got, want := body, *tc.expectedResponse; !cmp.Equal(want, got) {
+				t.Errorf("mismatch (-want +got):\n%s", cmp.Diff(want, got))
+			}
+		})
+	}
+}
+
+func TestRunBatchFailureThreshold(t *testing.T) {
+	batch := &batchRequest{
+		Action:           "delete",
+		Parallelism:      1,
+		FailureThreshold: 1,
+		Targets: []batchTarget{
+			{Namespace: "default", ReleaseName: "foo"},
+			{Namespace: "default", ReleaseName: "bar"},
+			{Namespace: "default", ReleaseName: "baz"},
+		},
+	}
+
+	results := runBatch(batch, func(target batchTarget) (int, error) {
+		if target.ReleaseName == "foo" {
+			return 0, errors.New("boom")
+		}
+		return 1, nil
+	})
+
+	want := []batchResult{
+		{batchTarget: batch.Targets[0], Status: batchFailed, Error: "boom"},
+		{batchTarget: batch.Targets[1], Status: batchSkipped},
+		{batchTarget: batch.Targets[2], Status: batchSkipped},
+	}
+	if got := results; !cmp.Equal(want, got) {
+		t.Errorf("mismatch (-want +got):\n%s", cmp.Diff(want, got))
+	}
+}
+
+func TestOperateTargetValidatesValues(t *testing.T) {
+	const releaseName = "my-release"
+	testCases := []struct {
+		name             string
+		currentValues    map[string]interface{}
+		patch            string
+		expectedError    string
+		expectedRevision int
+	}{
+		{
+			name:             "upgrades a release whose merged values match the schema",
+			currentValues:    map[string]interface{}{"replicaCount": 1},
+			patch:            "image: apache",
+			expectedRevision: 2,
+		},
+		{
+			name:             "fails a release whose merged values do not match the schema",
+			currentValues:    map[string]interface{}{"replicaCount": "two"},
+			patch:            "image: apache",
+			expectedError:    `The values do not match the schema of the chart: [{"path":"replicaCount","expectedType":"integer","message":"Invalid type. Expected: integer, given: string"}]`,
+			expectedRevision: 1,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			k := &kubefake.FailingKubeClient{PrintingKubeClient: kubefake.PrintingKubeClient{Out: ioutil.Discard}}
+			cfg := newConfigFixture(t, k)
+			cfg.ConfigForTarget = func(cluster, namespace string) (Config, error) {
+				return *cfg, nil
+			}
+			rel := createRelease("apache", releaseName, "default", 1, release.StatusDeployed)
+			rel.Config = tc.currentValues
+			createExistingReleases(t, cfg, []*release.Release{rel})
+			batch := &batchRequest{Action: "upgrade", Chart: &chartUtils.Details{Values: tc.patch}}
+			upgrade := &batchUpgrade{
+				chart: &chart.Chart{
+					Metadata: &chart.Metadata{Name: "apache"},
+					Schema:   []byte(`{"type":"object","properties":{"replicaCount":{"type":"integer"}}}`),
+				},
+			}
+
+			_, err := operateTarget(*cfg, batch, upgrade, batchTarget{Namespace: "default", ReleaseName: releaseName})
+			if tc.expectedError == "" && err != nil {
+				t.Fatalf("%+v", err)
+			}
+			if tc.expectedError != "" {
+				if err == nil {
+					t.Fatalf("expected an error")
+				}
+				if got, want := err.Error(), tc.expectedError; got != want {
+					t.Errorf("got: %q, want: %q", got, want)
+				}
+			}
+			latest, err := agent.GetRelease(cfg.ActionConfig, releaseName)
+			if err != nil {
+				t.Fatalf("%+v", err)
+			}
+			if got, want := latest.Version, tc.expectedRevision; got != want {
+				t.Errorf("got: %d, want: %d", got, want)
+			}
+		})
+	}
+}
+
+func TestPatchValues(t *testing.T) {
+	current := map[string]interface{}{
+		"replicaCount": float64(1),
+		"image":        map[string]interface{}{"repository": "bitnami/apache", "tag": "2.4.46"},
+	}
+
+	got, err := patchValues(current, "image:\n  tag: 2.4.47\n")
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	want := "image:\n  repository: bitnami/apache\n  tag: 2.4.47\nreplicaCount: 1\n"
+	if got != want {
+		t.Errorf("got: %q, want: %q", got, want)
+	}
+}
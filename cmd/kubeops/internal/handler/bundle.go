@@ -0,0 +1,215 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/kubeapps/common/response"
+	"github.com/kubeapps/kubeapps/pkg/agent"
+	chartUtils "github.com/kubeapps/kubeapps/pkg/chart"
+	"github.com/kubeapps/kubeapps/pkg/handlerutil"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	releaseBundleAPIVersion = "kubeapps.com/v1alpha1"
+	releaseBundleKind       = "ReleaseBundle"
+	formatParam             = "format"
+)
+
+// releaseBundle is a portable description of a release, used to install the same
+// application in another cluster or namespace.
+type releaseBundle struct {
+	APIVersion  string             `json:"apiVersion"`
+	Kind        string             `json:"kind"`
+	ReleaseName string             `json:"releaseName"`
+	Chart       releaseBundleChart `json:"chart"`
+	// Values are the values supplied by the user, in YAML.
+	Values string `json:"values,omitempty"`
+	// RegistrySecrets are the names of the docker registry secrets of the app
+	// repository used to pull the images of the release.
+	RegistrySecrets []string `json:"registrySecrets,omitempty"`
+}
+
+// releaseBundleChart identifies the chart of a release bundle.
+type releaseBundleChart struct {
+	AppRepositoryResourceName      string `json:"appRepositoryResourceName"`
+	AppRepositoryResourceNamespace string `json:"appRepositoryResourceNamespace"`
+	RepoURL                        string `json:"repoURL,omitempty"`
+	Name                           string `json:"name"`
+	Version                        string `json:"version"`
+}
+
+// releaseBundleReport lists what a bundle requires and is missing in the target.
+type releaseBundleReport struct {
+	MissingAppRepositories []string `json:"missingAppRepositories,omitempty"`
+	MissingSecrets         []string `json:"missingSecrets,omitempty"`
+}
+
+func (b *releaseBundle) validate() error {
+	if b.APIVersion != releaseBundleAPIVersion || b.Kind != releaseBundleKind {
+		return fmt.Errorf("Unsupported bundle %s %s, expected a %s %s", b.APIVersion, b.Kind, releaseBundleAPIVersion, releaseBundleKind)
+	}
+	if b.Chart.AppRepositoryResourceName == "" || b.Chart.AppRepositoryResourceNamespace == "" || b.Chart.Name == "" || b.Chart.Version == "" {
+		return fmt.Errorf("The chart of the bundle requires an appRepositoryResourceName, an appRepositoryResourceNamespace, a name and a version")
+	}
+	return nil
+}
+
+// ExportRelease returns the bundle of a release: the reference of its chart, its
+// user-supplied values and the registry secrets of its app repository.
+// The bundle is returned as a YAML document if the format query param is "yaml".
+func ExportRelease(cfg Config, w http.ResponseWriter, req *http.Request, params handlerutil.Params) {
+	releaseName := params[nameParam]
+	rel, err := agent.GetRelease(cfg.ActionConfig, releaseName)
+	if err != nil {
+		returnErrMessage(err, w)
+		return
+	}
+	ref, ok, err := agent.GetAppRepository(cfg.Clientset, rel)
+	if err != nil {
+		returnErrMessage(err, w)
+		return
+	}
+	if !ok {
+		response.NewErrorResponse(http.StatusUnprocessableEntity, fmt.Sprintf("Release %q is not associated with an app repository, associate it before exporting it", releaseName)).Write(w)
+		return
+	}
+	// TODO: currently app repositories are only supported on the cluster on which Kubeapps is installed. #1982
+	appRepo, _, _, err := chartUtils.GetAppRepoAndRelatedSecrets(ref.Name, ref.Namespace, cfg.KubeHandler, cfg.Token, cfg.Options.ClustersConfig.KubeappsClusterName, cfg.Options.KubeappsNamespace)
+	if err != nil {
+		returnErrMessage(fmt.Errorf("unable to get app repository %q: %v", ref.Name, err), w)
+		return
+	}
+	values := ""
+	if len(rel.Config) > 0 {
+		valuesYaml, err := yaml.Marshal(rel.Config)
+		if err != nil {
+			returnErrMessage(err, w)
+			return
+		}
+		values = string(valuesYaml)
+	}
+	bundle := releaseBundle{
+		APIVersion:  releaseBundleAPIVersion,
+		Kind:        releaseBundleKind,
+		ReleaseName: rel.Name,
+		Chart: releaseBundleChart{
+			AppRepositoryResourceName:      appRepo.Name,
+			AppRepositoryResourceNamespace: appRepo.Namespace,
+			RepoURL:                        appRepo.Spec.URL,
+			Name:                           rel.Chart.Metadata.Name,
+			Version:                        rel.Chart.Metadata.Version,
+		},
+		Values:          values,
+		RegistrySecrets: appRepo.Spec.DockerRegistrySecrets,
+	}
+
+	if req.URL.Query().Get(formatParam) != "yaml" {
+		response.NewDataResponse(bundle).Write(w)
+		return
+	}
+	bundleYaml, err := yaml.Marshal(bundle)
+	if err != nil {
+		returnErrMessage(err, w)
+		return
+	}
+	w.Header().Set("Content-Type", "application/x-yaml")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", rel.Name+".yaml"))
+	w.Write(bundleYaml)
+}
+
+// ImportRelease installs a release bundle, in JSON or YAML, in the cluster and
+// namespace of the request. The release is named as in the bundle unless the
+// releaseName query param is set. If the app repository of the bundle or its
+// registry secrets are missing, nothing is installed and a 422 response listing
+// them is returned.
+func ImportRelease(cfg Config, w http.ResponseWriter, req *http.Request, params handlerutil.Params) {
+	body, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		returnErrMessage(err, w)
+		return
+	}
+	bundle := &releaseBundle{}
+	if err := yaml.Unmarshal(body, bundle); err != nil {
+		response.NewErrorResponse(http.StatusBadRequest, fmt.Sprintf("Unable to parse request body: %v", err)).Write(w)
+		return
+	}
+	if err := bundle.validate(); err != nil {
+		response.NewErrorResponse(http.StatusUnprocessableEntity, err.Error()).Write(w)
+		return
+	}
+
+	report, err := checkReleaseBundle(cfg, bundle)
+	if err != nil {
+		returnErrMessage(err, w)
+		return
+	}
+	if len(report.MissingAppRepositories) > 0 || len(report.MissingSecrets) > 0 {
+		w.Header().Set("Content-Type", "application/json")
+		body, err := json.Marshal(report)
+		if err != nil {
+			returnErrMessage(err, w)
+			return
+		}
+		response.NewErrorResponse(http.StatusUnprocessableEntity, string(body)).Write(w)
+		return
+	}
+
+	releaseName := bundle.ReleaseName
+	if name := req.URL.Query().Get(nameParam); name != "" {
+		releaseName = name
+	}
+	installRelease(cfg, w, req, params[namespaceParam], &chartUtils.Details{
+		AppRepositoryResourceName:      bundle.Chart.AppRepositoryResourceName,
+		AppRepositoryResourceNamespace: bundle.Chart.AppRepositoryResourceNamespace,
+		ChartName:                      bundle.Chart.Name,
+		ReleaseName:                    releaseName,
+		Version:                        bundle.Chart.Version,
+		Values:                         bundle.Values,
+	})
+}
+
+// checkReleaseBundle reports whether the app repository of the bundle exists and
+// whether its registry secrets are both configured in the app repository and
+// present in the target cluster.
+func checkReleaseBundle(cfg Config, bundle *releaseBundle) (*releaseBundleReport, error) {
+	report := &releaseBundleReport{}
+	repoName, repoNamespace := bundle.Chart.AppRepositoryResourceName, bundle.Chart.AppRepositoryResourceNamespace
+	// TODO: currently app repositories are only supported on the cluster on which Kubeapps is installed. #1982
+	appRepo, _, _, err := chartUtils.GetAppRepoAndRelatedSecrets(repoName, repoNamespace, cfg.KubeHandler, cfg.Token, cfg.Options.ClustersConfig.KubeappsClusterName, cfg.Options.KubeappsNamespace)
+	if err != nil {
+		if handlerutil.ErrorCode(err) != http.StatusNotFound {
+			return nil, err
+		}
+		report.MissingAppRepositories = append(report.MissingAppRepositories, repoNamespace+"/"+repoName)
+		return report, nil
+	}
+	if len(bundle.RegistrySecrets) == 0 {
+		return report, nil
+	}
+
+	configured := map[string]bool{}
+	for _, s := range appRepo.Spec.DockerRegistrySecrets {
+		configured[s] = true
+	}
+	client, err := cfg.KubeHandler.AsUser(cfg.Token, cfg.Cluster)
+	if err != nil {
+		return nil, err
+	}
+	for _, secretName := range bundle.RegistrySecrets {
+		if configured[secretName] {
+			_, err := client.GetSecret(secretName, appRepo.Namespace)
+			if err == nil {
+				continue
+			}
+			if handlerutil.ErrorCode(err) != http.StatusNotFound {
+				return nil, err
+			}
+		}
+		report.MissingSecrets = append(report.MissingSecrets, appRepo.Namespace+"/"+secretName)
+	}
+	return report, nil
+}
@@ -0,0 +1,200 @@
+package handler
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/kubeapps/kubeapps/pkg/agent"
+	kubeappsKube "github.com/kubeapps/kubeapps/pkg/kube"
+	kubefake "helm.sh/helm/v3/pkg/kube/fake"
+	"helm.sh/helm/v3/pkg/release"
+	corev1 "k8s.io/api/core/v1"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestExportRelease(t *testing.T) {
+	const releaseName = "my-release"
+	testCases := []struct {
+		name         string
+		associated   bool
+		query        string
+		statusCode   int
+		responseBody string
+	}{
+		{
+			name:         "exports the bundle of a release",
+			associated:   true,
+			statusCode:   http.StatusOK,
+			responseBody: `{"data":{"apiVersion":"kubeapps.com/v1alpha1","kind":"ReleaseBundle","releaseName":"my-release","chart":{"appRepositoryResourceName":"bitnami","appRepositoryResourceNamespace":"default","repoURL":"http://foo.bar","name":"apache","version":"1.0.0"},"values":"replicaCount: 2\n","registrySecrets":["registry-creds"]}}`,
+		},
+		{
+			name:       "exports the bundle of a release as YAML",
+			associated: true,
+			query:      "?format=yaml",
+			statusCode: http.StatusOK,
+			responseBody: `apiVersion: kubeapps.com/v1alpha1
+chart:
+  appRepositoryResourceName: bitnami
+  appRepositoryResourceNamespace: default
+  name: apache
+  repoURL: http://foo.bar
+  version: 1.0.0
+kind: ReleaseBundle
+registrySecrets:
+- registry-creds
+releaseName: my-release
+values: |
+  replicaCount: 2
+`,
+		},
+		{
+			name:         "errors if the release is not associated with an app repository",
+			statusCode:   http.StatusUnprocessableEntity,
+			responseBody: `{"code":422,"message":"Release \"my-release\" is not associated with an app repository, associate it before exporting it"}`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			k := &kubefake.FailingKubeClient{PrintingKubeClient: kubefake.PrintingKubeClient{Out: ioutil.Discard}}
+			cfg := newConfigFixture(t, k)
+			cfg.KubeHandler.(*kubeappsKube.FakeHandler).AppRepos[0].Spec.DockerRegistrySecrets = []string{"registry-creds"}
+			rel := createReleaseWithChartVersion("apache", "1.0.0", "2.4.46", releaseName, "default")
+			rel.Config = map[string]interface{}{"replicaCount": 2}
+			if tc.associated {
+				associateAppRepository(t, cfg, rel, "bitnami", "default")
+			}
+			createExistingReleases(t, cfg, []*release.Release{rel})
+
+			req := httptest.NewRequest("GET", "https://example.com/whatever"+tc.query, nil)
+			response := httptest.NewRecorder()
+			ExportRelease(*cfg, response, req, map[string]string{nameParam: releaseName, namespaceParam: "default"})
+
+			if got, want := response.Code, tc.statusCode; got != want {
+				t.Errorf("got: %d, want: %d", got, want)
+			}
+			if got, want := response.Body.String(), tc.responseBody; got != want {
+				t.Errorf("mismatch (-want +got):\n%s", cmp.Diff(want, got))
+			}
+		})
+	}
+}
+
+func TestImportRelease(t *testing.T) {
+	const bundle = `
+apiVersion: kubeapps.com/v1alpha1
+kind: ReleaseBundle
+releaseName: my-release
+chart:
+  appRepositoryResourceName: bitnami
+  appRepositoryResourceNamespace: default
+  name: apache
+  version: 1.0.0
+values: |
+  replicaCount: 2
+`
+	testCases := []struct {
+		name                string
+		requestBody         string
+		query               string
+		registrySecrets     []string
+		existingSecrets     []*corev1.Secret
+		statusCode          int
+		responseBody        string
+		expectedReleaseName string
+	}{
+		{
+			name:                "installs the bundle",
+			requestBody:         bundle,
+			statusCode:          http.StatusOK,
+			expectedReleaseName: "my-release",
+		},
+		{
+			name:                "installs the bundle with another release name",
+			requestBody:         bundle,
+			query:               "?releaseName=other-release",
+			statusCode:          http.StatusOK,
+			expectedReleaseName: "other-release",
+		},
+		{
+			name:         "reports a missing app repository",
+			requestBody:  strings.Replace(bundle, "appRepositoryResourceName: bitnami", "appRepositoryResourceName: stable", 1),
+			statusCode:   http.StatusUnprocessableEntity,
+			responseBody: `{"code":422,"message":"{\"missingAppRepositories\":[\"default/stable\"]}"}`,
+		},
+		{
+			name:            "reports missing registry secrets",
+			requestBody:     bundle + "registrySecrets:\n- registry-creds\n- other-creds\n",
+			registrySecrets: []string{"registry-creds", "other-creds"},
+			existingSecrets: []*corev1.Secret{
+				{ObjectMeta: v1.ObjectMeta{Name: "registry-creds", Namespace: "default"}},
+			},
+			statusCode:   http.StatusUnprocessableEntity,
+			responseBody: `{"code":422,"message":"{\"missingSecrets\":[\"default/other-creds\"]}"}`,
+		},
+		{
+			name:         "reports registry secrets not configured in the app repository",
+			requestBody:  bundle + "registrySecrets:\n- registry-creds\n",
+			statusCode:   http.StatusUnprocessableEntity,
+			responseBody: `{"code":422,"message":"{\"missingSecrets\":[\"default/registry-creds\"]}"}`,
+		},
+		{
+			name:         "errors if the bundle version is not supported",
+			requestBody:  strings.Replace(bundle, "kubeapps.com/v1alpha1", "kubeapps.com/v2", 1),
+			statusCode:   http.StatusUnprocessableEntity,
+			responseBody: `{"code":422,"message":"Unsupported bundle kubeapps.com/v2 ReleaseBundle, expected a kubeapps.com/v1alpha1 ReleaseBundle"}`,
+		},
+		{
+			name:         "errors if the bundle cannot be parsed",
+			requestBody:  "chart: [",
+			statusCode:   http.StatusBadRequest,
+			responseBody: `{"code":400,"message":"Unable to parse request body: error converting YAML to JSON: yaml: line 1: did not find expected node content"}`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			k := &kubefake.FailingKubeClient{PrintingKubeClient: kubefake.PrintingKubeClient{Out: ioutil.Discard}}
+			cfg := newConfigFixture(t, k)
+			kubeHandler := cfg.KubeHandler.(*kubeappsKube.FakeHandler)
+			kubeHandler.AppRepos[0].Spec.DockerRegistrySecrets = tc.registrySecrets
+			kubeHandler.Secrets = tc.existingSecrets
+
+			req := httptest.NewRequest("POST", "https://example.com/whatever"+tc.query, strings.NewReader(tc.requestBody))
+			response := httptest.NewRecorder()
+			ImportRelease(*cfg, response, req, map[string]string{namespaceParam: "prod"})
+
+			if got, want := response.Code, tc.statusCode; got != want {
+				t.Fatalf("got: %d, want: %d, body: %s", got, want, response.Body)
+			}
+			if tc.expectedReleaseName == "" {
+				if got, want := response.Body.String(), tc.responseBody; got != want {
+					t.Errorf("got: %q, want: %q", got, want)
+				}
+				return
+			}
+
+			rel, err := agent.GetRelease(cfg.ActionConfig, tc.expectedReleaseName)
+			if err != nil {
+				t.Fatalf("%+v", err)
+			}
+			if got, want := rel.Namespace, "prod"; got != want {
+				t.Errorf("got: %q, want: %q", got, want)
+			}
+			if got, want := rel.Config, map[string]interface{}{"replicaCount": float64(2)}; !cmp.Equal(want, got) {
+				t.Errorf("mismatch (-want +got):\n%s", cmp.Diff(want, got))
+			}
+			appRepo, ok, err := agent.GetAppRepository(cfg.Clientset, rel)
+			if err != nil {
+				t.Fatalf("%+v", err)
+			}
+			if want := (agent.AppRepositoryRef{Name: "bitnami", Namespace: "default"}); !ok || appRepo != want {
+				t.Errorf("expected the imported release to be associated with default/bitnami")
+			}
+		})
+	}
+}
@@ -3,11 +3,14 @@ package handler
 import (
 	"encoding/json"
 	"fmt"
+	"io"
 	"net/http"
 	"strconv"
+	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/kubeapps/common/response"
+	"github.com/kubeapps/kubeapps/cmd/kubeops/internal/operations"
 	"github.com/kubeapps/kubeapps/pkg/agent"
 	"github.com/kubeapps/kubeapps/pkg/auth"
 	"github.com/kubeapps/kubeapps/pkg/chart"
@@ -18,6 +21,12 @@ import (
 	log "github.com/sirupsen/logrus"
 	"github.com/urfave/negroni"
 	"helm.sh/helm/v3/pkg/action"
+	helm3chart "helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/release"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
 )
@@ -27,9 +36,14 @@ const (
 	clusterParam   = "cluster"
 	namespaceParam = "namespace"
 	nameParam      = "releaseName"
+	podParam       = "podName"
+	dryRunParam    = "dryRun"
 	authUserError  = "Unexpected error while configuring authentication"
 )
 
+// keepAliveInterval is the interval between the comments sent on idle event streams.
+var keepAliveInterval = 30 * time.Second
+
 // This type represents the fact that a regular handler cannot actually be created until we have access to the request,
 // because a valid action config (and hence handler config) cannot be created until then.
 // If the handler config were a "this" argument instead of an explicit argument, it would be easy to create a handler with a "zero" config.
@@ -43,17 +57,43 @@ type Options struct {
 	UserAgent         string
 	KubeappsNamespace string
 	ClustersConfig    kube.ClustersConfig
+	Operations        *operations.Manager
+	ChartFinder       ChartFinder
+	PostRenderers     agent.PostRendererConfigs
 }
 
 // Config represents data needed by each handler to be able to create Helm 3 actions.
 // It cannot be created without a bearer token, so a new one must be created upon each HTTP request.
 type Config struct {
-	ActionConfig *action.Configuration
-	Options      Options
-	KubeHandler  kube.AuthHandler
-	Resolver     handlerutil.ResolverFactory
-	Cluster      string
-	Token        string
+	ActionConfig  *action.Configuration
+	Options       Options
+	KubeHandler   kube.AuthHandler
+	Resolver      handlerutil.ResolverFactory
+	UserAuth      auth.Checker
+	Clientset     kubernetes.Interface
+	DynamicClient dynamic.Interface
+	RESTMapper    meta.RESTMapper
+	Cluster       string
+	Token         string
+	// ConfigForTarget creates a config with the same user token for another
+	// cluster and namespace, for handlers operating on several targets.
+	ConfigForTarget func(cluster, namespace string) (Config, error)
+}
+
+// dryRunResponse is used to marshal the JSON response of a dry-run install or upgrade.
+type dryRunResponse struct {
+	Manifest         string        `json:"manifest"`
+	Hooks            []dryRunHook  `json:"hooks"`
+	ForbiddenActions []auth.Action `json:"forbiddenActions"`
+}
+
+// dryRunHook is a hook of a dry-run install or upgrade, which is not part of the
+// manifest of the release.
+type dryRunHook struct {
+	Name     string   `json:"name"`
+	Kind     string   `json:"kind"`
+	Events   []string `json:"events"`
+	Manifest string   `json:"manifest"`
 }
 
 // WithHandlerConfig takes a dependentHandler and creates a regular (WithParams) handler that,
@@ -71,52 +111,73 @@ func WithHandlerConfig(storageForDriver agent.StorageForDriver, options Options)
 			namespace := params[namespaceParam]
 			token := auth.ExtractToken(req.Header.Get(authHeader))
 
-			inClusterConfig, err := rest.InClusterConfig()
-			if err != nil {
-				log.Errorf("Failed to create in-cluster config: %v", err)
-				response.NewErrorResponse(http.StatusInternalServerError, authUserError).Write(w)
-				return
-			}
-
-			restConfig, err := kube.NewClusterConfig(inClusterConfig, token, cluster, options.ClustersConfig)
-			if err != nil {
-				log.Errorf("Failed to create in-cluster config with user token: %v", err)
-				response.NewErrorResponse(http.StatusInternalServerError, authUserError).Write(w)
-				return
-			}
-			userKubeClient, err := kubernetes.NewForConfig(restConfig)
-			if err != nil {
-				log.Errorf("Failed to create kube client with user config: %v", err)
-				response.NewErrorResponse(http.StatusInternalServerError, authUserError).Write(w)
-				return
-			}
-			actionConfig, err := agent.NewActionConfig(storageForDriver, restConfig, userKubeClient, namespace)
-			if err != nil {
-				log.Errorf("Failed to create action config with user client: %v", err)
-				response.NewErrorResponse(http.StatusInternalServerError, authUserError).Write(w)
-				return
-			}
-
-			kubeHandler, err := kube.NewHandler(options.KubeappsNamespace, options.ClustersConfig)
+			cfg, err := newConfig(storageForDriver, options, token, cluster, namespace)
 			if err != nil {
-				log.Errorf("Failed to create handler: %v", err)
 				response.NewErrorResponse(http.StatusInternalServerError, authUserError).Write(w)
 				return
 			}
-
-			cfg := Config{
-				Options:      options,
-				ActionConfig: actionConfig,
-				KubeHandler:  kubeHandler,
-				Cluster:      cluster,
-				Token:        token,
-				Resolver:     &handlerutil.ClientResolver{},
+			cfg.ConfigForTarget = func(cluster, namespace string) (Config, error) {
+				return newConfig(storageForDriver, options, token, cluster, namespace)
 			}
 			f(cfg, w, req, params)
 		}
 	}
 }
 
+// newConfig creates the handler config for the given user token, cluster and namespace.
+func newConfig(storageForDriver agent.StorageForDriver, options Options, token, cluster, namespace string) (Config, error) {
+	inClusterConfig, err := rest.InClusterConfig()
+	if err != nil {
+		log.Errorf("Failed to create in-cluster config: %v", err)
+		return Config{}, err
+	}
+
+	restConfig, err := kube.NewClusterConfig(inClusterConfig, token, cluster, options.ClustersConfig)
+	if err != nil {
+		log.Errorf("Failed to create in-cluster config with user token: %v", err)
+		return Config{}, err
+	}
+	userKubeClient, err := kubernetes.NewForConfig(restConfig)
+	if err != nil {
+		log.Errorf("Failed to create kube client with user config: %v", err)
+		return Config{}, err
+	}
+	actionConfig, err := agent.NewActionConfig(storageForDriver, restConfig, userKubeClient, namespace)
+	if err != nil {
+		log.Errorf("Failed to create action config with user client: %v", err)
+		return Config{}, err
+	}
+
+	dynamicClient, err := dynamic.NewForConfig(restConfig)
+	if err != nil {
+		log.Errorf("Failed to create dynamic client with user config: %v", err)
+		return Config{}, err
+	}
+	restMapper, err := actionConfig.RESTClientGetter.ToRESTMapper()
+	if err != nil {
+		log.Errorf("Failed to create REST mapper with user config: %v", err)
+		return Config{}, err
+	}
+
+	kubeHandler, err := kube.NewHandler(options.KubeappsNamespace, options.ClustersConfig)
+	if err != nil {
+		log.Errorf("Failed to create handler: %v", err)
+		return Config{}, err
+	}
+
+	return Config{
+		Options:       options,
+		ActionConfig:  actionConfig,
+		KubeHandler:   kubeHandler,
+		Cluster:       cluster,
+		Token:         token,
+		Resolver:      &handlerutil.ClientResolver{},
+		Clientset:     userKubeClient,
+		DynamicClient: dynamicClient,
+		RESTMapper:    restMapper,
+	}, nil
+}
+
 // AddRouteWith makes it easier to define routes in main.go and avoids code repetition.
 func AddRouteWith(
 	r *mux.Router,
@@ -153,14 +214,139 @@ func returnErrMessage(err error, w http.ResponseWriter) {
 	}
 }
 
+// userAuth returns the permission checker of the user. Unless UserAuth is set, it
+// is created when needed so that only the handlers checking the permissions pay for it.
+func (cfg Config) userAuth() (auth.Checker, error) {
+	if cfg.UserAuth != nil {
+		return cfg.UserAuth, nil
+	}
+	userAuth, err := auth.NewAuth(cfg.Token, cfg.Cluster, cfg.Options.ClustersConfig)
+	if err != nil {
+		log.Errorf("Failed to create auth checker with user token: %v", err)
+		return nil, err
+	}
+	return userAuth, nil
+}
+
+// returnDryRunResult checks the permissions of the user for the rendered release
+// and writes the manifest and hooks together with any forbidden actions.
+func returnDryRunResult(cfg Config, w http.ResponseWriter, namespace, action string, rel *release.Release) {
+	// Hooks are not part of the release manifest but they are created
+	// with the same user credentials, so they need to be checked as well.
+	manifest := rel.Manifest
+	hooks := []dryRunHook{}
+	for _, h := range rel.Hooks {
+		manifest = fmt.Sprintf("%s\n---\n%s", manifest, h.Manifest)
+		hook := dryRunHook{Name: h.Name, Kind: h.Kind, Events: []string{}, Manifest: h.Manifest}
+		for _, e := range h.Events {
+			hook.Events = append(hook.Events, e.String())
+		}
+		hooks = append(hooks, hook)
+	}
+	userAuth, err := cfg.userAuth()
+	if err != nil {
+		returnErrMessage(err, w)
+		return
+	}
+	forbiddenActions, err := userAuth.GetForbiddenActions(namespace, action, manifest)
+	if err != nil {
+		returnErrMessage(err, w)
+		return
+	}
+	if forbiddenActions == nil {
+		forbiddenActions = []auth.Action{}
+	}
+	response.NewDataResponse(dryRunResponse{
+		Manifest:         rel.Manifest,
+		Hooks:            hooks,
+		ForbiddenActions: forbiddenActions,
+	}).Write(w)
+}
+
+// releaseOptions returns the release options of the request, using the default
+// timeout of kubeops unless a timeout was requested.
+func releaseOptions(cfg Config, chartDetails *chartUtils.Details) chartUtils.ReleaseOptions {
+	options := chartDetails.ReleaseOptions
+	if options.Timeout == 0 {
+		options.Timeout = cfg.Options.Timeout
+	}
+	return options
+}
+
+// releaseTransformers returns the transformers post-rendering the releases of a
+// namespace of the cluster of the config. The strategic merge patches, if any, are
+// read from a ConfigMap of that namespace.
+func releaseTransformers(cfg Config, namespace string) ([]agent.ResourceTransformer, error) {
+	config := cfg.Options.PostRenderers.For(cfg.Cluster, namespace)
+	var patches []string
+	if config.PatchesConfigMap != "" {
+		var err error
+		patches, err = agent.LoadPatches(cfg.Clientset, namespace, config.PatchesConfigMap)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return config.Transformers(cfg.Options.PostRenderers.PodSpecPaths, patches)
+}
+
+// validateValues checks the values against the JSON schema of the chart before
+// calling Helm. If they do not match, it writes a 422 response whose message lists
+// the path, expected type and message of each failing value, and returns false.
+func validateValues(w http.ResponseWriter, ch *helm3chart.Chart, valuesYaml string) bool {
+	schemaErrors, err := agent.ValidateValues(ch, valuesYaml)
+	if err != nil {
+		response.NewErrorResponse(http.StatusUnprocessableEntity, err.Error()).Write(w)
+		return false
+	}
+	if len(schemaErrors) == 0 {
+		return true
+	}
+	w.Header().Set("Content-Type", "application/json")
+	body, err := json.Marshal(schemaErrors)
+	if err != nil {
+		returnErrMessage(err, w)
+		return false
+	}
+	response.NewErrorResponse(http.StatusUnprocessableEntity, string(body)).Write(w)
+	return false
+}
+
 // ListReleases list existing releases.
+// The releases can be filtered, sorted and paginated with query params, see
+// parseReleaseListOptions. If requested, the latest version of the chart of each
+// release is looked up in the app repositories.
 func ListReleases(cfg Config, w http.ResponseWriter, req *http.Request, params handlerutil.Params) {
-	apps, err := agent.ListReleases(cfg.ActionConfig, params[namespaceParam], cfg.Options.ListLimit, req.URL.Query().Get("statuses"))
+	options, err := parseReleaseListOptions(cfg, req)
+	if err != nil {
+		response.NewErrorResponse(http.StatusBadRequest, err.Error()).Write(w)
+		return
+	}
+	if options.latestVersions && cfg.Options.ChartFinder == nil {
+		response.NewErrorResponse(http.StatusNotImplemented, "Looking up the charts of the releases is not enabled").Write(w)
+		return
+	}
+	apps, err := agent.ListReleasesMatching(cfg.ActionConfig, params[namespaceParam], options.filter)
 	if err != nil {
 		returnErrMessage(err, w)
 		return
 	}
-	response.NewDataResponse(apps).Write(w)
+	addAppRepositories(cfg, params[namespaceParam], apps)
+	// The repo and upgradable filters need the charts of every release. Otherwise,
+	// only the charts of the releases of the page are looked up.
+	if options.repo != "" || options.upgradable {
+		addLatestVersions(cfg, apps)
+		if options.repo != "" {
+			apps = filterByRepo(apps, options.repo)
+		}
+		if options.upgradable {
+			apps = filterUpgradable(apps)
+		}
+	}
+	page, meta := paginateReleases(apps, options)
+	if options.latestVersions && options.repo == "" && !options.upgradable {
+		addLatestVersions(cfg, page)
+	}
+	response.NewDataResponseWithMeta(page, meta).Write(w)
 }
 
 // ListAllReleases list all the releases available.
@@ -169,12 +355,30 @@ func ListAllReleases(cfg Config, w http.ResponseWriter, req *http.Request, _ han
 }
 
 // CreateRelease creates a release.
+// If the dryRun query param is truthy, the release is only rendered and the
+// resulting manifest is returned together with the actions the user is not
+// allowed to perform.
+// If the async query param is truthy, the release is created in the background.
 func CreateRelease(cfg Config, w http.ResponseWriter, req *http.Request, params handlerutil.Params) {
+	if handlerutil.QueryParamIsTruthy(asyncParam, req) {
+		runAsync(cfg, w, req, params, "create", CreateRelease)
+		return
+	}
 	chartDetails, err := handlerutil.ParseRequest(req)
 	if err != nil {
 		returnErrMessage(err, w)
 		return
 	}
+	installRelease(cfg, w, req, params[namespaceParam], chartDetails)
+}
+
+// installRelease installs the chart of the given details in the namespace,
+// honouring the dryRun query param of the request.
+func installRelease(cfg Config, w http.ResponseWriter, req *http.Request, namespace string, chartDetails *chartUtils.Details) {
+	if err := chartDetails.ValidateForInstall(); err != nil {
+		response.NewErrorResponse(http.StatusUnprocessableEntity, err.Error()).Write(w)
+		return
+	}
 	// TODO: currently app repositories are only supported on the cluster on which Kubeapps is installed. #1982
 	appRepo, caCertSecret, authSecret, err := chart.GetAppRepoAndRelatedSecrets(chartDetails.AppRepositoryResourceName, chartDetails.AppRepositoryResourceNamespace, cfg.KubeHandler, cfg.Token, cfg.Options.ClustersConfig.KubeappsClusterName, cfg.Options.KubeappsNamespace)
 	if err != nil {
@@ -191,31 +395,57 @@ func CreateRelease(cfg Config, w http.ResponseWriter, req *http.Request, params
 		returnErrMessage(err, w)
 		return
 	}
-
 	releaseName := chartDetails.ReleaseName
-	namespace := params[namespaceParam]
 	valuesString := chartDetails.Values
+	if !validateValues(w, ch, valuesString) {
+		return
+	}
 	registrySecrets, err := chartUtils.RegistrySecretsPerDomain(appRepo.Spec.DockerRegistrySecrets, cfg.Cluster, appRepo.Namespace, cfg.Token, cfg.KubeHandler)
 	if err != nil {
 		returnErrMessage(err, w)
 		return
 	}
-	release, err := agent.CreateRelease(cfg.ActionConfig, releaseName, namespace, valuesString, ch, registrySecrets)
+	transformers, err := releaseTransformers(cfg, namespace)
+	if err != nil {
+		returnErrMessage(err, w)
+		return
+	}
+	if handlerutil.QueryParamIsTruthy(dryRunParam, req) {
+		rel, err := agent.DryRunCreateRelease(cfg.ActionConfig, releaseName, namespace, valuesString, ch, registrySecrets, cfg.Options.PostRenderers.PodSpecPaths, releaseOptions(cfg, chartDetails), transformers...)
+		if err != nil {
+			returnErrMessage(err, w)
+			return
+		}
+		returnDryRunResult(cfg, w, namespace, "create", rel)
+		return
+	}
+	release, err := agent.CreateRelease(cfg.ActionConfig, releaseName, namespace, valuesString, ch, registrySecrets, cfg.Options.PostRenderers.PodSpecPaths, releaseOptions(cfg, chartDetails), transformers...)
 	if err != nil {
 		returnErrMessage(err, w)
 		return
 	}
+	recordAppRepository(cfg, release, appRepo.Name, appRepo.Namespace)
 	response.NewDataResponse(release).Write(w)
 }
 
 // OperateRelease decides which method to call depending on the "action" query param.
+// If the async query param is truthy, the action is run in the background.
 func OperateRelease(cfg Config, w http.ResponseWriter, req *http.Request, params handlerutil.Params) {
+	if handlerutil.QueryParamIsTruthy(asyncParam, req) {
+		action := req.URL.Query().Get("action")
+		if action == "" {
+			action = "upgrade"
+		}
+		runAsync(cfg, w, req, params, action, OperateRelease)
+		return
+	}
 	switch req.FormValue("action") {
 	case "upgrade":
 		upgradeRelease(cfg, w, req, params)
 	case "rollback":
 		rollbackRelease(cfg, w, req, params)
-	// TODO: Add "test" case here.
+	case "test":
+		testRelease(cfg, w, req, params)
 	default:
 		// By default, for maintaining compatibility, we call upgrade.
 		upgradeRelease(cfg, w, req, params)
@@ -224,11 +454,15 @@ func OperateRelease(cfg Config, w http.ResponseWriter, req *http.Request, params
 
 func upgradeRelease(cfg Config, w http.ResponseWriter, req *http.Request, params handlerutil.Params) {
 	releaseName := params[nameParam]
-	chartDetails, err := handlerutil.ParseRequest(req)
+	chartDetails, err := parseUpgradeRequest(cfg, req, releaseName)
 	if err != nil {
 		returnErrMessage(err, w)
 		return
 	}
+	if err := chartDetails.ValidateForUpgrade(); err != nil {
+		response.NewErrorResponse(http.StatusUnprocessableEntity, err.Error()).Write(w)
+		return
+	}
 	appRepo, caCertSecret, authSecret, err := chart.GetAppRepoAndRelatedSecrets(chartDetails.AppRepositoryResourceName, chartDetails.AppRepositoryResourceNamespace, cfg.KubeHandler, cfg.Token, cfg.Cluster, cfg.Options.KubeappsNamespace)
 	if err != nil {
 		returnErrMessage(fmt.Errorf("unable to get app repository %q: %v", chartDetails.AppRepositoryResourceName, err), w)
@@ -240,17 +474,54 @@ func upgradeRelease(cfg Config, w http.ResponseWriter, req *http.Request, params
 		caCertSecret, authSecret,
 		cfg.Resolver.New(appRepo.Spec.Type, cfg.Options.UserAgent),
 	)
+	if err != nil {
+		returnErrMessage(err, w)
+		return
+	}
 	registrySecrets, err := chartUtils.RegistrySecretsPerDomain(appRepo.Spec.DockerRegistrySecrets, cfg.Cluster, appRepo.Namespace, cfg.Token, cfg.KubeHandler)
 	if err != nil {
 		returnErrMessage(err, w)
 		return
 	}
+	transformers, err := releaseTransformers(cfg, params[namespaceParam])
+	if err != nil {
+		returnErrMessage(err, w)
+		return
+	}
+	valuesToValidate := chartDetails.Values
+	if chartDetails.ReuseValues {
+		// Helm merges the values of the request on top of the ones of the release.
+		currentRelease, err := agent.GetRelease(cfg.ActionConfig, releaseName)
+		if err != nil {
+			returnErrMessage(err, w)
+			return
+		}
+		valuesToValidate, err = patchValues(currentRelease.Config, chartDetails.Values)
+		if err != nil {
+			returnErrMessage(err, w)
+			return
+		}
+	}
+	if !validateValues(w, ch, valuesToValidate) {
+		return
+	}
 
-	rel, err := agent.UpgradeRelease(cfg.ActionConfig, releaseName, chartDetails.Values, ch, registrySecrets)
+	if handlerutil.QueryParamIsTruthy(dryRunParam, req) {
+		rel, err := agent.DryRunUpgradeRelease(cfg.ActionConfig, releaseName, chartDetails.Values, ch, registrySecrets, cfg.Options.PostRenderers.PodSpecPaths, releaseOptions(cfg, chartDetails), transformers...)
+		if err != nil {
+			returnErrMessage(err, w)
+			return
+		}
+		returnDryRunResult(cfg, w, params[namespaceParam], "upgrade", rel)
+		return
+	}
+
+	rel, err := agent.UpgradeRelease(cfg.ActionConfig, releaseName, chartDetails.Values, ch, registrySecrets, cfg.Options.PostRenderers.PodSpecPaths, releaseOptions(cfg, chartDetails), transformers...)
 	if err != nil {
 		returnErrMessage(err, w)
 		return
 	}
+	recordAppRepository(cfg, rel, appRepo.Name, appRepo.Namespace)
 	compatRelease, err := helm3to2.Convert(*rel)
 	if err != nil {
 		returnErrMessage(err, w)
@@ -284,16 +555,83 @@ func rollbackRelease(cfg Config, w http.ResponseWriter, req *http.Request, param
 	response.NewDataResponse(compatRelease).Write(w)
 }
 
+// DiffRelease returns the resources which would be added, removed or changed
+// when upgrading a release with the chart details of the request.
+func DiffRelease(cfg Config, w http.ResponseWriter, req *http.Request, params handlerutil.Params) {
+	releaseName := params[nameParam]
+	chartDetails, err := parseUpgradeRequest(cfg, req, releaseName)
+	if err != nil {
+		returnErrMessage(err, w)
+		return
+	}
+	if err := chartDetails.ValidateForUpgrade(); err != nil {
+		response.NewErrorResponse(http.StatusUnprocessableEntity, err.Error()).Write(w)
+		return
+	}
+	appRepo, caCertSecret, authSecret, err := chart.GetAppRepoAndRelatedSecrets(chartDetails.AppRepositoryResourceName, chartDetails.AppRepositoryResourceNamespace, cfg.KubeHandler, cfg.Token, cfg.Cluster, cfg.Options.KubeappsNamespace)
+	if err != nil {
+		returnErrMessage(fmt.Errorf("unable to get app repository %q: %v", chartDetails.AppRepositoryResourceName, err), w)
+		return
+	}
+	ch, err := handlerutil.GetChart(
+		chartDetails,
+		appRepo,
+		caCertSecret, authSecret,
+		cfg.Resolver.New(appRepo.Spec.Type, cfg.Options.UserAgent),
+	)
+	if err != nil {
+		returnErrMessage(err, w)
+		return
+	}
+	registrySecrets, err := chartUtils.RegistrySecretsPerDomain(appRepo.Spec.DockerRegistrySecrets, cfg.Cluster, appRepo.Namespace, cfg.Token, cfg.KubeHandler)
+	if err != nil {
+		returnErrMessage(err, w)
+		return
+	}
+	transformers, err := releaseTransformers(cfg, params[namespaceParam])
+	if err != nil {
+		returnErrMessage(err, w)
+		return
+	}
+
+	currentRelease, err := agent.GetRelease(cfg.ActionConfig, releaseName)
+	if err != nil {
+		returnErrMessage(err, w)
+		return
+	}
+	proposedRelease, err := agent.DryRunUpgradeRelease(cfg.ActionConfig, releaseName, chartDetails.Values, ch, registrySecrets, cfg.Options.PostRenderers.PodSpecPaths, releaseOptions(cfg, chartDetails), transformers...)
+	if err != nil {
+		returnErrMessage(err, w)
+		return
+	}
+	diff, err := agent.DiffManifests(currentRelease.Manifest, proposedRelease.Manifest)
+	if err != nil {
+		returnErrMessage(err, w)
+		return
+	}
+	response.NewDataResponse(diff).Write(w)
+}
+
+func testRelease(cfg Config, w http.ResponseWriter, req *http.Request, params handlerutil.Params) {
+	releaseName := params[nameParam]
+	testStatus, err := agent.TestRelease(cfg.ActionConfig, cfg.Clientset, releaseName, cfg.Options.Timeout)
+	if err != nil {
+		returnErrMessage(err, w)
+		return
+	}
+	response.NewDataResponse(testStatus).Write(w)
+}
+
 // GetRelease returns a release.
+// A specific revision of the release can be requested with the revision query param.
 func GetRelease(cfg Config, w http.ResponseWriter, req *http.Request, params handlerutil.Params) {
 	// Namespace is already known by the RESTClientGetter.
 	releaseName := params[nameParam]
-	release, err := agent.GetRelease(cfg.ActionConfig, releaseName)
+	rel, err := getReleaseRevision(cfg, w, req, releaseName)
 	if err != nil {
-		returnErrMessage(err, w)
 		return
 	}
-	compatRelease, err := helm3to2.Convert(*release)
+	compatRelease, err := helm3to2.Convert(*rel)
 	if err != nil {
 		returnErrMessage(err, w)
 		return
@@ -301,8 +639,271 @@ func GetRelease(cfg Config, w http.ResponseWriter, req *http.Request, params han
 	response.NewDataResponse(compatRelease).Write(w)
 }
 
+// getReleaseRevision returns the revision of the release requested with the revision
+// query param, or its latest revision. Errors are written to the response.
+func getReleaseRevision(cfg Config, w http.ResponseWriter, req *http.Request, releaseName string) (*release.Release, error) {
+	var rel *release.Release
+	var err error
+	if revision := req.URL.Query().Get("revision"); revision != "" {
+		revisionInt, parseErr := strconv.ParseInt(revision, 10, 32)
+		if parseErr != nil {
+			response.NewErrorResponse(http.StatusUnprocessableEntity, fmt.Sprintf("Invalid revision %q in request", revision)).Write(w)
+			return nil, parseErr
+		}
+		rel, err = agent.GetReleaseRevision(cfg.ActionConfig, releaseName, int(revisionInt))
+	} else {
+		rel, err = agent.GetRelease(cfg.ActionConfig, releaseName)
+	}
+	if err != nil {
+		returnErrMessage(err, w)
+		return nil, err
+	}
+	return rel, nil
+}
+
+// GetReleaseValues returns the user-supplied and computed values of a release.
+// A specific revision of the release can be requested with the revision query param.
+func GetReleaseValues(cfg Config, w http.ResponseWriter, req *http.Request, params handlerutil.Params) {
+	releaseName := params[nameParam]
+	rel, err := getReleaseRevision(cfg, w, req, releaseName)
+	if err != nil {
+		return
+	}
+	values, err := agent.GetReleaseValues(rel)
+	if err != nil {
+		returnErrMessage(err, w)
+		return
+	}
+	response.NewDataResponse(values).Write(w)
+}
+
+// GetReleaseResourcesStatus returns the live status of the resources of a release.
+func GetReleaseResourcesStatus(cfg Config, w http.ResponseWriter, req *http.Request, params handlerutil.Params) {
+	releaseName := params[nameParam]
+	rel, err := agent.GetRelease(cfg.ActionConfig, releaseName)
+	if err != nil {
+		returnErrMessage(err, w)
+		return
+	}
+	clients := agent.ResourceClients{
+		Dynamic:    cfg.DynamicClient,
+		Clientset:  cfg.Clientset,
+		RESTMapper: cfg.RESTMapper,
+	}
+	status, err := agent.GetReleaseResourcesStatus(clients, rel)
+	if err != nil {
+		returnErrMessage(err, w)
+		return
+	}
+	response.NewDataResponse(status).Write(w)
+}
+
+// WatchReleaseResources streams the changes of the resources of a release as
+// server-sent events until the client disconnects.
+func WatchReleaseResources(cfg Config, w http.ResponseWriter, req *http.Request, params handlerutil.Params) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		response.NewErrorResponse(http.StatusInternalServerError, "Streaming is not supported").Write(w)
+		return
+	}
+	releaseName := params[nameParam]
+	rel, err := agent.GetRelease(cfg.ActionConfig, releaseName)
+	if err != nil {
+		returnErrMessage(err, w)
+		return
+	}
+	clients := agent.ResourceClients{
+		Dynamic:    cfg.DynamicClient,
+		Clientset:  cfg.Clientset,
+		RESTMapper: cfg.RESTMapper,
+	}
+	ctx := req.Context()
+	events, err := agent.WatchReleaseResources(ctx, clients, rel)
+	if err != nil {
+		returnErrMessage(err, w)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	// Send a comment periodically so that idle connections are not closed by proxies.
+	keepAlive := time.NewTicker(keepAliveInterval)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-keepAlive.C:
+			fmt.Fprint(w, ": keep-alive\n\n")
+		case event, ok := <-events:
+			if !ok {
+				return
+			}
+			data, err := json.Marshal(event)
+			if err != nil {
+				log.Errorf("Unable to marshal the event of %s %q: %v", event.Resource.Kind, event.Resource.Name, err)
+				continue
+			}
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.Type, data)
+		}
+		flusher.Flush()
+	}
+}
+
+// GetReleasePods returns the pods of the workloads of a release.
+func GetReleasePods(cfg Config, w http.ResponseWriter, req *http.Request, params handlerutil.Params) {
+	releaseName := params[nameParam]
+	rel, err := agent.GetRelease(cfg.ActionConfig, releaseName)
+	if err != nil {
+		returnErrMessage(err, w)
+		return
+	}
+	clients := agent.ResourceClients{
+		Dynamic:    cfg.DynamicClient,
+		Clientset:  cfg.Clientset,
+		RESTMapper: cfg.RESTMapper,
+	}
+	pods, err := agent.GetReleasePods(clients, rel)
+	if err != nil {
+		returnErrMessage(err, w)
+		return
+	}
+	response.NewDataResponse(pods).Write(w)
+}
+
+// parsePodLogOptions reads the log options supported by the pod logs endpoint
+// from the query of the request.
+func parsePodLogOptions(req *http.Request) (*corev1.PodLogOptions, error) {
+	query := req.URL.Query()
+	logOptions := &corev1.PodLogOptions{
+		Container: query.Get("container"),
+		Follow:    handlerutil.QueryParamIsTruthy("follow", req),
+		Previous:  handlerutil.QueryParamIsTruthy("previous", req),
+	}
+	if tailLines := query.Get("tailLines"); tailLines != "" {
+		lines, err := strconv.ParseInt(tailLines, 10, 64)
+		if err != nil || lines < 0 {
+			return nil, fmt.Errorf("Invalid tailLines %q in request", tailLines)
+		}
+		logOptions.TailLines = &lines
+	}
+	if sinceTime := query.Get("sinceTime"); sinceTime != "" {
+		since, err := time.Parse(time.RFC3339, sinceTime)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid sinceTime %q in request, expected RFC 3339 format", sinceTime)
+		}
+		logOptions.SinceTime = &metav1.Time{Time: since}
+	}
+	return logOptions, nil
+}
+
+// GetPodLogs streams the logs of a container of a pod of the release.
+// When no container is specified, the first container of the pod is used. The
+// podNamespace param selects the pod among the pods of the same name placed in
+// other namespaces by the manifest.
+func GetPodLogs(cfg Config, w http.ResponseWriter, req *http.Request, params handlerutil.Params) {
+	logOptions, err := parsePodLogOptions(req)
+	if err != nil {
+		response.NewErrorResponse(http.StatusUnprocessableEntity, err.Error()).Write(w)
+		return
+	}
+	releaseName := params[nameParam]
+	podName := params[podParam]
+	rel, err := agent.GetRelease(cfg.ActionConfig, releaseName)
+	if err != nil {
+		returnErrMessage(err, w)
+		return
+	}
+	clients := agent.ResourceClients{
+		Dynamic:    cfg.DynamicClient,
+		Clientset:  cfg.Clientset,
+		RESTMapper: cfg.RESTMapper,
+	}
+	pods, err := agent.GetReleasePods(clients, rel)
+	if err != nil {
+		returnErrMessage(err, w)
+		return
+	}
+	podNamespace := req.URL.Query().Get("podNamespace")
+	var pod *agent.ReleasePod
+	for i := range pods {
+		if pods[i].Name == podName && (podNamespace == "" || pods[i].Namespace == podNamespace) {
+			pod = &pods[i]
+			break
+		}
+	}
+	if pod == nil {
+		response.NewErrorResponse(http.StatusNotFound, fmt.Sprintf("Pod %q not found in release %q", podName, releaseName)).Write(w)
+		return
+	}
+	if logOptions.Container == "" && len(pod.Containers) > 0 {
+		logOptions.Container = pod.Containers[0]
+	}
+	if !pod.HasContainer(logOptions.Container) {
+		response.NewErrorResponse(http.StatusNotFound, fmt.Sprintf("Container %q not found in pod %q", logOptions.Container, podName)).Write(w)
+		return
+	}
+
+	logs, err := agent.StreamPodLogs(req.Context(), cfg.Clientset, pod.Namespace, pod.Name, logOptions)
+	if err != nil {
+		returnErrMessage(err, w)
+		return
+	}
+	defer logs.Close()
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	if err := copyFlushed(w, logs); err != nil {
+		log.Infof("Stopped streaming the logs of pod %q: %v", podName, err)
+	}
+}
+
+// copyFlushed copies the stream to the response, flushing after every read so
+// that followed logs reach the client as they are produced.
+func copyFlushed(w http.ResponseWriter, r io.Reader) error {
+	flusher, _ := w.(http.Flusher)
+	buf := make([]byte, 4096)
+	for {
+		n, err := r.Read(buf)
+		if n > 0 {
+			if _, writeErr := w.Write(buf[:n]); writeErr != nil {
+				return writeErr
+			}
+			if flusher != nil {
+				flusher.Flush()
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// GetReleaseHistory returns the revisions of a release.
+func GetReleaseHistory(cfg Config, w http.ResponseWriter, req *http.Request, params handlerutil.Params) {
+	releaseName := params[nameParam]
+	revisions, err := agent.GetReleaseHistory(cfg.ActionConfig, releaseName)
+	if err != nil {
+		returnErrMessage(err, w)
+		return
+	}
+	response.NewDataResponse(revisions).Write(w)
+}
+
 // DeleteRelease deletes a release.
+// If the async query param is truthy, the release is deleted in the background.
 func DeleteRelease(cfg Config, w http.ResponseWriter, req *http.Request, params handlerutil.Params) {
+	if handlerutil.QueryParamIsTruthy(asyncParam, req) {
+		runAsync(cfg, w, req, params, "delete", DeleteRelease)
+		return
+	}
 	releaseName := params[nameParam]
 	purge := handlerutil.QueryParamIsTruthy("purge", req)
 	// Helm 3 has --purge by default; --keep-history in Helm 3 corresponds to omitting --purge in Helm 2.
@@ -313,6 +914,12 @@ func DeleteRelease(cfg Config, w http.ResponseWriter, req *http.Request, params
 		returnErrMessage(err, w)
 		return
 	}
+	if purge {
+		// The history of the release is kept otherwise, and so its app repository.
+		if err := agent.DeleteAppRepository(cfg.Clientset, params[namespaceParam], releaseName); err != nil {
+			log.Warningf("%v", err)
+		}
+	}
 	w.Header().Set("Status-Code", "200")
 	w.Write([]byte("OK"))
 }
@@ -13,6 +13,8 @@ import (
 
 	"github.com/google/go-cmp/cmp"
 	"github.com/kubeapps/kubeapps/cmd/apprepository-controller/pkg/apis/apprepository/v1alpha1"
+	"github.com/kubeapps/kubeapps/pkg/auth"
+	authFake "github.com/kubeapps/kubeapps/pkg/auth/fake"
 	fakeHandlerUtils "github.com/kubeapps/kubeapps/pkg/handlerutil/fake"
 	kubeappsKube "github.com/kubeapps/kubeapps/pkg/kube"
 	"helm.sh/helm/v3/pkg/action"
@@ -23,6 +25,7 @@ import (
 	"helm.sh/helm/v3/pkg/storage/driver"
 	helmTime "helm.sh/helm/v3/pkg/time"
 	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8sfake "k8s.io/client-go/kubernetes/fake"
 
 	"helm.sh/helm/v3/pkg/release"
 )
@@ -57,7 +60,9 @@ func newConfigFixture(t *testing.T, k *kubefake.FailingKubeClient) *Config {
 				},
 			},
 		},
-		Resolver: &fakeHandlerUtils.ClientResolver{},
+		Resolver:  &fakeHandlerUtils.ClientResolver{},
+		UserAuth:  &authFake.FakeAuth{},
+		Clientset: k8sfake.NewSimpleClientset(),
 		Options: Options{
 			ListLimit: defaultListLimit,
 		},
@@ -141,6 +146,20 @@ func TestActions(t *testing.T) {
 			},
 			ResponseBody: "",
 		},
+		{
+			// Scenario params
+			Description:      "Create a release with invalid options",
+			ExistingReleases: []*release.Release{},
+			// Request params
+			RequestBody: `{"chartName": "foo", "releaseName": "foobar",	"version": "1.0.0", "appRepositoryResourceName": "bitnami", "appRepositoryResourceNamespace": "default", "reuseValues": true}`,
+			RequestQuery: "",
+			Action:       "create",
+			Params:       map[string]string{"namespace": "default"},
+			// Expected result
+			StatusCode:        422,
+			RemainingReleases: nil,
+			ResponseBody:      `{"code":422,"message":"The reuseValues option can only be used to upgrade a release"}`,
+		},
 		{
 			// Scenario params
 			Description:      "Get a non-existing release",
@@ -207,6 +226,44 @@ func TestActions(t *testing.T) {
 			},
 			ResponseBody: `{"data":{"name":"foobar","info":{"status":{"code":1}},"chart":{"metadata":{"name":"foo"},"values":{"raw":"{}\n"}},"config":{"raw":"{}\n"},"version":1,"namespace":"default"}}`,
 		},
+		{
+			// Scenario params
+			Description: "Get a specific revision of a release",
+			ExistingReleases: []*release.Release{
+				createRelease("foo", "foobar", "default", 1, release.StatusSuperseded),
+				createRelease("foo", "foobar", "default", 2, release.StatusDeployed),
+			},
+			// Request params
+			RequestBody:  "",
+			RequestQuery: "?revision=1",
+			Action:       "get",
+			Params:       map[string]string{"namespace": "default", "releaseName": "foobar"},
+			// Expected result
+			StatusCode: 200,
+			RemainingReleases: []*release.Release{
+				createRelease("foo", "foobar", "default", 1, release.StatusSuperseded),
+				createRelease("foo", "foobar", "default", 2, release.StatusDeployed),
+			},
+			ResponseBody: `{"data":{"name":"foobar","info":{"status":{"code":3}},"chart":{"metadata":{"name":"foo"},"values":{"raw":"{}\n"}},"config":{"raw":"{}\n"},"version":1,"namespace":"default"}}`,
+		},
+		{
+			// Scenario params
+			Description: "Get a non-existing revision of a release",
+			ExistingReleases: []*release.Release{
+				createRelease("foo", "foobar", "default", 1, release.StatusDeployed),
+			},
+			// Request params
+			RequestBody:  "",
+			RequestQuery: "?revision=2",
+			Action:       "get",
+			Params:       map[string]string{"namespace": "default", "releaseName": "foobar"},
+			// Expected result
+			StatusCode: 404,
+			RemainingReleases: []*release.Release{
+				createRelease("foo", "foobar", "default", 1, release.StatusDeployed),
+			},
+			ResponseBody: "",
+		},
 		{
 			// Scenario params
 			Description: "Get a deleted release",
@@ -534,3 +591,456 @@ func TestUpgradeAction(t *testing.T) {
 		})
 	}
 }
+
+func TestDryRunActions(t *testing.T) {
+	const releaseName = "my-release"
+	testCases := []struct {
+		name             string
+		existingReleases []*release.Release
+		forbiddenActions []auth.Action
+		action           string
+		requestBody      string
+		params           map[string]string
+		statusCode       int
+		expectedReleases []*release.Release
+		responseBody     string
+	}{
+		{
+			name:        "renders a new release without installing it",
+			action:      "create",
+			requestBody: `{"chartName": "apache", "releaseName": "my-release", "version": "1.0.0", "appRepositoryResourceName": "bitnami", "appRepositoryResourceNamespace": "default"}`,
+			params:      map[string]string{namespaceParam: "default"},
+			statusCode:  http.StatusOK,
+			// expectedReleases is `nil` because nothing is stored during a dry-run.
+			expectedReleases: nil,
+			responseBody:     `{"data":{"manifest":"","hooks":[],"forbiddenActions":[]}}`,
+		},
+		{
+			name: "returns the forbidden actions for a new release",
+			forbiddenActions: []auth.Action{
+				{APIVersion: "v1", Resource: "secrets", Namespace: "default", Verbs: []string{"create"}},
+			},
+			action:           "create",
+			requestBody:      `{"chartName": "apache", "releaseName": "my-release", "version": "1.0.0", "appRepositoryResourceName": "bitnami", "appRepositoryResourceNamespace": "default"}`,
+			params:           map[string]string{namespaceParam: "default"},
+			statusCode:       http.StatusOK,
+			expectedReleases: nil,
+			responseBody:     `{"data":{"manifest":"","hooks":[],"forbiddenActions":[{"apiGroup":"v1","resource":"secrets","namespace":"default","clusterWide":false,"verbs":["create"]}]}}`,
+		},
+		{
+			name: "errors if the release to render already exists",
+			existingReleases: []*release.Release{
+				createRelease("apache", releaseName, "default", 1, release.StatusDeployed),
+			},
+			action:      "create",
+			requestBody: `{"chartName": "apache", "releaseName": "my-release", "version": "1.0.0", "appRepositoryResourceName": "bitnami", "appRepositoryResourceNamespace": "default"}`,
+			params:      map[string]string{namespaceParam: "default"},
+			statusCode:  http.StatusConflict,
+			expectedReleases: []*release.Release{
+				createRelease("apache", releaseName, "default", 1, release.StatusDeployed),
+			},
+			responseBody: `{"code":409,"message":"release my-release already exists"}`,
+		},
+		{
+			name: "renders an upgrade without upgrading the release",
+			existingReleases: []*release.Release{
+				createRelease("apache", releaseName, "default", 1, release.StatusDeployed),
+			},
+			action:      "upgrade",
+			requestBody: `{"chartName": "apache", "releaseName": "my-release", "version": "1.0.0", "appRepositoryResourceName": "bitnami", "appRepositoryResourceNamespace": "default"}`,
+			params:      map[string]string{namespaceParam: "default", nameParam: releaseName},
+			statusCode:  http.StatusOK,
+			expectedReleases: []*release.Release{
+				createRelease("apache", releaseName, "default", 1, release.StatusDeployed),
+			},
+			responseBody: `{"data":{"manifest":"","hooks":[],"forbiddenActions":[]}}`,
+		},
+		{
+			name:             "errors when rendering an upgrade of a missing release",
+			action:           "upgrade",
+			requestBody:      `{"chartName": "apache", "releaseName": "my-release", "version": "1.0.0", "appRepositoryResourceName": "bitnami", "appRepositoryResourceNamespace": "default"}`,
+			params:           map[string]string{namespaceParam: "default", nameParam: releaseName},
+			statusCode:       http.StatusNotFound,
+			expectedReleases: nil,
+			responseBody:     `{"code":404,"message":"release: not found"}`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			k := &kubefake.FailingKubeClient{PrintingKubeClient: kubefake.PrintingKubeClient{Out: ioutil.Discard}}
+			cfg := newConfigFixture(t, k)
+			cfg.UserAuth = &authFake.FakeAuth{ForbiddenActions: tc.forbiddenActions}
+			createExistingReleases(t, cfg, tc.existingReleases)
+			req := httptest.NewRequest("POST", "https://example.com/whatever?action=upgrade&dryRun=true", strings.NewReader(tc.requestBody))
+			response := httptest.NewRecorder()
+
+			switch tc.action {
+			case "create":
+				CreateRelease(*cfg, response, req, tc.params)
+			case "upgrade":
+				OperateRelease(*cfg, response, req, tc.params)
+			default:
+				t.Fatalf("Unexpected action %s", tc.action)
+			}
+
+			if got, want := response.Code, tc.statusCode; got != want {
+				t.Errorf("got: %d, want: %d", got, want)
+			}
+			if got, want := response.Body.String(), tc.responseBody; got != want {
+				t.Errorf("got: %q, want: %q", got, want)
+			}
+
+			actualReleases, err := cfg.ActionConfig.Releases.ListReleases()
+			if err != nil {
+				t.Fatalf("%+v", err)
+			}
+			if got, want := actualReleases, tc.expectedReleases; !cmp.Equal(want, got, releaseComparer) {
+				t.Errorf("mismatch (-want +got):\n%s", cmp.Diff(want, got, releaseComparer))
+			}
+		})
+	}
+}
+
+func TestReturnDryRunResultWithHooks(t *testing.T) {
+	k := &kubefake.FailingKubeClient{PrintingKubeClient: kubefake.PrintingKubeClient{Out: ioutil.Discard}}
+	cfg := newConfigFixture(t, k)
+	rel := &release.Release{
+		Manifest: "kind: Deployment",
+		Hooks: []*release.Hook{
+			{Name: "migrate", Kind: "Job", Events: []release.HookEvent{release.HookPreInstall, release.HookPreUpgrade}, Manifest: "kind: Job"},
+		},
+	}
+	response := httptest.NewRecorder()
+
+	returnDryRunResult(*cfg, response, "default", "create", rel)
+
+	if got, want := response.Code, http.StatusOK; got != want {
+		t.Errorf("got: %d, want: %d", got, want)
+	}
+	expected := `{"data":{"manifest":"kind: Deployment","hooks":[{"name":"migrate","kind":"Job","events":["pre-install","pre-upgrade"],"manifest":"kind: Job"}],"forbiddenActions":[]}}`
+	if got, want := response.Body.String(), expected; got != want {
+		t.Errorf("got: %q, want: %q", got, want)
+	}
+}
+
+func TestDiffRelease(t *testing.T) {
+	const releaseName = "my-release"
+	releaseWithManifest := createRelease("apache", releaseName, "default", 1, release.StatusDeployed)
+	releaseWithManifest.Manifest = `---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: apache-config
+`
+	testCases := []struct {
+		name             string
+		existingReleases []*release.Release
+		requestBody      string
+		params           map[string]string
+		statusCode       int
+		responseBody     string
+	}{
+		{
+			name:             "returns the resources removed by an upgrade",
+			existingReleases: []*release.Release{releaseWithManifest},
+			requestBody:      `{"chartName": "apache", "releaseName": "my-release", "version": "1.0.0", "appRepositoryResourceName": "bitnami", "appRepositoryResourceNamespace": "default"}`,
+			params:           map[string]string{namespaceParam: "default", nameParam: releaseName},
+			statusCode:       http.StatusOK,
+			responseBody:     `{"data":[{"apiVersion":"v1","kind":"ConfigMap","name":"apache-config","change":"removed"}]}`,
+		},
+		{
+			name: "returns an empty diff when nothing changes",
+			existingReleases: []*release.Release{
+				createRelease("apache", releaseName, "default", 1, release.StatusDeployed),
+			},
+			requestBody:  `{"chartName": "apache", "releaseName": "my-release", "version": "1.0.0", "appRepositoryResourceName": "bitnami", "appRepositoryResourceNamespace": "default"}`,
+			params:       map[string]string{namespaceParam: "default", nameParam: releaseName},
+			statusCode:   http.StatusOK,
+			responseBody: `{"data":[]}`,
+		},
+		{
+			name:         "errors if the release does not exist",
+			requestBody:  `{"chartName": "apache", "releaseName": "my-release", "version": "1.0.0", "appRepositoryResourceName": "bitnami", "appRepositoryResourceNamespace": "default"}`,
+			params:       map[string]string{namespaceParam: "default", nameParam: releaseName},
+			statusCode:   http.StatusNotFound,
+			responseBody: `{"code":404,"message":"release: not found"}`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			k := &kubefake.FailingKubeClient{PrintingKubeClient: kubefake.PrintingKubeClient{Out: ioutil.Discard}}
+			cfg := newConfigFixture(t, k)
+			createExistingReleases(t, cfg, tc.existingReleases)
+			req := httptest.NewRequest("POST", "https://example.com/whatever", strings.NewReader(tc.requestBody))
+			response := httptest.NewRecorder()
+
+			DiffRelease(*cfg, response, req, tc.params)
+
+			if got, want := response.Code, tc.statusCode; got != want {
+				t.Errorf("got: %d, want: %d", got, want)
+			}
+			if got, want := response.Body.String(), tc.responseBody; got != want {
+				t.Errorf("got: %q, want: %q", got, want)
+			}
+		})
+	}
+}
+
+func TestGetReleaseHistory(t *testing.T) {
+	const releaseName = "my-release"
+	testCases := []struct {
+		name             string
+		existingReleases []*release.Release
+		params           map[string]string
+		statusCode       int
+		responseBody     string
+	}{
+		{
+			name: "returns the revisions of a release",
+			existingReleases: []*release.Release{
+				createRelease("apache", releaseName, "default", 2, release.StatusDeployed),
+				createRelease("apache", releaseName, "default", 1, release.StatusSuperseded),
+			},
+			params:       map[string]string{nameParam: releaseName},
+			statusCode:   http.StatusOK,
+			responseBody: `{"data":[{"revision":1,"status":"superseded","chartVersion":"","appVersion":"","description":"","firstDeployed":"","lastDeployed":"","deleted":""},{"revision":2,"status":"deployed","chartVersion":"","appVersion":"","description":"","firstDeployed":"","lastDeployed":"","deleted":""}]}`,
+		},
+		{
+			name:         "errors if the release does not exist",
+			params:       map[string]string{nameParam: releaseName},
+			statusCode:   http.StatusNotFound,
+			responseBody: `{"code":404,"message":"release: not found"}`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			k := &kubefake.FailingKubeClient{PrintingKubeClient: kubefake.PrintingKubeClient{Out: ioutil.Discard}}
+			cfg := newConfigFixture(t, k)
+			createExistingReleases(t, cfg, tc.existingReleases)
+			req := httptest.NewRequest("GET", "https://example.com/whatever", nil)
+			response := httptest.NewRecorder()
+
+			GetReleaseHistory(*cfg, response, req, tc.params)
+
+			if got, want := response.Code, tc.statusCode; got != want {
+				t.Errorf("got: %d, want: %d", got, want)
+			}
+			if got, want := response.Body.String(), tc.responseBody; got != want {
+				t.Errorf("got: %q, want: %q", got, want)
+			}
+		})
+	}
+}
+
+func TestWatchReleaseResources(t *testing.T) {
+	const releaseName = "my-release"
+	testCases := []struct {
+		name             string
+		existingReleases []*release.Release
+		statusCode       int
+		contentType      string
+		responseBody     string
+	}{
+		{
+			name: "opens an event stream for the release",
+			existingReleases: []*release.Release{
+				createRelease("apache", releaseName, "default", 1, release.StatusDeployed),
+			},
+			statusCode:  http.StatusOK,
+			contentType: "text/event-stream",
+		},
+		{
+			name:         "errors if the release does not exist",
+			statusCode:   http.StatusNotFound,
+			responseBody: `{"code":404,"message":"release: not found"}`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			k := &kubefake.FailingKubeClient{PrintingKubeClient: kubefake.PrintingKubeClient{Out: ioutil.Discard}}
+			cfg := newConfigFixture(t, k)
+			createExistingReleases(t, cfg, tc.existingReleases)
+			req := httptest.NewRequest("GET", "https://example.com/whatever", nil)
+			response := httptest.NewRecorder()
+
+			// The release has no resources so the stream is closed straight away.
+			WatchReleaseResources(*cfg, response, req, map[string]string{nameParam: releaseName})
+
+			if got, want := response.Code, tc.statusCode; got != want {
+				t.Errorf("got: %d, want: %d", got, want)
+			}
+			if tc.contentType != "" {
+				if got, want := response.Header().Get("Content-Type"), tc.contentType; got != want {
+					t.Errorf("got: %q, want: %q", got, want)
+				}
+			}
+			if got, want := response.Body.String(), tc.responseBody; got != want {
+				t.Errorf("got: %q, want: %q", got, want)
+			}
+		})
+	}
+}
+
+func TestGetPodLogs(t *testing.T) {
+	const releaseName = "my-release"
+	testCases := []struct {
+		name             string
+		existingReleases []*release.Release
+		query            string
+		statusCode       int
+		responseBody     string
+	}{
+		{
+			name:         "errors if tailLines is not a number",
+			query:        "?tailLines=ten",
+			statusCode:   http.StatusUnprocessableEntity,
+			responseBody: `{"code":422,"message":"Invalid tailLines \"ten\" in request"}`,
+		},
+		{
+			name:         "errors if sinceTime is not a RFC 3339 time",
+			query:        "?sinceTime=yesterday",
+			statusCode:   http.StatusUnprocessableEntity,
+			responseBody: `{"code":422,"message":"Invalid sinceTime \"yesterday\" in request, expected RFC 3339 format"}`,
+		},
+		{
+			name:         "errors if the release does not exist",
+			query:        "?tailLines=10&follow=true",
+			statusCode:   http.StatusNotFound,
+			responseBody: `{"code":404,"message":"release: not found"}`,
+		},
+		{
+			name: "errors if the pod does not belong to the release",
+			existingReleases: []*release.Release{
+				createRelease("apache", releaseName, "default", 1, release.StatusDeployed),
+			},
+			statusCode:   http.StatusNotFound,
+			responseBody: `{"code":404,"message":"Pod \"foo\" not found in release \"my-release\""}`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			k := &kubefake.FailingKubeClient{PrintingKubeClient: kubefake.PrintingKubeClient{Out: ioutil.Discard}}
+			cfg := newConfigFixture(t, k)
+			createExistingReleases(t, cfg, tc.existingReleases)
+			req := httptest.NewRequest("GET", "https://example.com/whatever"+tc.query, nil)
+			response := httptest.NewRecorder()
+
+			GetPodLogs(*cfg, response, req, map[string]string{nameParam: releaseName, podParam: "foo"})
+
+			if got, want := response.Code, tc.statusCode; got != want {
+				t.Errorf("got: %d, want: %d", got, want)
+			}
+			if got, want := response.Body.String(), tc.responseBody; got != want {
+				t.Errorf("got: %q, want: %q", got, want)
+			}
+		})
+	}
+}
+
+func TestGetReleaseValues(t *testing.T) {
+	const releaseName = "my-release"
+	testCases := []struct {
+		name             string
+		existingReleases []*release.Release
+		query            string
+		statusCode       int
+		responseBody     string
+	}{
+		{
+			name: "returns the values of the requested revision",
+			existingReleases: []*release.Release{
+				createRelease("apache", releaseName, "default", 2, release.StatusDeployed),
+				createRelease("apache", releaseName, "default", 1, release.StatusSuperseded),
+			},
+			query:        "?revision=1",
+			statusCode:   http.StatusOK,
+			responseBody: `{"data":{"revision":1,"userSupplied":{},"computed":{},"overrides":[]}}`,
+		},
+		{
+			name:         "errors if the revision is invalid",
+			query:        "?revision=latest",
+			statusCode:   http.StatusUnprocessableEntity,
+			responseBody: `{"code":422,"message":"Invalid revision \"latest\" in request"}`,
+		},
+		{
+			name:         "errors if the release does not exist",
+			statusCode:   http.StatusNotFound,
+			responseBody: `{"code":404,"message":"release: not found"}`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			k := &kubefake.FailingKubeClient{PrintingKubeClient: kubefake.PrintingKubeClient{Out: ioutil.Discard}}
+			cfg := newConfigFixture(t, k)
+			createExistingReleases(t, cfg, tc.existingReleases)
+			req := httptest.NewRequest("GET", "https://example.com/whatever"+tc.query, nil)
+			response := httptest.NewRecorder()
+
+			GetReleaseValues(*cfg, response, req, map[string]string{nameParam: releaseName})
+
+			if got, want := response.Code, tc.statusCode; got != want {
+				t.Errorf("got: %d, want: %d", got, want)
+			}
+			if got, want := response.Body.String(), tc.responseBody; got != want {
+				t.Errorf("got: %q, want: %q", got, want)
+			}
+		})
+	}
+}
+
+func TestValidateValues(t *testing.T) {
+	testCases := []struct {
+		name         string
+		values       string
+		valid        bool
+		statusCode   int
+		responseBody string
+	}{
+		{
+			name:   "accepts values matching the schema",
+			values: "replicaCount: 2",
+			valid:  true,
+		},
+		{
+			name:         "returns the values not matching the schema",
+			values:       "replicaCount: two",
+			statusCode:   http.StatusUnprocessableEntity,
+			responseBody: `{"code":422,"message":"[{\"path\":\"replicaCount\",\"expectedType\":\"integer\",\"message\":\"Invalid type. Expected: integer, given: string\"}]"}`,
+		},
+		{
+			name:         "errors if the values cannot be parsed",
+			values:       "replicaCount: [",
+			statusCode:   http.StatusUnprocessableEntity,
+			responseBody: `{"code":422,"message":"Unable to parse the values: error converting YAML to JSON: yaml: line 1: did not find expected node content"}`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ch := &chart.Chart{
+				Metadata: &chart.Metadata{Name: "apache"},
+				Schema:   []byte(`{"type":"object","properties":{"replicaCount":{"type":"integer"}}}`),
+			}
+			response := httptest.NewRecorder()
+
+			if got, want := validateValues(response, ch, tc.values), tc.valid; got != want {
+				t.Fatalf("got: %t, want: %t", got, want)
+			}
+			if tc.valid {
+				return
+			}
+			if got, want := response.Code, tc.statusCode; got != want {
+				t.Errorf("got: %d, want: %d", got, want)
+			}
+			if got, want := response.Body.String(), tc.responseBody; got != want {
+				t.Errorf("got: %q, want: %q", got, want)
+			}
+		})
+	}
+}
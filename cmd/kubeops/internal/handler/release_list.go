@@ -0,0 +1,260 @@
+package handler
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/kubeapps/kubeapps/pkg/agent"
+	"github.com/kubeapps/kubeapps/pkg/handlerutil"
+	"github.com/kubeapps/kubeapps/pkg/proxy"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+const (
+	sortByName    = "name"
+	sortByUpdated = "updated"
+	sortByChart   = "chart"
+	sortByStatus  = "status"
+)
+
+// releaseListOptions are the query params of a release list request.
+type releaseListOptions struct {
+	filter     agent.ReleaseFilter
+	repo       string
+	upgradable bool
+	// latestVersions is set if the latest versions of the charts of the releases
+	// are requested, which are looked up in the app repositories.
+	latestVersions bool
+	sortBy         string
+	descending     bool
+	limit          int
+	offset         int
+	cursor         *releaseListCursor
+}
+
+// releaseListCursor is the position after which the next page of releases starts.
+// It is sent to the client as an opaque continue token.
+type releaseListCursor struct {
+	SortBy     string    `json:"sortBy"`
+	Descending bool      `json:"descending,omitempty"`
+	Namespace  string    `json:"namespace"`
+	Name       string    `json:"name"`
+	Chart      string    `json:"chart,omitempty"`
+	Status     string    `json:"status,omitempty"`
+	Updated    time.Time `json:"updated"`
+}
+
+// releaseListMeta is returned together with a page of releases.
+type releaseListMeta struct {
+	// Total is the number of releases matching the filters.
+	Total    int    `json:"total"`
+	Offset   int    `json:"offset"`
+	Limit    int    `json:"limit"`
+	Continue string `json:"continue,omitempty"`
+}
+
+// parseReleaseListOptions parses the query params of a release list request.
+// The statuses, name, chart, repo, labelSelector and upgradable params filter the
+// releases, sortBy (name, updated, chart or status) and order (asc or desc) sort
+// them, and limit with either offset or continue select the page. The latestVersions
+// param requests the latest versions of the charts, as the repo and upgradable ones.
+// The page size defaults to, and is limited by, the configured list limit.
+func parseReleaseListOptions(cfg Config, req *http.Request) (*releaseListOptions, error) {
+	query := req.URL.Query()
+	options := &releaseListOptions{
+		filter: agent.ReleaseFilter{
+			Status:       query.Get("statuses"),
+			NameContains: query.Get("name"),
+			ChartName:    query.Get("chart"),
+		},
+		repo:   query.Get("repo"),
+		sortBy: sortByName,
+		limit:  cfg.Options.ListLimit,
+	}
+	options.upgradable = handlerutil.QueryParamIsTruthy(upgradableParam, req)
+	options.latestVersions = options.upgradable || options.repo != "" || handlerutil.QueryParamIsTruthy(latestVersionsParam, req)
+
+	if selector := query.Get("labelSelector"); selector != "" {
+		parsed, err := labels.Parse(selector)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid labelSelector %q: %v", selector, err)
+		}
+		options.filter.Selector = parsed
+	}
+
+	if sortBy := query.Get("sortBy"); sortBy != "" {
+		switch sortBy {
+		case sortByName, sortByUpdated, sortByChart, sortByStatus:
+			options.sortBy = sortBy
+		default:
+			return nil, fmt.Errorf("Invalid sortBy %q, it must be one of name, updated, chart or status", sortBy)
+		}
+	}
+	switch order := query.Get("order"); order {
+	case "", "asc":
+	case "desc":
+		options.descending = true
+	default:
+		return nil, fmt.Errorf("Invalid order %q, it must be asc or desc", order)
+	}
+
+	if limit := query.Get("limit"); limit != "" {
+		parsed, err := strconv.Atoi(limit)
+		if err != nil || parsed < 1 {
+			return nil, fmt.Errorf("Invalid limit %q, it must be a positive number", limit)
+		}
+		if parsed < options.limit || options.limit <= 0 {
+			options.limit = parsed
+		}
+	}
+	offset, token := query.Get("offset"), query.Get("continue")
+	if offset != "" && token != "" {
+		return nil, fmt.Errorf("The offset and continue params cannot be used together")
+	}
+	if offset != "" {
+		parsed, err := strconv.Atoi(offset)
+		if err != nil || parsed < 0 {
+			return nil, fmt.Errorf("Invalid offset %q, it must be a non-negative number", offset)
+		}
+		options.offset = parsed
+	}
+	if token != "" {
+		cursor, err := decodeReleaseListCursor(token)
+		if err != nil {
+			return nil, err
+		}
+		if cursor.SortBy != options.sortBy || cursor.Descending != options.descending {
+			return nil, fmt.Errorf("The continue token does not match the requested sort order")
+		}
+		options.cursor = cursor
+	}
+	return options, nil
+}
+
+func encodeReleaseListCursor(cursor releaseListCursor) string {
+	// The cursor only contains marshallable fields.
+	data, _ := json.Marshal(cursor)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeReleaseListCursor(token string) (*releaseListCursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid continue token")
+	}
+	cursor := &releaseListCursor{}
+	if err := json.Unmarshal(data, cursor); err != nil {
+		return nil, fmt.Errorf("Invalid continue token")
+	}
+	return cursor, nil
+}
+
+// cursorForRelease returns the cursor positioned at the given release.
+func cursorForRelease(options *releaseListOptions, app proxy.AppOverview) releaseListCursor {
+	return releaseListCursor{
+		SortBy:     options.sortBy,
+		Descending: options.descending,
+		Namespace:  app.Namespace,
+		Name:       app.ReleaseName,
+		Chart:      app.Chart,
+		Status:     app.Status,
+		Updated:    app.Updated,
+	}
+}
+
+// releaseLess returns a function reporting whether a release is listed before another
+// one. Releases with the same sort key are sorted by namespace and name so that the
+// order is total and a cursor identifies a single position.
+func releaseLess(sortBy string, descending bool) func(a, b *proxy.AppOverview) bool {
+	return func(a, b *proxy.AppOverview) bool {
+		cmp := 0
+		switch sortBy {
+		case sortByUpdated:
+			switch {
+			case a.Updated.Before(b.Updated):
+				cmp = -1
+			case a.Updated.After(b.Updated):
+				cmp = 1
+			}
+		case sortByChart:
+			cmp = compareStrings(a.Chart, b.Chart)
+		case sortByStatus:
+			cmp = compareStrings(a.Status, b.Status)
+		}
+		if cmp == 0 {
+			cmp = compareStrings(a.ReleaseName, b.ReleaseName)
+		}
+		if cmp == 0 {
+			cmp = compareStrings(a.Namespace, b.Namespace)
+		}
+		if descending {
+			return cmp > 0
+		}
+		return cmp < 0
+	}
+}
+
+func compareStrings(a, b string) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// paginateReleases sorts the releases and returns the requested page.
+func paginateReleases(apps []proxy.AppOverview, options *releaseListOptions) ([]proxy.AppOverview, releaseListMeta) {
+	less := releaseLess(options.sortBy, options.descending)
+	sort.SliceStable(apps, func(i, j int) bool {
+		return less(&apps[i], &apps[j])
+	})
+
+	start := options.offset
+	if options.cursor != nil {
+		after := &proxy.AppOverview{
+			ReleaseName: options.cursor.Name,
+			Namespace:   options.cursor.Namespace,
+			Chart:       options.cursor.Chart,
+			Status:      options.cursor.Status,
+			Updated:     options.cursor.Updated,
+		}
+		start = sort.Search(len(apps), func(i int) bool {
+			return less(after, &apps[i])
+		})
+	}
+	if start > len(apps) {
+		start = len(apps)
+	}
+	end := len(apps)
+	if options.limit > 0 && start+options.limit < end {
+		end = start + options.limit
+	}
+
+	meta := releaseListMeta{
+		Total:  len(apps),
+		Offset: start,
+		Limit:  options.limit,
+	}
+	if end < len(apps) {
+		meta.Continue = encodeReleaseListCursor(cursorForRelease(options, apps[end-1]))
+	}
+	return apps[start:end], meta
+}
+
+// filterByRepo returns the releases whose chart comes from the given app repository.
+func filterByRepo(apps []proxy.AppOverview, repo string) []proxy.AppOverview {
+	filtered := make([]proxy.AppOverview, 0)
+	for _, app := range apps {
+		if app.AppRepository == repo {
+			filtered = append(filtered, app)
+		}
+	}
+	return filtered
+}
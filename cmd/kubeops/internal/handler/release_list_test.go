@@ -0,0 +1,200 @@
+package handler
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/kubeapps/kubeapps/pkg/proxy"
+	kubefake "helm.sh/helm/v3/pkg/kube/fake"
+	"helm.sh/helm/v3/pkg/release"
+	helmTime "helm.sh/helm/v3/pkg/time"
+)
+
+type releaseListResponse struct {
+	Data []proxy.AppOverview `json:"data"`
+	Meta releaseListMeta     `json:"meta"`
+}
+
+func createReleaseDeployedAt(chartName, name, namespace string, deployed time.Time) *release.Release {
+	rel := createRelease(chartName, name, namespace, 1, release.StatusDeployed)
+	rel.Info.LastDeployed = helmTime.Time{Time: deployed}
+	return rel
+}
+
+func listReleases(t *testing.T, cfg *Config, queryString string) (int, releaseListResponse) {
+	req := httptest.NewRequest("GET", "https://example.com/whatever"+queryString, nil)
+	response := httptest.NewRecorder()
+	ListReleases(*cfg, response, req, map[string]string{namespaceParam: "default"})
+
+	body := releaseListResponse{}
+	if response.Code == http.StatusOK {
+		if err := json.Unmarshal(response.Body.Bytes(), &body); err != nil {
+			t.Fatalf("%+v", err)
+		}
+	}
+	return response.Code, body
+}
+
+func releaseNames(apps []proxy.AppOverview) []string {
+	names := []string{}
+	for _, app := range apps {
+		names = append(names, app.ReleaseName)
+	}
+	return names
+}
+
+func TestListReleasesPage(t *testing.T) {
+	now := time.Now().UTC()
+	existingReleases := []*release.Release{
+		createReleaseDeployedAt("apache", "web", "default", now.Add(-time.Hour)),
+		createReleaseDeployedAt("redis", "cache", "default", now),
+		createReleaseDeployedAt("apache", "blog", "default", now.Add(-2*time.Hour)),
+		createReleaseDeployedAt("apache", "elsewhere", "other", now),
+	}
+	// The labels of the Secret or ConfigMap storing the releases.
+	existingReleases[1].Labels = map[string]string{"tier": "backend"}
+	existingReleases[2].Labels = map[string]string{"tier": "frontend"}
+
+	testCases := []struct {
+		name          string
+		queryString   string
+		expectedCode  int
+		expectedNames []string
+		expectedMeta  releaseListMeta
+	}{
+		{
+			name:          "sorts the releases by name by default",
+			expectedCode:  http.StatusOK,
+			expectedNames: []string{"blog", "cache", "web"},
+			expectedMeta:  releaseListMeta{Total: 3, Limit: defaultListLimit},
+		},
+		{
+			name:          "sorts the releases by last update in descending order",
+			queryString:   "?sortBy=updated&order=desc",
+			expectedCode:  http.StatusOK,
+			expectedNames: []string{"cache", "web", "blog"},
+			expectedMeta:  releaseListMeta{Total: 3, Limit: defaultListLimit},
+		},
+		{
+			name:          "returns the page at the given offset",
+			queryString:   "?sortBy=chart&offset=1&limit=1",
+			expectedCode:  http.StatusOK,
+			expectedNames: []string{"web"},
+			expectedMeta: releaseListMeta{Total: 3, Offset: 1, Limit: 1, Continue: encodeReleaseListCursor(releaseListCursor{
+				SortBy:    sortByChart,
+				Namespace: "default",
+				Name:      "web",
+				Chart:     "apache",
+				Status:    "deployed",
+				Updated:   now.Add(-time.Hour),
+			})},
+		},
+		{
+			name:          "filters by chart and name",
+			queryString:   "?chart=apache&name=WE",
+			expectedCode:  http.StatusOK,
+			expectedNames: []string{"web"},
+			expectedMeta:  releaseListMeta{Total: 1, Limit: defaultListLimit},
+		},
+		{
+			name:          "filters by label selector",
+			queryString:   "?labelSelector=tier+in+(backend,frontend)",
+			expectedCode:  http.StatusOK,
+			expectedNames: []string{"blog", "cache"},
+			expectedMeta:  releaseListMeta{Total: 2, Limit: defaultListLimit},
+		},
+		{
+			name:         "returns a 400 for an invalid sort",
+			queryString:  "?sortBy=size",
+			expectedCode: http.StatusBadRequest,
+		},
+		{
+			name:         "returns a 400 for an invalid label selector",
+			queryString:  "?labelSelector=a+in+b",
+			expectedCode: http.StatusBadRequest,
+		},
+		{
+			name:         "returns a 400 when both an offset and a continue token are given",
+			queryString:  "?offset=1&continue=abc",
+			expectedCode: http.StatusBadRequest,
+		},
+		{
+			name:         "returns a 501 when filtering by repository without a chart finder",
+			queryString:  "?repo=bitnami",
+			expectedCode: http.StatusNotImplemented,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			k := &kubefake.FailingKubeClient{PrintingKubeClient: kubefake.PrintingKubeClient{Out: ioutil.Discard}}
+			cfg := newConfigFixture(t, k)
+			createExistingReleases(t, cfg, existingReleases)
+
+			code, body := listReleases(t, cfg, tc.queryString)
+
+			if got, want := code, tc.expectedCode; got != want {
+				t.Fatalf("got: %d, want: %d", got, want)
+			}
+			if code != http.StatusOK {
+				return
+			}
+			if got, want := releaseNames(body.Data), tc.expectedNames; !cmp.Equal(want, got) {
+				t.Errorf("mismatch (-want +got):\n%s", cmp.Diff(want, got))
+			}
+			if got, want := body.Meta, tc.expectedMeta; !cmp.Equal(want, got) {
+				t.Errorf("mismatch (-want +got):\n%s", cmp.Diff(want, got))
+			}
+		})
+	}
+}
+
+func TestListReleasesContinue(t *testing.T) {
+	k := &kubefake.FailingKubeClient{PrintingKubeClient: kubefake.PrintingKubeClient{Out: ioutil.Discard}}
+	cfg := newConfigFixture(t, k)
+	now := time.Now().UTC()
+	createExistingReleases(t, cfg, []*release.Release{
+		createReleaseDeployedAt("apache", "a", "default", now),
+		createReleaseDeployedAt("apache", "b", "default", now),
+		createReleaseDeployedAt("apache", "c", "default", now),
+	})
+
+	code, first := listReleases(t, cfg, "?limit=2")
+	if got, want := code, http.StatusOK; got != want {
+		t.Fatalf("got: %d, want: %d", got, want)
+	}
+	if got, want := releaseNames(first.Data), []string{"a", "b"}; !cmp.Equal(want, got) {
+		t.Errorf("mismatch (-want +got):\n%s", cmp.Diff(want, got))
+	}
+	if first.Meta.Continue == "" {
+		t.Fatalf("expected a continue token")
+	}
+
+	// A release created before the next page is requested does not shift the pages.
+	createExistingReleases(t, cfg, []*release.Release{
+		createReleaseDeployedAt("apache", "aa", "default", now),
+	})
+	code, second := listReleases(t, cfg, "?limit=2&continue="+first.Meta.Continue)
+	if got, want := code, http.StatusOK; got != want {
+		t.Fatalf("got: %d, want: %d", got, want)
+	}
+	if got, want := releaseNames(second.Data), []string{"c"}; !cmp.Equal(want, got) {
+		t.Errorf("mismatch (-want +got):\n%s", cmp.Diff(want, got))
+	}
+	if got, want := second.Meta.Total, 4; got != want {
+		t.Errorf("got: %d, want: %d", got, want)
+	}
+	if second.Meta.Continue != "" {
+		t.Errorf("expected no continue token on the last page")
+	}
+
+	code, _ = listReleases(t, cfg, "?order=desc&continue="+first.Meta.Continue)
+	if got, want := code, http.StatusBadRequest; got != want {
+		t.Errorf("got: %d, want: %d", got, want)
+	}
+}
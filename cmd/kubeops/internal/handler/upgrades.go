@@ -0,0 +1,141 @@
+package handler
+
+import (
+	"sync"
+
+	"github.com/Masterminds/semver/v3"
+	"github.com/kubeapps/kubeapps/cmd/kubeops/internal/assetsvc"
+	"github.com/kubeapps/kubeapps/pkg/proxy"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	upgradableParam     = "upgradable"
+	latestVersionsParam = "latestVersions"
+	// maxConcurrentChartQueries is the maximum number of queries of the charts of
+	// releases sent to the assetsvc at the same time.
+	maxConcurrentChartQueries = 5
+)
+
+// ChartFinder finds the charts synced from the app repositories which the user
+// of the token can view.
+type ChartFinder interface {
+	FindCharts(token string, query assetsvc.ChartQuery) ([]assetsvc.Chart, error)
+}
+
+// addLatestVersions sets the latest version available in the app repositories for
+// the chart of each release. Releases whose chart cannot be found are left unchanged.
+// The charts are queried once per chart version, a few at a time.
+func addLatestVersions(cfg Config, apps []proxy.AppOverview) {
+	queries := []assetsvc.ChartQuery{}
+	queryIndexes := map[assetsvc.ChartQuery]int{}
+	appQueries := make([]int, len(apps))
+	for i, app := range apps {
+		query := chartQueryForRelease(cfg, app.Namespace, app.ChartMetadata.Name, app.ChartMetadata.Version, app.ChartMetadata.AppVersion)
+		index, ok := queryIndexes[query]
+		if !ok {
+			index = len(queries)
+			queryIndexes[query] = index
+			queries = append(queries, query)
+		}
+		appQueries[i] = index
+	}
+
+	found := make([][]assetsvc.Chart, len(queries))
+	semaphore := make(chan struct{}, maxConcurrentChartQueries)
+	var wg sync.WaitGroup
+	for index, query := range queries {
+		wg.Add(1)
+		semaphore <- struct{}{}
+		go func(index int, query assetsvc.ChartQuery) {
+			defer func() {
+				<-semaphore
+				wg.Done()
+			}()
+			charts, err := cfg.Options.ChartFinder.FindCharts(cfg.Token, query)
+			if err != nil {
+				log.Warningf("Unable to find the latest version of the chart %q: %v", query.Name, err)
+			}
+			found[index] = charts
+		}(index, query)
+	}
+	wg.Wait()
+
+	for i := range apps {
+		app := &apps[i]
+		chart := selectReleaseChart(found[appQueries[i]], *app)
+		if chart == nil {
+			continue
+		}
+		app.AppRepository = chart.Repo.Name
+		app.AppRepositoryNamespace = chart.Repo.Namespace
+		app.LatestVersion = chart.LatestVersion
+		app.LatestAppVersion = chart.LatestAppVersion
+		app.UpgradeAvailable = isNewerVersion(chart.LatestVersion, app.ChartMetadata.Version)
+	}
+}
+
+// chartQueryForRelease returns the query of the charts which could have been used
+// to install a release of the namespace.
+func chartQueryForRelease(cfg Config, namespace, chartName, version, appVersion string) assetsvc.ChartQuery {
+	// TODO: currently app repositories are only supported on the cluster on which Kubeapps is installed. #1982
+	// Releases of other clusters can only come from the global repositories.
+	if cfg.Cluster != cfg.Options.ClustersConfig.KubeappsClusterName {
+		namespace = cfg.Options.KubeappsNamespace
+	}
+	return assetsvc.ChartQuery{
+		Cluster:    cfg.Options.ClustersConfig.KubeappsClusterName,
+		Namespace:  namespace,
+		Name:       chartName,
+		Version:    version,
+		AppVersion: appVersion,
+	}
+}
+
+// selectReleaseChart returns the chart of the app repository associated with the
+// release, if any. Otherwise it prefers the chart from a repository of the release
+// namespace over the global ones. It returns nil if no chart matches.
+func selectReleaseChart(charts []assetsvc.Chart, app proxy.AppOverview) *assetsvc.Chart {
+	if app.AppRepository != "" {
+		for i := range charts {
+			if charts[i].Repo.Name == app.AppRepository && charts[i].Repo.Namespace == app.AppRepositoryNamespace {
+				return &charts[i]
+			}
+		}
+		return nil
+	}
+	if len(charts) == 0 {
+		return nil
+	}
+	for i := range charts {
+		if charts[i].Repo.Namespace == app.Namespace {
+			return &charts[i]
+		}
+	}
+	return &charts[0]
+}
+
+// isNewerVersion returns true if the latest version is greater than the current one.
+// Versions which are not semantic versions are only compared for equality.
+func isNewerVersion(latest, current string) bool {
+	latestVersion, err := semver.NewVersion(latest)
+	if err != nil {
+		return latest != current
+	}
+	currentVersion, err := semver.NewVersion(current)
+	if err != nil {
+		return latest != current
+	}
+	return latestVersion.GreaterThan(currentVersion)
+}
+
+// filterUpgradable returns the releases for which a newer chart version is available.
+func filterUpgradable(apps []proxy.AppOverview) []proxy.AppOverview {
+	upgradable := make([]proxy.AppOverview, 0)
+	for _, app := range apps {
+		if app.UpgradeAvailable {
+			upgradable = append(upgradable, app)
+		}
+	}
+	return upgradable
+}
@@ -0,0 +1,256 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/kubeapps/kubeapps/cmd/kubeops/internal/assetsvc"
+	"github.com/kubeapps/kubeapps/pkg/chart/models"
+	"github.com/kubeapps/kubeapps/pkg/proxy"
+	kubefake "helm.sh/helm/v3/pkg/kube/fake"
+	"helm.sh/helm/v3/pkg/release"
+)
+
+type fakeChartFinder struct {
+	charts  map[string][]assetsvc.Chart
+	err     error
+	mutex   sync.Mutex
+	queries []assetsvc.ChartQuery
+	tokens  []string
+}
+
+func (f *fakeChartFinder) FindCharts(token string, query assetsvc.ChartQuery) ([]assetsvc.Chart, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.queries = append(f.queries, query)
+	f.tokens = append(f.tokens, token)
+	return f.charts[query.Name], f.err
+}
+
+func createReleaseWithChartVersion(chartName, version, appVersion, name, namespace string) *release.Release {
+	rel := createRelease(chartName, name, namespace, 1, release.StatusDeployed)
+	rel.Chart.Metadata.Version = version
+	rel.Chart.Metadata.AppVersion = appVersion
+	return rel
+}
+
+func TestListReleasesWithLatestVersions(t *testing.T) {
+	charts := map[string][]assetsvc.Chart{
+		"apache": {
+			{ID: "bitnami/apache", Name: "apache", Repo: models.Repo{Name: "bitnami", Namespace: "kubeapps"}, LatestVersion: "8.2.0", LatestAppVersion: "2.4.47"},
+			{ID: "my-repo/apache", Name: "apache", Repo: models.Repo{Name: "my-repo", Namespace: "default"}, LatestVersion: "8.1.0", LatestAppVersion: "2.4.46"},
+		},
+		"nginx": {
+			{ID: "bitnami/nginx", Name: "nginx", Repo: models.Repo{Name: "bitnami", Namespace: "kubeapps"}, LatestVersion: "8.0.0", LatestAppVersion: "1.19.6"},
+		},
+	}
+	testCases := []struct {
+		name             string
+		existingReleases []*release.Release
+		queryString      string
+		finder           *fakeChartFinder
+		expectedCode     int
+		expectedApps     []expectedVersions
+		expectedQueries  int
+	}{
+		{
+			name: "adds the latest version of the chart, preferring the repositories of the release namespace",
+			existingReleases: []*release.Release{
+				createReleaseWithChartVersion("apache", "8.0.0", "2.4.46", "my-apache", "default"),
+				createReleaseWithChartVersion("apache", "8.0.0", "2.4.46", "other-apache", "default"),
+				createReleaseWithChartVersion("nginx", "8.0.0", "1.19.6", "my-nginx", "default"),
+				createReleaseWithChartVersion("redis", "12.0.0", "6.0.10", "my-redis", "default"),
+			},
+			queryString:  "?latestVersions=true",
+			finder:       &fakeChartFinder{charts: charts},
+			expectedCode: http.StatusOK,
+			expectedApps: []expectedVersions{
+				{ReleaseName: "my-apache", LatestVersion: "8.1.0", LatestAppVersion: "2.4.46", UpgradeAvailable: true},
+				{ReleaseName: "my-nginx", LatestVersion: "8.0.0", LatestAppVersion: "1.19.6"},
+				{ReleaseName: "my-redis"},
+				{ReleaseName: "other-apache", LatestVersion: "8.1.0", LatestAppVersion: "2.4.46", UpgradeAvailable: true},
+			},
+			expectedQueries: 3,
+		},
+		{
+			name: "looks up only the charts of the releases of the page",
+			existingReleases: []*release.Release{
+				createReleaseWithChartVersion("apache", "8.0.0", "2.4.46", "my-apache", "default"),
+				createReleaseWithChartVersion("nginx", "8.0.0", "1.19.6", "my-nginx", "default"),
+			},
+			queryString:  "?latestVersions=true&limit=1",
+			finder:       &fakeChartFinder{charts: charts},
+			expectedCode: http.StatusOK,
+			expectedApps: []expectedVersions{
+				{ReleaseName: "my-apache", LatestVersion: "8.1.0", LatestAppVersion: "2.4.46", UpgradeAvailable: true},
+			},
+			expectedQueries: 1,
+		},
+		{
+			name: "does not look up the charts unless requested",
+			existingReleases: []*release.Release{
+				createReleaseWithChartVersion("apache", "8.0.0", "2.4.46", "my-apache", "default"),
+			},
+			finder:       &fakeChartFinder{charts: charts},
+			expectedCode: http.StatusOK,
+			expectedApps: []expectedVersions{
+				{ReleaseName: "my-apache"},
+			},
+		},
+		{
+			name: "lists only the upgradable releases",
+			existingReleases: []*release.Release{
+				createReleaseWithChartVersion("apache", "8.0.0", "2.4.46", "my-apache", "default"),
+				createReleaseWithChartVersion("nginx", "8.0.0", "1.19.6", "my-nginx", "default"),
+			},
+			queryString:  "?upgradable=true",
+			finder:       &fakeChartFinder{charts: charts},
+			expectedCode: http.StatusOK,
+			expectedApps: []expectedVersions{
+				{ReleaseName: "my-apache", LatestVersion: "8.1.0", LatestAppVersion: "2.4.46", UpgradeAvailable: true},
+			},
+			expectedQueries: 2,
+		},
+		{
+			name: "lists the releases when the assetsvc fails",
+			existingReleases: []*release.Release{
+				createReleaseWithChartVersion("apache", "8.0.0", "2.4.46", "my-apache", "default"),
+			},
+			queryString:  "?latestVersions=true",
+			finder:       &fakeChartFinder{err: fmt.Errorf("boom")},
+			expectedCode: http.StatusOK,
+			expectedApps: []expectedVersions{
+				{ReleaseName: "my-apache"},
+			},
+			expectedQueries: 1,
+		},
+		{
+			name:         "returns a 501 when filtering without a chart finder",
+			queryString:  "?upgradable=true",
+			expectedCode: http.StatusNotImplemented,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			k := &kubefake.FailingKubeClient{PrintingKubeClient: kubefake.PrintingKubeClient{Out: ioutil.Discard}}
+			cfg := newConfigFixture(t, k)
+			cfg.Cluster = "default"
+			cfg.Token = "user-token"
+			cfg.Options.ClustersConfig.KubeappsClusterName = "default"
+			cfg.Options.KubeappsNamespace = "kubeapps"
+			if tc.finder != nil {
+				cfg.Options.ChartFinder = tc.finder
+			}
+			createExistingReleases(t, cfg, tc.existingReleases)
+
+			req := httptest.NewRequest("GET", "https://example.com/whatever"+tc.queryString, nil)
+			response := httptest.NewRecorder()
+			ListReleases(*cfg, response, req, map[string]string{namespaceParam: "default"})
+
+			if got, want := response.Code, tc.expectedCode; got != want {
+				t.Fatalf("got: %d, want: %d", got, want)
+			}
+			if tc.expectedApps == nil {
+				return
+			}
+			body := struct {
+				Data []proxy.AppOverview `json:"data"`
+			}{}
+			if err := json.Unmarshal(response.Body.Bytes(), &body); err != nil {
+				t.Fatalf("%+v", err)
+			}
+			apps := []expectedVersions{}
+			for _, app := range body.Data {
+				apps = append(apps, expectedVersions{
+					ReleaseName:      app.ReleaseName,
+					LatestVersion:    app.LatestVersion,
+					LatestAppVersion: app.LatestAppVersion,
+					UpgradeAvailable: app.UpgradeAvailable,
+				})
+			}
+			if got, want := apps, tc.expectedApps; !cmp.Equal(want, got) {
+				t.Errorf("mismatch (-want +got):\n%s", cmp.Diff(want, got))
+			}
+			if got, want := len(tc.finder.queries), tc.expectedQueries; got != want {
+				t.Errorf("got: %d queries, want: %d", got, want)
+			}
+			for _, token := range tc.finder.tokens {
+				if got, want := token, cfg.Token; got != want {
+					t.Errorf("got: %q, want: %q", got, want)
+				}
+			}
+		})
+	}
+}
+
+type expectedVersions struct {
+	ReleaseName      string
+	LatestVersion    string
+	LatestAppVersion string
+	UpgradeAvailable bool
+}
+
+func TestChartQueryForRelease(t *testing.T) {
+	testCases := []struct {
+		name              string
+		cluster           string
+		expectedNamespace string
+	}{
+		{
+			name:              "uses the release namespace on the Kubeapps cluster",
+			cluster:           "default",
+			expectedNamespace: "dev",
+		},
+		{
+			name:              "uses the global namespace on other clusters",
+			cluster:           "other",
+			expectedNamespace: "kubeapps",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := Config{Cluster: tc.cluster}
+			cfg.Options.ClustersConfig.KubeappsClusterName = "default"
+			cfg.Options.KubeappsNamespace = "kubeapps"
+
+			want := assetsvc.ChartQuery{
+				Cluster:    "default",
+				Namespace:  tc.expectedNamespace,
+				Name:       "apache",
+				Version:    "8.0.0",
+				AppVersion: "2.4.46",
+			}
+			if got := chartQueryForRelease(cfg, "dev", "apache", "8.0.0", "2.4.46"); !cmp.Equal(want, got) {
+				t.Errorf("mismatch (-want +got):\n%s", cmp.Diff(want, got))
+			}
+		})
+	}
+}
+
+func TestIsNewerVersion(t *testing.T) {
+	testCases := []struct {
+		latest   string
+		current  string
+		expected bool
+	}{
+		{latest: "8.1.0", current: "8.0.0", expected: true},
+		{latest: "8.0.0", current: "8.0.0", expected: false},
+		{latest: "8.0.0", current: "8.1.0-rc.1", expected: false},
+		{latest: "v2", current: "v1", expected: true},
+		{latest: "latest", current: "latest", expected: false},
+	}
+
+	for _, tc := range testCases {
+		if got, want := isNewerVersion(tc.latest, tc.current), tc.expected; got != want {
+			t.Errorf("isNewerVersion(%q, %q): got: %t, want: %t", tc.latest, tc.current, got, want)
+		}
+	}
+}
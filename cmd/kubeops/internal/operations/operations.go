@@ -0,0 +1,268 @@
+// Package operations runs long release operations in a pool of background
+// workers and keeps track of their status.
+package operations
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Status represents the state of an operation.
+type Status string
+
+const (
+	// StatusPending is used for operations waiting for a free worker.
+	StatusPending Status = "pending"
+	// StatusRunning is used for operations being executed.
+	StatusRunning Status = "running"
+	// StatusSucceeded is used for operations which completed successfully.
+	StatusSucceeded Status = "succeeded"
+	// StatusFailed is used for operations which returned an error.
+	StatusFailed Status = "failed"
+)
+
+// ErrQueueFull is returned when an operation is submitted while every worker is
+// busy and the queue has reached its capacity.
+var ErrQueueFull = errors.New("Too many pending operations, try again later")
+
+// ErrShutdown is returned when an operation is submitted after the manager has been shut down.
+var ErrShutdown = errors.New("The operation manager is shutting down")
+
+// Operation represents a release operation run in the background.
+type Operation struct {
+	ID          string          `json:"id"`
+	Action      string          `json:"action"`
+	Cluster     string          `json:"cluster"`
+	Namespace   string          `json:"namespace"`
+	ReleaseName string          `json:"releaseName,omitempty"`
+	Status      Status          `json:"status"`
+	Progress    string          `json:"progress,omitempty"`
+	Result      json.RawMessage `json:"result,omitempty"`
+	Error       string          `json:"error,omitempty"`
+	CreatedAt   time.Time       `json:"createdAt"`
+	StartedAt   *time.Time      `json:"startedAt,omitempty"`
+	FinishedAt  *time.Time      `json:"finishedAt,omitempty"`
+
+	// Username is the verified identity of the user who submitted the
+	// operation, if known.
+	Username string `json:"username,omitempty"`
+
+	// owner identifies the user who submitted the operation so that only the
+	// same user can retrieve it, see Owner.
+	owner string
+}
+
+// Func is the work of an operation. It can report its progress with the given
+// function and returns the JSON result of the operation.
+type Func func(report func(progress string)) (json.RawMessage, error)
+
+type task struct {
+	id string
+	fn Func
+}
+
+// Manager runs operations in a fixed pool of workers and keeps finished
+// operations for a retention period so that their result can be retrieved.
+type Manager struct {
+	mu         sync.RWMutex
+	operations map[string]*Operation
+	queue      chan task
+	retention  time.Duration
+	closed     bool
+	wg         sync.WaitGroup
+	now        func() time.Time
+}
+
+// NewManager returns a manager running operations with the given number of
+// workers and accepting up to queueSize pending operations.
+func NewManager(workers, queueSize int, retention time.Duration) *Manager {
+	m := &Manager{
+		operations: map[string]*Operation{},
+		queue:      make(chan task, queueSize),
+		retention:  retention,
+		now:        time.Now,
+	}
+	for i := 0; i < workers; i++ {
+		m.wg.Add(1)
+		go m.work()
+	}
+	return m
+}
+
+// Owner returns the owner of the operations submitted by a user. The verified
+// username is used when known, so that the user keeps access to the operation
+// after refreshing their token. Otherwise, the owner is a hash of the token.
+func Owner(username, token string) string {
+	if username != "" {
+		return "user:" + username
+	}
+	sum := sha256.Sum256([]byte(token))
+	return "token:" + hex.EncodeToString(sum[:])
+}
+
+func newID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// Submit queues an operation submitted by the given owner and returns a copy of
+// its initial state. The ID, status and timestamps of op are set by the manager.
+func (m *Manager) Submit(owner string, op Operation, fn Func) (Operation, error) {
+	id, err := newID()
+	if err != nil {
+		return Operation{}, err
+	}
+	op.ID = id
+	op.Status = StatusPending
+	op.Progress = "Waiting for a free worker"
+	op.CreatedAt = m.now()
+	op.owner = owner
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.closed {
+		return Operation{}, ErrShutdown
+	}
+	m.pruneLocked()
+	select {
+	case m.queue <- task{id: id, fn: fn}:
+	default:
+		return Operation{}, ErrQueueFull
+	}
+	m.operations[id] = &op
+	logOperation(op).Info("operation submitted")
+	return op, nil
+}
+
+// Get returns the operation with the given ID if it was submitted by the same owner.
+func (m *Manager) Get(owner, id string) (Operation, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	op, ok := m.operations[id]
+	if !ok || op.owner != owner {
+		return Operation{}, false
+	}
+	return *op, true
+}
+
+// Shutdown stops accepting operations and waits for the queued ones to finish
+// or for the context to be done.
+func (m *Manager) Shutdown(ctx context.Context) error {
+	m.mu.Lock()
+	if !m.closed {
+		m.closed = true
+		close(m.queue)
+	}
+	m.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		m.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// pruneLocked removes the finished operations older than the retention period.
+// It must be called with the lock held.
+func (m *Manager) pruneLocked() {
+	for id, op := range m.operations {
+		if op.FinishedAt != nil && m.now().Sub(*op.FinishedAt) > m.retention {
+			delete(m.operations, id)
+		}
+	}
+}
+
+func (m *Manager) update(id string, f func(op *Operation)) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if op, ok := m.operations[id]; ok {
+		f(op)
+	}
+}
+
+func (m *Manager) work() {
+	defer m.wg.Done()
+	for t := range m.queue {
+		m.run(t)
+	}
+}
+
+func (m *Manager) run(t task) {
+	m.update(t.id, func(op *Operation) {
+		started := m.now()
+		op.Status = StatusRunning
+		op.Progress = ""
+		op.StartedAt = &started
+	})
+	report := func(progress string) {
+		m.update(t.id, func(op *Operation) {
+			op.Progress = progress
+		})
+	}
+
+	result, err := runSafely(t.fn, report)
+
+	var finished Operation
+	m.update(t.id, func(op *Operation) {
+		finishedAt := m.now()
+		op.FinishedAt = &finishedAt
+		op.Progress = ""
+		op.Result = result
+		if err != nil {
+			op.Status = StatusFailed
+			op.Error = err.Error()
+		} else {
+			op.Status = StatusSucceeded
+		}
+		finished = *op
+	})
+	logOperation(finished).Info("operation finished")
+}
+
+// logOperation returns a logger with the fields identifying an operation and its user.
+func logOperation(op Operation) *log.Entry {
+	fields := log.Fields{
+		"operation": op.ID,
+		"action":    op.Action,
+		"cluster":   op.Cluster,
+		"namespace": op.Namespace,
+		"release":   op.ReleaseName,
+		"status":    op.Status,
+	}
+	if op.Username != "" {
+		fields["username"] = op.Username
+	}
+	if op.Error != "" {
+		fields["error"] = op.Error
+	}
+	return log.WithFields(fields)
+}
+
+// runSafely runs the work of an operation, converting a panic into an error so
+// that it does not take down the worker.
+func runSafely(fn Func, report func(progress string)) (result json.RawMessage, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("Unexpected error running the operation: %v", r)
+		}
+	}()
+	return fn(report)
+}
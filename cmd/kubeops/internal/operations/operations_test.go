@@ -0,0 +1,236 @@
+package operations
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+// waitForOperation polls the manager until the operation has finished.
+func waitForOperation(t *testing.T, m *Manager, owner, id string) Operation {
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		op, ok := m.Get(owner, id)
+		if !ok {
+			t.Fatalf("operation %q not found", id)
+		}
+		if op.FinishedAt != nil {
+			return op
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("timed out waiting for operation %q", id)
+	return Operation{}
+}
+
+func TestManager(t *testing.T) {
+	testCases := []struct {
+		name           string
+		fn             Func
+		expectedStatus Status
+		expectedResult json.RawMessage
+		expectedError  string
+	}{
+		{
+			name: "stores the result of a successful operation",
+			fn: func(report func(string)) (json.RawMessage, error) {
+				report("installing")
+				return json.RawMessage(`{"name":"foo"}`), nil
+			},
+			expectedStatus: StatusSucceeded,
+			expectedResult: json.RawMessage(`{"name":"foo"}`),
+		},
+		{
+			name: "stores the error of a failed operation",
+			fn: func(report func(string)) (json.RawMessage, error) {
+				return nil, errors.New("release foo failed")
+			},
+			expectedStatus: StatusFailed,
+			expectedError:  "release foo failed",
+		},
+		{
+			name: "recovers from a panic of an operation",
+			fn: func(report func(string)) (json.RawMessage, error) {
+				panic("boom")
+			},
+			expectedStatus: StatusFailed,
+			expectedError:  "Unexpected error running the operation: boom",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			m := NewManager(1, 1, time.Hour)
+			defer m.Shutdown(context.Background())
+
+			submitted, err := m.Submit("token", Operation{Action: "create", Namespace: "default", ReleaseName: "foo"}, tc.fn)
+			if err != nil {
+				t.Fatalf("%+v", err)
+			}
+			if got, want := submitted.Status, StatusPending; got != want {
+				t.Errorf("got: %q, want: %q", got, want)
+			}
+
+			op := waitForOperation(t, m, "token", submitted.ID)
+			if got, want := op.Status, tc.expectedStatus; got != want {
+				t.Errorf("got: %q, want: %q", got, want)
+			}
+			if got, want := op.Result, tc.expectedResult; !cmp.Equal(want, got) {
+				t.Errorf("mismatch (-want +got):\n%s", cmp.Diff(want, got))
+			}
+			if got, want := op.Error, tc.expectedError; got != want {
+				t.Errorf("got: %q, want: %q", got, want)
+			}
+			if op.StartedAt == nil {
+				t.Errorf("expected the start time of the operation to be set")
+			}
+		})
+	}
+}
+
+func TestManagerGetChecksOwner(t *testing.T) {
+	m := NewManager(1, 1, time.Hour)
+	defer m.Shutdown(context.Background())
+
+	op, err := m.Submit(Owner("", "token"), Operation{Action: "delete"}, func(report func(string)) (json.RawMessage, error) {
+		return nil, nil
+	})
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if _, ok := m.Get(Owner("", "other-token"), op.ID); ok {
+		t.Errorf("expected the operation to be hidden from other users")
+	}
+	if _, ok := m.Get(Owner("", "token"), "unknown"); ok {
+		t.Errorf("expected an unknown operation to not be found")
+	}
+}
+
+func TestOwner(t *testing.T) {
+	testCases := []struct {
+		name      string
+		username1 string
+		token1    string
+		username2 string
+		token2    string
+		expected  bool
+	}{
+		{
+			name:      "identifies a user by their username after refreshing their token",
+			username1: "jane@example.com",
+			token1:    "token",
+			username2: "jane@example.com",
+			token2:    "refreshed-token",
+			expected:  true,
+		},
+		{
+			name:      "distinguishes the users with the same token",
+			username1: "jane@example.com",
+			token1:    "token",
+			username2: "john@example.com",
+			token2:    "token",
+			expected:  false,
+		},
+		{
+			name:     "identifies a user without username by their token",
+			token1:   "token",
+			token2:   "token",
+			expected: true,
+		},
+		{
+			name:     "distinguishes the tokens of users without username",
+			token1:   "token",
+			token2:   "other-token",
+			expected: false,
+		},
+		{
+			// A username cannot be forged with a token of the same value.
+			name:      "distinguishes a username from a token",
+			username1: "token",
+			token1:    "other-token",
+			token2:    "token",
+			expected:  false,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got, want := Owner(tc.username1, tc.token1) == Owner(tc.username2, tc.token2), tc.expected; got != want {
+				t.Errorf("got: %t, want: %t", got, want)
+			}
+		})
+	}
+}
+
+func TestManagerQueueFull(t *testing.T) {
+	m := NewManager(1, 1, time.Hour)
+	release := make(chan struct{})
+	defer m.Shutdown(context.Background())
+	defer close(release)
+
+	blocking := func(report func(string)) (json.RawMessage, error) {
+		<-release
+		return nil, nil
+	}
+	// The first operation is taken by the worker, the second one waits in the queue.
+	first, err := m.Submit("token", Operation{}, blocking)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for op, _ := m.Get("token", first.ID); op.Status != StatusRunning; op, _ = m.Get("token", first.ID) {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for the first operation to run")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if _, err := m.Submit("token", Operation{}, blocking); err != nil {
+		t.Fatalf("%+v", err)
+	}
+
+	if _, err := m.Submit("token", Operation{}, blocking); err != ErrQueueFull {
+		t.Errorf("got: %v, want: %v", err, ErrQueueFull)
+	}
+}
+
+func TestManagerPrunesFinishedOperations(t *testing.T) {
+	m := NewManager(1, 1, time.Minute)
+	defer m.Shutdown(context.Background())
+	noop := func(report func(string)) (json.RawMessage, error) {
+		return nil, nil
+	}
+
+	old, err := m.Submit("token", Operation{}, noop)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	waitForOperation(t, m, "token", old.ID)
+
+	m.mu.Lock()
+	m.now = func() time.Time { return time.Now().Add(2 * time.Minute) }
+	m.mu.Unlock()
+	if _, err := m.Submit("token", Operation{}, noop); err != nil {
+		t.Fatalf("%+v", err)
+	}
+
+	if _, ok := m.Get("token", old.ID); ok {
+		t.Errorf("expected the operation finished before the retention period to be removed")
+	}
+}
+
+func TestManagerShutdown(t *testing.T) {
+	m := NewManager(1, 1, time.Hour)
+	if err := m.Shutdown(context.Background()); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	_, err := m.Submit("token", Operation{}, func(report func(string)) (json.RawMessage, error) {
+		return nil, nil
+	})
+	if got, want := err, ErrShutdown; got != want {
+		t.Errorf("got: %v, want: %v", got, want)
+	}
+}
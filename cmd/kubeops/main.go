@@ -2,8 +2,7 @@ package main
 
 import (
 	"context"
-	"encoding/base64"
-	"encoding/json"
+	"expvar"
 	"fmt"
 	"io/ioutil"
 	"net/http"
@@ -11,21 +10,24 @@ import (
 	"net/url"
 	"os"
 	"os/signal"
-	"path/filepath"
 	"syscall"
 	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/heptiolabs/healthcheck"
+	"github.com/kubeapps/kubeapps/cmd/kubeops/internal/assetsvc"
 	"github.com/kubeapps/kubeapps/cmd/kubeops/internal/handler"
+	"github.com/kubeapps/kubeapps/cmd/kubeops/internal/operations"
 	"github.com/kubeapps/kubeapps/pkg/agent"
 	"github.com/kubeapps/kubeapps/pkg/auth"
 	backendHandlers "github.com/kubeapps/kubeapps/pkg/http-handler"
 	"github.com/kubeapps/kubeapps/pkg/kube"
+	"github.com/kubeapps/kubeapps/pkg/oidc"
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/pflag"
 	"github.com/urfave/negroni"
 	"k8s.io/helm/pkg/helm/environment"
+	"sigs.k8s.io/yaml"
 )
 
 const clustersCAFilesPrefix = "/etc/additional-clusters-cafiles"
@@ -33,9 +35,26 @@ const clustersCAFilesPrefix = "/etc/additional-clusters-cafiles"
 var (
 	clustersConfigPath string
 	assetsvcURL        string
+	assetsvcTimeout    time.Duration
+	chartLookup        bool
+	authGateActions    []string
+	authGateAuditLog   bool
+	authGateRole       string
 	helmDriverArg      string
+	helmDriverSQLConn  string
 	listLimit          int
+	listPageSize       int
+	metricsAddress     string
+	oidcClockSkew      time.Duration
+	oidcClientID       string
+	oidcGroupsClaim    string
+	oidcIssuerURL      string
+	oidcUsernameClaim  string
+	operationQueueSize int
+	operationRetention time.Duration
+	operationWorkers   int
 	pinnipedProxyURL   string
+	postRendererPath   string
 	settings           environment.EnvSettings
 	timeout            int64
 	userAgentComment   string
@@ -44,12 +63,30 @@ var (
 func init() {
 	settings.AddFlags(pflag.CommandLine)
 	pflag.StringVar(&assetsvcURL, "assetsvc-url", "https://kubeapps-internal-assetsvc:8080", "URL to the internal assetsvc")
+	pflag.DurationVar(&assetsvcTimeout, "assetsvc-timeout", 10*time.Second, "Timeout of the requests to the internal assetsvc")
+	pflag.BoolVar(&chartLookup, "chart-lookup", true, "Look up the charts of the releases in the assetsvc to find their app repositories and latest versions")
 	pflag.StringVar(&helmDriverArg, "helm-driver", "", "which Helm driver type to use")
+	pflag.StringVar(&helmDriverSQLConn, "helm-driver-sql-connection-string", os.Getenv("HELM_DRIVER_SQL_CONNECTION_STRING"), "PostgreSQL connection string used by the sql Helm driver")
 	pflag.IntVar(&listLimit, "list-max", 256, "maximum number of releases to fetch")
+	pflag.CommandLine.MarkDeprecated("list-max", "the releases are now listed in pages, use --list-page-size instead")
+	pflag.IntVar(&listPageSize, "list-page-size", 256, "Default and maximum number of releases returned in a page of the release list")
 	pflag.StringVar(&userAgentComment, "user-agent-comment", "", "UserAgent comment used during outbound requests")
 	// Default timeout from https://github.com/helm/helm/blob/b0b0accdfc84e154b3d48ec334cd5b4f9b345667/cmd/helm/install.go#L216
 	pflag.Int64Var(&timeout, "timeout", 300, "Timeout to perform release operations (install, upgrade, rollback, delete)")
 	pflag.StringVar(&clustersConfigPath, "clusters-config-path", "", "Configuration for clusters")
+	pflag.StringVar(&postRendererPath, "post-renderer-config-path", "", "Configuration of the transformations applied to the resources of the releases, per cluster and namespace")
+	pflag.IntVar(&operationWorkers, "operation-workers", 5, "Number of workers running asynchronous release operations")
+	pflag.IntVar(&operationQueueSize, "operation-queue-size", 100, "Maximum number of asynchronous release operations waiting for a worker")
+	pflag.DurationVar(&operationRetention, "operation-retention", time.Hour, "Time to keep the result of finished asynchronous release operations")
+	pflag.StringArrayVar(&authGateActions, "auth-gate-action", nil, "Action, such as \"list apprepositories.kubeapps.com\", that users should be allowed in a namespace to view its charts. Can be repeated. Defaults to \"get secrets\" unless a ClusterRole is configured")
+	pflag.StringVar(&authGateRole, "auth-gate-cluster-role", "", "Name of a ClusterRole whose rules users should have in a namespace to view its charts")
+	pflag.BoolVar(&authGateAuditLog, "auth-gate-audit-log", false, "Log every decision of the authorization of the access to the charts of namespaces")
+	pflag.StringVar(&oidcIssuerURL, "oidc-issuer-url", "", "URL of the OIDC issuer whose ID tokens are verified before reaching the API server. Disabled if empty")
+	pflag.StringVar(&oidcClientID, "oidc-client-id", "", "Client ID which the OIDC ID tokens should be issued for")
+	pflag.StringVar(&oidcUsernameClaim, "oidc-username-claim", "sub", "Claim of the OIDC ID tokens used as the username")
+	pflag.StringVar(&oidcGroupsClaim, "oidc-groups-claim", "groups", "Claim of the OIDC ID tokens used as the groups")
+	pflag.DurationVar(&oidcClockSkew, "oidc-clock-skew", 30*time.Second, "Tolerated difference between the clocks of the OIDC issuer and kubeops")
+	pflag.StringVar(&metricsAddress, "metrics-address", "", "Address, such as \"127.0.0.1:9090\", of an internal listener serving the metrics at /debug/vars. Disabled if empty")
 	pflag.StringVar(&pinnipedProxyURL, "pinniped-proxy-url", "http://kubeapps-internal-pinniped-proxy.kubeapps:3333", "internal url to be used for requests to clusters configured for credential proxying via pinniped")
 }
 
@@ -74,17 +111,36 @@ func main() {
 		defer cleanupCAFiles()
 	}
 
+	// The releases fetched with the deprecated --list-max are now the first page.
+	if pflag.CommandLine.Changed("list-max") && !pflag.CommandLine.Changed("list-page-size") {
+		listPageSize = listLimit
+	}
+
+	var postRenderers agent.PostRendererConfigs
+	if postRendererPath != "" {
+		var err error
+		postRenderers, err = parsePostRendererConfig(postRendererPath)
+		if err != nil {
+			log.Fatalf("unable to parse post-renderer config: %+v", err)
+		}
+	}
+
 	options := handler.Options{
-		ListLimit:         listLimit,
+		ListLimit:         listPageSize,
 		Timeout:           timeout,
 		KubeappsNamespace: kubeappsNamespace,
 		ClustersConfig:    clustersConfig,
+		Operations:        operations.NewManager(operationWorkers, operationQueueSize, operationRetention),
+		PostRenderers:     postRenderers,
+	}
+	if chartLookup {
+		options.ChartFinder = assetsvc.NewClient(assetsvcURL, assetsvcTimeout)
 	}
 
 	storageForDriver := agent.StorageForSecrets
 	if helmDriverArg != "" {
 		var err error
-		storageForDriver, err = agent.ParseDriverType(helmDriverArg)
+		storageForDriver, err = agent.ParseDriverType(helmDriverArg, helmDriverSQLConn)
 		if err != nil {
 			panic(err)
 		}
@@ -104,21 +160,38 @@ func main() {
 	addRoute("GET", "/clusters/{cluster}/releases", handler.ListAllReleases)
 	addRoute("GET", "/clusters/{cluster}/namespaces/{namespace}/releases", handler.ListReleases)
 	addRoute("POST", "/clusters/{cluster}/namespaces/{namespace}/releases", handler.CreateRelease)
+	addRoute("POST", "/clusters/{cluster}/namespaces/{namespace}/releases/import", handler.ImportRelease)
 	addRoute("GET", "/clusters/{cluster}/namespaces/{namespace}/releases/{releaseName}", handler.GetRelease)
 	addRoute("PUT", "/clusters/{cluster}/namespaces/{namespace}/releases/{releaseName}", handler.OperateRelease)
 	addRoute("DELETE", "/clusters/{cluster}/namespaces/{namespace}/releases/{releaseName}", handler.DeleteRelease)
+	addRoute("POST", "/clusters/{cluster}/namespaces/{namespace}/releases/{releaseName}/diff", handler.DiffRelease)
+	addRoute("GET", "/clusters/{cluster}/namespaces/{namespace}/releases/{releaseName}/history", handler.GetReleaseHistory)
+	addRoute("GET", "/clusters/{cluster}/namespaces/{namespace}/releases/{releaseName}/values", handler.GetReleaseValues)
+	addRoute("GET", "/clusters/{cluster}/namespaces/{namespace}/releases/{releaseName}/apprepository", handler.GetReleaseAppRepository)
+	addRoute("PUT", "/clusters/{cluster}/namespaces/{namespace}/releases/{releaseName}/apprepository", handler.SetReleaseAppRepository)
+	addRoute("GET", "/clusters/{cluster}/namespaces/{namespace}/releases/{releaseName}/export", handler.ExportRelease)
+	addRoute("GET", "/clusters/{cluster}/namespaces/{namespace}/releases/{releaseName}/status", handler.GetReleaseResourcesStatus)
+	addRoute("GET", "/clusters/{cluster}/namespaces/{namespace}/releases/{releaseName}/watch", handler.WatchReleaseResources)
+	addRoute("GET", "/clusters/{cluster}/namespaces/{namespace}/releases/{releaseName}/pods", handler.GetReleasePods)
+	addRoute("GET", "/clusters/{cluster}/namespaces/{namespace}/releases/{releaseName}/pods/{podName}/logs", handler.GetPodLogs)
+	addRoute("GET", "/operations/{operationID}", handler.GetOperation)
+	addRoute("POST", "/releases/batch", handler.BatchOperateReleases)
 
 	// Backend routes unrelated to kubeops functionality.
-	err := backendHandlers.SetupDefaultRoutes(r.PathPrefix("/backend/v1").Subrouter(), clustersConfig)
+	err := backendHandlers.SetupDefaultRoutes(r.PathPrefix("/backend/v1").Subrouter(), clustersConfig, helmDriverArg)
 	if err != nil {
 		log.Fatalf("Unable to setup backend routes: %+v", err)
 	}
 
 	// assetsvc reverse proxy
 	// TODO(mnelson) remove this reverse proxy once the haproxy frontend
-	// proxies requests directly to the assetsvc. Move the authz to the
-	// assetsvc itself.
-	authGate := auth.AuthGate(clustersConfig, kubeappsNamespace)
+	// proxies requests directly to the assetsvc, which can authorize them
+	// itself with --enable-auth-gate.
+	authGateConfig, err := auth.NewAuthGateConfig(authGateActions, authGateRole, authGateAuditLog)
+	if err != nil {
+		log.Fatalf("Unable to parse the auth gate configuration: %+v", err)
+	}
+	authGate := auth.AuthGate(clustersConfig, kubeappsNamespace, authGateConfig)
 	parsedAssetsvcURL, err := url.Parse(assetsvcURL)
 	if err != nil {
 		log.Fatalf("Unable to parse the assetsvc URL: %v", err)
@@ -137,6 +210,19 @@ func main() {
 	))
 
 	n := negroni.Classic()
+	if oidcIssuerURL != "" {
+		verifier, err := oidc.NewVerifier(context.Background(), oidc.Config{
+			IssuerURL:     oidcIssuerURL,
+			Audience:      oidcClientID,
+			UsernameClaim: oidcUsernameClaim,
+			GroupsClaim:   oidcGroupsClaim,
+			ClockSkew:     oidcClockSkew,
+		})
+		if err != nil {
+			log.Fatalf("Unable to create the OIDC verifier: %+v", err)
+		}
+		n.Use(oidc.Middleware(verifier))
+	}
 	n.UseHandler(r)
 
 	port := os.Getenv("PORT")
@@ -150,6 +236,17 @@ func main() {
 		Handler: n,
 	}
 
+	if metricsAddress != "" {
+		// Metrics, such as the hits of the cache of the authorization reviews, are
+		// served apart from the API as they are not authenticated.
+		go func() {
+			log.WithFields(log.Fields{"addr": metricsAddress}).Info("Serving metrics")
+			if err := http.ListenAndServe(metricsAddress, metricsHandler()); err != nil {
+				log.Errorf("Unable to serve the metrics: %v", err)
+			}
+		}()
+	}
+
 	go func() {
 		log.WithFields(log.Fields{"addr": addr}).Info("Started Kubeops")
 		err := srv.ListenAndServe()
@@ -172,55 +269,50 @@ func main() {
 	// Doesn't block if no connections, but will otherwise wait
 	// until the timeout deadline.
 	srv.Shutdown(ctx)
+	// Asynchronous operations are not bound to a request, so wait for them as well.
+	if err := options.Operations.Shutdown(ctx); err != nil {
+		log.Errorf("Unable to wait for the pending operations: %v", err)
+	}
 	log.Info("All requests have been served. Exiting")
 	os.Exit(0)
 }
 
-func parseClusterConfig(configPath, caFilesPrefix string) (kube.ClustersConfig, func(), error) {
-	caFilesDir, err := ioutil.TempDir(caFilesPrefix, "")
-	if err != nil {
-		return kube.ClustersConfig{}, func() {}, err
-	}
-	deferFn := func() { os.RemoveAll(caFilesDir) }
+// metricsHandler serves the variables published with expvar as expvar.Handler, except
+// the command line, which includes secrets such as the SQL connection string.
+func metricsHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/vars", func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		fmt.Fprintf(w, "{\n")
+		first := true
+		expvar.Do(func(kv expvar.KeyValue) {
+			if kv.Key == "cmdline" {
+				return
+			}
+			if !first {
+				fmt.Fprintf(w, ",\n")
+			}
+			first = false
+			fmt.Fprintf(w, "%q: %s", kv.Key, kv.Value)
+		})
+		fmt.Fprintf(w, "\n}\n")
+	})
+	return mux
+}
+
+// parsePostRendererConfig reads the post-renderer configuration, in YAML or JSON.
+func parsePostRendererConfig(configPath string) (agent.PostRendererConfigs, error) {
 	content, err := ioutil.ReadFile(configPath)
 	if err != nil {
-		return kube.ClustersConfig{}, deferFn, err
+		return agent.PostRendererConfigs{}, err
 	}
-
-	var clusterConfigs []kube.ClusterConfig
-	if err = json.Unmarshal(content, &clusterConfigs); err != nil {
-		return kube.ClustersConfig{}, deferFn, err
+	var configs agent.PostRendererConfigs
+	if err := yaml.UnmarshalStrict(content, &configs); err != nil {
+		return agent.PostRendererConfigs{}, err
 	}
+	return configs, nil
+}
 
-	configs := kube.ClustersConfig{Clusters: map[string]kube.ClusterConfig{}}
-	configs.PinnipedProxyURL = pinnipedProxyURL
-	for _, c := range clusterConfigs {
-		if c.APIServiceURL == "" {
-			if configs.KubeappsClusterName == "" {
-				configs.KubeappsClusterName = c.Name
-			} else {
-				return kube.ClustersConfig{}, nil, fmt.Errorf("only one cluster can be configured without an apiServiceURL, two defined: %q, %q", configs.KubeappsClusterName, c.Name)
-			}
-		}
-
-		// We need to decode the base64-encoded cadata from the input.
-		if c.CertificateAuthorityData != "" {
-			decodedCAData, err := base64.StdEncoding.DecodeString(c.CertificateAuthorityData)
-			if err != nil {
-				return kube.ClustersConfig{}, deferFn, err
-			}
-			c.CertificateAuthorityDataDecoded = string(decodedCAData)
-
-			// We also need a CAFile field because Helm uses the genericclioptions.ConfigFlags
-			// struct which does not support CAData.
-			// https://github.com/kubernetes/cli-runtime/issues/8
-			c.CAFile = filepath.Join(caFilesDir, c.Name)
-			err = ioutil.WriteFile(c.CAFile, decodedCAData, 0644)
-			if err != nil {
-				return kube.ClustersConfig{}, deferFn, err
-			}
-		}
-		configs.Clusters[c.Name] = c
-	}
-	return configs, deferFn, nil
+func parseClusterConfig(configPath, caFilesPrefix string) (kube.ClustersConfig, func(), error) {
+	return kube.ParseClusterConfig(configPath, caFilesPrefix, pinnipedProxyURL)
 }
@@ -1,12 +1,16 @@
 package main
 
 import (
+	"encoding/json"
+	"expvar"
 	"io/ioutil"
+	"net/http/httptest"
 	"os"
 	"testing"
 
 	"github.com/google/go-cmp/cmp"
 	"github.com/google/go-cmp/cmp/cmpopts"
+	"github.com/kubeapps/kubeapps/pkg/agent"
 	"github.com/kubeapps/kubeapps/pkg/kube"
 )
 
@@ -159,6 +163,103 @@ func TestParseClusterConfig(t *testing.T) {
 	}
 }
 
+func TestParsePostRendererConfig(t *testing.T) {
+	testCases := []struct {
+		name           string
+		config         string
+		expectedErr    bool
+		expectedConfig agent.PostRendererConfigs
+	}{
+		{
+			name: "parses the configs per cluster and namespace",
+			config: `
+default:
+  labels:
+    team: platform
+clusters:
+  prod:
+    namespaces:
+      payments:
+        nodeSelector:
+          pool: pci
+        patchesConfigMap: kubeapps-patches
+`,
+			expectedConfig: agent.PostRendererConfigs{
+				Default: &agent.PostRendererConfig{Labels: map[string]string{"team": "platform"}},
+				Clusters: map[string]agent.ClusterPostRendererConfig{
+					"prod": {
+						Namespaces: map[string]agent.PostRendererConfig{
+							"payments": {
+								NodeSelector:     map[string]string{"pool": "pci"},
+								PatchesConfigMap: "kubeapps-patches",
+							},
+						},
+					},
+				},
+			},
+		},
+		{
+			name: "parses the pod spec paths of custom resources",
+			config: `
+podSpecPaths:
+  Workflow.argoproj.io: spec.templates.podSpec
+default:
+  imagePullSecrets:
+  - mirror-creds
+`,
+			expectedConfig: agent.PostRendererConfigs{
+				PodSpecPaths: agent.PodSpecPaths{{Group: "argoproj.io", Kind: "Workflow"}: {"spec", "templates", "podSpec"}},
+				Default:      &agent.PostRendererConfig{ImagePullSecrets: []string{"mirror-creds"}},
+			},
+		},
+		{
+			name: "errors on the pod spec paths of kinds without group",
+			config: `
+podSpecPaths:
+  Workflow: spec.templates.podSpec
+`,
+			expectedErr: true,
+		},
+		{
+			name:        "errors on unknown fields",
+			config:      `default: {label: {team: platform}}`,
+			expectedErr: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			path := createConfigFile(t, tc.config)
+			defer os.Remove(path)
+
+			config, err := parsePostRendererConfig(path)
+			if got, want := err != nil, tc.expectedErr; got != want {
+				t.Errorf("got: %t, want: %t: err: %+v", got, want, err)
+			}
+			if got, want := config, tc.expectedConfig; !cmp.Equal(want, got) {
+				t.Errorf("mismatch (-want +got):\n%s", cmp.Diff(want, got))
+			}
+		})
+	}
+}
+
+func TestMetricsHandler(t *testing.T) {
+	expvar.NewInt("kubeops_test_metric").Set(3)
+	response := httptest.NewRecorder()
+	metricsHandler().ServeHTTP(response, httptest.NewRequest("GET", "/debug/vars", nil))
+
+	var vars map[string]interface{}
+	if err := json.Unmarshal(response.Body.Bytes(), &vars); err != nil {
+		t.Fatalf("%+v. Body: %s", err, response.Body)
+	}
+	if got, want := vars["kubeops_test_metric"], float64(3); got != want {
+		t.Errorf("got: %v, want: %v", got, want)
+	}
+	if _, ok := vars["cmdline"]; ok {
+		t.Errorf("the command line should not be served")
+	}
+}
+
 func createConfigFile(t *testing.T, content string) string {
 	tmpfile, err := ioutil.TempFile("", "")
 	if err != nil {
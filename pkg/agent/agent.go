@@ -1,10 +1,16 @@
 package agent
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
 	"strings"
+	"time"
 
+	chartUtils "github.com/kubeapps/kubeapps/pkg/chart"
 	"github.com/kubeapps/kubeapps/pkg/chart/helm3to2"
 	"github.com/kubeapps/kubeapps/pkg/proxy"
 	log "github.com/sirupsen/logrus"
@@ -12,9 +18,12 @@ import (
 	"helm.sh/helm/v3/pkg/chart"
 	"helm.sh/helm/v3/pkg/chartutil"
 	"helm.sh/helm/v3/pkg/kube"
+	"helm.sh/helm/v3/pkg/postrender"
 	"helm.sh/helm/v3/pkg/release"
 	"helm.sh/helm/v3/pkg/storage"
 	"helm.sh/helm/v3/pkg/storage/driver"
+	helmTime "helm.sh/helm/v3/pkg/time"
+	corev1 "k8s.io/api/core/v1"
 	"k8s.io/cli-runtime/pkg/genericclioptions"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
@@ -22,63 +31,113 @@ import (
 )
 
 // StorageForDriver is a function type which returns a specific storage.
-type StorageForDriver func(namespace string, clientset *kubernetes.Clientset) *storage.Storage
+type StorageForDriver func(namespace string, clientset *kubernetes.Clientset) (*storage.Storage, error)
 
 // StorageForSecrets returns a storage using the Secret driver.
-func StorageForSecrets(namespace string, clientset *kubernetes.Clientset) *storage.Storage {
+func StorageForSecrets(namespace string, clientset *kubernetes.Clientset) (*storage.Storage, error) {
 	d := driver.NewSecrets(clientset.CoreV1().Secrets(namespace))
 	d.Log = log.Infof
-	return storage.Init(d)
+	return storage.Init(d), nil
 }
 
 // StorageForConfigMaps returns a storage using the ConfigMap driver.
-func StorageForConfigMaps(namespace string, clientset *kubernetes.Clientset) *storage.Storage {
+func StorageForConfigMaps(namespace string, clientset *kubernetes.Clientset) (*storage.Storage, error) {
 	d := driver.NewConfigMaps(clientset.CoreV1().ConfigMaps(namespace))
 	d.Log = log.Infof
-	return storage.Init(d)
+	return storage.Init(d), nil
 }
 
 // StorageForMemory returns a storage using the Memory driver.
-func StorageForMemory(_ string, _ *kubernetes.Clientset) *storage.Storage {
+func StorageForMemory(_ string, _ *kubernetes.Clientset) (*storage.Storage, error) {
 	d := driver.NewMemory()
-	return storage.Init(d)
+	return storage.Init(d), nil
 }
 
-// ListReleases lists releases in the specified namespace, or all namespaces if the empty string is given.
-func ListReleases(actionConfig *action.Configuration, namespace string, listLimit int, status string) ([]proxy.AppOverview, error) {
-	allNamespaces := namespace == ""
-	cmd := action.NewList(actionConfig)
-	if allNamespaces {
-		cmd.AllNamespaces = true
+// StorageForSQL returns a function creating storages using the database and schema
+// of the SQL driver of Helm, in PostgreSQL. The database is opened once and shared by
+// the storages, each created for the namespace and user of a request. As releases are
+// not stored in Kubernetes, the actions of users on them are authorized as with the
+// secrets driver, with SelfSubjectAccessReviews on the secrets of the namespace.
+func StorageForSQL(connectionString string) (StorageForDriver, error) {
+	if connectionString == "" {
+		return nil, errors.New("The SQL Helm driver requires a connection string")
 	}
-	cmd.Limit = listLimit
-	if status == "all" {
-		cmd.StateMask = action.ListAll
+	db, err := openSQLDatabase(connectionString)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to connect to the SQL Helm driver database: %v", err)
 	}
-	releases, err := cmd.Run()
+	return func(namespace string, clientset *kubernetes.Clientset) (*storage.Storage, error) {
+		return storage.Init(newSQLDriver(db, namespace, secretsAccessReviewer(clientset))), nil
+	}, nil
+}
+
+// newInstall returns an install action configured with the given release options.
+func newInstall(actionConfig *action.Configuration, name, namespace string, registrySecrets map[string]string, podSpecPaths PodSpecPaths, options chartUtils.ReleaseOptions, transformers []ResourceTransformer) (*action.Install, error) {
+	cmd := action.NewInstall(actionConfig)
+	cmd.ReleaseName = name
+	cmd.Namespace = namespace
+	cmd.Wait = options.Wait
+	cmd.WaitForJobs = options.WaitForJobs
+	cmd.Atomic = options.IsAtomic()
+	cmd.Timeout = time.Duration(options.Timeout) * time.Second
+	cmd.SkipCRDs = options.SkipCRDs
+	cmd.DisableHooks = options.DisableHooks
+	cmd.CreateNamespace = options.CreateNamespace
+	cmd.Description = options.Description
+	var err error
+	cmd.PostRenderer, err = newPostRenderer(registrySecrets, podSpecPaths, transformers)
 	if err != nil {
 		return nil, err
 	}
-	appOverviews := make([]proxy.AppOverview, 0)
-	for _, r := range releases {
-		if allNamespaces || r.Namespace == namespace {
-			appOverviews = append(appOverviews, appOverviewFromRelease(r))
-		}
+	return cmd, nil
+}
+
+// newPostRenderer returns the post renderer running the given transformers and
+// then adding the image pull secrets of the registries, so that the secrets match
+// the final images, such as the ones rewritten to a mirror.
+func newPostRenderer(registrySecrets map[string]string, podSpecPaths PodSpecPaths, transformers []ResourceTransformer) (postrender.PostRenderer, error) {
+	dockerSecrets, err := NewDockerSecretsPostRenderer(registrySecrets, podSpecPaths)
+	if err != nil {
+		return nil, err
+	}
+	if len(transformers) == 0 {
+		return dockerSecrets, nil
+	}
+	pipeline := append([]ResourceTransformer{}, transformers...)
+	return NewPostRendererPipeline(append(pipeline, dockerSecrets)...), nil
+}
+
+// newUpgrade returns an upgrade action configured with the given release options.
+func newUpgrade(actionConfig *action.Configuration, registrySecrets map[string]string, podSpecPaths PodSpecPaths, options chartUtils.ReleaseOptions, transformers []ResourceTransformer) (*action.Upgrade, error) {
+	cmd := action.NewUpgrade(actionConfig)
+	cmd.Wait = options.Wait
+	cmd.WaitForJobs = options.WaitForJobs
+	cmd.Atomic = options.IsAtomic()
+	cmd.Timeout = time.Duration(options.Timeout) * time.Second
+	cmd.SkipCRDs = options.SkipCRDs
+	cmd.DisableHooks = options.DisableHooks
+	cmd.ResetValues = options.ResetValues
+	cmd.ReuseValues = options.ReuseValues
+	cmd.Force = options.Force
+	cmd.Description = options.Description
+	var err error
+	cmd.PostRenderer, err = newPostRenderer(registrySecrets, podSpecPaths, transformers)
+	if err != nil {
+		return nil, err
 	}
-	return appOverviews, nil
+	return cmd, nil
 }
 
 // CreateRelease creates a release.
-func CreateRelease(actionConfig *action.Configuration, name, namespace, valueString string, ch *chart.Chart, registrySecrets map[string]string) (*release.Release, error) {
+// Unless the atomic option is explicitly set, a failed release is deleted
+// without waiting for its resources to be ready.
+func CreateRelease(actionConfig *action.Configuration, name, namespace, valueString string, ch *chart.Chart, registrySecrets map[string]string, podSpecPaths PodSpecPaths, options chartUtils.ReleaseOptions, transformers ...ResourceTransformer) (*release.Release, error) {
 	// Check if the release already exists
 	_, err := GetRelease(actionConfig, name)
 	if err == nil {
 		return nil, fmt.Errorf("release %s already exists", name)
 	}
-	cmd := action.NewInstall(actionConfig)
-	cmd.ReleaseName = name
-	cmd.Namespace = namespace
-	cmd.PostRenderer, err = NewDockerSecretsPostRenderer(registrySecrets)
+	cmd, err := newInstall(actionConfig, name, namespace, registrySecrets, podSpecPaths, options, transformers)
 	if err != nil {
 		return nil, err
 	}
@@ -88,6 +147,10 @@ func CreateRelease(actionConfig *action.Configuration, name, namespace, valueStr
 	}
 	release, err := cmd.Run(ch, values)
 	if err != nil {
+		if options.Atomic != nil {
+			// Helm already uninstalled the release if atomic was requested.
+			return nil, fmt.Errorf("Release %q failed: %v", name, err)
+		}
 		// Simulate the Atomic flag and delete the release if failed
 		errDelete := DeleteRelease(actionConfig, name, false)
 		if errDelete != nil && !strings.Contains(errDelete.Error(), "release: not found") {
@@ -98,17 +161,39 @@ func CreateRelease(actionConfig *action.Configuration, name, namespace, valueStr
 	return release, nil
 }
 
+// DryRunCreateRelease renders a release as CreateRelease would, including the
+// post-rendering of image pull secrets, without installing anything in the cluster.
+func DryRunCreateRelease(actionConfig *action.Configuration, name, namespace, valueString string, ch *chart.Chart, registrySecrets map[string]string, podSpecPaths PodSpecPaths, options chartUtils.ReleaseOptions, transformers ...ResourceTransformer) (*release.Release, error) {
+	// Check if the release already exists
+	_, err := GetRelease(actionConfig, name)
+	if err == nil {
+		return nil, fmt.Errorf("release %s already exists", name)
+	}
+	cmd, err := newInstall(actionConfig, name, namespace, registrySecrets, podSpecPaths, options, transformers)
+	if err != nil {
+		return nil, err
+	}
+	cmd.DryRun = true
+	values, err := getValues([]byte(valueString))
+	if err != nil {
+		return nil, err
+	}
+	release, err := cmd.Run(ch, values)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to render the release: %v", err)
+	}
+	return release, nil
+}
+
 // UpgradeRelease upgrades a release.
-func UpgradeRelease(actionConfig *action.Configuration, name, valuesYaml string, ch *chart.Chart, registrySecrets map[string]string) (*release.Release, error) {
+func UpgradeRelease(actionConfig *action.Configuration, name, valuesYaml string, ch *chart.Chart, registrySecrets map[string]string, podSpecPaths PodSpecPaths, options chartUtils.ReleaseOptions, transformers ...ResourceTransformer) (*release.Release, error) {
 	// Check if the release already exists:
 	_, err := GetRelease(actionConfig, name)
 	if err != nil {
 		return nil, err
 	}
 	log.Printf("Upgrading release %s", name)
-	cmd := action.NewUpgrade(actionConfig)
-
-	cmd.PostRenderer, err = NewDockerSecretsPostRenderer(registrySecrets)
+	cmd, err := newUpgrade(actionConfig, registrySecrets, podSpecPaths, options, transformers)
 	if err != nil {
 		return nil, err
 	}
@@ -123,6 +208,30 @@ func UpgradeRelease(actionConfig *action.Configuration, name, valuesYaml string,
 	return res, nil
 }
 
+// DryRunUpgradeRelease renders the upgrade of a release as UpgradeRelease would,
+// without modifying the release or its resources.
+func DryRunUpgradeRelease(actionConfig *action.Configuration, name, valuesYaml string, ch *chart.Chart, registrySecrets map[string]string, podSpecPaths PodSpecPaths, options chartUtils.ReleaseOptions, transformers ...ResourceTransformer) (*release.Release, error) {
+	// Check if the release already exists:
+	_, err := GetRelease(actionConfig, name)
+	if err != nil {
+		return nil, err
+	}
+	cmd, err := newUpgrade(actionConfig, registrySecrets, podSpecPaths, options, transformers)
+	if err != nil {
+		return nil, err
+	}
+	cmd.DryRun = true
+	values, err := chartutil.ReadValues([]byte(valuesYaml))
+	if err != nil {
+		return nil, fmt.Errorf("Unable to render the upgrade because values could not be parsed: %v", err)
+	}
+	res, err := cmd.Run(name, ch, values)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to render the upgrade: %v", err)
+	}
+	return res, nil
+}
+
 // RollbackRelease rolls back a release to the specified revision.
 func RollbackRelease(actionConfig *action.Configuration, releaseName string, revision int) (*release.Release, error) {
 	log.Printf("Rolling back %s to revision %d.", releaseName, revision)
@@ -149,6 +258,119 @@ func GetRelease(actionConfig *action.Configuration, name string) (*release.Relea
 	return release, nil
 }
 
+// GetReleaseRevision returns the info of a specific revision of a release.
+func GetReleaseRevision(actionConfig *action.Configuration, name string, revision int) (*release.Release, error) {
+	// Namespace is already known by the RESTClientGetter.
+	cmd := action.NewGet(actionConfig)
+	cmd.Version = revision
+	release, err := cmd.Run(name)
+	if err != nil {
+		return nil, err
+	}
+	return release, nil
+}
+
+// ReleaseRevision represents a single revision in the history of a release.
+type ReleaseRevision struct {
+	Revision      int           `json:"revision"`
+	Status        string        `json:"status"`
+	ChartVersion  string        `json:"chartVersion"`
+	AppVersion    string        `json:"appVersion"`
+	Description   string        `json:"description"`
+	FirstDeployed helmTime.Time `json:"firstDeployed"`
+	LastDeployed  helmTime.Time `json:"lastDeployed"`
+	Deleted       helmTime.Time `json:"deleted"`
+}
+
+// GetReleaseHistory returns the revisions of a release, sorted from the oldest to the newest.
+func GetReleaseHistory(actionConfig *action.Configuration, name string) ([]ReleaseRevision, error) {
+	// Namespace is already known by the RESTClientGetter.
+	cmd := action.NewHistory(actionConfig)
+	releases, err := cmd.Run(name)
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(releases, func(i, j int) bool { return releases[i].Version < releases[j].Version })
+	revisions := make([]ReleaseRevision, 0, len(releases))
+	for _, r := range releases {
+		revisions = append(revisions, releaseRevisionFromRelease(r))
+	}
+	return revisions, nil
+}
+
+// TestRelease runs the test hooks of a release and returns the status of each
+// test pod, keyed by the phase of the hook, together with its logs when available.
+// An error is only returned if the tests could not be run at all; failing tests
+// are reported with the "Failed" phase.
+func TestRelease(actionConfig *action.Configuration, clientset kubernetes.Interface, name string, timeout int64) (*proxy.TestStatus, error) {
+	// Namespace is already known by the RESTClientGetter.
+	cmd := action.NewReleaseTesting(actionConfig)
+	cmd.Timeout = time.Duration(timeout) * time.Second
+	log.Printf("Running tests for release %s", name)
+	rel, err := cmd.Run(name)
+	if err != nil {
+		if rel == nil {
+			return nil, err
+		}
+		log.Infof("Tests for release %q did not succeed: %v", name, err)
+	}
+
+	testStatus := proxy.TestStatus{}
+	for _, h := range rel.Hooks {
+		if !isTestHook(h) {
+			continue
+		}
+		phase := release.HookPhaseUnknown
+		if h.LastRun.Phase != "" {
+			phase = h.LastRun.Phase
+		}
+		message := h.Name
+		if h.Kind == "Pod" {
+			logs, err := getPodLogs(clientset, rel.Namespace, h.Name, testLogsLimit)
+			if err != nil {
+				// The pod may have been removed by a deletion policy of the hook.
+				log.Infof("Unable to get the logs of the test pod %q: %v", h.Name, err)
+			} else if logs != "" {
+				message = fmt.Sprintf("%s:\n%s", h.Name, logs)
+			}
+		}
+		testStatus[phase.String()] = append(testStatus[phase.String()], message)
+	}
+	return &testStatus, nil
+}
+
+func isTestHook(h *release.Hook) bool {
+	for _, e := range h.Events {
+		if e == release.HookTest {
+			return true
+		}
+	}
+	return false
+}
+
+// testLogsLimit is the maximum size of the logs of a test pod returned with the
+// test results, so that a verbose test pod cannot exhaust the memory of kubeops.
+const testLogsLimit = 64 * 1024
+
+// getPodLogs returns the logs of a pod, truncated to limit bytes with a note.
+func getPodLogs(clientset kubernetes.Interface, namespace, name string, limit int64) (string, error) {
+	// One more byte is read to tell whether the logs are truncated.
+	readLimit := limit + 1
+	logs, err := clientset.CoreV1().Pods(namespace).GetLogs(name, &corev1.PodLogOptions{LimitBytes: &readLimit}).Stream(context.TODO())
+	if err != nil {
+		return "", err
+	}
+	defer logs.Close()
+	body, err := ioutil.ReadAll(io.LimitReader(logs, readLimit))
+	if err != nil {
+		return "", err
+	}
+	if int64(len(body)) > limit {
+		return fmt.Sprintf("%s\n[The logs are truncated to their first %d bytes]", body[:limit], limit), nil
+	}
+	return string(body), nil
+}
+
 // DeleteRelease deletes a release.
 func DeleteRelease(actionConfig *action.Configuration, name string, keepHistory bool) error {
 	// Namespace is already known by the RESTClientGetter.
@@ -162,7 +384,10 @@ func DeleteRelease(actionConfig *action.Configuration, name string, keepHistory
 // Among other things, the action.Configuration controls which namespace the command is run against.
 func NewActionConfig(storageForDriver StorageForDriver, config *rest.Config, clientset *kubernetes.Clientset, namespace string) (*action.Configuration, error) {
 	actionConfig := new(action.Configuration)
-	store := storageForDriver(namespace, clientset)
+	store, err := storageForDriver(namespace, clientset)
+	if err != nil {
+		return nil, err
+	}
 	restClientGetter := NewConfigFlagsFromCluster(namespace, config)
 	actionConfig.RESTClientGetter = restClientGetter
 	actionConfig.KubeClient = kube.New(restClientGetter)
@@ -209,7 +434,8 @@ func stringptr(val string) *string {
 }
 
 // ParseDriverType maps strings to well-typed driver representations.
-func ParseDriverType(raw string) (StorageForDriver, error) {
+// The connection string is only used by the SQL driver.
+func ParseDriverType(raw, sqlConnectionString string) (StorageForDriver, error) {
 	switch raw {
 	case "secret", "secrets":
 		return StorageForSecrets, nil
@@ -217,11 +443,31 @@ func ParseDriverType(raw string) (StorageForDriver, error) {
 		return StorageForConfigMaps, nil
 	case "memory":
 		return StorageForMemory, nil
+	case "sql":
+		return StorageForSQL(sqlConnectionString)
 	default:
 		return nil, errors.New("Invalid Helm driver type: " + raw)
 	}
 }
 
+func releaseRevisionFromRelease(r *release.Release) ReleaseRevision {
+	revision := ReleaseRevision{
+		Revision: r.Version,
+	}
+	if r.Info != nil {
+		revision.Status = r.Info.Status.String()
+		revision.Description = r.Info.Description
+		revision.FirstDeployed = r.Info.FirstDeployed
+		revision.LastDeployed = r.Info.LastDeployed
+		revision.Deleted = r.Info.Deleted
+	}
+	if r.Chart != nil && r.Chart.Metadata != nil {
+		revision.ChartVersion = r.Chart.Metadata.Version
+		revision.AppVersion = r.Chart.Metadata.AppVersion
+	}
+	return revision
+}
+
 func appOverviewFromRelease(r *release.Release) proxy.AppOverview {
 	r2Metadata := helm3to2.ConvertMetadata(*r.Chart.Metadata)
 	return proxy.AppOverview{
@@ -232,5 +478,6 @@ func appOverviewFromRelease(r *release.Release) proxy.AppOverview {
 		Status:        r.Info.Status.String(),
 		Chart:         r.Chart.Name(),
 		ChartMetadata: *r2Metadata,
+		Updated:       r.Info.LastDeployed.Time,
 	}
 }
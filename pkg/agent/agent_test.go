@@ -1,6 +1,7 @@
 package agent
 
 import (
+	"errors"
 	"io/ioutil"
 	"sort"
 	"testing"
@@ -15,6 +16,7 @@ import (
 	"helm.sh/helm/v3/pkg/storage"
 	"helm.sh/helm/v3/pkg/storage/driver"
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/fake"
 	"k8s.io/client-go/rest"
 	chartv1 "k8s.io/helm/pkg/proto/hapi/chart"
 
@@ -22,8 +24,6 @@ import (
 	"github.com/kubeapps/kubeapps/pkg/proxy"
 )
 
-const defaultListLimit = 256
-
 // newActionConfigFixture returns an action.Configuration with fake clients
 // and memory storage.
 func newActionConfigFixture(t *testing.T) *action.Configuration {
@@ -143,6 +143,7 @@ func TestCreateReleases(t *testing.T) {
 		values            string
 		version           int
 		existingReleases  []releaseStub
+		options           kubechart.ReleaseOptions
 		remainingReleases int
 		shouldFail        bool
 	}{
@@ -158,6 +159,21 @@ func TestCreateReleases(t *testing.T) {
 			remainingReleases: 2,
 			shouldFail:        false,
 		},
+		{
+			desc:      "install new release with options",
+			chartName: "mychart",
+			values:    "",
+			namespace: "default",
+			version:   1,
+			options: kubechart.ReleaseOptions{
+				Wait:        true,
+				Timeout:     60,
+				SkipCRDs:    true,
+				Description: "Installed by CI",
+			},
+			remainingReleases: 1,
+			shouldFail:        false,
+		},
 		{
 			desc:      "install with an existing name",
 			chartName: "mychart",
@@ -194,7 +210,7 @@ func TestCreateReleases(t *testing.T) {
 				ChartName: tc.chartName,
 			}, "")
 			// Perform test
-			rls, err := CreateRelease(actionConfig, tc.chartName, tc.namespace, tc.values, ch, nil)
+			rls, err := CreateRelease(actionConfig, tc.chartName, tc.namespace, tc.values, ch, nil, nil, tc.options)
 			// Check result
 			if tc.shouldFail && err == nil {
 				t.Errorf("Should fail with %v; instead got %s in %s", tc.desc, tc.releaseName, tc.namespace)
@@ -202,6 +218,11 @@ func TestCreateReleases(t *testing.T) {
 			if !tc.shouldFail && rls == nil {
 				t.Errorf("Should succeed with %v; instead got error %v", tc.desc, err)
 			}
+			if tc.options.Description != "" && rls != nil {
+				if got, want := rls.Info.Description, tc.options.Description; got != want {
+					t.Errorf("got: %q, want: %q", got, want)
+				}
+			}
 			rlss, err := actionConfig.Releases.ListReleases()
 			if err != nil {
 				t.Errorf("Unexpected err %v", err)
@@ -213,11 +234,10 @@ func TestCreateReleases(t *testing.T) {
 	}
 }
 
-func TestListReleases(t *testing.T) {
+func TestListReleasesMatchingOverviews(t *testing.T) {
 	testCases := []struct {
 		name         string
 		namespace    string
-		listLimit    int
 		status       string
 		releases     []releaseStub
 		expectedApps []proxy.AppOverview
@@ -225,7 +245,6 @@ func TestListReleases(t *testing.T) {
 		{
 			name:      "returns all apps across namespaces",
 			namespace: "",
-			listLimit: defaultListLimit,
 			releases: []releaseStub{
 				{"airwatch", "default", 1, "1.0.0", release.StatusDeployed},
 				{"wordpress", "default", 1, "1.0.1", release.StatusDeployed},
@@ -273,7 +292,6 @@ func TestListReleases(t *testing.T) {
 		{
 			name:      "returns apps for the given namespace",
 			namespace: "default",
-			listLimit: defaultListLimit,
 			releases: []releaseStub{
 				{"airwatch", "default", 1, "1.0.0", release.StatusDeployed},
 				{"wordpress", "default", 1, "1.0.1", release.StatusDeployed},
@@ -306,34 +324,9 @@ func TestListReleases(t *testing.T) {
 				},
 			},
 		},
-		{
-			name:      "returns at most listLimit apps",
-			namespace: "default",
-			listLimit: 1,
-			releases: []releaseStub{
-				{"airwatch", "default", 1, "1.0.0", release.StatusDeployed},
-				{"wordpress", "default", 1, "1.0.1", release.StatusDeployed},
-				{"not-in-namespace", "other", 1, "1.0.2", release.StatusDeployed},
-			},
-			expectedApps: []proxy.AppOverview{
-				{
-					ReleaseName: "airwatch",
-					Namespace:   "default",
-					Version:     "1.0.0",
-					Status:      "deployed",
-					Icon:        "https://example.com/icon.png",
-					ChartMetadata: chartv1.Metadata{
-						Version:     "1.0.0",
-						Icon:        "https://example.com/icon.png",
-						Maintainers: []*chartv1.Maintainer{},
-					},
-				},
-			},
-		},
 		{
 			name:      "returns two apps with same name but different namespaces and versions",
 			namespace: "",
-			listLimit: defaultListLimit,
 			releases: []releaseStub{
 				{"wordpress", "default", 1, "1.0.0", release.StatusDeployed},
 				{"wordpress", "dev", 2, "2.0.0", release.StatusDeployed},
@@ -368,7 +361,6 @@ func TestListReleases(t *testing.T) {
 		{
 			name:      "ignore uninstalled apps",
 			namespace: "",
-			listLimit: defaultListLimit,
 			releases: []releaseStub{
 				{"wordpress", "default", 1, "1.0.0", release.StatusDeployed},
 				{"wordpress", "dev", 2, "1.0.0", release.StatusUninstalled},
@@ -392,7 +384,6 @@ func TestListReleases(t *testing.T) {
 			name:      "include uninstalled apps when requesting all statuses",
 			namespace: "",
 			status:    "all",
-			listLimit: defaultListLimit,
 			releases: []releaseStub{
 				{"wordpress", "default", 1, "1.0.0", release.StatusDeployed},
 				{"wordpress", "dev", 2, "1.0.1", release.StatusUninstalled},
@@ -432,7 +423,7 @@ func TestListReleases(t *testing.T) {
 			makeReleases(t, actionConfig, tc.releases)
 			actionConfig.Releases.Driver.(*driver.Memory).SetNamespace(tc.namespace)
 
-			apps, err := ListReleases(actionConfig, tc.namespace, tc.listLimit, tc.status)
+			apps, err := ListReleasesMatching(actionConfig, tc.namespace, ReleaseFilter{Status: tc.status})
 			if err != nil {
 				t.Errorf("%v", err)
 			}
@@ -530,11 +521,14 @@ func TestParseDriverType(t *testing.T) {
 
 	for _, tc := range validTestCases {
 		t.Run(tc.input, func(t *testing.T) {
-			storageForDriver, err := ParseDriverType(tc.input)
+			storageForDriver, err := ParseDriverType(tc.input, "")
+			if err != nil {
+				t.Fatalf("%v", err)
+			}
+			storage, err := storageForDriver("default", &kubernetes.Clientset{})
 			if err != nil {
 				t.Fatalf("%v", err)
 			}
-			storage := storageForDriver("default", &kubernetes.Clientset{})
 			if got, want := storage.Name(), tc.driverName; got != want {
 				t.Errorf("expected: %s, actual: %s", want, got)
 			}
@@ -543,7 +537,7 @@ func TestParseDriverType(t *testing.T) {
 
 	invalidTestCase := "andresmgot"
 	t.Run(invalidTestCase, func(t *testing.T) {
-		storageForDriver, err := ParseDriverType(invalidTestCase)
+		storageForDriver, err := ParseDriverType(invalidTestCase, "")
 		if err == nil {
 			t.Errorf("Expected \"%s\" to be an invalid driver type, but it was parsed as %v", invalidTestCase, storageForDriver)
 		}
@@ -551,6 +545,16 @@ func TestParseDriverType(t *testing.T) {
 			t.Errorf("got: %#v, want: nil", storageForDriver)
 		}
 	})
+
+	t.Run("sql without connection string", func(t *testing.T) {
+		storageForDriver, err := ParseDriverType("sql", "")
+		if err == nil {
+			t.Errorf("Expected the sql driver to require a connection string, but it was parsed as %v", storageForDriver)
+		}
+		if storageForDriver != nil {
+			t.Errorf("got: %#v, want: nil", storageForDriver)
+		}
+	})
 }
 
 func TestRollbackRelease(t *testing.T) {
@@ -642,6 +646,7 @@ func TestUpgradeRelease(t *testing.T) {
 		release     string
 		valuesYaml  string
 		chartName   string
+		options     kubechart.ReleaseOptions
 		shouldFail  bool
 	}{
 		{
@@ -653,6 +658,20 @@ func TestUpgradeRelease(t *testing.T) {
 			release:    "myrls",
 			chartName:  "mynewchart",
 		},
+		{
+			description: "upgrade a release with options",
+			releases: []releaseStub{
+				{"myrls", "default", revisionBeingUpdated, "mychart", release.StatusDeployed},
+			},
+			valuesYaml: "IsValidYaml: true",
+			release:    "myrls",
+			chartName:  "mynewchart",
+			options: kubechart.ReleaseOptions{
+				ReuseValues:  true,
+				DisableHooks: true,
+				Description:  "Upgraded by CI",
+			},
+		},
 		{
 			description: "upgrade a release with invalid values",
 			releases: []releaseStub{
@@ -682,7 +701,7 @@ func TestUpgradeRelease(t *testing.T) {
 			ch, _ := fakechart.GetChart(&kubechart.Details{
 				ChartName: tc.chartName,
 			}, "")
-			newRelease, err := UpgradeRelease(cfg, tc.release, tc.valuesYaml, ch, nil)
+			newRelease, err := UpgradeRelease(cfg, tc.release, tc.valuesYaml, ch, nil, tc.options)
 			// Check for errors
 			if got, want := err != nil, tc.shouldFail; got != want {
 				t.Errorf("Failure: got: %v, want: %v", got, want)
@@ -698,6 +717,11 @@ func TestUpgradeRelease(t *testing.T) {
 			if got, want := newRelease.Version, revisionBeingUpdated+1; got != want {
 				t.Errorf("got: %d, want: %d", got, want)
 			}
+			if tc.options.Description != "" {
+				if got, want := newRelease.Info.Description, tc.options.Description; got != want {
+					t.Errorf("got: %q, want: %q", got, want)
+				}
+			}
 			if got, want := rel.Info.Status, release.StatusDeployed; got != want {
 				t.Errorf("got: %q, want: %q", got, want)
 			}
@@ -710,6 +734,159 @@ func TestUpgradeRelease(t *testing.T) {
 	}
 }
 
+func TestGetReleaseHistory(t *testing.T) {
+	testCases := []struct {
+		name      string
+		releases  []releaseStub
+		release   string
+		expected  []ReleaseRevision
+		shouldErr bool
+	}{
+		{
+			name: "returns the revisions sorted by revision",
+			releases: []releaseStub{
+				{"airwatch", "default", 2, "1.0.1", release.StatusDeployed},
+				{"airwatch", "default", 1, "1.0.0", release.StatusSuperseded},
+				{"otherrelease", "default", 1, "1.0.0", release.StatusDeployed},
+			},
+			release: "airwatch",
+			expected: []ReleaseRevision{
+				{Revision: 1, Status: "superseded", ChartVersion: "1.0.0"},
+				{Revision: 2, Status: "deployed", ChartVersion: "1.0.1"},
+			},
+		},
+		{
+			name: "errors when the release does not exist",
+			releases: []releaseStub{
+				{"otherrelease", "default", 1, "1.0.0", release.StatusDeployed},
+			},
+			release:   "airwatch",
+			shouldErr: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := newActionConfigFixture(t)
+			makeReleases(t, cfg, tc.releases)
+
+			revisions, err := GetReleaseHistory(cfg, tc.release)
+			if got, want := err != nil, tc.shouldErr; got != want {
+				t.Fatalf("got error: %v, want error: %v", err, want)
+			}
+			if !cmp.Equal(revisions, tc.expected) {
+				t.Errorf(cmp.Diff(tc.expected, revisions))
+			}
+		})
+	}
+}
+
+func TestTestRelease(t *testing.T) {
+	testHook := func(name, kind string) *release.Hook {
+		return &release.Hook{
+			Name:     name,
+			Kind:     kind,
+			Manifest: "apiVersion: v1\nkind: " + kind + "\nmetadata:\n  name: " + name,
+			Events:   []release.HookEvent{release.HookTest},
+		}
+	}
+	testCases := []struct {
+		name        string
+		hooks       []*release.Hook
+		watchError  error
+		release     string
+		expected    *proxy.TestStatus
+		shouldError bool
+	}{
+		{
+			name:     "returns the status and logs of test pods",
+			hooks:    []*release.Hook{testHook("airwatch-test", "Pod")},
+			release:  "airwatch",
+			expected: &proxy.TestStatus{"Succeeded": []string{"airwatch-test:\nfake logs"}},
+		},
+		{
+			name:       "returns failed test pods",
+			hooks:      []*release.Hook{testHook("airwatch-test", "Pod")},
+			watchError: errors.New("pod airwatch-test failed"),
+			release:    "airwatch",
+			expected:   &proxy.TestStatus{"Failed": []string{"airwatch-test:\nfake logs"}},
+		},
+		{
+			name: "ignores hooks which are not tests",
+			hooks: []*release.Hook{
+				testHook("airwatch-test", "Job"),
+				{Name: "airwatch-install", Kind: "Job", Events: []release.HookEvent{release.HookPreInstall}},
+			},
+			release:  "airwatch",
+			expected: &proxy.TestStatus{"Succeeded": []string{"airwatch-test"}},
+		},
+		{
+			name:        "errors when the release does not exist",
+			release:     "apache",
+			shouldError: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := newActionConfigFixture(t)
+			cfg.KubeClient.(*kubefake.FailingKubeClient).WatchUntilReadyError = tc.watchError
+			err := cfg.Releases.Create(&release.Release{
+				Name:      "airwatch",
+				Namespace: "default",
+				Version:   1,
+				Info:      &release.Info{Status: release.StatusDeployed},
+				Chart:     &chart.Chart{Metadata: &chart.Metadata{Version: "1.0.0"}},
+				Hooks:     tc.hooks,
+			})
+			if err != nil {
+				t.Fatalf("%+v", err)
+			}
+
+			testStatus, err := TestRelease(cfg, fake.NewSimpleClientset(), tc.release, 300)
+			if got, want := err != nil, tc.shouldError; got != want {
+				t.Fatalf("got error: %v, want error: %v", err, want)
+			}
+			if !cmp.Equal(testStatus, tc.expected) {
+				t.Errorf(cmp.Diff(tc.expected, testStatus))
+			}
+		})
+	}
+}
+
+func TestGetPodLogs(t *testing.T) {
+	// The fake clientset returns "fake logs" for every pod.
+	clientset := fake.NewSimpleClientset()
+	testCases := []struct {
+		name     string
+		limit    int64
+		expected string
+	}{
+		{
+			name:     "returns the logs within the limit",
+			limit:    9,
+			expected: "fake logs",
+		},
+		{
+			name:     "truncates the logs exceeding the limit",
+			limit:    4,
+			expected: "fake\n[The logs are truncated to their first 4 bytes]",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			logs, err := getPodLogs(clientset, "default", "airwatch-test", tc.limit)
+			if err != nil {
+				t.Fatalf("%+v", err)
+			}
+			if got, want := logs, tc.expected; got != want {
+				t.Errorf("got: %q, want: %q", got, want)
+			}
+		})
+	}
+}
+
 func TestNewConfigFlagsFromCluster(t *testing.T) {
 	testCases := []struct {
 		name   string
@@ -0,0 +1,151 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+
+	"helm.sh/helm/v3/pkg/release"
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	// AppRepositoryNameAnnotation is the annotation recording the name of the app
+	// repository associated with a release.
+	AppRepositoryNameAnnotation = "kubeapps.com/app-repository-name"
+	// AppRepositoryNamespaceAnnotation is the annotation recording the namespace of
+	// the app repository associated with a release.
+	AppRepositoryNamespaceAnnotation = "kubeapps.com/app-repository-namespace"
+	// appRepositoryChartAnnotation records the name of the chart of the release
+	// when it was associated with an app repository.
+	appRepositoryChartAnnotation = "kubeapps.com/app-repository-chart"
+	// appRepositoryReleaseLabel labels the ConfigMaps recording the app repository
+	// of a release with the name of the release.
+	appRepositoryReleaseLabel = "kubeapps.com/app-repository-release"
+)
+
+// AppRepositoryRef identifies the app repository associated with a release.
+type AppRepositoryRef struct {
+	Name      string
+	Namespace string
+}
+
+// AppRepositoryAssociation is the app repository associated with a release,
+// together with the name of the chart of the release when it was associated.
+type AppRepositoryAssociation struct {
+	AppRepositoryRef
+	Chart string
+}
+
+// Matches returns whether the association was recorded for a release of the
+// chart. The ConfigMap of an association is not owned by its release, so that it
+// is left behind when the release is uninstalled without Kubeapps, and should be
+// ignored by a later release of another chart with the same name.
+func (a AppRepositoryAssociation) Matches(chartName string) bool {
+	return a.Chart != "" && a.Chart == chartName
+}
+
+// The app repository of a release is recorded in a ConfigMap of the namespace of
+// the release, rather than in the release itself, so that the release stored by
+// Helm is left unchanged whatever the Helm driver.
+func appRepositoryConfigMapName(releaseName string) string {
+	return "kubeapps-apprepository-" + releaseName
+}
+
+func appRepositoryFromConfigMap(cm *corev1.ConfigMap) (AppRepositoryAssociation, bool) {
+	association := AppRepositoryAssociation{
+		AppRepositoryRef: AppRepositoryRef{
+			Name:      cm.Annotations[AppRepositoryNameAnnotation],
+			Namespace: cm.Annotations[AppRepositoryNamespaceAnnotation],
+		},
+		Chart: cm.Annotations[appRepositoryChartAnnotation],
+	}
+	return association, association.Name != "" && association.Namespace != ""
+}
+
+func releaseChartName(rel *release.Release) string {
+	if rel.Chart == nil || rel.Chart.Metadata == nil {
+		return ""
+	}
+	return rel.Chart.Metadata.Name
+}
+
+// GetAppRepository returns the app repository associated with a release, if any.
+// An association recorded for another chart is ignored.
+func GetAppRepository(clientset kubernetes.Interface, rel *release.Release) (AppRepositoryRef, bool, error) {
+	cm, err := clientset.CoreV1().ConfigMaps(rel.Namespace).Get(context.TODO(), appRepositoryConfigMapName(rel.Name), metav1.GetOptions{})
+	if err != nil {
+		if k8serrors.IsNotFound(err) {
+			return AppRepositoryRef{}, false, nil
+		}
+		return AppRepositoryRef{}, false, fmt.Errorf("Unable to get the app repository of release %q: %v", rel.Name, err)
+	}
+	association, ok := appRepositoryFromConfigMap(cm)
+	if !ok || !association.Matches(releaseChartName(rel)) {
+		return AppRepositoryRef{}, false, nil
+	}
+	return association.AppRepositoryRef, true, nil
+}
+
+// ListAppRepositories returns the app repositories associated with the releases of
+// the namespace, or of every namespace if it is empty, by namespace and release name.
+// The callers should ignore the associations which do not match the chart of the release.
+func ListAppRepositories(clientset kubernetes.Interface, namespace string) (map[string]map[string]AppRepositoryAssociation, error) {
+	list, err := clientset.CoreV1().ConfigMaps(namespace).List(context.TODO(), metav1.ListOptions{LabelSelector: appRepositoryReleaseLabel})
+	if err != nil {
+		return nil, fmt.Errorf("Unable to list the app repositories of the releases: %v", err)
+	}
+	associations := map[string]map[string]AppRepositoryAssociation{}
+	for i := range list.Items {
+		cm := &list.Items[i]
+		association, ok := appRepositoryFromConfigMap(cm)
+		if !ok {
+			continue
+		}
+		if associations[cm.Namespace] == nil {
+			associations[cm.Namespace] = map[string]AppRepositoryAssociation{}
+		}
+		associations[cm.Namespace][cm.Labels[appRepositoryReleaseLabel]] = association
+	}
+	return associations, nil
+}
+
+// SetAppRepository associates a release with an app repository, replacing the
+// previous association, if any.
+func SetAppRepository(clientset kubernetes.Interface, rel *release.Release, ref AppRepositoryRef) error {
+	configMaps := clientset.CoreV1().ConfigMaps(rel.Namespace)
+	cm := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      appRepositoryConfigMapName(rel.Name),
+			Namespace: rel.Namespace,
+			Labels: map[string]string{
+				appRepositoryReleaseLabel:      rel.Name,
+				"app.kubernetes.io/managed-by": "kubeapps",
+			},
+			Annotations: map[string]string{
+				AppRepositoryNameAnnotation:      ref.Name,
+				AppRepositoryNamespaceAnnotation: ref.Namespace,
+				appRepositoryChartAnnotation:     releaseChartName(rel),
+			},
+		},
+	}
+	_, err := configMaps.Create(context.TODO(), cm, metav1.CreateOptions{})
+	if k8serrors.IsAlreadyExists(err) {
+		_, err = configMaps.Update(context.TODO(), cm, metav1.UpdateOptions{})
+	}
+	if err != nil {
+		return fmt.Errorf("Unable to associate release %q with an app repository: %v", rel.Name, err)
+	}
+	return nil
+}
+
+// DeleteAppRepository removes the association of a release with an app repository, if any.
+func DeleteAppRepository(clientset kubernetes.Interface, namespace, releaseName string) error {
+	err := clientset.CoreV1().ConfigMaps(namespace).Delete(context.TODO(), appRepositoryConfigMapName(releaseName), metav1.DeleteOptions{})
+	if err != nil && !k8serrors.IsNotFound(err) {
+		return fmt.Errorf("Unable to remove the app repository of release %q: %v", releaseName, err)
+	}
+	return nil
+}
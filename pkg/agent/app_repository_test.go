@@ -0,0 +1,123 @@
+package agent
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/release"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func newReleaseOfChart(name, namespace, chartName string) *release.Release {
+	return &release.Release{
+		Name:      name,
+		Namespace: namespace,
+		Chart:     &chart.Chart{Metadata: &chart.Metadata{Name: chartName}},
+	}
+}
+
+func TestSetAppRepository(t *testing.T) {
+	clientset := fake.NewSimpleClientset()
+	rel := newReleaseOfChart("foo", "default", "apache")
+
+	if _, ok, err := GetAppRepository(clientset, rel); err != nil || ok {
+		t.Fatalf("expected no app repository, got: %t, %v", ok, err)
+	}
+
+	for _, ref := range []AppRepositoryRef{
+		{Name: "bitnami", Namespace: "kubeapps"},
+		{Name: "my-repo", Namespace: "default"},
+	} {
+		if err := SetAppRepository(clientset, rel, ref); err != nil {
+			t.Fatalf("%+v", err)
+		}
+		got, ok, err := GetAppRepository(clientset, rel)
+		if err != nil || !ok {
+			t.Fatalf("expected an app repository, got: %t, %v", ok, err)
+		}
+		if want := ref; got != want {
+			t.Errorf("got: %+v, want: %+v", got, want)
+		}
+	}
+
+	if err := DeleteAppRepository(clientset, "default", "foo"); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if _, ok, err := GetAppRepository(clientset, rel); err != nil || ok {
+		t.Errorf("expected no app repository after deleting it, got: %t, %v", ok, err)
+	}
+	if err := DeleteAppRepository(clientset, "default", "foo"); err != nil {
+		t.Errorf("expected deleting a missing app repository to succeed, got: %v", err)
+	}
+}
+
+func TestGetAppRepositoryOfAnotherChart(t *testing.T) {
+	clientset := fake.NewSimpleClientset()
+	// The association of a release uninstalled without Kubeapps is left behind.
+	if err := SetAppRepository(clientset, newReleaseOfChart("foo", "default", "apache"), AppRepositoryRef{Name: "bitnami", Namespace: "kubeapps"}); err != nil {
+		t.Fatalf("%+v", err)
+	}
+
+	if _, ok, err := GetAppRepository(clientset, newReleaseOfChart("foo", "default", "wordpress")); err != nil || ok {
+		t.Errorf("expected a release of another chart to ignore the association, got: %t, %v", ok, err)
+	}
+}
+
+func TestListAppRepositories(t *testing.T) {
+	clientset := fake.NewSimpleClientset()
+	for _, r := range []struct {
+		rel *release.Release
+		ref AppRepositoryRef
+	}{
+		{newReleaseOfChart("foo", "default", "apache"), AppRepositoryRef{Name: "bitnami", Namespace: "kubeapps"}},
+		{newReleaseOfChart("bar", "default", "wordpress"), AppRepositoryRef{Name: "my-repo", Namespace: "default"}},
+		{newReleaseOfChart("foo", "dev", "apache"), AppRepositoryRef{Name: "bitnami", Namespace: "kubeapps"}},
+	} {
+		if err := SetAppRepository(clientset, r.rel, r.ref); err != nil {
+			t.Fatalf("%+v", err)
+		}
+	}
+
+	testCases := []struct {
+		name      string
+		namespace string
+		expected  map[string]map[string]AppRepositoryAssociation
+	}{
+		{
+			name:      "lists the app repositories of a namespace",
+			namespace: "default",
+			expected: map[string]map[string]AppRepositoryAssociation{
+				"default": {
+					"foo": {AppRepositoryRef{Name: "bitnami", Namespace: "kubeapps"}, "apache"},
+					"bar": {AppRepositoryRef{Name: "my-repo", Namespace: "default"}, "wordpress"},
+				},
+			},
+		},
+		{
+			name:      "lists the app repositories of every namespace",
+			namespace: "",
+			expected: map[string]map[string]AppRepositoryAssociation{
+				"default": {
+					"foo": {AppRepositoryRef{Name: "bitnami", Namespace: "kubeapps"}, "apache"},
+					"bar": {AppRepositoryRef{Name: "my-repo", Namespace: "default"}, "wordpress"},
+				},
+				"dev": {
+					"foo": {AppRepositoryRef{Name: "bitnami", Namespace: "kubeapps"}, "apache"},
+				},
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := ListAppRepositories(clientset, tc.namespace)
+			if err != nil {
+				t.Fatalf("%+v", err)
+			}
+			if want := tc.expected; !cmp.Equal(want, got) {
+				t.Errorf("mismatch (-want +got):\n%s", cmp.Diff(want, got))
+			}
+		})
+	}
+}
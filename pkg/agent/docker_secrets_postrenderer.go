@@ -2,13 +2,14 @@ package agent
 
 import (
 	"bytes"
-	"io"
+	"encoding/json"
+	"fmt"
 	"net/url"
 	"strings"
 
 	"github.com/docker/distribution/reference"
 	log "github.com/sirupsen/logrus"
-	"gopkg.in/yaml.v2"
+	"k8s.io/apimachinery/pkg/runtime/schema"
 )
 
 const (
@@ -21,11 +22,14 @@ const (
 type DockerSecretsPostRenderer struct {
 	// secrets maps a registry domain to a single secret to be used for that domain.
 	secrets map[string]string
+	// podSpecPaths are the pod spec paths of the custom resources creating pods.
+	podSpecPaths PodSpecPaths
 }
 
-// NewDockerSecretsPostRenderer returns a post renderer configured with the specified secrets.
-func NewDockerSecretsPostRenderer(secrets map[string]string) (*DockerSecretsPostRenderer, error) {
-	r := &DockerSecretsPostRenderer{}
+// NewDockerSecretsPostRenderer returns a post renderer configured with the specified
+// secrets, which are also added to the pods of the custom resources of podSpecPaths.
+func NewDockerSecretsPostRenderer(secrets map[string]string, podSpecPaths PodSpecPaths) (*DockerSecretsPostRenderer, error) {
+	r := &DockerSecretsPostRenderer{podSpecPaths: podSpecPaths}
 	r.secrets = map[string]string{}
 	// Docker authentication credentials can be stored as either the registry domain
 	// or explicitly with the protocol and potential path of the server.
@@ -64,8 +68,7 @@ func (r *DockerSecretsPostRenderer) processResourceList(resourceList []interface
 			continue
 		}
 
-		kind, ok := kindValue.(string)
-		if !ok {
+		if _, ok := kindValue.(string); !ok {
 			log.Errorf("invalid resource: non-string resource kind. %+v", resource)
 			continue
 		}
@@ -78,7 +81,7 @@ func (r *DockerSecretsPostRenderer) processResourceList(resourceList []interface
 			continue
 		}
 
-		podSpec := getResourcePodSpec(kind, resource)
+		podSpec := r.podSpecPaths.podSpec(resource)
 		if podSpec == nil {
 			continue
 		}
@@ -92,39 +95,18 @@ func (r *DockerSecretsPostRenderer) Run(renderedManifests *bytes.Buffer) (modifi
 	if len(r.secrets) == 0 {
 		return renderedManifests, nil
 	}
-
-	decoder := yaml.NewDecoder(renderedManifests)
-	var resourceList []interface{}
-	for {
-		var resource interface{}
-		err := decoder.Decode(&resource)
-		if err == io.EOF {
-			break
-		}
-		if err != nil {
-			return nil, err
-		}
-		resourceList = append(resourceList, resource)
-	}
-
 	// TODO(mnelson): If re-rendering the entire manifest creates issues, we
 	// could instead find the correct byte position and insert the image pull
 	// secret into the byte stream at the relevant points, but this will be
 	// more complex.
-	r.processResourceList(resourceList)
-
-	modifiedManifests = bytes.NewBuffer([]byte{})
-	encoder := yaml.NewEncoder(modifiedManifests)
-	defer encoder.Close()
-
-	for _, resource := range resourceList {
-		err = encoder.Encode(resource)
-		if err != nil {
-			return nil, err
-		}
-	}
+	return NewPostRendererPipeline(r).Run(renderedManifests)
+}
 
-	return modifiedManifests, nil
+// Transform implements ResourceTransformer, so that the image pull secrets can be
+// added as part of a PostRendererPipeline.
+func (r *DockerSecretsPostRenderer) Transform(resourceList []interface{}) error {
+	r.processResourceList(resourceList)
+	return nil
 }
 
 // updatePodSpecWithPullSecrets updates the podSpec inline with the relevant pull secrets.
@@ -133,6 +115,7 @@ func (r *DockerSecretsPostRenderer) Run(renderedManifests *bytes.Buffer) (modifi
 // we limit our assumptions of the untyped handling to the following:
 // - The pod spec includes a 'containers' key with a slice value
 // - Each container value is a map with an 'image' key and string value.
+// The images of the init and ephemeral containers are handled the same way.
 // An invalid resource doc is logged but left for the k8s API to respond to.
 func (r *DockerSecretsPostRenderer) updatePodSpecWithPullSecrets(podSpec map[interface{}]interface{}) {
 	containersObject, ok := podSpec["containers"]
@@ -145,19 +128,33 @@ func (r *DockerSecretsPostRenderer) updatePodSpecWithPullSecrets(podSpec map[int
 		log.Errorf("podSpec containers key is not a slice: %+v", podSpec)
 		return
 	}
+	containers = append([]interface{}{}, containers...)
+	for _, key := range []string{"initContainers", "ephemeralContainers"} {
+		if otherContainers, ok := podSpec[key].([]interface{}); ok {
+			containers = append(containers, otherContainers...)
+		}
+	}
 
 	// If there are existing pull secrets, initialise our slice with that value
 	// and additionally initialize a map keyed by secret name which we can
 	// use to test existence more easily.
+	// The pull secrets parsed from the manifest are a slice of untyped maps.
 	var imagePullSecrets []map[string]interface{}
 	existingNames := map[string]bool{}
-	if existingPullSecrets, ok := podSpec["imagePullSecrets"]; ok {
-		imagePullSecrets = existingPullSecrets.([]map[string]interface{})
-		for _, s := range imagePullSecrets {
-			if name, ok := s["name"]; ok {
-				if n, ok := name.(string); ok {
-					existingNames[n] = true
-				}
+	switch existingPullSecrets := podSpec["imagePullSecrets"].(type) {
+	case []map[string]interface{}:
+		imagePullSecrets = existingPullSecrets
+	case []interface{}:
+		for _, s := range existingPullSecrets {
+			if secret, ok := withStringKeys(s).(map[string]interface{}); ok {
+				imagePullSecrets = append(imagePullSecrets, secret)
+			}
+		}
+	}
+	for _, s := range imagePullSecrets {
+		if name, ok := s["name"]; ok {
+			if n, ok := name.(string); ok {
+				existingNames[n] = true
 			}
 		}
 	}
@@ -201,30 +198,100 @@ func (r *DockerSecretsPostRenderer) updatePodSpecWithPullSecrets(podSpec map[int
 	}
 }
 
-// getResourcePodSpec checks the kind of the resource and extracts the pod spec accordingly.
+// PodSpecPaths maps the group and kind of the custom resources creating pods to
+// the keys of their pod spec, so that their pods are post-rendered like those of
+// the Kubernetes workloads. It is configured as a map of the kinds qualified by
+// their group, such as Workflow.argoproj.io, to dotted paths, such as spec.template.spec.
+type PodSpecPaths map[schema.GroupKind][]string
+
+// defaultPodSpecPaths are the keys of the pod spec of the Kubernetes workloads,
+// which cannot be overridden.
+var defaultPodSpecPaths = PodSpecPaths{
+	{Kind: "Pod"}: {"spec"},
+	// These resources all include a spec.template.spec PodSpec.
+	// https://kubernetes.io/docs/reference/generated/kubernetes-api/v1.18/#podtemplatespec-v1-core
+	{Kind: "ReplicationController"}:           {"spec", "template", "spec"},
+	{Group: "apps", Kind: "DaemonSet"}:        {"spec", "template", "spec"},
+	{Group: "apps", Kind: "Deployment"}:       {"spec", "template", "spec"},
+	{Group: "apps", Kind: "ReplicaSet"}:       {"spec", "template", "spec"},
+	{Group: "apps", Kind: "StatefulSet"}:      {"spec", "template", "spec"},
+	{Group: "extensions", Kind: "DaemonSet"}:  {"spec", "template", "spec"},
+	{Group: "extensions", Kind: "Deployment"}: {"spec", "template", "spec"},
+	{Group: "extensions", Kind: "ReplicaSet"}: {"spec", "template", "spec"},
+	{Group: "batch", Kind: "Job"}:             {"spec", "template", "spec"},
+	{Kind: "PodTemplate"}:                     {"template", "spec"},
+	// A CronJob spec contains a jobTemplate:
+	// https://kubernetes.io/docs/reference/generated/kubernetes-api/v1.18/#cronjobspec-v1beta1-batch
+	{Group: "batch", Kind: "CronJob"}: {"spec", "jobTemplate", "spec", "template", "spec"},
+}
+
+// NewPodSpecPaths returns the pod spec paths of custom resources, keyed by their
+// kind qualified by their group. The paths of the Kubernetes workloads cannot be overridden.
+func NewPodSpecPaths(paths map[string]string) (PodSpecPaths, error) {
+	p := PodSpecPaths{}
+	for groupKind, path := range paths {
+		gk := schema.ParseGroupKind(groupKind)
+		if gk.Group == "" {
+			return nil, fmt.Errorf("Invalid kind %q, it must be qualified by its group, such as Workflow.argoproj.io", groupKind)
+		}
+		keys := strings.Split(path, ".")
+		for _, k := range keys {
+			if k == "" {
+				return nil, fmt.Errorf("Invalid pod spec path %q of kind %q", path, groupKind)
+			}
+		}
+		if existing, ok := defaultPodSpecPaths[gk]; ok && strings.Join(existing, ".") != path {
+			return nil, fmt.Errorf("Invalid pod spec path %q of kind %q: it is already %q", path, groupKind, strings.Join(existing, "."))
+		}
+		p[gk] = keys
+	}
+	return p, nil
+}
+
+// UnmarshalJSON parses and validates the pod spec paths of a configuration.
+func (p *PodSpecPaths) UnmarshalJSON(data []byte) error {
+	paths := map[string]string{}
+	if err := json.Unmarshal(data, &paths); err != nil {
+		return err
+	}
+	parsed, err := NewPodSpecPaths(paths)
+	if err != nil {
+		return err
+	}
+	*p = parsed
+	return nil
+}
+
+// keys returns the keys of the pod spec of a resource, if its group and kind are
+// known. Resources without apiVersion are in the core group.
+func (p PodSpecPaths) keys(resource map[interface{}]interface{}) ([]string, bool) {
+	kind, _ := resource["kind"].(string)
+	apiVersion, _ := resource["apiVersion"].(string)
+	gv, err := schema.ParseGroupVersion(apiVersion)
+	if err != nil {
+		return nil, false
+	}
+	gk := gv.WithKind(kind).GroupKind()
+	if keys, ok := defaultPodSpecPaths[gk]; ok {
+		return keys, true
+	}
+	keys, ok := p[gk]
+	return keys, ok
+}
+
+// podSpec extracts the pod spec of a resource according to its group and kind.
 // We do not parse the yaml into actual Kubernetes objects since we want to be
 // independent of api versions. This requires special care and limitations, so
 // we limit our assumptions of the untyped handling to the following, with any
 // invalid docs ignored and left for the API server to respond accordingly:
 // - A resource doc is a map with a "kind" key with a string value
 // - A pod resource doc has a "spec" key containing a map
-func getResourcePodSpec(kind string, resource map[interface{}]interface{}) map[interface{}]interface{} {
-	switch kind {
-	case "Pod":
-		return getMapForKeys([]string{"spec"}, resource)
-	case "DaemonSet", "Deployment", "Job", "ReplicaSet", "ReplicationController", "StatefulSet":
-		// These resources all include a spec.template.spec PodSpec.
-		// https://kubernetes.io/docs/reference/generated/kubernetes-api/v1.18/#podtemplatespec-v1-core
-		return getMapForKeys([]string{"spec", "template", "spec"}, resource)
-	case "PodTemplate":
-		return getMapForKeys([]string{"template", "spec"}, resource)
-	case "CronJob":
-		// A CronJob spec contains a jobTemplate:
-		// https://kubernetes.io/docs/reference/generated/kubernetes-api/v1.18/#cronjobspec-v1beta1-batch
-		return getMapForKeys([]string{"spec", "jobTemplate", "spec", "template", "spec"}, resource)
+func (p PodSpecPaths) podSpec(resource map[interface{}]interface{}) map[interface{}]interface{} {
+	keys, ok := p.keys(resource)
+	if !ok {
+		return nil
 	}
-
-	return nil
+	return getMapForKeys(keys, resource)
 }
 
 func getMapForKeys(keys []string, m map[interface{}]interface{}) map[interface{}]interface{} {
@@ -42,7 +42,7 @@ func TestNewDockerSecretsPostRenderer(t *testing.T) {
 
 	for _, tc := range testCases {
 		t.Run(tc.name, func(t *testing.T) {
-			r, err := NewDockerSecretsPostRenderer(tc.secrets)
+			r, err := NewDockerSecretsPostRenderer(tc.secrets, nil)
 			if got, want := err != nil, tc.expectErr; got != want {
 				t.Fatalf("got: %t, want: %t. err: %+v", got, want, err)
 			}
@@ -261,7 +261,7 @@ other: doc
 
 	for _, tc := range testCases {
 		t.Run(tc.name, func(t *testing.T) {
-			r, err := NewDockerSecretsPostRenderer(tc.secrets)
+			r, err := NewDockerSecretsPostRenderer(tc.secrets, nil)
 			if err != nil {
 				t.Fatalf("%+v", err)
 			}
@@ -363,6 +363,28 @@ func TestUpdatePodSpecWithPullSecrets(t *testing.T) {
 				{"name": "secret-3"},
 			},
 		},
+		{
+			name: "it appends to image pull secrets parsed from the manifest",
+			podSpec: map[interface{}]interface{}{
+				"containers": []interface{}{
+					map[interface{}]interface{}{
+						"image": "example.com/foobar:v1",
+					},
+				},
+				"imagePullSecrets": []interface{}{
+					map[interface{}]interface{}{
+						"name": "secret-1",
+					},
+				},
+			},
+			secrets: map[string]string{
+				"example.com": "secret-2",
+			},
+			expectedPullSecrets: []map[string]interface{}{
+				{"name": "secret-1"},
+				{"name": "secret-2"},
+			},
+		},
 		{
 			name: "it does not duplicate existing image pull secrets",
 			podSpec: map[interface{}]interface{}{
@@ -457,6 +479,34 @@ func TestUpdatePodSpecWithPullSecrets(t *testing.T) {
 				{"name": "secret-1"},
 			},
 		},
+		{
+			name: "it adds the image pull secrets of init and ephemeral containers",
+			podSpec: map[interface{}]interface{}{
+				"initContainers": []interface{}{
+					map[interface{}]interface{}{
+						"image": "example.com/init:v1",
+					},
+				},
+				"containers": []interface{}{
+					map[interface{}]interface{}{
+						"image": "docker.io/bitnami/nginx:v1",
+					},
+				},
+				"ephemeralContainers": []interface{}{
+					map[interface{}]interface{}{
+						"image": "debug.example.com/busybox:v1",
+					},
+				},
+			},
+			secrets: map[string]string{
+				"example.com":       "secret-1",
+				"debug.example.com": "secret-2",
+			},
+			expectedPullSecrets: []map[string]interface{}{
+				{"name": "secret-1"},
+				{"name": "secret-2"},
+			},
+		},
 		{
 			name: "it ignores containers without an image key",
 			podSpec: map[interface{}]interface{}{
@@ -480,7 +530,7 @@ func TestUpdatePodSpecWithPullSecrets(t *testing.T) {
 
 	for _, tc := range testCases {
 		t.Run(tc.name, func(t *testing.T) {
-			r, err := NewDockerSecretsPostRenderer(tc.secrets)
+			r, err := NewDockerSecretsPostRenderer(tc.secrets, nil)
 			if err != nil {
 				t.Fatalf("%+v", err)
 			}
@@ -494,66 +544,68 @@ func TestUpdatePodSpecWithPullSecrets(t *testing.T) {
 	}
 }
 
-func TestGetResourcePodSpec(t *testing.T) {
+func TestPodSpecPathsPodSpec(t *testing.T) {
+	templateSpec := func(kind, apiVersion string) map[interface{}]interface{} {
+		return map[interface{}]interface{}{
+			"apiVersion": apiVersion,
+			"kind":       kind,
+			"spec": map[interface{}]interface{}{
+				"template": map[interface{}]interface{}{
+					"spec": map[interface{}]interface{}{"some": "spec"},
+				},
+			},
+		}
+	}
 	testCases := []struct {
 		name     string
-		kind     string
 		resource map[interface{}]interface{}
 		result   map[interface{}]interface{}
 	}{
 		{
 			name: "it ignores an invalid doc with a non-map spec",
-			kind: "Pod",
 			resource: map[interface{}]interface{}{
+				"kind": "Pod",
 				"spec": "not a map",
 			},
 			result: nil,
 		},
 		{
 			name: "it returns the pod spec from a pod",
-			kind: "Pod",
 			resource: map[interface{}]interface{}{
-				"spec": map[interface{}]interface{}{"some": "spec"},
+				"apiVersion": "v1",
+				"kind":       "Pod",
+				"spec":       map[interface{}]interface{}{"some": "spec"},
 			},
 			result: map[interface{}]interface{}{
 				"some": "spec",
 			},
 		},
 		{
-			name: "it returns the pod spec from a daemon set",
-			kind: "DaemonSet",
-			resource: map[interface{}]interface{}{
-				"kind": "DaemonSet",
-				"spec": map[interface{}]interface{}{
-					"template": map[interface{}]interface{}{
-						"spec": map[interface{}]interface{}{"some": "spec"},
-					},
-				},
-			},
+			name:     "it returns the pod spec from a daemon set",
+			resource: templateSpec("DaemonSet", "apps/v1"),
 			result: map[interface{}]interface{}{
 				"some": "spec",
 			},
 		},
 		{
-			name: "it returns the pod spec from a deployment",
-			kind: "Deployment",
-			resource: map[interface{}]interface{}{
-				"kind": "Deployment",
-				"spec": map[interface{}]interface{}{
-					"template": map[interface{}]interface{}{
-						"spec": map[interface{}]interface{}{"some": "spec"},
-					},
-				},
+			name:     "it returns the pod spec from a deployment",
+			resource: templateSpec("Deployment", "apps/v1"),
+			result: map[interface{}]interface{}{
+				"some": "spec",
 			},
+		},
+		{
+			name:     "it returns the pod spec from a deployment of the extensions group",
+			resource: templateSpec("Deployment", "extensions/v1beta1"),
 			result: map[interface{}]interface{}{
 				"some": "spec",
 			},
 		},
 		{
 			name: "it returns the pod spec from a CronJob",
-			kind: "CronJob",
 			resource: map[interface{}]interface{}{
-				"kind": "CronJob",
+				"apiVersion": "batch/v1beta1",
+				"kind":       "CronJob",
 				"spec": map[interface{}]interface{}{
 					"jobTemplate": map[interface{}]interface{}{
 						"spec": map[interface{}]interface{}{
@@ -570,13 +622,139 @@ func TestGetResourcePodSpec(t *testing.T) {
 				"some": "spec",
 			},
 		},
+		{
+			name:     "it returns the pod spec from a job",
+			resource: templateSpec("Job", "batch/v1"),
+			result: map[interface{}]interface{}{
+				"some": "spec",
+			},
+		},
+		{
+			name:     "it returns the pod spec from a replica set",
+			resource: templateSpec("ReplicaSet", "apps/v1"),
+			result: map[interface{}]interface{}{
+				"some": "spec",
+			},
+		},
+		{
+			name: "it returns the pod spec from a custom resource of the pod spec paths",
+			resource: map[interface{}]interface{}{
+				"apiVersion": "example.com/v1",
+				"kind":       "TestWorkload",
+				"spec": map[interface{}]interface{}{
+					"runner": map[interface{}]interface{}{
+						"podSpec": map[interface{}]interface{}{"some": "spec"},
+					},
+				},
+			},
+			result: map[interface{}]interface{}{
+				"some": "spec",
+			},
+		},
+		{
+			name:     "it ignores the kinds of a workload in another group",
+			resource: templateSpec("Deployment", "example.com/v1"),
+			result:   nil,
+		},
+		{
+			name: "it ignores the kinds of the pod spec paths in another group",
+			resource: map[interface{}]interface{}{
+				"apiVersion": "other.com/v1",
+				"kind":       "TestWorkload",
+				"spec": map[interface{}]interface{}{
+					"runner": map[interface{}]interface{}{
+						"podSpec": map[interface{}]interface{}{"some": "spec"},
+					},
+				},
+			},
+			result: nil,
+		},
+		{
+			name: "it ignores unknown kinds",
+			resource: map[interface{}]interface{}{
+				"apiVersion": "v1",
+				"kind":       "Service",
+				"spec":       map[interface{}]interface{}{"some": "spec"},
+			},
+			result: nil,
+		},
+	}
+
+	paths, err := NewPodSpecPaths(map[string]string{"TestWorkload.example.com": "spec.runner.podSpec"})
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got, want := paths.podSpec(tc.resource), tc.result; !cmp.Equal(got, want) {
+				t.Errorf("mismatch (-want +got):\n%s", cmp.Diff(want, got))
+			}
+		})
+	}
+}
+
+func TestNewPodSpecPaths(t *testing.T) {
+	testCases := []struct {
+		name      string
+		paths     map[string]string
+		expected  PodSpecPaths
+		expectErr bool
+	}{
+		{
+			name:     "it parses the paths of custom resources",
+			paths:    map[string]string{"Workflow.argoproj.io": "spec.template.spec"},
+			expected: PodSpecPaths{{Group: "argoproj.io", Kind: "Workflow"}: {"spec", "template", "spec"}},
+		},
+		{
+			name:     "it accepts the existing path of a kind",
+			paths:    map[string]string{"Deployment.apps": "spec.template.spec"},
+			expected: PodSpecPaths{{Group: "apps", Kind: "Deployment"}: {"spec", "template", "spec"}},
+		},
+		{
+			name:     "it accepts a kind of a workload in another group",
+			paths:    map[string]string{"Deployment.example.com": "spec.podSpec"},
+			expected: PodSpecPaths{{Group: "example.com", Kind: "Deployment"}: {"spec", "podSpec"}},
+		},
+		{
+			name:      "it errors when overriding the path of a kind",
+			paths:     map[string]string{"Deployment.apps": "spec.podSpec"},
+			expectErr: true,
+		},
+		{
+			name:      "it errors with a kind without group",
+			paths:     map[string]string{"Workflow": "spec.template.spec"},
+			expectErr: true,
+		},
+		{
+			name:      "it errors with empty keys",
+			paths:     map[string]string{"Workflow.argoproj.io": "spec..spec"},
+			expectErr: true,
+		},
 	}
 
 	for _, tc := range testCases {
 		t.Run(tc.name, func(t *testing.T) {
-			if got, want := getResourcePodSpec(tc.kind, tc.resource), tc.result; !cmp.Equal(got, want) {
+			paths, err := NewPodSpecPaths(tc.paths)
+			if got, want := err != nil, tc.expectErr; got != want {
+				t.Fatalf("got: %t, want: %t. err: %+v", got, want, err)
+			}
+			if got, want := paths, tc.expected; !tc.expectErr && !cmp.Equal(want, got) {
 				t.Errorf("mismatch (-want +got):\n%s", cmp.Diff(want, got))
 			}
 		})
 	}
 }
+
+func TestPodSpecPathsPodTemplate(t *testing.T) {
+	paths := PodSpecPaths{{Group: "example.com", Kind: "TestWorkload"}: {"spec", "template", "spec"}}
+	if got, want := paths.podTemplate(map[interface{}]interface{}{
+		"apiVersion": "example.com/v1",
+		"kind":       "TestWorkload",
+		"spec": map[interface{}]interface{}{
+			"template": map[interface{}]interface{}{"spec": map[interface{}]interface{}{}},
+		},
+	}), map[interface{}]interface{}{"spec": map[interface{}]interface{}{}}; !cmp.Equal(want, got) {
+		t.Errorf("mismatch (-want +got):\n%s", cmp.Diff(want, got))
+	}
+}
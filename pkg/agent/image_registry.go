@@ -0,0 +1,131 @@
+package agent
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/docker/distribution/reference"
+	log "github.com/sirupsen/logrus"
+)
+
+// ImageMirror rewrites the images of a registry, or of a repository path within a
+// registry, to a mirror.
+type ImageMirror struct {
+	// From is a registry domain optionally followed by a repository path, such as
+	// docker.io/bitnami or docker.io/bitnami/*. Images without domain, such as
+	// nginx or bitnami/nginx, are on docker.io, the former in its library path.
+	From string `json:"from"`
+	// To is the registry domain and repository path replacing From, such as
+	// mirror.corp/bitnami.
+	To string `json:"to"`
+	// PullSecret is the name of an image pull secret for the mirror, added to the
+	// pod specs whose images are rewritten.
+	PullSecret string `json:"pullSecret,omitempty"`
+}
+
+// ImageRegistryTransformer rewrites the images of the containers, init containers and
+// ephemeral containers to the mirror of their registry. The tag and digest of the images are kept.
+type ImageRegistryTransformer struct {
+	// mirrors are sorted from the most to the least specific.
+	mirrors      []ImageMirror
+	podSpecPaths PodSpecPaths
+}
+
+// NewImageRegistryTransformer returns a transformer rewriting the images of the
+// mirrors, including the ones of the pods of the custom resources of podSpecPaths.
+func NewImageRegistryTransformer(mirrors []ImageMirror, podSpecPaths PodSpecPaths) (*ImageRegistryTransformer, error) {
+	t := &ImageRegistryTransformer{podSpecPaths: podSpecPaths}
+	for _, m := range mirrors {
+		from, err := normalizeImagePrefix(m.From)
+		if err != nil {
+			return nil, err
+		}
+		to := strings.TrimSuffix(strings.TrimSuffix(m.To, "*"), "/")
+		if _, err := reference.ParseNormalizedNamed(to); err != nil {
+			return nil, fmt.Errorf("Invalid image mirror %q: %v", m.To, err)
+		}
+		t.mirrors = append(t.mirrors, ImageMirror{From: from, To: to, PullSecret: m.PullSecret})
+	}
+	sort.SliceStable(t.mirrors, func(i, j int) bool {
+		return len(t.mirrors[i].From) > len(t.mirrors[j].From)
+	})
+	return t, nil
+}
+
+// normalizeImagePrefix returns the registry domain and repository path of a mirror
+// source, with the implicit docker.io domain made explicit.
+func normalizeImagePrefix(prefix string) (string, error) {
+	prefix = strings.TrimSuffix(strings.TrimSuffix(prefix, "*"), "/")
+	if prefix == "" {
+		return "", fmt.Errorf("Invalid image mirror, it requires a registry to mirror")
+	}
+	components := strings.SplitN(prefix, "/", 2)
+	domain := components[0]
+	if domain == IndexDockerIO {
+		domain = DockerIO
+	}
+	if !strings.ContainsAny(domain, ".:") && domain != "localhost" {
+		// The prefix has no domain, such as bitnami.
+		return DockerIO + "/" + prefix, nil
+	}
+	if len(components) == 1 {
+		return domain, nil
+	}
+	return domain + "/" + components[1], nil
+}
+
+// Transform implements ResourceTransformer.
+func (t *ImageRegistryTransformer) Transform(resourceList []interface{}) error {
+	return forEachResource(resourceList, func(kind string, resource map[interface{}]interface{}) error {
+		podSpec := t.podSpecPaths.podSpec(resource)
+		if podSpec == nil {
+			return nil
+		}
+		pullSecrets := []string{}
+		for _, container := range podSpecContainers(podSpec, "initContainers", "containers", "ephemeralContainers") {
+			image, ok := container["image"].(string)
+			if !ok {
+				continue
+			}
+			rewritten, mirror, ok := t.rewrite(image)
+			if !ok {
+				continue
+			}
+			log.Infof("rewriting image %s to %s", image, rewritten)
+			container["image"] = rewritten
+			if mirror.PullSecret != "" {
+				pullSecrets = append(pullSecrets, mirror.PullSecret)
+			}
+		}
+		if len(pullSecrets) > 0 {
+			addPullSecrets(podSpec, pullSecrets)
+		}
+		return nil
+	})
+}
+
+// rewrite returns the image rewritten to the most specific mirror of its registry.
+func (t *ImageRegistryTransformer) rewrite(image string) (string, ImageMirror, bool) {
+	ref, err := reference.ParseNormalizedNamed(image)
+	if err != nil {
+		log.Errorf("unable to parse image reference: %q", image)
+		return "", ImageMirror{}, false
+	}
+	// The name is fully qualified, such as docker.io/library/nginx for nginx.
+	name := ref.Name()
+	for _, m := range t.mirrors {
+		if name != m.From && !strings.HasPrefix(name, m.From+"/") {
+			continue
+		}
+		rewritten := m.To + strings.TrimPrefix(name, m.From)
+		if tagged, ok := ref.(reference.Tagged); ok {
+			rewritten += ":" + tagged.Tag()
+		}
+		if digested, ok := ref.(reference.Digested); ok {
+			rewritten += "@" + digested.Digest().String()
+		}
+		return rewritten, m, true
+	}
+	return "", ImageMirror{}, false
+}
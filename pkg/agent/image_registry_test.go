@@ -0,0 +1,184 @@
+package agent
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+const testDigest = "sha256:0f0c8f2fb2b6d8d7c6b7f8c9e4a2b0c1d3e5f7a9b1c3d5e7f9a1b3c5d7e9f1a3"
+
+func TestNewImageRegistryTransformer(t *testing.T) {
+	testCases := []struct {
+		name            string
+		mirrors         []ImageMirror
+		expectedMirrors []ImageMirror
+		expectErr       bool
+	}{
+		{
+			name: "it normalizes the mirrors and sorts them from the most specific",
+			mirrors: []ImageMirror{
+				{From: "docker.io", To: "mirror.corp/dockerhub"},
+				{From: "bitnami/*", To: "mirror.corp/bitnami/*", PullSecret: "mirror-creds"},
+				{From: "index.docker.io/library", To: "mirror.corp/library/"},
+			},
+			expectedMirrors: []ImageMirror{
+				{From: "docker.io/bitnami", To: "mirror.corp/bitnami", PullSecret: "mirror-creds"},
+				{From: "docker.io/library", To: "mirror.corp/library"},
+				{From: "docker.io", To: "mirror.corp/dockerhub"},
+			},
+		},
+		{
+			name:      "it errors without registry to mirror",
+			mirrors:   []ImageMirror{{From: "*", To: "mirror.corp"}},
+			expectErr: true,
+		},
+		{
+			name:      "it errors if the mirror is not a valid repository",
+			mirrors:   []ImageMirror{{From: "docker.io", To: "Mirror.Corp/Bitnami"}},
+			expectErr: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			transformer, err := NewImageRegistryTransformer(tc.mirrors, nil)
+			if got, want := err != nil, tc.expectErr; got != want {
+				t.Fatalf("got: %t, want: %t. err: %+v", got, want, err)
+			}
+			if tc.expectErr {
+				return
+			}
+			if got, want := transformer.mirrors, tc.expectedMirrors; !cmp.Equal(want, got) {
+				t.Errorf("mismatch (-want +got):\n%s", cmp.Diff(want, got))
+			}
+		})
+	}
+}
+
+func TestImageRegistryRewrite(t *testing.T) {
+	transformer, err := NewImageRegistryTransformer([]ImageMirror{
+		{From: "docker.io/bitnami/*", To: "mirror.corp/bitnami/*"},
+		{From: "docker.io", To: "mirror.corp/dockerhub"},
+		{From: "quay.io/prometheus", To: "mirror.corp/quay/prometheus"},
+	}, nil)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+
+	testCases := []struct {
+		name     string
+		image    string
+		expected string
+	}{
+		{
+			name:     "it rewrites images with an implicit docker.io domain",
+			image:    "bitnami/nginx:1.19.6",
+			expected: "mirror.corp/bitnami/nginx:1.19.6",
+		},
+		{
+			name:     "it rewrites images of the implicit library path",
+			image:    "nginx",
+			expected: "mirror.corp/dockerhub/library/nginx",
+		},
+		{
+			name:     "it rewrites images of the legacy docker hub domain",
+			image:    "index.docker.io/bitnami/redis:6.0",
+			expected: "mirror.corp/bitnami/redis:6.0",
+		},
+		{
+			name:     "it keeps the digest of the images",
+			image:    "docker.io/library/nginx@" + testDigest,
+			expected: "mirror.corp/dockerhub/library/nginx@" + testDigest,
+		},
+		{
+			name:     "it keeps both the tag and the digest of the images",
+			image:    "bitnami/nginx:1.19.6@" + testDigest,
+			expected: "mirror.corp/bitnami/nginx:1.19.6@" + testDigest,
+		},
+		{
+			name:     "it rewrites images of a repository path",
+			image:    "quay.io/prometheus/node-exporter:v1.0.1",
+			expected: "mirror.corp/quay/prometheus/node-exporter:v1.0.1",
+		},
+		{
+			name:  "it does not rewrite images of other repository paths",
+			image: "quay.io/prometheus-operator/prometheus-operator:v0.44.1",
+		},
+		{
+			name:  "it does not rewrite images of other registries",
+			image: "gcr.io/google-containers/pause:3.2",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			rewritten, _, ok := transformer.rewrite(tc.image)
+			if got, want := ok, tc.expected != ""; got != want {
+				t.Fatalf("got: %t, want: %t", got, want)
+			}
+			if got, want := rewritten, tc.expected; got != want {
+				t.Errorf("got: %q, want: %q", got, want)
+			}
+		})
+	}
+}
+
+func TestImageRegistryTransformerWithRegistrySecrets(t *testing.T) {
+	transformer, err := NewImageRegistryTransformer([]ImageMirror{
+		{From: "docker.io/bitnami", To: "mirror.corp/bitnami"},
+		{From: "quay.io", To: "quay-mirror.corp", PullSecret: "quay-mirror-creds"},
+	}, nil)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	// The registry secrets of the app repository match the rewritten domains.
+	postRenderer, err := newPostRenderer(map[string]string{"mirror.corp": "mirror-creds"}, nil, []ResourceTransformer{transformer})
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+
+	renderedManifests, err := postRenderer.Run(bytes.NewBufferString(`apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: web
+spec:
+  template:
+    spec:
+      initContainers:
+      - name: init
+        image: quay.io/coreos/etcd:v3.4
+      containers:
+      - name: web
+        image: bitnami/nginx:1.19.6
+      - name: other
+        image: gcr.io/google-containers/pause:3.2
+`))
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+
+	expected := `apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: web
+spec:
+  template:
+    spec:
+      containers:
+      - image: mirror.corp/bitnami/nginx:1.19.6
+        name: web
+      - image: gcr.io/google-containers/pause:3.2
+        name: other
+      imagePullSecrets:
+      - name: quay-mirror-creds
+      - name: mirror-creds
+      initContainers:
+      - image: quay-mirror.corp/coreos/etcd:v3.4
+        name: init
+`
+	if got, want := renderedManifests.String(), expected; got != want {
+		t.Errorf("mismatch (-want +got):\n%s", cmp.Diff(want, got))
+	}
+}
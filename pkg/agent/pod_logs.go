@@ -0,0 +1,148 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+
+	yamlUtils "github.com/kubeapps/kubeapps/pkg/yaml"
+	"helm.sh/helm/v3/pkg/release"
+	corev1 "k8s.io/api/core/v1"
+	k8sErrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+)
+
+// ReleasePod represents a pod created by the workloads of a release.
+type ReleasePod struct {
+	Name           string   `json:"name"`
+	Namespace      string   `json:"namespace"`
+	Owner          string   `json:"owner"`
+	Phase          string   `json:"phase"`
+	Containers     []string `json:"containers"`
+	InitContainers []string `json:"initContainers,omitempty"`
+}
+
+// HasContainer returns whether the pod has a container or init container with the given name.
+func (p ReleasePod) HasContainer(name string) bool {
+	for _, containers := range [][]string{p.Containers, p.InitContainers} {
+		for _, c := range containers {
+			if c == name {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func newReleasePod(pod *corev1.Pod, owner string) ReleasePod {
+	releasePod := ReleasePod{
+		Name:       pod.Name,
+		Namespace:  pod.Namespace,
+		Owner:      owner,
+		Phase:      string(pod.Status.Phase),
+		Containers: []string{},
+	}
+	for _, c := range pod.Spec.Containers {
+		releasePod.Containers = append(releasePod.Containers, c.Name)
+	}
+	for _, c := range pod.Spec.InitContainers {
+		releasePod.InitContainers = append(releasePod.InitContainers, c.Name)
+	}
+	return releasePod
+}
+
+// GetReleasePods returns the pods of the workloads of a release, sorted by name.
+// Workloads which are missing or which the user cannot read are skipped.
+func GetReleasePods(clients ResourceClients, rel *release.Release) ([]ReleasePod, error) {
+	objs, err := yamlUtils.ParseObjects(rel.Manifest)
+	if err != nil {
+		return nil, err
+	}
+	pods := []ReleasePod{}
+	// The pods are identified by namespace and name since the manifest can
+	// place resources outside of the namespace of the release.
+	seen := map[string]bool{}
+	for _, obj := range objs {
+		switch obj.GetKind() {
+		case "Pod", "Deployment", "StatefulSet", "DaemonSet", "ReplicaSet", "Job", "CronJob":
+		default:
+			continue
+		}
+		live, err := getLiveResource(clients, obj, rel.Namespace)
+		if err != nil {
+			if k8sErrors.IsNotFound(err) || k8sErrors.IsForbidden(err) || meta.IsNoMatchError(err) {
+				continue
+			}
+			return nil, err
+		}
+		owner := fmt.Sprintf("%s/%s", live.GetKind(), live.GetName())
+
+		var workloadPods []corev1.Pod
+		switch live.GetKind() {
+		case "Pod":
+			pod := corev1.Pod{}
+			err = runtime.DefaultUnstructuredConverter.FromUnstructured(live.Object, &pod)
+			workloadPods = []corev1.Pod{pod}
+		case "CronJob":
+			workloadPods, err = listCronJobPods(clients.Clientset, live)
+		default:
+			workloadPods, err = listWorkloadPods(clients.Clientset, live)
+		}
+		if err != nil {
+			return nil, err
+		}
+		for i := range workloadPods {
+			key := workloadPods[i].Namespace + "/" + workloadPods[i].Name
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+			pods = append(pods, newReleasePod(&workloadPods[i], owner))
+		}
+	}
+	sort.Slice(pods, func(i, j int) bool {
+		if pods[i].Name != pods[j].Name {
+			return pods[i].Name < pods[j].Name
+		}
+		return pods[i].Namespace < pods[j].Namespace
+	})
+	return pods, nil
+}
+
+// listCronJobPods returns the pods of the Jobs created by a CronJob, which has no
+// selector of its own.
+func listCronJobPods(clientset kubernetes.Interface, cronJob *unstructured.Unstructured) ([]corev1.Pod, error) {
+	jobs, err := clientset.BatchV1().Jobs(cronJob.GetNamespace()).List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	cronJobOwner := map[types.UID]bool{cronJob.GetUID(): true}
+	pods := []corev1.Pod{}
+	for _, job := range jobs.Items {
+		if job.Spec.Selector == nil || !isOwnedBy(job.OwnerReferences, cronJobOwner) {
+			continue
+		}
+		selector, err := metav1.LabelSelectorAsSelector(job.Spec.Selector)
+		if err != nil {
+			return nil, err
+		}
+		jobPods, err := listOwnedPods(clientset, job.Namespace, metav1.ListOptions{LabelSelector: selector.String()}, map[types.UID]bool{job.UID: true})
+		if err != nil {
+			return nil, err
+		}
+		pods = append(pods, jobPods...)
+	}
+	return pods, nil
+}
+
+// StreamPodLogs opens a stream with the logs of a pod container. The caller is
+// responsible for closing the stream.
+func StreamPodLogs(ctx context.Context, clientset kubernetes.Interface, namespace, podName string, options *corev1.PodLogOptions) (io.ReadCloser, error) {
+	return clientset.CoreV1().Pods(namespace).GetLogs(podName, options).Stream(ctx)
+}
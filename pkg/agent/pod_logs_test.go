@@ -0,0 +1,216 @@
+package agent
+
+import (
+	"context"
+	"io/ioutil"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	yamlUtils "github.com/kubeapps/kubeapps/pkg/yaml"
+	"helm.sh/helm/v3/pkg/release"
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	fakeDynamic "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestGetReleasePods(t *testing.T) {
+	manifest := `---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: foo
+---
+apiVersion: v1
+kind: Pod
+metadata:
+  name: foo-test
+---
+apiVersion: v1
+kind: Pod
+metadata:
+  name: foo-test
+  namespace: other
+---
+apiVersion: v1
+kind: Service
+metadata:
+  name: foo
+---
+apiVersion: batch/v1beta1
+kind: CronJob
+metadata:
+  name: foo-backup
+---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: missing
+`
+	liveResources := `---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: foo
+  namespace: default
+  uid: foo-uid
+spec:
+  selector:
+    matchLabels:
+      app: foo
+---
+apiVersion: v1
+kind: Pod
+metadata:
+  name: foo-test
+  namespace: default
+spec:
+  containers:
+  - name: test
+status:
+  phase: Succeeded
+---
+apiVersion: v1
+kind: Pod
+metadata:
+  name: foo-test
+  namespace: other
+spec:
+  containers:
+  - name: test
+status:
+  phase: Failed
+---
+apiVersion: v1
+kind: Service
+metadata:
+  name: foo
+  namespace: default
+---
+apiVersion: batch/v1beta1
+kind: CronJob
+metadata:
+  name: foo-backup
+  namespace: default
+  uid: foo-backup-uid
+`
+	replicaSet := &appsv1.ReplicaSet{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            "foo-5678",
+			Namespace:       "default",
+			UID:             "foo-5678-uid",
+			Labels:          map[string]string{"app": "foo"},
+			OwnerReferences: []metav1.OwnerReference{{APIVersion: "apps/v1", Kind: "Deployment", Name: "foo", UID: "foo-uid"}},
+		},
+	}
+	deploymentPod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            "foo-1234",
+			Namespace:       "default",
+			Labels:          map[string]string{"app": "foo"},
+			OwnerReferences: []metav1.OwnerReference{{APIVersion: "apps/v1", Kind: "ReplicaSet", Name: "foo-5678", UID: "foo-5678-uid"}},
+		},
+		Spec: corev1.PodSpec{
+			InitContainers: []corev1.Container{{Name: "init"}},
+			Containers:     []corev1.Container{{Name: "foo"}, {Name: "sidecar"}},
+		},
+		Status: corev1.PodStatus{Phase: corev1.PodRunning},
+	}
+	// The Jobs of a CronJob create its pods.
+	cronJobJob := &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            "foo-backup-1600",
+			Namespace:       "default",
+			UID:             "foo-backup-1600-uid",
+			OwnerReferences: []metav1.OwnerReference{{APIVersion: "batch/v1beta1", Kind: "CronJob", Name: "foo-backup", UID: "foo-backup-uid"}},
+		},
+		Spec: batchv1.JobSpec{
+			Selector: &metav1.LabelSelector{MatchLabels: map[string]string{"controller-uid": "foo-backup-1600-uid"}},
+		},
+	}
+	cronJobPod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            "foo-backup-1600-abcd",
+			Namespace:       "default",
+			Labels:          map[string]string{"controller-uid": "foo-backup-1600-uid"},
+			OwnerReferences: []metav1.OwnerReference{{APIVersion: "batch/v1", Kind: "Job", Name: "foo-backup-1600", UID: "foo-backup-1600-uid"}},
+		},
+		Spec:   corev1.PodSpec{Containers: []corev1.Container{{Name: "backup"}}},
+		Status: corev1.PodStatus{Phase: corev1.PodSucceeded},
+	}
+	otherJob := &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "other-job",
+			Namespace: "default",
+			UID:       "other-job-uid",
+		},
+		Spec: batchv1.JobSpec{
+			Selector: &metav1.LabelSelector{MatchLabels: map[string]string{"controller-uid": "other-job-uid"}},
+		},
+	}
+	otherJobPod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            "other-job-abcd",
+			Namespace:       "default",
+			Labels:          map[string]string{"controller-uid": "other-job-uid"},
+			OwnerReferences: []metav1.OwnerReference{{APIVersion: "batch/v1", Kind: "Job", Name: "other-job", UID: "other-job-uid"}},
+		},
+	}
+	otherPod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "bar-1234", Namespace: "default", Labels: map[string]string{"app": "bar"}},
+	}
+
+	liveObjs, err := yamlUtils.ParseObjects(liveResources)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	runtimeObjs := []runtime.Object{}
+	for _, obj := range liveObjs {
+		runtimeObjs = append(runtimeObjs, obj)
+	}
+	clients := ResourceClients{
+		Dynamic:    fakeDynamic.NewSimpleDynamicClient(runtime.NewScheme(), runtimeObjs...),
+		Clientset:  fake.NewSimpleClientset(replicaSet, deploymentPod, cronJobJob, cronJobPod, otherJob, otherJobPod, otherPod),
+		RESTMapper: newRESTMapperFixture(),
+	}
+	rel := &release.Release{Name: "foo", Namespace: "default", Manifest: manifest}
+
+	pods, err := GetReleasePods(clients, rel)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	expected := []ReleasePod{
+		{Name: "foo-1234", Namespace: "default", Owner: "Deployment/foo", Phase: "Running", Containers: []string{"foo", "sidecar"}, InitContainers: []string{"init"}},
+		{Name: "foo-backup-1600-abcd", Namespace: "default", Owner: "CronJob/foo-backup", Phase: "Succeeded", Containers: []string{"backup"}},
+		{Name: "foo-test", Namespace: "default", Owner: "Pod/foo-test", Phase: "Succeeded", Containers: []string{"test"}},
+		{Name: "foo-test", Namespace: "other", Owner: "Pod/foo-test", Phase: "Failed", Containers: []string{"test"}},
+	}
+	if got, want := pods, expected; !cmp.Equal(want, got) {
+		t.Errorf("mismatch (-want +got):\n%s", cmp.Diff(want, got))
+	}
+	if !pods[0].HasContainer("init") || pods[0].HasContainer("test") {
+		t.Errorf("unexpected containers for pod %+v", pods[0])
+	}
+}
+
+func TestStreamPodLogs(t *testing.T) {
+	clientset := fake.NewSimpleClientset(&corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "foo", Namespace: "default"},
+	})
+
+	logs, err := StreamPodLogs(context.TODO(), clientset, "default", "foo", &corev1.PodLogOptions{Container: "foo"})
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	defer logs.Close()
+	content, err := ioutil.ReadAll(logs)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if got, want := string(content), "fake logs"; got != want {
+		t.Errorf("got: %q, want: %q", got, want)
+	}
+}
@@ -0,0 +1,213 @@
+package agent
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"sort"
+
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v2"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// ResourceTransformer transforms the resources rendered for a release. The resources
+// are the untyped YAML documents of the manifest, so that transformers are
+// independent of api versions, and are modified in place.
+type ResourceTransformer interface {
+	Transform(resourceList []interface{}) error
+}
+
+// PostRendererPipeline is a helm post-renderer (see https://helm.sh/docs/topics/advanced/#post-rendering)
+// which parses the rendered manifest once and runs a chain of transformers on its resources.
+type PostRendererPipeline struct {
+	transformers []ResourceTransformer
+}
+
+// NewPostRendererPipeline returns a post renderer running the transformers in order.
+func NewPostRendererPipeline(transformers ...ResourceTransformer) *PostRendererPipeline {
+	return &PostRendererPipeline{transformers: transformers}
+}
+
+// Run returns the rendered yaml including the changes of every transformer.
+// The manifest is returned as is if there is no transformer.
+func (p *PostRendererPipeline) Run(renderedManifests *bytes.Buffer) (modifiedManifests *bytes.Buffer, err error) {
+	if len(p.transformers) == 0 {
+		return renderedManifests, nil
+	}
+
+	decoder := yaml.NewDecoder(renderedManifests)
+	var resourceList []interface{}
+	for {
+		var resource interface{}
+		err := decoder.Decode(&resource)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		resourceList = append(resourceList, resource)
+	}
+
+	for _, t := range p.transformers {
+		if err := t.Transform(resourceList); err != nil {
+			return nil, err
+		}
+	}
+
+	modifiedManifests = bytes.NewBuffer([]byte{})
+	encoder := yaml.NewEncoder(modifiedManifests)
+	defer encoder.Close()
+
+	for _, resource := range resourceList {
+		err = encoder.Encode(resource)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return modifiedManifests, nil
+}
+
+// forEachResource calls f with the kind of every resource of the list, including
+// the items of List resources. Invalid resources are logged and skipped.
+func forEachResource(resourceList []interface{}, f func(kind string, resource map[interface{}]interface{}) error) error {
+	for _, resourceItem := range resourceList {
+		resource, ok := resourceItem.(map[interface{}]interface{})
+		if !ok {
+			continue
+		}
+		kind, ok := resource["kind"].(string)
+		if !ok {
+			log.Errorf("invalid resource: no string kind. %+v", resource)
+			continue
+		}
+		if items, ok := resource["items"]; ok {
+			if itemsSlice, ok := items.([]interface{}); ok {
+				if err := forEachResource(itemsSlice, f); err != nil {
+					return err
+				}
+			} else {
+				log.Errorf("Items of list type did not contain a slice: %+v", resource)
+			}
+			continue
+		}
+		if err := f(kind, resource); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// PostRendererConfig configures the transformations applied to the resources of
+// the releases, on top of the image pull secrets of their app repository.
+type PostRendererConfig struct {
+	// Labels and Annotations are added to every resource and pod template,
+	// unless already set.
+	Labels      map[string]string `json:"labels,omitempty"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+	// ImageMirrors rewrite the images of the containers to mirrors of their registry.
+	ImageMirrors []ImageMirror `json:"imageMirrors,omitempty"`
+	// ImagePullSecrets are added to every pod spec.
+	ImagePullSecrets []string `json:"imagePullSecrets,omitempty"`
+	// ResourceRequests are the default requests, such as cpu or memory, of the
+	// containers which do not set them.
+	ResourceRequests map[string]string `json:"resourceRequests,omitempty"`
+	// NodeSelector and Tolerations are added to every pod spec, unless already set.
+	NodeSelector map[string]string   `json:"nodeSelector,omitempty"`
+	Tolerations  []corev1.Toleration `json:"tolerations,omitempty"`
+	// PatchesConfigMap is the name of a ConfigMap, in the namespace of the release,
+	// whose entries are strategic merge patches applied to the matching resources.
+	PatchesConfigMap string `json:"patchesConfigMap,omitempty"`
+}
+
+// ClusterPostRendererConfig configures the post-rendering of the releases of a
+// cluster, possibly overridden for some namespaces.
+type ClusterPostRendererConfig struct {
+	Default    *PostRendererConfig           `json:"default,omitempty"`
+	Namespaces map[string]PostRendererConfig `json:"namespaces,omitempty"`
+}
+
+// PostRendererConfigs configures the post-rendering of releases per cluster and
+// namespace. The configuration of a namespace replaces the one of its cluster,
+// which replaces the default one.
+type PostRendererConfigs struct {
+	// PodSpecPaths maps the kinds of custom resources creating pods, qualified by
+	// their group, to the dotted path of their pod spec, for every cluster.
+	PodSpecPaths PodSpecPaths                         `json:"podSpecPaths,omitempty"`
+	Default      *PostRendererConfig                  `json:"default,omitempty"`
+	Clusters     map[string]ClusterPostRendererConfig `json:"clusters,omitempty"`
+}
+
+// For returns the configuration of the releases of a namespace of a cluster.
+func (c PostRendererConfigs) For(cluster, namespace string) PostRendererConfig {
+	if clusterConfig, ok := c.Clusters[cluster]; ok {
+		if config, ok := clusterConfig.Namespaces[namespace]; ok {
+			return config
+		}
+		if clusterConfig.Default != nil {
+			return *clusterConfig.Default
+		}
+	}
+	if c.Default != nil {
+		return *c.Default
+	}
+	return PostRendererConfig{}
+}
+
+// Transformers returns the transformers of the configuration, the images being
+// rewritten first and the strategic merge patches being applied last. The pods of
+// the custom resources of podSpecPaths are transformed as well.
+func (c PostRendererConfig) Transformers(podSpecPaths PodSpecPaths, patches []string) ([]ResourceTransformer, error) {
+	transformers := []ResourceTransformer{}
+	if len(c.ImageMirrors) > 0 {
+		t, err := NewImageRegistryTransformer(c.ImageMirrors, podSpecPaths)
+		if err != nil {
+			return nil, err
+		}
+		transformers = append(transformers, t)
+	}
+	if len(c.Labels) > 0 || len(c.Annotations) > 0 {
+		transformers = append(transformers, &MetadataTransformer{Labels: c.Labels, Annotations: c.Annotations, PodSpecPaths: podSpecPaths})
+	}
+	if len(c.ImagePullSecrets) > 0 {
+		transformers = append(transformers, &PullSecretsTransformer{Secrets: c.ImagePullSecrets, PodSpecPaths: podSpecPaths})
+	}
+	if len(c.ResourceRequests) > 0 {
+		transformers = append(transformers, &ResourceRequestsTransformer{Requests: c.ResourceRequests, PodSpecPaths: podSpecPaths})
+	}
+	if len(c.NodeSelector) > 0 || len(c.Tolerations) > 0 {
+		transformers = append(transformers, &SchedulingTransformer{NodeSelector: c.NodeSelector, Tolerations: c.Tolerations, PodSpecPaths: podSpecPaths})
+	}
+	if len(patches) > 0 {
+		t, err := NewPatchesTransformer(patches)
+		if err != nil {
+			return nil, err
+		}
+		transformers = append(transformers, t)
+	}
+	return transformers, nil
+}
+
+// LoadPatches returns the strategic merge patches stored in the entries of a ConfigMap,
+// sorted by key.
+func LoadPatches(clientset kubernetes.Interface, namespace, name string) ([]string, error) {
+	configMap, err := clientset.CoreV1().ConfigMaps(namespace).Get(context.TODO(), name, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("Unable to get the patches of ConfigMap %q: %v", name, err)
+	}
+	keys := []string{}
+	for key := range configMap.Data {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	patches := []string{}
+	for _, key := range keys {
+		patches = append(patches, configMap.Data[key])
+	}
+	return patches, nil
+}
@@ -0,0 +1,131 @@
+package agent
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestPostRendererPipelineWithoutTransformers(t *testing.T) {
+	input := bytes.NewBufferString(`anything at : all`)
+
+	output, err := NewPostRendererPipeline().Run(input)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if got, want := output.String(), `anything at : all`; got != want {
+		t.Errorf("got: %q, want: %q", got, want)
+	}
+}
+
+func TestPostRendererConfigsFor(t *testing.T) {
+	defaultConfig := PostRendererConfig{Labels: map[string]string{"scope": "default"}}
+	clusterConfig := PostRendererConfig{Labels: map[string]string{"scope": "cluster"}}
+	namespaceConfig := PostRendererConfig{Labels: map[string]string{"scope": "namespace"}}
+	configs := PostRendererConfigs{
+		Default: &defaultConfig,
+		Clusters: map[string]ClusterPostRendererConfig{
+			"prod": {
+				Default:    &clusterConfig,
+				Namespaces: map[string]PostRendererConfig{"payments": namespaceConfig},
+			},
+			"staging": {
+				Namespaces: map[string]PostRendererConfig{"payments": namespaceConfig},
+			},
+		},
+	}
+
+	testCases := []struct {
+		name      string
+		configs   PostRendererConfigs
+		cluster   string
+		namespace string
+		expected  PostRendererConfig
+	}{
+		{
+			name:      "it returns the config of the namespace",
+			configs:   configs,
+			cluster:   "prod",
+			namespace: "payments",
+			expected:  namespaceConfig,
+		},
+		{
+			name:      "it returns the config of the cluster for other namespaces",
+			configs:   configs,
+			cluster:   "prod",
+			namespace: "default",
+			expected:  clusterConfig,
+		},
+		{
+			name:      "it returns the default config for clusters without default",
+			configs:   configs,
+			cluster:   "staging",
+			namespace: "default",
+			expected:  defaultConfig,
+		},
+		{
+			name:      "it returns the default config for other clusters",
+			configs:   configs,
+			cluster:   "other",
+			namespace: "payments",
+			expected:  defaultConfig,
+		},
+		{
+			name:     "it returns an empty config without configuration",
+			cluster:  "prod",
+			expected: PostRendererConfig{},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got, want := tc.configs.For(tc.cluster, tc.namespace), tc.expected; !cmp.Equal(want, got) {
+				t.Errorf("mismatch (-want +got):\n%s", cmp.Diff(want, got))
+			}
+		})
+	}
+}
+
+func TestPostRendererConfigTransformers(t *testing.T) {
+	config := PostRendererConfig{
+		Annotations:      map[string]string{"owner": "kubeapps"},
+		ResourceRequests: map[string]string{"cpu": "50m"},
+	}
+
+	transformers, err := config.Transformers(nil, []string{"kind: Deployment\nmetadata:\n  name: web\n"})
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if got, want := len(transformers), 3; got != want {
+		t.Fatalf("got: %d, want: %d", got, want)
+	}
+	if _, ok := transformers[2].(*PatchesTransformer); !ok {
+		t.Errorf("expected the patches to be applied last, got: %T", transformers[2])
+	}
+}
+
+func TestLoadPatches(t *testing.T) {
+	clientset := fake.NewSimpleClientset(&corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "patches", Namespace: "default"},
+		Data: map[string]string{
+			"b-service.yaml":    "kind: Service",
+			"a-deployment.yaml": "kind: Deployment",
+		},
+	})
+
+	patches, err := LoadPatches(clientset, "default", "patches")
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if got, want := patches, []string{"kind: Deployment", "kind: Service"}; !cmp.Equal(want, got) {
+		t.Errorf("mismatch (-want +got):\n%s", cmp.Diff(want, got))
+	}
+
+	if _, err := LoadPatches(clientset, "other", "patches"); err == nil {
+		t.Errorf("expected an error for a missing ConfigMap")
+	}
+}
@@ -0,0 +1,173 @@
+package agent
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	yamlUtils "github.com/kubeapps/kubeapps/pkg/yaml"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+const (
+	// ResourceAdded identifies a resource which is only present in the proposed manifest.
+	ResourceAdded = "added"
+	// ResourceRemoved identifies a resource which is only present in the current manifest.
+	ResourceRemoved = "removed"
+	// ResourceChanged identifies a resource present in both manifests with different content.
+	ResourceChanged = "changed"
+)
+
+// FieldDiff represents a change of a single field of a resource. A nil
+// value means the field is not set on that side.
+type FieldDiff struct {
+	Path     string      `json:"path"`
+	Current  interface{} `json:"current,omitempty"`
+	Proposed interface{} `json:"proposed,omitempty"`
+}
+
+// ResourceDiff represents a resource which differs between two manifests.
+type ResourceDiff struct {
+	APIVersion string      `json:"apiVersion"`
+	Kind       string      `json:"kind"`
+	Namespace  string      `json:"namespace,omitempty"`
+	Name       string      `json:"name"`
+	Change     string      `json:"change"`
+	Fields     []FieldDiff `json:"fields,omitempty"`
+}
+
+// resourceKey identifies a resource independently of the version of its API group,
+// so that a resource moved to a newer API version is reported as changed.
+func resourceKey(obj *unstructured.Unstructured) string {
+	gvk := obj.GroupVersionKind()
+	return fmt.Sprintf("%s/%s/%s/%s", gvk.Group, gvk.Kind, obj.GetNamespace(), obj.GetName())
+}
+
+func newResourceDiff(obj *unstructured.Unstructured, change string) ResourceDiff {
+	return ResourceDiff{
+		APIVersion: obj.GetAPIVersion(),
+		Kind:       obj.GetKind(),
+		Namespace:  obj.GetNamespace(),
+		Name:       obj.GetName(),
+		Change:     change,
+	}
+}
+
+// DiffManifests compares the resources of the current and proposed manifests and
+// returns the ones which are added, removed or changed, sorted by kind, namespace and name.
+// Unchanged resources are not included.
+func DiffManifests(current, proposed string) ([]ResourceDiff, error) {
+	currentObjs, err := yamlUtils.ParseObjects(current)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to parse the current manifest: %v", err)
+	}
+	proposedObjs, err := yamlUtils.ParseObjects(proposed)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to parse the proposed manifest: %v", err)
+	}
+
+	currentByKey := map[string]*unstructured.Unstructured{}
+	for _, obj := range currentObjs {
+		currentByKey[resourceKey(obj)] = obj
+	}
+
+	diffs := []ResourceDiff{}
+	seen := map[string]bool{}
+	for _, obj := range proposedObjs {
+		key := resourceKey(obj)
+		seen[key] = true
+		currentObj, ok := currentByKey[key]
+		if !ok {
+			diffs = append(diffs, newResourceDiff(obj, ResourceAdded))
+			continue
+		}
+		fields := diffFields("", currentObj.Object, obj.Object)
+		if len(fields) > 0 {
+			d := newResourceDiff(obj, ResourceChanged)
+			d.Fields = fields
+			diffs = append(diffs, d)
+		}
+	}
+	for _, obj := range currentObjs {
+		if !seen[resourceKey(obj)] {
+			diffs = append(diffs, newResourceDiff(obj, ResourceRemoved))
+		}
+	}
+
+	sort.SliceStable(diffs, func(i, j int) bool {
+		if diffs[i].Kind != diffs[j].Kind {
+			return diffs[i].Kind < diffs[j].Kind
+		}
+		if diffs[i].Namespace != diffs[j].Namespace {
+			return diffs[i].Namespace < diffs[j].Namespace
+		}
+		return diffs[i].Name < diffs[j].Name
+	})
+	return diffs, nil
+}
+
+// fieldPath appends a map key to a path, quoting keys which contain dots
+// (such as most label and annotation keys) so the path remains unambiguous.
+func fieldPath(path, key string) string {
+	if strings.Contains(key, ".") {
+		return fmt.Sprintf("%s[%q]", path, key)
+	}
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+// diffFields recursively compares two unstructured values and returns the
+// leaf fields which differ.
+func diffFields(path string, current, proposed interface{}) []FieldDiff {
+	switch c := current.(type) {
+	case map[string]interface{}:
+		p, ok := proposed.(map[string]interface{})
+		if !ok {
+			break
+		}
+		keys := []string{}
+		for k := range c {
+			keys = append(keys, k)
+		}
+		for k := range p {
+			if _, ok := c[k]; !ok {
+				keys = append(keys, k)
+			}
+		}
+		sort.Strings(keys)
+		diffs := []FieldDiff{}
+		for _, k := range keys {
+			diffs = append(diffs, diffFields(fieldPath(path, k), c[k], p[k])...)
+		}
+		return diffs
+	case []interface{}:
+		p, ok := proposed.([]interface{})
+		if !ok {
+			break
+		}
+		length := len(c)
+		if len(p) > length {
+			length = len(p)
+		}
+		diffs := []FieldDiff{}
+		for i := 0; i < length; i++ {
+			var currentItem, proposedItem interface{}
+			if i < len(c) {
+				currentItem = c[i]
+			}
+			if i < len(p) {
+				proposedItem = p[i]
+			}
+			diffs = append(diffs, diffFields(fmt.Sprintf("%s[%d]", path, i), currentItem, proposedItem)...)
+		}
+		return diffs
+	}
+
+	if reflect.DeepEqual(current, proposed) {
+		return nil
+	}
+	return []FieldDiff{{Path: path, Current: current, Proposed: proposed}}
+}
@@ -0,0 +1,144 @@
+package agent
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestDiffManifests(t *testing.T) {
+	testCases := []struct {
+		name     string
+		current  string
+		proposed string
+		expected []ResourceDiff
+	}{
+		{
+			name: "returns no differences for identical manifests",
+			current: `---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: foo
+data:
+  key: value
+`,
+			proposed: `---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: foo
+data:
+  key: value
+`,
+			expected: []ResourceDiff{},
+		},
+		{
+			name: "returns added and removed resources",
+			current: `---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: foo
+`,
+			proposed: `---
+apiVersion: v1
+kind: Secret
+metadata:
+  name: bar
+  namespace: other
+`,
+			expected: []ResourceDiff{
+				{APIVersion: "v1", Kind: "ConfigMap", Name: "foo", Change: ResourceRemoved},
+				{APIVersion: "v1", Kind: "Secret", Namespace: "other", Name: "bar", Change: ResourceAdded},
+			},
+		},
+		{
+			name: "returns the changed fields of a resource",
+			current: `---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: foo
+  labels:
+    app.kubernetes.io/version: "1.0"
+spec:
+  replicas: 1
+  template:
+    spec:
+      containers:
+      - name: foo
+        image: foo:1.0
+`,
+			proposed: `---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: foo
+  labels:
+    app.kubernetes.io/version: "1.1"
+spec:
+  replicas: 2
+  template:
+    spec:
+      containers:
+      - name: foo
+        image: foo:1.1
+      - name: sidecar
+        image: sidecar:1.0
+`,
+			expected: []ResourceDiff{
+				{
+					APIVersion: "apps/v1",
+					Kind:       "Deployment",
+					Name:       "foo",
+					Change:     ResourceChanged,
+					Fields: []FieldDiff{
+						{Path: `metadata.labels["app.kubernetes.io/version"]`, Current: "1.0", Proposed: "1.1"},
+						{Path: "spec.replicas", Current: int64(1), Proposed: int64(2)},
+						{Path: "spec.template.spec.containers[0].image", Current: "foo:1.0", Proposed: "foo:1.1"},
+						{Path: "spec.template.spec.containers[1]", Proposed: map[string]interface{}{"name": "sidecar", "image": "sidecar:1.0"}},
+					},
+				},
+			},
+		},
+		{
+			name: "treats a resource moved to a new api version as changed",
+			current: `---
+apiVersion: apps/v1beta1
+kind: Deployment
+metadata:
+  name: foo
+`,
+			proposed: `---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: foo
+`,
+			expected: []ResourceDiff{
+				{
+					APIVersion: "apps/v1",
+					Kind:       "Deployment",
+					Name:       "foo",
+					Change:     ResourceChanged,
+					Fields: []FieldDiff{
+						{Path: "apiVersion", Current: "apps/v1beta1", Proposed: "apps/v1"},
+					},
+				},
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			diffs, err := DiffManifests(tc.current, tc.proposed)
+			if err != nil {
+				t.Fatalf("%+v", err)
+			}
+			if got, want := diffs, tc.expected; !cmp.Equal(want, got) {
+				t.Errorf("mismatch (-want +got):\n%s", cmp.Diff(want, got))
+			}
+		})
+	}
+}
@@ -0,0 +1,57 @@
+package agent
+
+import (
+	"strings"
+
+	"github.com/kubeapps/kubeapps/pkg/proxy"
+	"helm.sh/helm/v3/pkg/action"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// ReleaseFilter selects the releases to list.
+type ReleaseFilter struct {
+	// Status is "all" to list the releases in every status, otherwise only the
+	// deployed and failed releases are listed.
+	Status string
+	// NameContains selects the releases with a name containing it, ignoring the case.
+	NameContains string
+	// ChartName selects the releases of the chart.
+	ChartName string
+	// Selector selects the releases by the labels of the Secret or ConfigMap
+	// storing them, including the labels added by users. The memory and SQL
+	// drivers do not store labels.
+	Selector labels.Selector
+}
+
+// ListReleasesMatching lists every release in the specified namespace, or all
+// namespaces if the empty string is given, which matches the filter.
+func ListReleasesMatching(actionConfig *action.Configuration, namespace string, filter ReleaseFilter) ([]proxy.AppOverview, error) {
+	allNamespaces := namespace == ""
+	cmd := action.NewList(actionConfig)
+	cmd.AllNamespaces = allNamespaces
+	if filter.Status == "all" {
+		cmd.StateMask = action.ListAll
+	}
+	releases, err := cmd.Run()
+	if err != nil {
+		return nil, err
+	}
+	nameContains := strings.ToLower(filter.NameContains)
+	appOverviews := make([]proxy.AppOverview, 0)
+	for _, r := range releases {
+		if !allNamespaces && r.Namespace != namespace {
+			continue
+		}
+		if nameContains != "" && !strings.Contains(strings.ToLower(r.Name), nameContains) {
+			continue
+		}
+		if filter.ChartName != "" && (r.Chart == nil || r.Chart.Name() != filter.ChartName) {
+			continue
+		}
+		if filter.Selector != nil && !filter.Selector.Matches(labels.Set(r.Labels)) {
+			continue
+		}
+		appOverviews = append(appOverviews, appOverviewFromRelease(r))
+	}
+	return appOverviews, nil
+}
@@ -0,0 +1,143 @@
+package agent
+
+import (
+	"context"
+	"sort"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"helm.sh/helm/v3/pkg/release"
+	"helm.sh/helm/v3/pkg/storage"
+	"helm.sh/helm/v3/pkg/storage/driver"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestListReleasesMatching(t *testing.T) {
+	releases := []releaseStub{
+		{"airwatch", "default", 1, "1.0.0", release.StatusDeployed},
+		{"wordpress", "default", 2, "1.0.1", release.StatusDeployed},
+		{"my-wordpress", "other", 1, "1.0.2", release.StatusDeployed},
+		{"removed-wordpress", "other", 1, "1.0.3", release.StatusUninstalled},
+	}
+
+	testCases := []struct {
+		name          string
+		namespace     string
+		filter        ReleaseFilter
+		expectedNames []string
+	}{
+		{
+			name:          "lists every deployed release without a filter",
+			expectedNames: []string{"airwatch", "my-wordpress", "wordpress"},
+		},
+		{
+			name:          "lists the releases of every status",
+			filter:        ReleaseFilter{Status: "all"},
+			expectedNames: []string{"airwatch", "my-wordpress", "removed-wordpress", "wordpress"},
+		},
+		{
+			name:          "filters the releases of a namespace",
+			namespace:     "other",
+			expectedNames: []string{"my-wordpress"},
+		},
+		{
+			name:          "filters by a substring of the name ignoring the case",
+			filter:        ReleaseFilter{NameContains: "WordPress"},
+			expectedNames: []string{"my-wordpress", "wordpress"},
+		},
+		{
+			name:          "filters by chart name",
+			filter:        ReleaseFilter{ChartName: "airwatch-chart"},
+			expectedNames: []string{},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			actionConfig := newActionConfigFixture(t)
+			makeReleases(t, actionConfig, releases)
+			actionConfig.Releases.Driver.(*driver.Memory).SetNamespace(tc.namespace)
+
+			apps, err := ListReleasesMatching(actionConfig, tc.namespace, tc.filter)
+			if err != nil {
+				t.Fatalf("%+v", err)
+			}
+			names := []string{}
+			for _, app := range apps {
+				names = append(names, app.ReleaseName)
+			}
+			sort.Strings(names)
+			if got, want := names, tc.expectedNames; !cmp.Equal(want, got) {
+				t.Errorf("mismatch (-want +got):\n%s", cmp.Diff(want, got))
+			}
+		})
+	}
+}
+
+func TestListReleasesMatchingLabels(t *testing.T) {
+	clientset := fake.NewSimpleClientset()
+	secrets := clientset.CoreV1().Secrets("default")
+	actionConfig := newActionConfigFixture(t)
+	actionConfig.Releases = storage.Init(driver.NewSecrets(secrets))
+	makeReleases(t, actionConfig, []releaseStub{
+		{"blog", "default", 1, "1.0.0", release.StatusDeployed},
+		{"shop", "default", 1, "1.0.0", release.StatusDeployed},
+		{"shop", "default", 2, "1.0.1", release.StatusDeployed},
+	})
+	// Users can label the storage of their releases, such as with kubectl label.
+	for _, name := range []string{"sh.helm.release.v1.blog.v1", "sh.helm.release.v1.shop.v1"} {
+		secret, err := secrets.Get(context.TODO(), name, metav1.GetOptions{})
+		if err != nil {
+			t.Fatalf("%+v", err)
+		}
+		secret.Labels["team"] = "web"
+		if _, err := secrets.Update(context.TODO(), secret, metav1.UpdateOptions{}); err != nil {
+			t.Fatalf("%+v", err)
+		}
+	}
+
+	testCases := []struct {
+		name          string
+		selector      string
+		expectedNames []string
+	}{
+		{
+			name:          "filters by the labels added to the storage",
+			selector:      "team=web",
+			expectedNames: []string{"blog"},
+		},
+		{
+			name:          "filters by the labels set by Helm",
+			selector:      "version=2",
+			expectedNames: []string{"shop"},
+		},
+		{
+			name:          "filters by missing labels",
+			selector:      "!team",
+			expectedNames: []string{"shop"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			selector, err := labels.Parse(tc.selector)
+			if err != nil {
+				t.Fatalf("%+v", err)
+			}
+			apps, err := ListReleasesMatching(actionConfig, "default", ReleaseFilter{Selector: selector})
+			if err != nil {
+				t.Fatalf("%+v", err)
+			}
+			names := []string{}
+			for _, app := range apps {
+				names = append(names, app.ReleaseName)
+			}
+			sort.Strings(names)
+			if got, want := names, tc.expectedNames; !cmp.Equal(want, got) {
+				t.Errorf("mismatch (-want +got):\n%s", cmp.Diff(want, got))
+			}
+		})
+	}
+}
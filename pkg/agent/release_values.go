@@ -0,0 +1,89 @@
+package agent
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+
+	"helm.sh/helm/v3/pkg/chartutil"
+	"helm.sh/helm/v3/pkg/release"
+)
+
+const (
+	// ValueAdded identifies a user-supplied value without a default in the chart.
+	ValueAdded = "added"
+	// ValueChanged identifies a user-supplied value which differs from the chart default.
+	ValueChanged = "changed"
+	// ValueUnchanged identifies a user-supplied value equal to the chart default.
+	ValueUnchanged = "unchanged"
+)
+
+// ValueOverride represents a value supplied by the user compared with the
+// default value of the chart.
+type ValueOverride struct {
+	Path    string      `json:"path"`
+	Change  string      `json:"change"`
+	Default interface{} `json:"default,omitempty"`
+	Value   interface{} `json:"value"`
+}
+
+// ReleaseValues contains the values of a release revision: the ones supplied by
+// the user, the ones computed by merging them with the chart defaults, and the
+// comparison of the user-supplied values with the chart defaults.
+type ReleaseValues struct {
+	Revision     int                    `json:"revision"`
+	UserSupplied map[string]interface{} `json:"userSupplied"`
+	Computed     map[string]interface{} `json:"computed"`
+	Overrides    []ValueOverride        `json:"overrides"`
+}
+
+// GetReleaseValues returns the values of a release revision.
+func GetReleaseValues(rel *release.Release) (*ReleaseValues, error) {
+	userSupplied := rel.Config
+	if userSupplied == nil {
+		userSupplied = map[string]interface{}{}
+	}
+	computed := userSupplied
+	var defaults map[string]interface{}
+	if rel.Chart != nil {
+		coalesced, err := chartutil.CoalesceValues(rel.Chart, userSupplied)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to compute the values of the release: %v", err)
+		}
+		computed = coalesced
+		defaults = rel.Chart.Values
+	}
+	overrides := compareValues("", defaults, userSupplied)
+	sort.Slice(overrides, func(i, j int) bool {
+		return overrides[i].Path < overrides[j].Path
+	})
+	return &ReleaseValues{
+		Revision:     rel.Version,
+		UserSupplied: userSupplied,
+		Computed:     computed,
+		Overrides:    overrides,
+	}, nil
+}
+
+// compareValues returns the leaf values supplied by the user together with the
+// corresponding default of the chart. Lists are compared as a whole.
+func compareValues(path string, defaults, values map[string]interface{}) []ValueOverride {
+	overrides := []ValueOverride{}
+	for key, value := range values {
+		keyPath := fieldPath(path, key)
+		defaultValue, hasDefault := defaults[key]
+		valueMap, isMap := value.(map[string]interface{})
+		defaultMap, isDefaultMap := defaultValue.(map[string]interface{})
+		switch {
+		case isMap && (isDefaultMap || !hasDefault):
+			overrides = append(overrides, compareValues(keyPath, defaultMap, valueMap)...)
+		case !hasDefault:
+			overrides = append(overrides, ValueOverride{Path: keyPath, Change: ValueAdded, Value: value})
+		case reflect.DeepEqual(defaultValue, value):
+			overrides = append(overrides, ValueOverride{Path: keyPath, Change: ValueUnchanged, Default: defaultValue, Value: value})
+		default:
+			overrides = append(overrides, ValueOverride{Path: keyPath, Change: ValueChanged, Default: defaultValue, Value: value})
+		}
+	}
+	return overrides
+}
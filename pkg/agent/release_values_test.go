@@ -0,0 +1,96 @@
+package agent
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/release"
+)
+
+func TestGetReleaseValues(t *testing.T) {
+	chartValues := map[string]interface{}{
+		"replicaCount": float64(1),
+		"image": map[string]interface{}{
+			"repository": "bitnami/apache",
+			"tag":        "2.4.46",
+		},
+		"podLabels": map[string]interface{}{},
+		"service":   map[string]interface{}{"type": "LoadBalancer"},
+	}
+
+	testCases := []struct {
+		name     string
+		config   map[string]interface{}
+		expected *ReleaseValues
+	}{
+		{
+			name: "returns the chart defaults when the user did not supply values",
+			expected: &ReleaseValues{
+				Revision:     2,
+				UserSupplied: map[string]interface{}{},
+				Computed:     chartValues,
+				Overrides:    []ValueOverride{},
+			},
+		},
+		{
+			name: "compares the user-supplied values with the chart defaults",
+			config: map[string]interface{}{
+				"replicaCount": float64(1),
+				"image":        map[string]interface{}{"tag": "2.4.47"},
+				"podLabels":    map[string]interface{}{"app.kubernetes.io/team": "web"},
+				"service":      "ClusterIP",
+				"extra":        []interface{}{"foo"},
+			},
+			expected: &ReleaseValues{
+				Revision: 2,
+				UserSupplied: map[string]interface{}{
+					"replicaCount": float64(1),
+					"image":        map[string]interface{}{"tag": "2.4.47"},
+					"podLabels":    map[string]interface{}{"app.kubernetes.io/team": "web"},
+					"service":      "ClusterIP",
+					"extra":        []interface{}{"foo"},
+				},
+				Computed: map[string]interface{}{
+					"replicaCount": float64(1),
+					"image": map[string]interface{}{
+						"repository": "bitnami/apache",
+						"tag":        "2.4.47",
+					},
+					"podLabels": map[string]interface{}{"app.kubernetes.io/team": "web"},
+					"service":   "ClusterIP",
+					"extra":     []interface{}{"foo"},
+				},
+				Overrides: []ValueOverride{
+					{Path: "extra", Change: ValueAdded, Value: []interface{}{"foo"}},
+					{Path: "image.tag", Change: ValueChanged, Default: "2.4.46", Value: "2.4.47"},
+					{Path: `podLabels["app.kubernetes.io/team"]`, Change: ValueAdded, Value: "web"},
+					{Path: "replicaCount", Change: ValueUnchanged, Default: float64(1), Value: float64(1)},
+					{Path: "service", Change: ValueChanged, Default: map[string]interface{}{"type": "LoadBalancer"}, Value: "ClusterIP"},
+				},
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			rel := &release.Release{
+				Name:    "foo",
+				Version: 2,
+				Config:  tc.config,
+				Chart: &chart.Chart{
+					Metadata: &chart.Metadata{Name: "apache"},
+					Values:   chartValues,
+				},
+			}
+
+			values, err := GetReleaseValues(rel)
+			if err != nil {
+				t.Fatalf("%+v", err)
+			}
+			if got, want := values, tc.expected; !cmp.Equal(want, got) {
+				t.Errorf("mismatch (-want +got):\n%s", cmp.Diff(want, got))
+			}
+		})
+	}
+}
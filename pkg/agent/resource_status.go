@@ -0,0 +1,501 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+
+	yamlUtils "github.com/kubeapps/kubeapps/pkg/yaml"
+	"helm.sh/helm/v3/pkg/release"
+	corev1 "k8s.io/api/core/v1"
+	k8sErrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	// HealthHealthy is used for resources which are ready.
+	HealthHealthy = "Healthy"
+	// HealthProgressing is used for resources which are not ready yet, such as a rollout in progress.
+	HealthProgressing = "Progressing"
+	// HealthDegraded is used for resources which failed or cannot become ready.
+	HealthDegraded = "Degraded"
+	// HealthMissing is used for resources of the manifest which are not present in the cluster.
+	HealthMissing = "Missing"
+	// HealthUnknown is used for resources whose status could not be determined.
+	HealthUnknown = "Unknown"
+)
+
+// healthSeverity orders the health values so that the worst one can be
+// used as the aggregate health of a group of resources.
+var healthSeverity = map[string]int{
+	HealthHealthy:     0,
+	HealthUnknown:     1,
+	HealthProgressing: 2,
+	HealthMissing:     3,
+	HealthDegraded:    4,
+}
+
+func worstHealth(a, b string) string {
+	if healthSeverity[b] > healthSeverity[a] {
+		return b
+	}
+	return a
+}
+
+// ResourceStatus represents the live status of a resource. Workloads include
+// the status of the pods they select as children.
+type ResourceStatus struct {
+	APIVersion string           `json:"apiVersion"`
+	Kind       string           `json:"kind"`
+	Namespace  string           `json:"namespace,omitempty"`
+	Name       string           `json:"name"`
+	Health     string           `json:"health"`
+	Message    string           `json:"message,omitempty"`
+	Children   []ResourceStatus `json:"children,omitempty"`
+}
+
+// ReleaseResourcesStatus represents the live status of the resources of a release
+// together with their aggregated health.
+type ReleaseResourcesStatus struct {
+	Health    string           `json:"health"`
+	Resources []ResourceStatus `json:"resources"`
+}
+
+// ResourceClients groups the user-scoped clients used to fetch the live
+// resources of a release.
+type ResourceClients struct {
+	Dynamic    dynamic.Interface
+	Clientset  kubernetes.Interface
+	RESTMapper meta.RESTMapper
+}
+
+// GetReleaseResourcesStatus fetches every resource of the release manifest from the
+// cluster and computes its readiness.
+func GetReleaseResourcesStatus(clients ResourceClients, rel *release.Release) (*ReleaseResourcesStatus, error) {
+	objs, err := yamlUtils.ParseObjects(rel.Manifest)
+	if err != nil {
+		return nil, err
+	}
+	status := &ReleaseResourcesStatus{
+		Health:    HealthHealthy,
+		Resources: []ResourceStatus{},
+	}
+	for _, obj := range objs {
+		resourceStatus, err := getResourceStatus(clients, obj, rel.Namespace)
+		if err != nil {
+			return nil, err
+		}
+		status.Health = worstHealth(status.Health, resourceStatus.Health)
+		status.Resources = append(status.Resources, resourceStatus)
+	}
+	return status, nil
+}
+
+// resourceInterfaceFor returns the dynamic client for a manifest object, defaulting
+// the namespace of namespaced resources to the release namespace.
+func resourceInterfaceFor(clients ResourceClients, obj *unstructured.Unstructured, namespace string) (dynamic.ResourceInterface, error) {
+	gvk := obj.GroupVersionKind()
+	mapping, err := clients.RESTMapper.RESTMapping(gvk.GroupKind(), gvk.Version)
+	if err != nil {
+		return nil, err
+	}
+	if mapping.Scope.Name() != meta.RESTScopeNameNamespace {
+		return clients.Dynamic.Resource(mapping.Resource), nil
+	}
+	if obj.GetNamespace() != "" {
+		namespace = obj.GetNamespace()
+	}
+	return clients.Dynamic.Resource(mapping.Resource).Namespace(namespace), nil
+}
+
+// getLiveResource fetches the current state of a manifest object from the cluster.
+func getLiveResource(clients ResourceClients, obj *unstructured.Unstructured, namespace string) (*unstructured.Unstructured, error) {
+	resourceClient, err := resourceInterfaceFor(clients, obj, namespace)
+	if err != nil {
+		return nil, err
+	}
+	return resourceClient.Get(context.TODO(), obj.GetName(), metav1.GetOptions{})
+}
+
+func newResourceStatus(obj *unstructured.Unstructured) ResourceStatus {
+	return ResourceStatus{
+		APIVersion: obj.GetAPIVersion(),
+		Kind:       obj.GetKind(),
+		Namespace:  obj.GetNamespace(),
+		Name:       obj.GetName(),
+	}
+}
+
+func getResourceStatus(clients ResourceClients, obj *unstructured.Unstructured, namespace string) (ResourceStatus, error) {
+	status := newResourceStatus(obj)
+	live, err := getLiveResource(clients, obj, namespace)
+	if err != nil {
+		switch {
+		case k8sErrors.IsNotFound(err):
+			status.Health = HealthMissing
+		case meta.IsNoMatchError(err):
+			status.Health = HealthUnknown
+			status.Message = fmt.Sprintf("Unable to find the kind %s in the cluster", obj.GetKind())
+		case k8sErrors.IsForbidden(err):
+			status.Health = HealthUnknown
+			status.Message = err.Error()
+		default:
+			return status, err
+		}
+		return status, nil
+	}
+	err = setLiveStatus(clients, &status, live)
+	return status, err
+}
+
+// setLiveStatus computes the health and the children of a resource from its live state.
+func setLiveStatus(clients ResourceClients, status *ResourceStatus, live *unstructured.Unstructured) error {
+	var err error
+	status.Namespace = live.GetNamespace()
+
+	switch live.GetKind() {
+	case "Service":
+		status.Health, status.Message, err = serviceHealth(clients.Clientset, live)
+		if err != nil {
+			return err
+		}
+	default:
+		status.Health, status.Message = resourceHealth(live)
+	}
+
+	switch live.GetKind() {
+	case "Deployment", "StatefulSet", "DaemonSet", "ReplicaSet", "Job":
+		status.Children, err = getPodsStatus(clients.Clientset, live)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// listWorkloadPods returns the pods selected and owned by a workload, so that the
+// pods of another workload matching the same labels are left out. The pods of a
+// Deployment are owned by its ReplicaSets.
+func listWorkloadPods(clientset kubernetes.Interface, workload *unstructured.Unstructured) ([]corev1.Pod, error) {
+	selectorMap, found, err := unstructured.NestedMap(workload.Object, "spec", "selector")
+	if err != nil || !found {
+		return nil, err
+	}
+	labelSelector := &metav1.LabelSelector{}
+	err = runtime.DefaultUnstructuredConverter.FromUnstructured(selectorMap, labelSelector)
+	if err != nil {
+		return nil, err
+	}
+	selector, err := metav1.LabelSelectorAsSelector(labelSelector)
+	if err != nil {
+		return nil, err
+	}
+	listOptions := metav1.ListOptions{LabelSelector: selector.String()}
+
+	owners := map[types.UID]bool{workload.GetUID(): true}
+	if workload.GetKind() == "Deployment" {
+		replicaSets, err := clientset.AppsV1().ReplicaSets(workload.GetNamespace()).List(context.TODO(), listOptions)
+		if err != nil {
+			return nil, err
+		}
+		replicaSetOwners := map[types.UID]bool{}
+		for _, rs := range replicaSets.Items {
+			if isOwnedBy(rs.OwnerReferences, owners) {
+				replicaSetOwners[rs.UID] = true
+			}
+		}
+		owners = replicaSetOwners
+	}
+
+	return listOwnedPods(clientset, workload.GetNamespace(), listOptions, owners)
+}
+
+// listOwnedPods returns the pods of the list which are owned by one of the owners.
+func listOwnedPods(clientset kubernetes.Interface, namespace string, listOptions metav1.ListOptions, owners map[types.UID]bool) ([]corev1.Pod, error) {
+	pods, err := clientset.CoreV1().Pods(namespace).List(context.TODO(), listOptions)
+	if err != nil {
+		return nil, err
+	}
+	ownedPods := []corev1.Pod{}
+	for _, pod := range pods.Items {
+		if isOwnedBy(pod.OwnerReferences, owners) {
+			ownedPods = append(ownedPods, pod)
+		}
+	}
+	return ownedPods, nil
+}
+
+// isOwnedBy returns whether one of the owner references is one of the owners.
+func isOwnedBy(ownerReferences []metav1.OwnerReference, owners map[types.UID]bool) bool {
+	for _, ref := range ownerReferences {
+		if owners[ref.UID] {
+			return true
+		}
+	}
+	return false
+}
+
+// getPodsStatus returns the status of the pods of a workload.
+func getPodsStatus(clientset kubernetes.Interface, workload *unstructured.Unstructured) ([]ResourceStatus, error) {
+	pods, err := listWorkloadPods(clientset, workload)
+	if err != nil {
+		if k8sErrors.IsForbidden(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	statuses := []ResourceStatus{}
+	for i := range pods {
+		podObject, err := runtime.DefaultUnstructuredConverter.ToUnstructured(&pods[i])
+		if err != nil {
+			return nil, err
+		}
+		pod := &unstructured.Unstructured{Object: podObject}
+		health, message := podHealth(pod)
+		statuses = append(statuses, ResourceStatus{
+			APIVersion: "v1",
+			Kind:       "Pod",
+			Namespace:  pod.GetNamespace(),
+			Name:       pod.GetName(),
+			Health:     health,
+			Message:    message,
+		})
+	}
+	return statuses, nil
+}
+
+// resourceHealth computes the readiness of a live resource from its status. Kinds
+// without a known readiness are considered healthy as long as they exist.
+func resourceHealth(obj *unstructured.Unstructured) (string, string) {
+	switch obj.GetKind() {
+	case "Deployment":
+		return deploymentHealth(obj)
+	case "StatefulSet":
+		return statefulSetHealth(obj)
+	case "DaemonSet":
+		return daemonSetHealth(obj)
+	case "ReplicaSet":
+		return replicaSetHealth(obj)
+	case "Pod":
+		return podHealth(obj)
+	case "PersistentVolumeClaim":
+		return pvcHealth(obj)
+	case "Job":
+		return jobHealth(obj)
+	}
+	return HealthHealthy, ""
+}
+
+func nestedInt64(obj *unstructured.Unstructured, defaultValue int64, fields ...string) int64 {
+	value, found, err := unstructured.NestedInt64(obj.Object, fields...)
+	if err != nil || !found {
+		return defaultValue
+	}
+	return value
+}
+
+func nestedString(obj *unstructured.Unstructured, fields ...string) string {
+	value, _, _ := unstructured.NestedString(obj.Object, fields...)
+	return value
+}
+
+// findCondition returns the status and message of the condition with the given type.
+func findCondition(obj *unstructured.Unstructured, conditionType string) (string, string, string) {
+	conditions, _, _ := unstructured.NestedSlice(obj.Object, "status", "conditions")
+	for _, c := range conditions {
+		condition, ok := c.(map[string]interface{})
+		if !ok || condition["type"] != conditionType {
+			continue
+		}
+		status, _ := condition["status"].(string)
+		reason, _ := condition["reason"].(string)
+		message, _ := condition["message"].(string)
+		return status, reason, message
+	}
+	return "", "", ""
+}
+
+func isGenerationObserved(obj *unstructured.Unstructured) bool {
+	return nestedInt64(obj, 0, "status", "observedGeneration") >= obj.GetGeneration()
+}
+
+func deploymentHealth(obj *unstructured.Unstructured) (string, string) {
+	if !isGenerationObserved(obj) {
+		return HealthProgressing, "Waiting for the deployment spec update to be observed"
+	}
+	if _, reason, message := findCondition(obj, "Progressing"); reason == "ProgressDeadlineExceeded" {
+		return HealthDegraded, message
+	}
+	replicas := nestedInt64(obj, 1, "spec", "replicas")
+	statusReplicas := nestedInt64(obj, 0, "status", "replicas")
+	updated := nestedInt64(obj, 0, "status", "updatedReplicas")
+	available := nestedInt64(obj, 0, "status", "availableReplicas")
+	if updated < replicas {
+		return HealthProgressing, fmt.Sprintf("%d out of %d new replicas have been updated", updated, replicas)
+	}
+	if statusReplicas > updated {
+		return HealthProgressing, fmt.Sprintf("%d old replicas are pending termination", statusReplicas-updated)
+	}
+	if available < updated {
+		return HealthProgressing, fmt.Sprintf("%d of %d updated replicas are available", available, updated)
+	}
+	return HealthHealthy, ""
+}
+
+func statefulSetHealth(obj *unstructured.Unstructured) (string, string) {
+	if !isGenerationObserved(obj) {
+		return HealthProgressing, "Waiting for the statefulset spec update to be observed"
+	}
+	replicas := nestedInt64(obj, 1, "spec", "replicas")
+	ready := nestedInt64(obj, 0, "status", "readyReplicas")
+	if ready < replicas {
+		return HealthProgressing, fmt.Sprintf("%d of %d replicas are ready", ready, replicas)
+	}
+	strategy := nestedString(obj, "spec", "updateStrategy", "type")
+	if strategy == "" || strategy == "RollingUpdate" {
+		if nestedString(obj, "status", "updateRevision") != nestedString(obj, "status", "currentRevision") {
+			return HealthProgressing, "Waiting for the rolling update to complete"
+		}
+	}
+	return HealthHealthy, ""
+}
+
+func daemonSetHealth(obj *unstructured.Unstructured) (string, string) {
+	if !isGenerationObserved(obj) {
+		return HealthProgressing, "Waiting for the daemonset spec update to be observed"
+	}
+	desired := nestedInt64(obj, 0, "status", "desiredNumberScheduled")
+	updated := nestedInt64(obj, 0, "status", "updatedNumberScheduled")
+	available := nestedInt64(obj, 0, "status", "numberAvailable")
+	if updated < desired {
+		return HealthProgressing, fmt.Sprintf("%d out of %d new pods have been updated", updated, desired)
+	}
+	if available < desired {
+		return HealthProgressing, fmt.Sprintf("%d of %d updated pods are available", available, desired)
+	}
+	return HealthHealthy, ""
+}
+
+func replicaSetHealth(obj *unstructured.Unstructured) (string, string) {
+	if !isGenerationObserved(obj) {
+		return HealthProgressing, "Waiting for the replicaset spec update to be observed"
+	}
+	// The ReplicaFailure condition is set when pods cannot be created, such as
+	// when they exceed a quota.
+	if status, _, message := findCondition(obj, "ReplicaFailure"); status == "True" {
+		return HealthDegraded, message
+	}
+	replicas := nestedInt64(obj, 1, "spec", "replicas")
+	available := nestedInt64(obj, 0, "status", "availableReplicas")
+	if available < replicas {
+		return HealthProgressing, fmt.Sprintf("%d of %d replicas are available", available, replicas)
+	}
+	return HealthHealthy, ""
+}
+
+// podWaitingFailures are the reasons of a waiting container which will not resolve on their own.
+var podWaitingFailures = map[string]bool{
+	"CrashLoopBackOff":           true,
+	"ErrImagePull":               true,
+	"ImagePullBackOff":           true,
+	"InvalidImageName":           true,
+	"CreateContainerConfigError": true,
+}
+
+func podHealth(obj *unstructured.Unstructured) (string, string) {
+	for _, key := range []string{"initContainerStatuses", "containerStatuses"} {
+		containerStatuses, _, _ := unstructured.NestedSlice(obj.Object, "status", key)
+		for _, c := range containerStatuses {
+			containerStatus, ok := c.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			reason, _, _ := unstructured.NestedString(containerStatus, "state", "waiting", "reason")
+			if podWaitingFailures[reason] {
+				message, _, _ := unstructured.NestedString(containerStatus, "state", "waiting", "message")
+				return HealthDegraded, fmt.Sprintf("%s: %s", reason, message)
+			}
+		}
+	}
+
+	switch nestedString(obj, "status", "phase") {
+	case "Succeeded":
+		return HealthHealthy, ""
+	case "Failed":
+		return HealthDegraded, nestedString(obj, "status", "message")
+	case "Pending":
+		return HealthProgressing, "Pod is pending"
+	case "Running":
+		if status, _, message := findCondition(obj, "Ready"); status != "True" {
+			return HealthProgressing, message
+		}
+		return HealthHealthy, ""
+	}
+	return HealthUnknown, ""
+}
+
+func pvcHealth(obj *unstructured.Unstructured) (string, string) {
+	switch phase := nestedString(obj, "status", "phase"); phase {
+	case "Bound":
+		return HealthHealthy, ""
+	case "Lost":
+		return HealthDegraded, "The persistent volume of the claim has been lost"
+	default:
+		return HealthProgressing, fmt.Sprintf("Claim is %s", phase)
+	}
+}
+
+func jobHealth(obj *unstructured.Unstructured) (string, string) {
+	if status, _, message := findCondition(obj, "Failed"); status == "True" {
+		return HealthDegraded, message
+	}
+	if status, _, _ := findCondition(obj, "Complete"); status == "True" {
+		return HealthHealthy, ""
+	}
+	completions := nestedInt64(obj, 1, "spec", "completions")
+	succeeded := nestedInt64(obj, 0, "status", "succeeded")
+	if succeeded >= completions {
+		return HealthHealthy, ""
+	}
+	return HealthProgressing, fmt.Sprintf("%d of %d completions succeeded", succeeded, completions)
+}
+
+// serviceHealth checks that a service has ready endpoints or, for a load
+// balancer, that an ingress point has been assigned.
+func serviceHealth(clientset kubernetes.Interface, obj *unstructured.Unstructured) (string, string, error) {
+	serviceType := nestedString(obj, "spec", "type")
+	if serviceType == "ExternalName" {
+		return HealthHealthy, "", nil
+	}
+	if serviceType == "LoadBalancer" {
+		ingress, _, _ := unstructured.NestedSlice(obj.Object, "status", "loadBalancer", "ingress")
+		if len(ingress) == 0 {
+			return HealthProgressing, "Waiting for the load balancer to be assigned", nil
+		}
+	}
+	// Services without a selector have their endpoints managed externally.
+	if selector, _, _ := unstructured.NestedMap(obj.Object, "spec", "selector"); len(selector) == 0 {
+		return HealthHealthy, "", nil
+	}
+	endpoints, err := clientset.CoreV1().Endpoints(obj.GetNamespace()).Get(context.TODO(), obj.GetName(), metav1.GetOptions{})
+	if err != nil {
+		if k8sErrors.IsNotFound(err) {
+			return HealthProgressing, "No endpoints available", nil
+		}
+		if k8sErrors.IsForbidden(err) {
+			return HealthUnknown, err.Error(), nil
+		}
+		return "", "", err
+	}
+	for _, subset := range endpoints.Subsets {
+		if len(subset.Addresses) > 0 {
+			return HealthHealthy, "", nil
+		}
+	}
+	return HealthProgressing, "No ready endpoints available", nil
+}
@@ -0,0 +1,315 @@
+package agent
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	yamlUtils "github.com/kubeapps/kubeapps/pkg/yaml"
+	"helm.sh/helm/v3/pkg/release"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	fakeDynamic "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+const manifestDeploymentAndService = `---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: foo
+---
+apiVersion: v1
+kind: Service
+metadata:
+  name: foo
+`
+
+func newRESTMapperFixture() meta.RESTMapper {
+	mapper := meta.NewDefaultRESTMapper([]schema.GroupVersion{})
+	mapper.Add(schema.GroupVersionKind{Group: "apps", Version: "v1", Kind: "Deployment"}, meta.RESTScopeNamespace)
+	mapper.Add(schema.GroupVersionKind{Group: "apps", Version: "v1", Kind: "ReplicaSet"}, meta.RESTScopeNamespace)
+	mapper.Add(schema.GroupVersionKind{Group: "batch", Version: "v1beta1", Kind: "CronJob"}, meta.RESTScopeNamespace)
+	mapper.Add(schema.GroupVersionKind{Version: "v1", Kind: "Service"}, meta.RESTScopeNamespace)
+	mapper.Add(schema.GroupVersionKind{Version: "v1", Kind: "Pod"}, meta.RESTScopeNamespace)
+	mapper.Add(schema.GroupVersionKind{Version: "v1", Kind: "PersistentVolumeClaim"}, meta.RESTScopeNamespace)
+	mapper.Add(schema.GroupVersionKind{Group: "rbac.authorization.k8s.io", Version: "v1", Kind: "ClusterRole"}, meta.RESTScopeRoot)
+	return mapper
+}
+
+func TestGetReleaseResourcesStatus(t *testing.T) {
+	replicaSet := &appsv1.ReplicaSet{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            "foo-5678",
+			Namespace:       "default",
+			UID:             "foo-5678-uid",
+			Labels:          map[string]string{"app": "foo"},
+			OwnerReferences: []metav1.OwnerReference{{APIVersion: "apps/v1", Kind: "Deployment", Name: "foo", UID: "foo-uid"}},
+		},
+	}
+	readyPod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            "foo-5678-1234",
+			Namespace:       "default",
+			Labels:          map[string]string{"app": "foo"},
+			OwnerReferences: []metav1.OwnerReference{{APIVersion: "apps/v1", Kind: "ReplicaSet", Name: "foo-5678", UID: "foo-5678-uid"}},
+		},
+		Status: corev1.PodStatus{
+			Phase:      corev1.PodRunning,
+			Conditions: []corev1.PodCondition{{Type: corev1.PodReady, Status: corev1.ConditionTrue}},
+		},
+	}
+	// The pods of other workloads may match the labels of the selector.
+	otherPod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            "other-1234",
+			Namespace:       "default",
+			Labels:          map[string]string{"app": "foo"},
+			OwnerReferences: []metav1.OwnerReference{{APIVersion: "apps/v1", Kind: "ReplicaSet", Name: "other", UID: "other-uid"}},
+		},
+		Status: corev1.PodStatus{Phase: corev1.PodPending},
+	}
+	readyEndpoints := &corev1.Endpoints{
+		ObjectMeta: metav1.ObjectMeta{Name: "foo", Namespace: "default"},
+		Subsets:    []corev1.EndpointSubset{{Addresses: []corev1.EndpointAddress{{IP: "10.0.0.1"}}}},
+	}
+
+	testCases := []struct {
+		name           string
+		manifest       string
+		liveResources  string
+		typedResources []runtime.Object
+		expected       *ReleaseResourcesStatus
+	}{
+		{
+			name:     "returns healthy resources with the pods of workloads",
+			manifest: manifestDeploymentAndService,
+			liveResources: `---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: foo
+  namespace: default
+  uid: foo-uid
+  generation: 2
+spec:
+  replicas: 1
+  selector:
+    matchLabels:
+      app: foo
+status:
+  observedGeneration: 2
+  replicas: 1
+  updatedReplicas: 1
+  availableReplicas: 1
+---
+apiVersion: v1
+kind: Service
+metadata:
+  name: foo
+  namespace: default
+spec:
+  selector:
+    app: foo
+`,
+			typedResources: []runtime.Object{replicaSet, readyPod, otherPod, readyEndpoints},
+			expected: &ReleaseResourcesStatus{
+				Health: HealthHealthy,
+				Resources: []ResourceStatus{
+					{
+						APIVersion: "apps/v1",
+						Kind:       "Deployment",
+						Namespace:  "default",
+						Name:       "foo",
+						Health:     HealthHealthy,
+						Children: []ResourceStatus{
+							{APIVersion: "v1", Kind: "Pod", Namespace: "default", Name: "foo-5678-1234", Health: HealthHealthy},
+						},
+					},
+					{APIVersion: "v1", Kind: "Service", Namespace: "default", Name: "foo", Health: HealthHealthy},
+				},
+			},
+		},
+		{
+			name:     "returns a progressing deployment and a service without endpoints",
+			manifest: manifestDeploymentAndService,
+			liveResources: `---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: foo
+  namespace: default
+  generation: 1
+spec:
+  replicas: 2
+  selector:
+    matchLabels:
+      app: foo
+status:
+  observedGeneration: 1
+  replicas: 2
+  updatedReplicas: 2
+  availableReplicas: 1
+---
+apiVersion: v1
+kind: Service
+metadata:
+  name: foo
+  namespace: default
+spec:
+  selector:
+    app: foo
+`,
+			expected: &ReleaseResourcesStatus{
+				Health: HealthProgressing,
+				Resources: []ResourceStatus{
+					{
+						APIVersion: "apps/v1",
+						Kind:       "Deployment",
+						Namespace:  "default",
+						Name:       "foo",
+						Health:     HealthProgressing,
+						Message:    "1 of 2 updated replicas are available",
+						Children:   []ResourceStatus{},
+					},
+					{APIVersion: "v1", Kind: "Service", Namespace: "default", Name: "foo", Health: HealthProgressing, Message: "No endpoints available"},
+				},
+			},
+		},
+		{
+			name: "returns a degraded replicaset with its pods",
+			manifest: `---
+apiVersion: apps/v1
+kind: ReplicaSet
+metadata:
+  name: foo-5678
+`,
+			liveResources: `---
+apiVersion: apps/v1
+kind: ReplicaSet
+metadata:
+  name: foo-5678
+  namespace: default
+  uid: foo-5678-uid
+  generation: 1
+spec:
+  replicas: 2
+  selector:
+    matchLabels:
+      app: foo
+status:
+  observedGeneration: 1
+  replicas: 1
+  availableReplicas: 1
+  conditions:
+  - type: ReplicaFailure
+    status: "True"
+    reason: FailedCreate
+    message: exceeded quota
+`,
+			typedResources: []runtime.Object{readyPod, otherPod},
+			expected: &ReleaseResourcesStatus{
+				Health: HealthDegraded,
+				Resources: []ResourceStatus{
+					{
+						APIVersion: "apps/v1",
+						Kind:       "ReplicaSet",
+						Namespace:  "default",
+						Name:       "foo-5678",
+						Health:     HealthDegraded,
+						Message:    "exceeded quota",
+						Children: []ResourceStatus{
+							{APIVersion: "v1", Kind: "Pod", Namespace: "default", Name: "foo-5678-1234", Health: HealthHealthy},
+						},
+					},
+				},
+			},
+		},
+		{
+			name: "returns missing resources and unknown kinds",
+			manifest: `---
+apiVersion: v1
+kind: PersistentVolumeClaim
+metadata:
+  name: data
+---
+apiVersion: example.com/v1
+kind: Widget
+metadata:
+  name: foo
+`,
+			expected: &ReleaseResourcesStatus{
+				Health: HealthMissing,
+				Resources: []ResourceStatus{
+					{APIVersion: "v1", Kind: "PersistentVolumeClaim", Name: "data", Health: HealthMissing},
+					{APIVersion: "example.com/v1", Kind: "Widget", Name: "foo", Health: HealthUnknown, Message: "Unable to find the kind Widget in the cluster"},
+				},
+			},
+		},
+		{
+			name: "returns the status of cluster-wide resources and claims",
+			manifest: `---
+apiVersion: v1
+kind: PersistentVolumeClaim
+metadata:
+  name: data
+---
+apiVersion: rbac.authorization.k8s.io/v1
+kind: ClusterRole
+metadata:
+  name: foo
+`,
+			liveResources: `---
+apiVersion: v1
+kind: PersistentVolumeClaim
+metadata:
+  name: data
+  namespace: default
+status:
+  phase: Pending
+---
+apiVersion: rbac.authorization.k8s.io/v1
+kind: ClusterRole
+metadata:
+  name: foo
+`,
+			expected: &ReleaseResourcesStatus{
+				Health: HealthProgressing,
+				Resources: []ResourceStatus{
+					{APIVersion: "v1", Kind: "PersistentVolumeClaim", Namespace: "default", Name: "data", Health: HealthProgressing, Message: "Claim is Pending"},
+					{APIVersion: "rbac.authorization.k8s.io/v1", Kind: "ClusterRole", Name: "foo", Health: HealthHealthy},
+				},
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			liveObjs, err := yamlUtils.ParseObjects(tc.liveResources)
+			if err != nil {
+				t.Fatalf("%+v", err)
+			}
+			runtimeObjs := []runtime.Object{}
+			for _, obj := range liveObjs {
+				runtimeObjs = append(runtimeObjs, obj)
+			}
+			clients := ResourceClients{
+				Dynamic:    fakeDynamic.NewSimpleDynamicClient(runtime.NewScheme(), runtimeObjs...),
+				Clientset:  fake.NewSimpleClientset(tc.typedResources...),
+				RESTMapper: newRESTMapperFixture(),
+			}
+			rel := &release.Release{Name: "foo", Namespace: "default", Manifest: tc.manifest}
+
+			status, err := GetReleaseResourcesStatus(clients, rel)
+			if err != nil {
+				t.Fatalf("%+v", err)
+			}
+			if got, want := status, tc.expected; !cmp.Equal(want, got) {
+				t.Errorf("mismatch (-want +got):\n%s", cmp.Diff(want, got))
+			}
+		})
+	}
+}
@@ -0,0 +1,148 @@
+package agent
+
+import (
+	"context"
+	"sync"
+
+	yamlUtils "github.com/kubeapps/kubeapps/pkg/yaml"
+	log "github.com/sirupsen/logrus"
+	"helm.sh/helm/v3/pkg/release"
+	k8sErrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/watch"
+)
+
+// ResourceEvent represents a change of a resource of a release. ReadinessChanged is
+// set when the health of the resource differs from the one of its previous event.
+type ResourceEvent struct {
+	Type             watch.EventType `json:"type"`
+	Resource         ResourceStatus  `json:"resource"`
+	PreviousHealth   string          `json:"previousHealth,omitempty"`
+	ReadinessChanged bool            `json:"readinessChanged"`
+}
+
+type resourceWatcher struct {
+	obj     *unstructured.Unstructured
+	watcher watch.Interface
+}
+
+// WatchReleaseResources opens a watch on every resource of the release manifest and
+// returns a channel with their events. The channel is closed once the context is
+// cancelled or every watch has been closed by the API server.
+// Resources whose kind is not known by the cluster are not watched.
+func WatchReleaseResources(ctx context.Context, clients ResourceClients, rel *release.Release) (<-chan ResourceEvent, error) {
+	objs, err := yamlUtils.ParseObjects(rel.Manifest)
+	if err != nil {
+		return nil, err
+	}
+
+	watchers := []resourceWatcher{}
+	stopWatchers := func() {
+		for _, rw := range watchers {
+			rw.watcher.Stop()
+		}
+	}
+	for _, obj := range objs {
+		resourceClient, err := resourceInterfaceFor(clients, obj, rel.Namespace)
+		if err != nil {
+			if meta.IsNoMatchError(err) {
+				continue
+			}
+			stopWatchers()
+			return nil, err
+		}
+		watcher, err := resourceClient.Watch(ctx, metav1.ListOptions{
+			FieldSelector: fields.OneTermEqualSelector("metadata.name", obj.GetName()).String(),
+		})
+		if err != nil {
+			stopWatchers()
+			return nil, err
+		}
+		watchers = append(watchers, resourceWatcher{obj: obj, watcher: watcher})
+	}
+
+	events := make(chan ResourceEvent)
+	var wg sync.WaitGroup
+	for _, rw := range watchers {
+		wg.Add(1)
+		go func(rw resourceWatcher) {
+			defer wg.Done()
+			defer rw.watcher.Stop()
+			streamResourceEvents(ctx, clients, rw, events)
+		}(rw)
+	}
+	go func() {
+		wg.Wait()
+		close(events)
+	}()
+	return events, nil
+}
+
+// streamResourceEvents converts the events of a single watch into resource events
+// until the watch is closed or the context is cancelled.
+func streamResourceEvents(ctx context.Context, clients ResourceClients, rw resourceWatcher, events chan<- ResourceEvent) {
+	previousHealth := ""
+	for {
+		var e watch.Event
+		var ok bool
+		select {
+		case <-ctx.Done():
+			return
+		case e, ok = <-rw.watcher.ResultChan():
+			if !ok {
+				return
+			}
+		}
+
+		status := newResourceStatus(rw.obj)
+		switch e.Type {
+		case watch.Added, watch.Modified:
+			live, isUnstructured := e.Object.(*unstructured.Unstructured)
+			// The field selector is not supported by every API server, so ensure
+			// the event belongs to the watched resource.
+			if !isUnstructured || live.GetName() != rw.obj.GetName() {
+				continue
+			}
+			if err := setLiveStatus(clients, &status, live); err != nil {
+				status.Health = HealthUnknown
+				status.Message = err.Error()
+			}
+		case watch.Deleted:
+			if live, isUnstructured := e.Object.(*unstructured.Unstructured); isUnstructured {
+				if live.GetName() != rw.obj.GetName() {
+					continue
+				}
+				status.Namespace = live.GetNamespace()
+			}
+			status.Health = HealthMissing
+		case watch.Error:
+			status.Health = HealthUnknown
+			status.Message = k8sErrors.FromObject(e.Object).Error()
+			log.Infof("Watch of %s %q closed: %s", rw.obj.GetKind(), rw.obj.GetName(), status.Message)
+		default:
+			continue
+		}
+
+		event := ResourceEvent{
+			Type:             e.Type,
+			Resource:         status,
+			ReadinessChanged: status.Health != previousHealth,
+		}
+		if event.ReadinessChanged {
+			event.PreviousHealth = previousHealth
+		}
+		previousHealth = status.Health
+
+		select {
+		case events <- event:
+		case <-ctx.Done():
+			return
+		}
+		if e.Type == watch.Error {
+			return
+		}
+	}
+}
@@ -0,0 +1,124 @@
+package agent
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	yamlUtils "github.com/kubeapps/kubeapps/pkg/yaml"
+	"helm.sh/helm/v3/pkg/release"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/watch"
+	fakeDynamic "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestWatchReleaseResources(t *testing.T) {
+	manifest := `---
+apiVersion: v1
+kind: PersistentVolumeClaim
+metadata:
+  name: data
+---
+apiVersion: example.com/v1
+kind: Widget
+metadata:
+  name: foo
+`
+	pendingClaim := `---
+apiVersion: v1
+kind: PersistentVolumeClaim
+metadata:
+  name: data
+  namespace: default
+status:
+  phase: Pending
+`
+	boundClaim := `---
+apiVersion: v1
+kind: PersistentVolumeClaim
+metadata:
+  name: data
+  namespace: default
+status:
+  phase: Bound
+`
+	otherClaim := `---
+apiVersion: v1
+kind: PersistentVolumeClaim
+metadata:
+  name: other
+  namespace: default
+status:
+  phase: Bound
+`
+	claimStatus := func(health, message string) ResourceStatus {
+		return ResourceStatus{APIVersion: "v1", Kind: "PersistentVolumeClaim", Namespace: "default", Name: "data", Health: health, Message: message}
+	}
+	expectedEvents := []ResourceEvent{
+		{Type: watch.Added, Resource: claimStatus(HealthProgressing, "Claim is Pending"), ReadinessChanged: true},
+		{Type: watch.Modified, Resource: claimStatus(HealthProgressing, "Claim is Pending")},
+		{Type: watch.Modified, Resource: claimStatus(HealthHealthy, ""), PreviousHealth: HealthProgressing, ReadinessChanged: true},
+		{Type: watch.Deleted, Resource: claimStatus(HealthMissing, ""), PreviousHealth: HealthHealthy, ReadinessChanged: true},
+	}
+
+	dynamicClient := fakeDynamic.NewSimpleDynamicClient(runtime.NewScheme())
+	clients := ResourceClients{
+		Dynamic:    dynamicClient,
+		Clientset:  fake.NewSimpleClientset(),
+		RESTMapper: newRESTMapperFixture(),
+	}
+	rel := &release.Release{Name: "foo", Namespace: "default", Manifest: manifest}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	events, err := WatchReleaseResources(ctx, clients, rel)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+
+	claims := dynamicClient.Resource(schema.GroupVersionResource{Version: "v1", Resource: "persistentvolumeclaims"}).Namespace("default")
+	for i, live := range []string{pendingClaim, otherClaim, pendingClaim, boundClaim} {
+		objs, err := yamlUtils.ParseObjects(live)
+		if err != nil {
+			t.Fatalf("%+v", err)
+		}
+		switch i {
+		case 0, 1:
+			_, err = claims.Create(context.TODO(), objs[0], metav1.CreateOptions{})
+		default:
+			_, err = claims.Update(context.TODO(), objs[0], metav1.UpdateOptions{})
+		}
+		if err != nil {
+			t.Fatalf("%+v", err)
+		}
+	}
+	err = claims.Delete(context.TODO(), "data", metav1.DeleteOptions{})
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+
+	for _, want := range expectedEvents {
+		select {
+		case got := <-events:
+			if !cmp.Equal(want, got) {
+				t.Errorf("mismatch (-want +got):\n%s", cmp.Diff(want, got))
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for event %+v", want)
+		}
+	}
+
+	cancel()
+	select {
+	case _, ok := <-events:
+		if ok {
+			t.Errorf("expected the events channel to be closed after cancelling the context")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timed out waiting for the events channel to be closed")
+	}
+}
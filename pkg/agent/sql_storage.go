@@ -0,0 +1,349 @@
+package agent
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	_ "github.com/lib/pq" // Register the postgres driver of database/sql.
+	log "github.com/sirupsen/logrus"
+	"helm.sh/helm/v3/pkg/release"
+	"helm.sh/helm/v3/pkg/storage/driver"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// The table and values of the schema of the SQL driver of Helm, so that the releases
+// are shared with the Helm CLI.
+const (
+	sqlReleaseTable        = "releases_v1"
+	sqlReleaseDefaultOwner = "helm"
+	sqlReleaseDefaultType  = "helm.sh/release.v1"
+)
+
+// sqlReleaseLabels are the labels of the releases which can be queried, as columns
+// of the releases table.
+var sqlReleaseLabels = map[string]struct{}{
+	"name":       {},
+	"owner":      {},
+	"status":     {},
+	"version":    {},
+	"createdAt":  {},
+	"modifiedAt": {},
+}
+
+// accessReviewer returns whether a user can do an action on the secrets of a namespace.
+type accessReviewer func(verb, namespace string) (bool, error)
+
+// sqlDriver is a Helm storage driver using the database and schema of the SQL driver
+// of Helm. Unlike the Helm driver, it does not keep state between calls, so that a
+// driver is cheaply created for each request on top of the database of the process.
+//
+// As the releases are not stored in Kubernetes, the API server cannot authorize the
+// access to them. Users should then be allowed the same actions on the secrets of the
+// namespace as with the secrets driver, checked with SelfSubjectAccessReviews.
+type sqlDriver struct {
+	db        *sqlx.DB
+	namespace string
+	canI      accessReviewer
+	Log       func(string, ...interface{})
+}
+
+var _ driver.Driver = (*sqlDriver)(nil)
+
+// newSQLDriver returns a driver for the releases of a namespace, or of all namespaces
+// if empty, authorizing the actions with the reviewer.
+func newSQLDriver(db *sqlx.DB, namespace string, canI accessReviewer) *sqlDriver {
+	return &sqlDriver{db: db, namespace: namespace, canI: cachedAccessReviewer(canI), Log: log.Infof}
+}
+
+// Name returns the name of the driver, as the one of Helm.
+func (d *sqlDriver) Name() string {
+	return driver.SQLDriverName
+}
+
+func (d *sqlDriver) authorize(verb, namespace string) error {
+	allowed, err := d.canI(verb, namespace)
+	if err != nil {
+		return fmt.Errorf("Unable to check the access to the releases: %v", err)
+	}
+	if !allowed {
+		if namespace == "" {
+			return fmt.Errorf("Unauthorized to %s the releases of all namespaces", verb)
+		}
+		return fmt.Errorf("Unauthorized to %s the releases of the namespace %q", verb, namespace)
+	}
+	return nil
+}
+
+// Get returns the release named by key.
+func (d *sqlDriver) Get(key string) (*release.Release, error) {
+	if err := d.authorize("get", d.namespace); err != nil {
+		return nil, err
+	}
+	var body string
+	query := fmt.Sprintf("SELECT body FROM %s WHERE key = $1 AND namespace = $2", sqlReleaseTable)
+	if err := d.db.Get(&body, query, key, d.namespace); err != nil {
+		return nil, driver.ErrReleaseNotFound
+	}
+	return decodeSQLRelease(body)
+}
+
+// List returns the releases of the namespace matching the filter.
+func (d *sqlDriver) List(filter func(*release.Release) bool) ([]*release.Release, error) {
+	if err := d.authorize("list", d.namespace); err != nil {
+		return nil, err
+	}
+	bodies, err := d.selectBodies(map[string]string{"owner": sqlReleaseDefaultOwner})
+	if err != nil {
+		return nil, err
+	}
+	var releases []*release.Release
+	for _, body := range bodies {
+		rel, err := decodeSQLRelease(body)
+		if err != nil {
+			d.Log("list: failed to decode release: %v", err)
+			continue
+		}
+		if filter(rel) {
+			releases = append(releases, rel)
+		}
+	}
+	return releases, nil
+}
+
+// Query returns the releases of the namespace matching the labels.
+func (d *sqlDriver) Query(labels map[string]string) ([]*release.Release, error) {
+	if err := d.authorize("list", d.namespace); err != nil {
+		return nil, err
+	}
+	for label := range labels {
+		if _, ok := sqlReleaseLabels[label]; !ok {
+			return nil, fmt.Errorf("unknown label %s", label)
+		}
+	}
+	bodies, err := d.selectBodies(labels)
+	if err != nil {
+		return nil, err
+	}
+	if len(bodies) == 0 {
+		return nil, driver.ErrReleaseNotFound
+	}
+	var releases []*release.Release
+	for _, body := range bodies {
+		rel, err := decodeSQLRelease(body)
+		if err != nil {
+			d.Log("query: failed to decode release: %v", err)
+			continue
+		}
+		releases = append(releases, rel)
+	}
+	return releases, nil
+}
+
+// selectBodies returns the encoded releases of the namespace matching the labels.
+func (d *sqlDriver) selectBodies(labels map[string]string) ([]string, error) {
+	names := make([]string, 0, len(labels))
+	for label := range labels {
+		names = append(names, label)
+	}
+	sort.Strings(names)
+	var conditions []string
+	var args []interface{}
+	for _, name := range names {
+		args = append(args, labels[name])
+		conditions = append(conditions, fmt.Sprintf("%s = $%d", name, len(args)))
+	}
+	if d.namespace != "" {
+		args = append(args, d.namespace)
+		conditions = append(conditions, fmt.Sprintf("namespace = $%d", len(args)))
+	}
+	query := fmt.Sprintf("SELECT body FROM %s", sqlReleaseTable)
+	if len(conditions) > 0 {
+		query += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	var bodies []string
+	if err := d.db.Select(&bodies, query, args...); err != nil {
+		return nil, err
+	}
+	return bodies, nil
+}
+
+// Create stores a new release.
+func (d *sqlDriver) Create(key string, rel *release.Release) error {
+	namespace := releaseNamespace(rel)
+	if err := d.authorize("create", namespace); err != nil {
+		return err
+	}
+	body, err := encodeSQLRelease(rel)
+	if err != nil {
+		return err
+	}
+	query := fmt.Sprintf("INSERT INTO %s (key, type, body, name, namespace, version, status, owner, createdAt) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)", sqlReleaseTable)
+	if _, err := d.db.Exec(query, key, sqlReleaseDefaultType, body, rel.Name, namespace, rel.Version, rel.Info.Status.String(), sqlReleaseDefaultOwner, time.Now().Unix()); err != nil {
+		var existing string
+		if err := d.db.Get(&existing, fmt.Sprintf("SELECT key FROM %s WHERE key = $1 AND namespace = $2", sqlReleaseTable), key, namespace); err == nil {
+			return driver.ErrReleaseExists
+		}
+		return err
+	}
+	return nil
+}
+
+// Update updates a stored release.
+func (d *sqlDriver) Update(key string, rel *release.Release) error {
+	namespace := releaseNamespace(rel)
+	if err := d.authorize("update", namespace); err != nil {
+		return err
+	}
+	body, err := encodeSQLRelease(rel)
+	if err != nil {
+		return err
+	}
+	query := fmt.Sprintf("UPDATE %s SET body = $1, name = $2, version = $3, status = $4, owner = $5, modifiedAt = $6 WHERE key = $7 AND namespace = $8", sqlReleaseTable)
+	_, err = d.db.Exec(query, body, rel.Name, rel.Version, rel.Info.Status.String(), sqlReleaseDefaultOwner, time.Now().Unix(), key, namespace)
+	return err
+}
+
+// Delete deletes a stored release and returns it.
+func (d *sqlDriver) Delete(key string) (*release.Release, error) {
+	if err := d.authorize("delete", d.namespace); err != nil {
+		return nil, err
+	}
+	rel, err := d.Get(key)
+	if err != nil {
+		return nil, err
+	}
+	query := fmt.Sprintf("DELETE FROM %s WHERE key = $1 AND namespace = $2", sqlReleaseTable)
+	if _, err := d.db.Exec(query, key, d.namespace); err != nil {
+		return nil, err
+	}
+	return rel, nil
+}
+
+// releaseNamespace returns the namespace of a release, defaulting to "default" as Helm.
+func releaseNamespace(rel *release.Release) string {
+	if rel.Namespace == "" {
+		return "default"
+	}
+	return rel.Namespace
+}
+
+// encodeSQLRelease encodes a release as the drivers of Helm, in gzipped JSON encoded
+// in base64.
+func encodeSQLRelease(rel *release.Release) (string, error) {
+	content, err := json.Marshal(rel)
+	if err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	w, err := gzip.NewWriterLevel(&buf, gzip.BestCompression)
+	if err != nil {
+		return "", err
+	}
+	if _, err := w.Write(content); err != nil {
+		return "", err
+	}
+	if err := w.Close(); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(buf.Bytes()), nil
+}
+
+// decodeSQLRelease decodes a release encoded by encodeSQLRelease. As with Helm, the
+// content may not be gzipped.
+func decodeSQLRelease(body string) (*release.Release, error) {
+	content, err := base64.StdEncoding.DecodeString(body)
+	if err != nil {
+		return nil, err
+	}
+	if len(content) > 3 && bytes.Equal(content[0:3], []byte{0x1f, 0x8b, 0x08}) {
+		r, err := gzip.NewReader(bytes.NewReader(content))
+		if err != nil {
+			return nil, err
+		}
+		defer r.Close()
+		if content, err = ioutil.ReadAll(r); err != nil {
+			return nil, err
+		}
+	}
+	var rel release.Release
+	if err := json.Unmarshal(content, &rel); err != nil {
+		return nil, err
+	}
+	return &rel, nil
+}
+
+// secretsAccessReviewer returns a reviewer checking the access of the user of the
+// clientset to the secrets of namespaces with SelfSubjectAccessReviews.
+func secretsAccessReviewer(clientset kubernetes.Interface) accessReviewer {
+	return func(verb, namespace string) (bool, error) {
+		review, err := clientset.AuthorizationV1().SelfSubjectAccessReviews().Create(context.TODO(), &authorizationv1.SelfSubjectAccessReview{
+			Spec: authorizationv1.SelfSubjectAccessReviewSpec{
+				ResourceAttributes: &authorizationv1.ResourceAttributes{
+					Namespace: namespace,
+					Verb:      verb,
+					Resource:  "secrets",
+				},
+			},
+		}, metav1.CreateOptions{})
+		if err != nil {
+			return false, err
+		}
+		return review.Status.Allowed, nil
+	}
+}
+
+// cachedAccessReviewer returns a reviewer reusing the decisions of the reviewer, so
+// that the calls of a driver, which is created for a request, are reviewed once.
+func cachedAccessReviewer(canI accessReviewer) accessReviewer {
+	var mutex sync.Mutex
+	decisions := map[string]bool{}
+	return func(verb, namespace string) (bool, error) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		key := verb + "/" + namespace
+		if allowed, ok := decisions[key]; ok {
+			return allowed, nil
+		}
+		allowed, err := canI(verb, namespace)
+		if err != nil {
+			return false, err
+		}
+		decisions[key] = allowed
+		return allowed, nil
+	}
+}
+
+// openSQLDatabase connects to the database of the SQL driver. The schema is created
+// with the driver of Helm if it does not exist yet, so that it is the same as with
+// the Helm CLI.
+func openSQLDatabase(connectionString string) (*sqlx.DB, error) {
+	db, err := sqlx.Connect("postgres", connectionString)
+	if err != nil {
+		return nil, err
+	}
+	var table *string
+	if err := db.Get(&table, "SELECT to_regclass($1)::text", sqlReleaseTable); err != nil {
+		db.Close()
+		return nil, err
+	}
+	if table == nil {
+		// The Helm driver keeps its own connection, which is only used to create the schema.
+		if _, err := driver.NewSQL(connectionString, log.Infof, ""); err != nil {
+			db.Close()
+			return nil, err
+		}
+	}
+	return db, nil
+}
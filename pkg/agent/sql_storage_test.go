@@ -0,0 +1,177 @@
+package agent
+
+import (
+	"testing"
+
+	"github.com/kubeapps/kubeapps/pkg/dbutils/dbutilstest/pgtest"
+	"helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/release"
+	"helm.sh/helm/v3/pkg/storage"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+// allowNamespaces returns a reviewer allowing every action in the namespaces only,
+// counting the reviews.
+func allowNamespaces(reviews *int, namespaces ...string) accessReviewer {
+	return func(verb, namespace string) (bool, error) {
+		*reviews++
+		for _, ns := range namespaces {
+			if ns == namespace {
+				return true, nil
+			}
+		}
+		return false, nil
+	}
+}
+
+func TestSQLDriverAuthorization(t *testing.T) {
+	rel := &release.Release{Name: "foo", Namespace: "other", Version: 1, Info: &release.Info{Status: release.StatusDeployed}}
+
+	testCases := []struct {
+		name string
+		call func(*storage.Storage) error
+	}{
+		{
+			name: "it forbids getting the releases of other namespaces",
+			call: func(s *storage.Storage) error { _, err := s.Get("foo", 1); return err },
+		},
+		{
+			name: "it forbids listing the releases of other namespaces",
+			call: func(s *storage.Storage) error { _, err := s.ListReleases(); return err },
+		},
+		{
+			name: "it forbids querying the releases of other namespaces",
+			call: func(s *storage.Storage) error { _, err := s.History("foo"); return err },
+		},
+		{
+			name: "it forbids creating releases in other namespaces",
+			call: func(s *storage.Storage) error { return s.Create(rel) },
+		},
+		{
+			name: "it forbids updating releases of other namespaces",
+			call: func(s *storage.Storage) error { return s.Update(rel) },
+		},
+		{
+			name: "it forbids deleting the releases of other namespaces",
+			call: func(s *storage.Storage) error { _, err := s.Delete("foo", 1); return err },
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			reviews := 0
+			// The database is not reached as the actions are not authorized.
+			s := storage.Init(newSQLDriver(nil, "other", allowNamespaces(&reviews, "default")))
+			if err := tc.call(s); err == nil {
+				t.Errorf("expected an error")
+			}
+			if reviews != 1 {
+				t.Errorf("got: %d reviews, want: 1", reviews)
+			}
+		})
+	}
+}
+
+func TestCachedAccessReviewer(t *testing.T) {
+	reviews := 0
+	canI := cachedAccessReviewer(allowNamespaces(&reviews, "default"))
+	for i := 0; i < 3; i++ {
+		if allowed, _ := canI("get", "default"); !allowed {
+			t.Errorf("expected get to be allowed in default")
+		}
+		if allowed, _ := canI("get", "other"); allowed {
+			t.Errorf("expected get not to be allowed in other")
+		}
+	}
+	if got, want := reviews, 2; got != want {
+		t.Errorf("got: %d, want: %d", got, want)
+	}
+}
+
+func TestSecretsAccessReviewer(t *testing.T) {
+	clientset := fake.NewSimpleClientset()
+	var got *authorizationv1.ResourceAttributes
+	clientset.Fake.PrependReactor("create", "selfsubjectaccessreviews", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		review := action.(k8stesting.CreateAction).GetObject().(*authorizationv1.SelfSubjectAccessReview)
+		got = review.Spec.ResourceAttributes
+		return true, &authorizationv1.SelfSubjectAccessReview{Status: authorizationv1.SubjectAccessReviewStatus{Allowed: true}}, nil
+	})
+
+	allowed, err := secretsAccessReviewer(clientset)("list", "team")
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if !allowed {
+		t.Errorf("expected the action to be allowed")
+	}
+	want := &authorizationv1.ResourceAttributes{Namespace: "team", Verb: "list", Resource: "secrets"}
+	if got == nil || *got != *want {
+		t.Errorf("got: %+v, want: %+v", got, want)
+	}
+}
+
+func TestSQLReleaseEncoding(t *testing.T) {
+	rel := &release.Release{Name: "foo", Namespace: "default", Version: 2, Chart: &chart.Chart{Metadata: &chart.Metadata{Name: "apache", Version: "1.0.0"}}}
+	body, err := encodeSQLRelease(rel)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	got, err := decodeSQLRelease(body)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if got.Name != rel.Name || got.Version != rel.Version || got.Chart.Metadata.Version != "1.0.0" {
+		t.Errorf("got: %+v, want: %+v", got, rel)
+	}
+}
+
+func TestStorageForSQL(t *testing.T) {
+	pgtest.SkipIfNoDB(t)
+
+	db, err := openSQLDatabase(pgtest.ConnectionString())
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	defer db.Close()
+	reviews := 0
+	store := storage.Init(newSQLDriver(db, "default", allowNamespaces(&reviews, "default", "other")))
+	if got, want := store.Name(), "SQL"; got != want {
+		t.Errorf("got: %q, want: %q", got, want)
+	}
+
+	rel := &release.Release{
+		Name:      "sql-driver-test",
+		Namespace: "default",
+		Version:   1,
+		Info:      &release.Info{Status: release.StatusDeployed},
+		Chart:     &chart.Chart{Metadata: &chart.Metadata{Name: "apache", Version: "1.0.0"}},
+	}
+	if err := store.Create(rel); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	defer store.Delete(rel.Name, rel.Version)
+
+	got, err := store.Get(rel.Name, rel.Version)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if got.Chart.Metadata.Version != "1.0.0" || got.Info.Status != release.StatusDeployed {
+		t.Errorf("got: %+v, want: %+v", got, rel)
+	}
+	releases, err := store.ListReleases()
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if len(releases) != 1 {
+		t.Errorf("got: %d releases, want: 1", len(releases))
+	}
+
+	// The releases of a namespace are not visible from another one.
+	otherStore := storage.Init(newSQLDriver(db, "other", allowNamespaces(&reviews, "default", "other")))
+	if _, err := otherStore.Get(rel.Name, rel.Version); err == nil {
+		t.Errorf("expected the release not to be found in another namespace")
+	}
+}
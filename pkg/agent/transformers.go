@@ -0,0 +1,417 @@
+package agent
+
+import (
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v2"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/strategicpatch"
+	"k8s.io/client-go/kubernetes/scheme"
+	sigsyaml "sigs.k8s.io/yaml"
+)
+
+// MetadataTransformer adds labels and annotations to every resource and to the
+// pod templates of the workloads. Existing values are kept.
+type MetadataTransformer struct {
+	Labels       map[string]string
+	Annotations  map[string]string
+	PodSpecPaths PodSpecPaths
+}
+
+// Transform implements ResourceTransformer.
+func (t *MetadataTransformer) Transform(resourceList []interface{}) error {
+	return forEachResource(resourceList, func(kind string, resource map[interface{}]interface{}) error {
+		metadatas := []map[interface{}]interface{}{childMap(resource, "metadata")}
+		if template := t.PodSpecPaths.podTemplate(resource); template != nil {
+			metadatas = append(metadatas, childMap(template, "metadata"))
+		}
+		for _, metadata := range metadatas {
+			setMissingValues(metadata, "labels", t.Labels)
+			setMissingValues(metadata, "annotations", t.Annotations)
+		}
+		return nil
+	})
+}
+
+// PullSecretsTransformer adds image pull secrets to every pod spec, whatever the
+// registry of their images.
+type PullSecretsTransformer struct {
+	Secrets      []string
+	PodSpecPaths PodSpecPaths
+}
+
+// Transform implements ResourceTransformer.
+func (t *PullSecretsTransformer) Transform(resourceList []interface{}) error {
+	return forEachResource(resourceList, func(kind string, resource map[interface{}]interface{}) error {
+		if podSpec := t.PodSpecPaths.podSpec(resource); podSpec != nil {
+			addPullSecrets(podSpec, t.Secrets)
+		}
+		return nil
+	})
+}
+
+// ResourceRequestsTransformer sets the default resource requests of the containers
+// which do not request those resources.
+type ResourceRequestsTransformer struct {
+	Requests     map[string]string
+	PodSpecPaths PodSpecPaths
+}
+
+// Transform implements ResourceTransformer.
+func (t *ResourceRequestsTransformer) Transform(resourceList []interface{}) error {
+	return forEachResource(resourceList, func(kind string, resource map[interface{}]interface{}) error {
+		podSpec := t.PodSpecPaths.podSpec(resource)
+		if podSpec == nil {
+			return nil
+		}
+		// Ephemeral containers cannot request resources.
+		for _, container := range podSpecContainers(podSpec, "initContainers", "containers") {
+			setMissingValues(childMap(container, "resources"), "requests", t.Requests)
+		}
+		return nil
+	})
+}
+
+// SchedulingTransformer adds a node selector and tolerations to every pod spec.
+// The node selector does not override the keys already selected and tolerations
+// are only added once.
+type SchedulingTransformer struct {
+	NodeSelector map[string]string
+	Tolerations  []corev1.Toleration
+	PodSpecPaths PodSpecPaths
+}
+
+// Transform implements ResourceTransformer.
+func (t *SchedulingTransformer) Transform(resourceList []interface{}) error {
+	tolerations := []interface{}{}
+	for _, toleration := range t.Tolerations {
+		untyped, err := toUntyped(toleration)
+		if err != nil {
+			return err
+		}
+		tolerations = append(tolerations, untyped)
+	}
+	return forEachResource(resourceList, func(kind string, resource map[interface{}]interface{}) error {
+		podSpec := t.PodSpecPaths.podSpec(resource)
+		if podSpec == nil {
+			return nil
+		}
+		setMissingValues(podSpec, "nodeSelector", t.NodeSelector)
+		if len(tolerations) == 0 {
+			return nil
+		}
+		existing, _ := podSpec["tolerations"].([]interface{})
+		for _, toleration := range tolerations {
+			if !containsValue(existing, toleration) {
+				existing = append(existing, toleration)
+			}
+		}
+		podSpec["tolerations"] = existing
+		return nil
+	})
+}
+
+// PatchesTransformer applies kustomize-style strategic merge patches: each patch
+// is a partial resource identified by its apiVersion, kind, name and, optionally,
+// namespace. Kinds unknown to Kubernetes are patched with a JSON merge patch.
+type PatchesTransformer struct {
+	patches []map[interface{}]interface{}
+}
+
+// NewPatchesTransformer returns a transformer applying the patches, each of which
+// can contain several YAML documents.
+func NewPatchesTransformer(patchesYaml []string) (*PatchesTransformer, error) {
+	t := &PatchesTransformer{}
+	for _, patchYaml := range patchesYaml {
+		decoder := yaml.NewDecoder(strings.NewReader(patchYaml))
+		for {
+			var patch map[interface{}]interface{}
+			err := decoder.Decode(&patch)
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return nil, fmt.Errorf("Unable to parse the patch: %v", err)
+			}
+			if patch == nil {
+				continue
+			}
+			if _, ok := patch["kind"].(string); !ok {
+				return nil, fmt.Errorf("Invalid patch, it must have a kind: %v", patch)
+			}
+			metadata, _ := lookupMap(patch, "metadata")
+			if name, _ := metadata["name"].(string); name == "" {
+				return nil, fmt.Errorf("Invalid patch, it must have a metadata.name: %v", patch)
+			}
+			t.patches = append(t.patches, patch)
+		}
+	}
+	return t, nil
+}
+
+// Transform implements ResourceTransformer.
+func (t *PatchesTransformer) Transform(resourceList []interface{}) error {
+	return forEachResource(resourceList, func(kind string, resource map[interface{}]interface{}) error {
+		for _, patch := range t.patches {
+			if !patchMatches(patch, kind, resource) {
+				continue
+			}
+			patched, err := strategicMergePatch(resource, patch)
+			if err != nil {
+				return err
+			}
+			// Replace the content of the resource, which is referenced by the resource list.
+			for k := range resource {
+				delete(resource, k)
+			}
+			for k, v := range patched {
+				resource[k] = v
+			}
+		}
+		return nil
+	})
+}
+
+func patchMatches(patch map[interface{}]interface{}, kind string, resource map[interface{}]interface{}) bool {
+	if patch["kind"] != kind {
+		return false
+	}
+	if apiVersion, ok := patch["apiVersion"]; ok && apiVersion != resource["apiVersion"] {
+		return false
+	}
+	patchMetadata, _ := lookupMap(patch, "metadata")
+	metadata, ok := lookupMap(resource, "metadata")
+	if !ok || patchMetadata["name"] != metadata["name"] {
+		return false
+	}
+	if namespace, ok := patchMetadata["namespace"]; ok && namespace != metadata["namespace"] {
+		return false
+	}
+	return true
+}
+
+// strategicMergePatch patches a resource using the patch strategy of its Kubernetes type.
+func strategicMergePatch(resource, patch map[interface{}]interface{}) (map[interface{}]interface{}, error) {
+	original, _ := withStringKeys(resource).(map[string]interface{})
+	patchMap, _ := withStringKeys(patch).(map[string]interface{})
+	apiVersion, _ := resource["apiVersion"].(string)
+	kind, _ := resource["kind"].(string)
+	obj, err := scheme.Scheme.New(schema.FromAPIVersionAndKind(apiVersion, kind))
+	var patched map[string]interface{}
+	if err == nil {
+		patched, err = strategicpatch.StrategicMergeMapPatch(original, patchMap, obj)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to patch %s %v: %v", kind, resource["metadata"], err)
+		}
+	} else {
+		patched, _ = mergePatch(original, patchMap).(map[string]interface{})
+	}
+	result, _ := withInterfaceKeys(patched).(map[interface{}]interface{})
+	return result, nil
+}
+
+// mergePatch applies a JSON merge patch (RFC 7386).
+func mergePatch(original, patch interface{}) interface{} {
+	patchMap, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+	originalMap, ok := original.(map[string]interface{})
+	if !ok {
+		originalMap = map[string]interface{}{}
+	}
+	for k, v := range patchMap {
+		if v == nil {
+			delete(originalMap, k)
+			continue
+		}
+		originalMap[k] = mergePatch(originalMap[k], v)
+	}
+	return originalMap
+}
+
+// podTemplate returns the pod template of the workload resources, that is the
+// parent of their pod spec.
+func (p PodSpecPaths) podTemplate(resource map[interface{}]interface{}) map[interface{}]interface{} {
+	keys, ok := p.keys(resource)
+	// The pod spec of a Pod has no template.
+	if !ok || len(keys) < 2 || keys[len(keys)-1] != "spec" {
+		return nil
+	}
+	template, _ := lookupMap(resource, keys[:len(keys)-1]...)
+	return template
+}
+
+// podSpecContainers returns the containers of the given lists of a pod spec, such
+// as initContainers and containers.
+func podSpecContainers(podSpec map[interface{}]interface{}, keys ...string) []map[interface{}]interface{} {
+	containers := []map[interface{}]interface{}{}
+	for _, key := range keys {
+		list, _ := podSpec[key].([]interface{})
+		for _, c := range list {
+			if container, ok := c.(map[interface{}]interface{}); ok {
+				containers = append(containers, container)
+			}
+		}
+	}
+	return containers
+}
+
+// addPullSecrets adds the image pull secrets not already included in the pod spec.
+func addPullSecrets(podSpec map[interface{}]interface{}, secretNames []string) {
+	var imagePullSecrets []interface{}
+	existingNames := map[string]bool{}
+	switch existing := podSpec["imagePullSecrets"].(type) {
+	case []interface{}:
+		imagePullSecrets = existing
+	case []map[string]interface{}:
+		for _, s := range existing {
+			imagePullSecrets = append(imagePullSecrets, s)
+		}
+	}
+	for _, s := range imagePullSecrets {
+		switch secret := s.(type) {
+		case map[interface{}]interface{}:
+			if name, ok := secret["name"].(string); ok {
+				existingNames[name] = true
+			}
+		case map[string]interface{}:
+			if name, ok := secret["name"].(string); ok {
+				existingNames[name] = true
+			}
+		}
+	}
+	for _, name := range secretNames {
+		if !existingNames[name] {
+			imagePullSecrets = append(imagePullSecrets, map[interface{}]interface{}{"name": name})
+			existingNames[name] = true
+		}
+	}
+	if len(imagePullSecrets) > 0 {
+		podSpec["imagePullSecrets"] = imagePullSecrets
+	}
+}
+
+// setMissingValues sets the values of the map at key which are not already set.
+func setMissingValues(parent map[interface{}]interface{}, key string, values map[string]string) {
+	if len(values) == 0 || parent == nil {
+		return
+	}
+	m := childMap(parent, key)
+	if m == nil {
+		return
+	}
+	for k, v := range values {
+		if _, ok := m[k]; !ok {
+			m[k] = v
+		}
+	}
+}
+
+// childMap returns the map at key, which is created if not set. It returns nil,
+// logging an error, if the key is set to something else than a map.
+func childMap(parent map[interface{}]interface{}, key string) map[interface{}]interface{} {
+	value, ok := parent[key]
+	if !ok || value == nil {
+		m := map[interface{}]interface{}{}
+		parent[key] = m
+		return m
+	}
+	m, ok := value.(map[interface{}]interface{})
+	if !ok {
+		log.Errorf("invalid resource: non-map %q, in %+v", key, parent)
+		return nil
+	}
+	return m
+}
+
+// lookupMap returns the map at the keys, if any.
+func lookupMap(m map[interface{}]interface{}, keys ...string) (map[interface{}]interface{}, bool) {
+	current := m
+	for _, k := range keys {
+		next, ok := current[k].(map[interface{}]interface{})
+		if !ok {
+			return nil, false
+		}
+		current = next
+	}
+	return current, true
+}
+
+func containsValue(list []interface{}, value interface{}) bool {
+	for _, v := range list {
+		if reflect.DeepEqual(v, value) {
+			return true
+		}
+	}
+	return false
+}
+
+// toUntyped converts a Kubernetes type to the untyped YAML representation of the resources.
+func toUntyped(obj interface{}) (interface{}, error) {
+	data, err := sigsyaml.Marshal(obj)
+	if err != nil {
+		return nil, err
+	}
+	var untyped interface{}
+	if err := yaml.Unmarshal(data, &untyped); err != nil {
+		return nil, err
+	}
+	return untyped, nil
+}
+
+// withStringKeys converts the maps parsed by yaml.v2 to the maps expected by the
+// Kubernetes patch helpers.
+func withStringKeys(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[interface{}]interface{}:
+		m := map[string]interface{}{}
+		for key, item := range v {
+			m[fmt.Sprintf("%v", key)] = withStringKeys(item)
+		}
+		return m
+	case map[string]interface{}:
+		m := map[string]interface{}{}
+		for key, item := range v {
+			m[key] = withStringKeys(item)
+		}
+		return m
+	case []interface{}:
+		list := make([]interface{}, len(v))
+		for i, item := range v {
+			list[i] = withStringKeys(item)
+		}
+		return list
+	case []map[string]interface{}:
+		list := make([]interface{}, len(v))
+		for i, item := range v {
+			list[i] = withStringKeys(item)
+		}
+		return list
+	}
+	return value
+}
+
+// withInterfaceKeys converts maps back to the representation of yaml.v2.
+func withInterfaceKeys(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		m := map[interface{}]interface{}{}
+		for key, item := range v {
+			m[key] = withInterfaceKeys(item)
+		}
+		return m
+	case []interface{}:
+		list := make([]interface{}, len(v))
+		for i, item := range v {
+			list[i] = withInterfaceKeys(item)
+		}
+		return list
+	}
+	return value
+}
@@ -0,0 +1,427 @@
+package agent
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	corev1 "k8s.io/api/core/v1"
+)
+
+func TestTransformers(t *testing.T) {
+	testCases := []struct {
+		name         string
+		transformers []ResourceTransformer
+		input        string
+		output       string
+	}{
+		{
+			name:         "it adds labels and annotations to resources and pod templates",
+			transformers: []ResourceTransformer{&MetadataTransformer{Labels: map[string]string{"team": "platform", "app": "other"}, Annotations: map[string]string{"owner": "kubeapps"}}},
+			input: `apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: web
+  labels:
+    app: web
+spec:
+  template:
+    spec:
+      containers:
+      - name: web
+        image: nginx
+---
+apiVersion: v1
+kind: Service
+metadata:
+  name: web
+`,
+			output: `apiVersion: apps/v1
+kind: Deployment
+metadata:
+  annotations:
+    owner: kubeapps
+  labels:
+    app: web
+    team: platform
+  name: web
+spec:
+  template:
+    metadata:
+      annotations:
+        owner: kubeapps
+      labels:
+        app: other
+        team: platform
+    spec:
+      containers:
+      - image: nginx
+        name: web
+---
+apiVersion: v1
+kind: Service
+metadata:
+  annotations:
+    owner: kubeapps
+  labels:
+    app: other
+    team: platform
+  name: web
+`,
+		},
+		{
+			name:         "it adds image pull secrets which are not already included",
+			transformers: []ResourceTransformer{&PullSecretsTransformer{Secrets: []string{"existing", "mirror"}}},
+			input: `apiVersion: v1
+kind: Pod
+metadata:
+  name: web
+spec:
+  containers:
+  - name: web
+    image: nginx
+  imagePullSecrets:
+  - name: existing
+`,
+			output: `apiVersion: v1
+kind: Pod
+metadata:
+  name: web
+spec:
+  containers:
+  - image: nginx
+    name: web
+  imagePullSecrets:
+  - name: existing
+  - name: mirror
+`,
+		},
+		{
+			name:         "it sets the default resource requests of the containers",
+			transformers: []ResourceTransformer{&ResourceRequestsTransformer{Requests: map[string]string{"cpu": "50m", "memory": "64Mi"}}},
+			input: `apiVersion: batch/v1beta1
+kind: CronJob
+metadata:
+  name: backup
+spec:
+  jobTemplate:
+    spec:
+      template:
+        spec:
+          initContainers:
+          - name: init
+            image: busybox
+          containers:
+          - name: backup
+            image: backup
+            resources:
+              requests:
+                cpu: 100m
+`,
+			output: `apiVersion: batch/v1beta1
+kind: CronJob
+metadata:
+  name: backup
+spec:
+  jobTemplate:
+    spec:
+      template:
+        spec:
+          containers:
+          - image: backup
+            name: backup
+            resources:
+              requests:
+                cpu: 100m
+                memory: 64Mi
+          initContainers:
+          - image: busybox
+            name: init
+            resources:
+              requests:
+                cpu: 50m
+                memory: 64Mi
+`,
+		},
+		{
+			name: "it adds the node selector and the missing tolerations",
+			transformers: []ResourceTransformer{&SchedulingTransformer{
+				NodeSelector: map[string]string{"pool": "apps", "zone": "a"},
+				Tolerations: []corev1.Toleration{
+					{Key: "dedicated", Operator: corev1.TolerationOpEqual, Value: "apps", Effect: corev1.TaintEffectNoSchedule},
+					{Key: "spot", Operator: corev1.TolerationOpExists},
+				},
+			}},
+			input: `apiVersion: apps/v1
+kind: StatefulSet
+metadata:
+  name: db
+spec:
+  template:
+    spec:
+      containers:
+      - name: db
+        image: postgres
+      nodeSelector:
+        zone: b
+      tolerations:
+      - key: dedicated
+        operator: Equal
+        value: apps
+        effect: NoSchedule
+`,
+			output: `apiVersion: apps/v1
+kind: StatefulSet
+metadata:
+  name: db
+spec:
+  template:
+    spec:
+      containers:
+      - image: postgres
+        name: db
+      nodeSelector:
+        pool: apps
+        zone: b
+      tolerations:
+      - effect: NoSchedule
+        key: dedicated
+        operator: Equal
+        value: apps
+      - key: spot
+        operator: Exists
+`,
+		},
+		{
+			name: "it transforms the pods of the custom resources of the pod spec paths",
+			transformers: []ResourceTransformer{&PullSecretsTransformer{
+				Secrets:      []string{"mirror"},
+				PodSpecPaths: PodSpecPaths{{Group: "argoproj.io", Kind: "Workflow"}: {"spec", "podSpec"}},
+			}},
+			input: `apiVersion: argoproj.io/v1alpha1
+kind: Workflow
+metadata:
+  name: build
+spec:
+  podSpec:
+    containers:
+    - image: nginx
+      name: build
+---
+apiVersion: example.com/v1
+kind: Deployment
+metadata:
+  name: web
+spec:
+  template:
+    spec:
+      containers:
+      - image: nginx
+        name: web
+`,
+			output: `apiVersion: argoproj.io/v1alpha1
+kind: Workflow
+metadata:
+  name: build
+spec:
+  podSpec:
+    containers:
+    - image: nginx
+      name: build
+    imagePullSecrets:
+    - name: mirror
+---
+apiVersion: example.com/v1
+kind: Deployment
+metadata:
+  name: web
+spec:
+  template:
+    spec:
+      containers:
+      - image: nginx
+        name: web
+`,
+		},
+		{
+			name: "it runs the transformers in order",
+			transformers: []ResourceTransformer{
+				&DockerSecretsPostRenderer{secrets: map[string]string{"example.com": "registry"}},
+				&PullSecretsTransformer{Secrets: []string{"registry", "mirror"}},
+			},
+			input: `apiVersion: v1
+kind: Pod
+metadata:
+  name: web
+spec:
+  containers:
+  - name: web
+    image: example.com/nginx
+`,
+			output: `apiVersion: v1
+kind: Pod
+metadata:
+  name: web
+spec:
+  containers:
+  - image: example.com/nginx
+    name: web
+  imagePullSecrets:
+  - name: registry
+  - name: mirror
+`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			renderedManifests, err := NewPostRendererPipeline(tc.transformers...).Run(bytes.NewBufferString(tc.input))
+			if err != nil {
+				t.Fatalf("%+v", err)
+			}
+			if got, want := renderedManifests.String(), tc.output; got != want {
+				t.Errorf("mismatch (-want +got):\n%s", cmp.Diff(want, got))
+			}
+		})
+	}
+}
+
+func TestPatchesTransformer(t *testing.T) {
+	const manifest = `apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: web
+spec:
+  replicas: 1
+  template:
+    spec:
+      containers:
+      - name: web
+        image: nginx
+      - name: sidecar
+        image: envoy
+---
+apiVersion: example.com/v1
+kind: Database
+metadata:
+  name: web
+spec:
+  size: small
+  backups:
+    enabled: true
+`
+	testCases := []struct {
+		name        string
+		patches     []string
+		output      string
+		expectedErr bool
+	}{
+		{
+			name: "it merges the patches with the strategy of the Kubernetes types",
+			patches: []string{`apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: web
+spec:
+  replicas: 3
+  template:
+    spec:
+      containers:
+      - name: web
+        env:
+        - name: LOG_LEVEL
+          value: debug
+`},
+			output: `apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: web
+spec:
+  replicas: 3
+  template:
+    spec:
+      containers:
+      - env:
+        - name: LOG_LEVEL
+          value: debug
+        image: nginx
+        name: web
+      - image: envoy
+        name: sidecar
+---
+apiVersion: example.com/v1
+kind: Database
+metadata:
+  name: web
+spec:
+  backups:
+    enabled: true
+  size: small
+`,
+		},
+		{
+			name: "it merges the patches of unknown kinds as JSON merge patches",
+			patches: []string{`apiVersion: example.com/v1
+kind: Database
+metadata:
+  name: web
+spec:
+  size: large
+  backups: null
+---
+apiVersion: example.com/v1
+kind: Database
+metadata:
+  name: other
+spec:
+  size: huge
+`},
+			output: `apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: web
+spec:
+  replicas: 1
+  template:
+    spec:
+      containers:
+      - image: nginx
+        name: web
+      - image: envoy
+        name: sidecar
+---
+apiVersion: example.com/v1
+kind: Database
+metadata:
+  name: web
+spec:
+  size: large
+`,
+		},
+		{
+			name:        "it errors if a patch does not identify a resource",
+			patches:     []string{"kind: Deployment\nspec:\n  replicas: 3\n"},
+			expectedErr: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			transformer, err := NewPatchesTransformer(tc.patches)
+			if got, want := err != nil, tc.expectedErr; got != want {
+				t.Fatalf("got: %t, want: %t. err: %+v", got, want, err)
+			}
+			if tc.expectedErr {
+				return
+			}
+
+			renderedManifests, err := NewPostRendererPipeline(transformer).Run(bytes.NewBufferString(manifest))
+			if err != nil {
+				t.Fatalf("%+v", err)
+			}
+			if got, want := renderedManifests.String(), tc.output; got != want {
+				t.Errorf("mismatch (-want +got):\n%s", cmp.Diff(want, got))
+			}
+		})
+	}
+}
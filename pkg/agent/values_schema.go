@@ -0,0 +1,83 @@
+package agent
+
+import (
+	"fmt"
+
+	"github.com/xeipuuv/gojsonschema"
+	"helm.sh/helm/v3/pkg/chart"
+	"helm.sh/helm/v3/pkg/chartutil"
+)
+
+// SchemaError represents a value which does not match the JSON schema of a chart.
+type SchemaError struct {
+	Path         string `json:"path"`
+	ExpectedType string `json:"expectedType,omitempty"`
+	Message      string `json:"message"`
+}
+
+// ValidateValues validates the values supplied by the user, merged with the chart
+// defaults, against the values.schema.json of the chart and of its dependencies,
+// as Helm does when rendering the chart. It returns the values which do not match.
+func ValidateValues(ch *chart.Chart, valuesYaml string) ([]SchemaError, error) {
+	values, err := chartutil.ReadValues([]byte(valuesYaml))
+	if err != nil {
+		return nil, fmt.Errorf("Unable to parse the values: %v", err)
+	}
+	coalesced, err := chartutil.CoalesceValues(ch, values)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to compute the values of the release: %v", err)
+	}
+	return validateChartValues(ch, coalesced, "")
+}
+
+func validateChartValues(ch *chart.Chart, values map[string]interface{}, path string) ([]SchemaError, error) {
+	schemaErrors := []SchemaError{}
+	if len(ch.Schema) > 0 {
+		result, err := gojsonschema.Validate(gojsonschema.NewBytesLoader(ch.Schema), gojsonschema.NewGoLoader(values))
+		if err != nil {
+			return nil, fmt.Errorf("Unable to validate the values against the schema of chart %q: %v", ch.Name(), err)
+		}
+		for _, e := range result.Errors() {
+			schemaErrors = append(schemaErrors, newSchemaError(e, path))
+		}
+	}
+	for _, dependency := range ch.Dependencies() {
+		dependencyValues, _ := values[dependency.Name()].(map[string]interface{})
+		if dependencyValues == nil {
+			dependencyValues = map[string]interface{}{}
+		}
+		dependencyErrors, err := validateChartValues(dependency, dependencyValues, fieldPath(path, dependency.Name()))
+		if err != nil {
+			return nil, err
+		}
+		schemaErrors = append(schemaErrors, dependencyErrors...)
+	}
+	return schemaErrors, nil
+}
+
+// newSchemaError returns the path, expected type and message of a validation error.
+// The path of a missing required value is the one of the value itself.
+func newSchemaError(e gojsonschema.ResultError, path string) SchemaError {
+	// The field is already a dot-separated path within the chart values.
+	if field := e.Field(); field != gojsonschema.STRING_ROOT_SCHEMA_PROPERTY {
+		if path != "" {
+			field = path + "." + field
+		}
+		path = field
+	}
+	if property, ok := e.Details()["property"].(string); ok && e.Type() == "required" {
+		path = fieldPath(path, property)
+	}
+	if path == "" {
+		path = gojsonschema.STRING_ROOT_SCHEMA_PROPERTY
+	}
+	expectedType := ""
+	if e.Type() == "invalid_type" {
+		expectedType = fmt.Sprintf("%v", e.Details()["expected"])
+	}
+	return SchemaError{
+		Path:         path,
+		ExpectedType: expectedType,
+		Message:      e.Description(),
+	}
+}
@@ -0,0 +1,97 @@
+package agent
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"helm.sh/helm/v3/pkg/chart"
+)
+
+const testSchema = `{
+  "$schema": "http://json-schema.org/schema#",
+  "type": "object",
+  "required": ["image"],
+  "properties": {
+    "replicaCount": {"type": "integer", "minimum": 1},
+    "image": {
+      "type": "object",
+      "required": ["repository"],
+      "properties": {
+        "repository": {"type": "string"},
+        "tag": {"type": "string"}
+      }
+    }
+  }
+}`
+
+const testDependencySchema = `{
+  "type": "object",
+  "properties": {
+    "enabled": {"type": "boolean"}
+  }
+}`
+
+func TestValidateValues(t *testing.T) {
+	testCases := []struct {
+		name           string
+		chartValues    map[string]interface{}
+		values         string
+		expectedErrors []SchemaError
+	}{
+		{
+			name:           "accepts values matching the schema",
+			chartValues:    map[string]interface{}{"image": map[string]interface{}{"repository": "bitnami/apache"}},
+			values:         "replicaCount: 2\n",
+			expectedErrors: []SchemaError{},
+		},
+		{
+			name:        "reports values of the wrong type",
+			chartValues: map[string]interface{}{"image": map[string]interface{}{"repository": "bitnami/apache"}},
+			values:      "replicaCount: two\nimage:\n  tag: 2\n",
+			expectedErrors: []SchemaError{
+				{Path: "image.tag", ExpectedType: "string", Message: "Invalid type. Expected: string, given: integer"},
+				{Path: "replicaCount", ExpectedType: "integer", Message: "Invalid type. Expected: integer, given: string"},
+			},
+		},
+		{
+			name:        "reports missing required values",
+			chartValues: map[string]interface{}{},
+			values:      "replicaCount: 0\n",
+			expectedErrors: []SchemaError{
+				{Path: "image", Message: "image is required"},
+				{Path: "replicaCount", Message: "Must be greater than or equal to 1"},
+			},
+		},
+		{
+			name:        "validates the values of the dependencies",
+			chartValues: map[string]interface{}{"image": map[string]interface{}{"repository": "bitnami/apache"}},
+			values:      "mariadb:\n  enabled: yes please\n",
+			expectedErrors: []SchemaError{
+				{Path: "mariadb.enabled", ExpectedType: "boolean", Message: "Invalid type. Expected: boolean, given: string"},
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ch := &chart.Chart{
+				Metadata: &chart.Metadata{Name: "apache"},
+				Values:   tc.chartValues,
+				Schema:   []byte(testSchema),
+			}
+			ch.AddDependency(&chart.Chart{
+				Metadata: &chart.Metadata{Name: "mariadb"},
+				Values:   map[string]interface{}{"enabled": true},
+				Schema:   []byte(testDependencySchema),
+			})
+
+			schemaErrors, err := ValidateValues(ch, tc.values)
+			if err != nil {
+				t.Fatalf("%+v", err)
+			}
+			if got, want := schemaErrors, tc.expectedErrors; !cmp.Equal(want, got) {
+				t.Errorf("mismatch (-want +got):\n%s", cmp.Diff(want, got))
+			}
+		})
+	}
+}
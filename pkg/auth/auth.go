@@ -18,16 +18,20 @@ package auth
 
 import (
 	"context"
+	"crypto/sha256"
 	"fmt"
 	"regexp"
 	"strings"
+	"sync"
 
 	"github.com/kubeapps/kubeapps/pkg/kube"
 	yamlUtils "github.com/kubeapps/kubeapps/pkg/yaml"
 	authorizationapi "k8s.io/api/authorization/v1"
-	k8sErrors "k8s.io/apimachinery/pkg/api/errors"
+	rbacv1 "k8s.io/api/rbac/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	discovery "k8s.io/client-go/discovery"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/discovery"
 	"k8s.io/client-go/kubernetes"
 	authorizationv1 "k8s.io/client-go/kubernetes/typed/authorization/v1"
 	"k8s.io/client-go/rest"
@@ -40,17 +44,12 @@ type resource struct {
 }
 
 type k8sAuthInterface interface {
-	GetResourceList(groupVersion string) (*metav1.APIResourceList, error)
 	CanI(verb, group, resource, namespace string) (bool, error)
+	RulesReview(namespace string) (*authorizationapi.SubjectRulesReviewStatus, error)
 }
 
 type k8sAuth struct {
-	AuthCli      authorizationv1.AuthorizationV1Interface
-	DiscoveryCli discovery.DiscoveryInterface
-}
-
-func (u k8sAuth) GetResourceList(groupVersion string) (*metav1.APIResourceList, error) {
-	return u.DiscoveryCli.ServerResourcesForGroupVersion(groupVersion)
+	AuthCli authorizationv1.AuthorizationV1Interface
 }
 
 func (u k8sAuth) CanI(verb, group, resource, namespace string) (bool, error) {
@@ -71,9 +70,27 @@ func (u k8sAuth) CanI(verb, group, resource, namespace string) (bool, error) {
 	return res.Status.Allowed, nil
 }
 
+func (u k8sAuth) RulesReview(namespace string) (*authorizationapi.SubjectRulesReviewStatus, error) {
+	res, err := u.AuthCli.SelfSubjectRulesReviews().Create(context.TODO(), &authorizationapi.SelfSubjectRulesReview{
+		Spec: authorizationapi.SelfSubjectRulesReviewSpec{
+			Namespace: namespace,
+		},
+	}, metav1.CreateOptions{})
+	if err != nil {
+		return nil, err
+	}
+	return &res.Status, nil
+}
+
 // UserAuth contains information to check user permissions
 type UserAuth struct {
 	k8sAuth k8sAuthInterface
+	mapper  meta.RESTMapper
+	// mapperKey identifies the RESTMapper of the user in the cache of RESTMappers.
+	mapperKey string
+	// cacheKey identifies the token and cluster of the user in the review cache.
+	// The decisions are not cached if it is empty.
+	cacheKey string
 }
 
 // Action represents a specific set of verbs against a resource
@@ -88,16 +105,18 @@ type Action struct {
 // Checker for the exported funcs
 type Checker interface {
 	ValidateForNamespace(namespace string) (bool, error)
+	ValidateActionsForNamespace(namespace string, rules []rbacv1.PolicyRule) (bool, error)
+	ValidateRulesForNamespace(namespace string, rules []rbacv1.PolicyRule) (bool, error)
 	GetForbiddenActions(namespace, action, manifest string) ([]Action, error)
 }
 
 // NewAuth creates an auth agent
 func NewAuth(token, clusterName string, clustersConfig kube.ClustersConfig) (*UserAuth, error) {
-	config, err := rest.InClusterConfig()
+	inClusterConfig, err := rest.InClusterConfig()
 	if err != nil {
 		return nil, err
 	}
-	config, err = kube.NewClusterConfig(config, token, clusterName, clustersConfig)
+	config, err := kube.NewClusterConfig(inClusterConfig, token, clusterName, clustersConfig)
 	if err != nil {
 		return nil, err
 	}
@@ -105,20 +124,140 @@ func NewAuth(token, clusterName string, clustersConfig kube.ClustersConfig) (*Us
 	if err != nil {
 		return nil, err
 	}
-	authCli := kubeClient.AuthorizationV1()
-	discoveryCli := kubeClient.Discovery()
 	k8sAuthCli := k8sAuth{
-		AuthCli:      authCli,
-		DiscoveryCli: discoveryCli,
+		AuthCli: kubeClient.AuthorizationV1(),
 	}
+	cacheKey := fmt.Sprintf("%s/%x", clusterName, sha256.Sum256([]byte(token)))
+
+	// The RESTMapper of a cluster is shared by the users if the service account of
+	// Kubeapps can discover its resources. Otherwise, each user has its own RESTMapper,
+	// so that the discovery is not done with the token of another user.
+	svcConfig, err := serviceAccountConfig(inClusterConfig, clusterName, clustersConfig)
+	if err != nil {
+		return nil, err
+	}
+	mapperKey, discoveryConfig := cacheKey, config
+	if svcConfig != nil {
+		mapperKey, discoveryConfig = clusterName, svcConfig
+	}
+	mapper, err := restMapperFor(mapperKey, func() (discovery.DiscoveryInterface, error) {
+		return discovery.NewDiscoveryClientForConfig(discoveryConfig)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &UserAuth{
+		k8sAuth:   k8sAuthCli,
+		mapper:    mapper,
+		mapperKey: mapperKey,
+		cacheKey:  cacheKey,
+	}, nil
+}
 
-	return &UserAuth{k8sAuthCli}, nil
+// serviceAccountConfig returns the config of the service account of Kubeapps on a
+// cluster, or nil if no service token is configured for the additional cluster.
+func serviceAccountConfig(inClusterConfig *rest.Config, clusterName string, clustersConfig kube.ClustersConfig) (*rest.Config, error) {
+	if clusterName == clustersConfig.KubeappsClusterName {
+		return inClusterConfig, nil
+	}
+	clusterConfig, ok := clustersConfig.Clusters[clusterName]
+	if !ok || clusterConfig.ServiceToken == "" {
+		return nil, nil
+	}
+	// The config is created without user token, so that it is not proxied by pinniped.
+	config, err := kube.NewClusterConfig(inClusterConfig, "", clusterName, clustersConfig)
+	if err != nil {
+		return nil, err
+	}
+	config.BearerToken = clusterConfig.ServiceToken
+	return config, nil
 }
 
 // ValidateForNamespace checks if the user can access secrets in the given
 // namespace, as a check of whether they can view the namespace.
 func (u *UserAuth) ValidateForNamespace(namespace string) (bool, error) {
-	return u.k8sAuth.CanI("get", "", "secrets", namespace)
+	return u.canI("get", "", "secrets", namespace)
+}
+
+// ValidateActionsForNamespace checks if the user can do every verb of the rules on
+// their resources in the given namespace, with an access review each. The user is
+// not allowed if the rules have no action to review.
+func (u *UserAuth) ValidateActionsForNamespace(namespace string, rules []rbacv1.PolicyRule) (bool, error) {
+	reviewed := false
+	for _, rule := range rules {
+		for _, group := range rule.APIGroups {
+			for _, resource := range rule.Resources {
+				for _, verb := range rule.Verbs {
+					allowed, err := u.canI(verb, group, resource, namespace)
+					if err != nil || !allowed {
+						return false, err
+					}
+					reviewed = true
+				}
+			}
+		}
+	}
+	return reviewed, nil
+}
+
+// ValidateRulesForNamespace checks if the rules of the user in the given namespace,
+// as returned by a rules review, include the rules, such as those of a ClusterRole.
+// If the rules review is incomplete, such as with authorizers other than RBAC,
+// the actions of the rules are reviewed instead. As rules reviews are namespaced,
+// the actions are also reviewed for the access to all namespaces.
+// The user is not allowed if the rules have no resource rule, as with an empty
+// aggregated ClusterRole.
+func (u *UserAuth) ValidateRulesForNamespace(namespace string, rules []rbacv1.PolicyRule) (bool, error) {
+	required := []authorizationapi.ResourceRule{}
+	for _, rule := range rules {
+		// Non resource rules, such as /healthz, are not namespaced.
+		if len(rule.Resources) == 0 {
+			continue
+		}
+		required = append(required, authorizationapi.ResourceRule{
+			Verbs:         rule.Verbs,
+			APIGroups:     rule.APIGroups,
+			Resources:     rule.Resources,
+			ResourceNames: rule.ResourceNames,
+		})
+	}
+	if len(required) == 0 {
+		return false, fmt.Errorf("there are no resource rules to check")
+	}
+	if namespace == "" {
+		return u.ValidateActionsForNamespace(namespace, rules)
+	}
+	status, err := u.k8sAuth.RulesReview(namespace)
+	if err != nil {
+		return false, err
+	}
+	if RulesAllow(status.ResourceRules, required) {
+		return true, nil
+	}
+	if status.Incomplete {
+		return u.ValidateActionsForNamespace(namespace, rules)
+	}
+	return false, nil
+}
+
+// canI returns the cached decision of a review of the user, if any, or creates the review.
+func (u *UserAuth) canI(verb, group, resource, namespace string) (bool, error) {
+	if u.cacheKey == "" {
+		return u.k8sAuth.CanI(verb, group, resource, namespace)
+	}
+	key := reviewKey{u.cacheKey, verb, group, resource, namespace}
+	if allowed, ok := decisions.get(key); ok {
+		reviewCacheHits.Add(1)
+		return allowed, nil
+	}
+	reviewCacheMisses.Add(1)
+	allowed, err := u.k8sAuth.CanI(verb, group, resource, namespace)
+	if err != nil {
+		return false, err
+	}
+	decisions.set(key, allowed)
+	return allowed, nil
 }
 
 type resourceInfo struct {
@@ -126,17 +265,16 @@ type resourceInfo struct {
 	Namespaced bool
 }
 
-func (u *UserAuth) resolve(groupVersion, kind string) (resourceInfo, error) {
-	resourceList, err := u.k8sAuth.GetResourceList(groupVersion)
+func (u *UserAuth) resolve(apiVersion, kind string) (resourceInfo, error) {
+	gv, err := schema.ParseGroupVersion(apiVersion)
 	if err != nil {
 		return resourceInfo{}, err
 	}
-	for _, r := range resourceList.APIResources {
-		if r.Kind == kind {
-			return resourceInfo{r.Name, r.Namespaced}, nil
-		}
+	mapping, err := u.mapper.RESTMapping(gv.WithKind(kind).GroupKind(), gv.Version)
+	if err != nil {
+		return resourceInfo{}, err
 	}
-	return resourceInfo{}, fmt.Errorf("Unable to find the kind %s in the resource group %s", kind, groupVersion)
+	return resourceInfo{mapping.Resource.Resource, mapping.Scope.Name() == meta.RESTScopeNameNamespace}, nil
 }
 
 func (u *UserAuth) getResourcesToCheck(namespace, manifest string) ([]resource, error) {
@@ -162,54 +300,98 @@ func (u *UserAuth) getResourcesToCheck(namespace, manifest string) ([]resource,
 	return result, nil
 }
 
-func (u *UserAuth) isAllowed(verb string, itemsToCheck []resource) ([]Action, error) {
-	rejectedActions := []Action{}
+// isAllowed returns the actions, among the verbs on the resources, which the user
+// is not allowed to do. The reviews run concurrently, up to maxConcurrentReviews
+// at a time, and the actions are returned in the order of the verbs and resources.
+func (u *UserAuth) isAllowed(verbs []string, itemsToCheck []resource) ([]Action, error) {
+	type review struct {
+		verb  string
+		item  resource
+		rInfo resourceInfo
+	}
+	resolved := []review{}
 	for _, i := range itemsToCheck {
 		rInfo, err := u.resolve(i.APIVersion, i.Kind)
 		if err != nil {
-			if k8sErrors.IsNotFound(err) {
+			if meta.IsNoMatchError(err) {
 				// The resource version/kind is not registered in the k8s API so
 				// we assume it's a CRD that is going to be created with the chart
 				// In any case, if a chart tries to install a resource that doesn't
 				// exist it's fine to ignore it here since the installation will fail
 				continue
 			}
+			// The discovery may have failed, so do not reuse the RESTMapper.
+			forgetRESTMapper(u.mapperKey)
 			return []Action{}, err
 		}
-		group := i.APIVersion
-		if group == "v1" {
-			// The group should be empty for the core API group
-			group = ""
+		resolved = append(resolved, review{item: i, rInfo: rInfo})
+	}
+	reviews := []review{}
+	for _, verb := range verbs {
+		for _, r := range resolved {
+			reviews = append(reviews, review{verb, r.item, r.rInfo})
 		}
-		allowed, err := u.k8sAuth.CanI(verb, group, rInfo.Name, i.Namespace)
-		if err != nil {
-			return []Action{}, err
+	}
+
+	allowed := make([]bool, len(reviews))
+	errs := make([]error, len(reviews))
+	semaphore := make(chan struct{}, maxConcurrentReviews)
+	var wg sync.WaitGroup
+	for index, r := range reviews {
+		wg.Add(1)
+		semaphore <- struct{}{}
+		go func(index int, r review) {
+			defer func() {
+				<-semaphore
+				wg.Done()
+			}()
+			allowed[index], errs[index] = u.canIForGroupVersion(r.verb, r.item.APIVersion, r.rInfo.Name, r.item.Namespace)
+		}(index, r)
+	}
+	wg.Wait()
+
+	rejectedActions := []Action{}
+	for index, r := range reviews {
+		if errs[index] != nil {
+			return []Action{}, errs[index]
 		}
-		// If the "group" is versioned the user may be able to have access to any
-		// version of the group but the above call may return "false"
-		if !allowed && strings.Contains(group, "/") {
-			groupID := strings.Split(group, "/")[0]
-			allowed, err = u.k8sAuth.CanI(verb, groupID, rInfo.Name, i.Namespace)
-			if err != nil {
-				return []Action{}, err
-			}
+		if allowed[index] {
+			continue
 		}
-		if !allowed {
-			rejectedAction := Action{
-				APIVersion:  i.APIVersion,
-				Resource:    rInfo.Name,
-				Verbs:       []string{verb},
-				ClusterWide: !rInfo.Namespaced,
-			}
-			if rInfo.Namespaced {
-				rejectedAction.Namespace = i.Namespace
-			}
-			rejectedActions = append(rejectedActions, rejectedAction)
+		rejectedAction := Action{
+			APIVersion:  r.item.APIVersion,
+			Resource:    r.rInfo.Name,
+			Verbs:       []string{r.verb},
+			ClusterWide: !r.rInfo.Namespaced,
 		}
+		if r.rInfo.Namespaced {
+			rejectedAction.Namespace = r.item.Namespace
+		}
+		rejectedActions = append(rejectedActions, rejectedAction)
 	}
 	return rejectedActions, nil
 }
 
+// canIForGroupVersion checks if the user can do the verb on a resource of the api version.
+func (u *UserAuth) canIForGroupVersion(verb, apiVersion, resource, namespace string) (bool, error) {
+	group := apiVersion
+	if group == "v1" {
+		// The group should be empty for the core API group
+		group = ""
+	}
+	allowed, err := u.canI(verb, group, resource, namespace)
+	if err != nil {
+		return false, err
+	}
+	// If the "group" is versioned the user may be able to have access to any
+	// version of the group but the above call may return "false"
+	if !allowed && strings.Contains(group, "/") {
+		groupID := strings.Split(group, "/")[0]
+		return u.canI(verb, groupID, resource, namespace)
+	}
+	return allowed, nil
+}
+
 func uniqVerbs(current []string, new []string) []string {
 	resMap := map[string]bool{}
 	for _, v := range current {
@@ -261,18 +443,15 @@ func (u *UserAuth) GetForbiddenActions(namespace, action, manifest string) ([]Ac
 	switch action {
 	case "upgrade":
 		// For upgrading a chart the user should be able to create, update and delete resources
-		for _, v := range []string{"create", "update", "delete"} {
-			actions, err := u.isAllowed(v, resources)
-			if err != nil {
-				return []Action{}, err
-			}
-			forbiddenActions = append(forbiddenActions, actions...)
+		forbiddenActions, err = u.isAllowed([]string{"create", "update", "delete"}, resources)
+		if err != nil {
+			return []Action{}, err
 		}
 		if len(forbiddenActions) > 0 {
 			forbiddenActions = reduceActionsByVerb(forbiddenActions)
 		}
 	default:
-		forbiddenActions, err = u.isAllowed(action, resources)
+		forbiddenActions, err = u.isAllowed([]string{action}, resources)
 		if err != nil {
 			return []Action{}, err
 		}
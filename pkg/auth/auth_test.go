@@ -19,40 +19,45 @@ package auth
 import (
 	"github.com/google/go-cmp/cmp"
 	"github.com/google/go-cmp/cmp/cmpopts"
+	"github.com/kubeapps/kubeapps/pkg/kube"
 	"reflect"
 	"strings"
+	"sync/atomic"
 	"testing"
 
-	k8sErrors "k8s.io/apimachinery/pkg/api/errors"
+	authorizationapi "k8s.io/api/authorization/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	"k8s.io/apimachinery/pkg/runtime/schema"
-	discovery "k8s.io/client-go/discovery"
+	"k8s.io/client-go/discovery/cached/memory"
 	fakediscovery "k8s.io/client-go/discovery/fake"
 	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/restmapper"
 )
 
 type fakeK8sAuth struct {
-	DiscoveryCli discovery.DiscoveryInterface
-	canIResult   bool
-	canIError    error
+	canIResult bool
+	canIError  error
+	// reviews counts the calls to CanI.
+	reviews     *int32
+	rulesReview authorizationapi.SubjectRulesReviewStatus
 }
 
 func (u fakeK8sAuth) Validate() error {
 	return nil
 }
-func (u fakeK8sAuth) GetResourceList(groupVersion string) (*metav1.APIResourceList, error) {
-	g, err := u.DiscoveryCli.ServerResourcesForGroupVersion(groupVersion)
-	if err != nil && strings.Contains(err.Error(), "not found") {
-		// Fake DiscoveryCli doesn't return a valid NotFound error so we need to forge it
-		err = k8sErrors.NewNotFound(schema.GroupResource{}, groupVersion)
-	}
-	return g, err
-}
 
 func (u fakeK8sAuth) CanI(verb, group, resource, namespace string) (bool, error) {
+	if u.reviews != nil {
+		atomic.AddInt32(u.reviews, 1)
+	}
 	return u.canIResult, u.canIError
 }
 
+func (u fakeK8sAuth) RulesReview(namespace string) (*authorizationapi.SubjectRulesReviewStatus, error) {
+	return &u.rulesReview, u.canIError
+}
+
 func newFakeUserAuth(canIResult bool, canIError error) *UserAuth {
 	resourceListV1 := metav1.APIResourceList{
 		GroupVersion: "v1",
@@ -87,8 +92,68 @@ func newFakeUserAuth(canIResult bool, canIError error) *UserAuth {
 		&resourceListExtensionsV1Beta1,
 		&resourceListClusterRoleRBAC,
 	}
-	fakeK8sAuthCli := fakeK8sAuth{cli.Discovery(), canIResult, canIError}
-	return &UserAuth{fakeK8sAuthCli}
+	fakeK8sAuthCli := fakeK8sAuth{canIResult: canIResult, canIError: canIError}
+	return &UserAuth{
+		k8sAuth: fakeK8sAuthCli,
+		mapper:  restmapper.NewDeferredDiscoveryRESTMapper(memory.NewMemCacheClient(cli.Discovery())),
+	}
+}
+
+func TestServiceAccountConfig(t *testing.T) {
+	inClusterConfig := &rest.Config{Host: "https://kubeapps-cluster", BearerToken: "kubeapps-sa-token"}
+	clustersConfig := kube.ClustersConfig{
+		KubeappsClusterName: "default",
+		Clusters: map[string]kube.ClusterConfig{
+			"default":     {Name: "default"},
+			"additional":  {Name: "additional", APIServiceURL: "https://additional", ServiceToken: "additional-sa-token"},
+			"no-sa-token": {Name: "no-sa-token", APIServiceURL: "https://no-sa-token"},
+		},
+	}
+
+	testCases := []struct {
+		name          string
+		cluster       string
+		expectedHost  string
+		expectedToken string
+	}{
+		{
+			name:          "it uses the in-cluster config on the cluster of Kubeapps",
+			cluster:       "default",
+			expectedHost:  "https://kubeapps-cluster",
+			expectedToken: "kubeapps-sa-token",
+		},
+		{
+			name:          "it uses the service token of an additional cluster",
+			cluster:       "additional",
+			expectedHost:  "https://additional",
+			expectedToken: "additional-sa-token",
+		},
+		{
+			name:    "it returns no config without service token",
+			cluster: "no-sa-token",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			config, err := serviceAccountConfig(inClusterConfig, tc.cluster, clustersConfig)
+			if err != nil {
+				t.Fatalf("%+v", err)
+			}
+			if tc.expectedHost == "" {
+				if config != nil {
+					t.Errorf("got: %+v, want: nil", config)
+				}
+				return
+			}
+			if got, want := config.Host, tc.expectedHost; got != want {
+				t.Errorf("got: %q, want: %q", got, want)
+			}
+			if got, want := config.BearerToken, tc.expectedToken; got != want {
+				t.Errorf("got: %q, want: %q", got, want)
+			}
+		})
+	}
 }
 
 func TestGetForbidden(t *testing.T) {
@@ -206,6 +271,171 @@ kind: FooBar
 	}
 }
 
+func TestGetForbiddenActionsOfManyResources(t *testing.T) {
+	const namespace = "test-namespace"
+	manifest := ""
+	expectedActions := []Action{}
+	for _, ns := range []string{"a", "b", "c", "d", "e", "f", "g", "h", "i", "j", "k", "l"} {
+		manifest += "---\napiVersion: v1\nkind: Pod\nmetadata:\n  namespace: " + ns + "\n"
+		expectedActions = append(expectedActions, Action{APIVersion: "v1", Resource: "pods", Namespace: ns, Verbs: []string{"create"}})
+	}
+	auth := newFakeUserAuth(false, nil)
+
+	res, err := auth.GetForbiddenActions(namespace, "create", manifest)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	// The actions are in the order of the manifest, whatever the order of the reviews.
+	if got, want := res, expectedActions; !cmp.Equal(want, got) {
+		t.Errorf("mismatch (-want +got):\n%s", cmp.Diff(want, got))
+	}
+}
+
+func TestCachedReviews(t *testing.T) {
+	const manifest = `---
+apiVersion: v1
+kind: Pod
+`
+	var reviews int32
+	auth := newFakeUserAuth(true, nil)
+	auth.k8sAuth = fakeK8sAuth{canIResult: true, reviews: &reviews}
+	auth.cacheKey = "default/" + t.Name()
+	hits := reviewCacheHits.Value()
+
+	for i := 0; i < 3; i++ {
+		if _, err := auth.GetForbiddenActions("default", "create", manifest); err != nil {
+			t.Fatalf("%+v", err)
+		}
+	}
+	if got, want := atomic.LoadInt32(&reviews), int32(1); got != want {
+		t.Errorf("got: %d, want: %d", got, want)
+	}
+	if got, want := reviewCacheHits.Value()-hits, int64(2); got != want {
+		t.Errorf("got: %d, want: %d", got, want)
+	}
+
+	// The decisions are not shared with other users or clusters.
+	auth.cacheKey = "other/" + t.Name()
+	if _, err := auth.GetForbiddenActions("default", "create", manifest); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if got, want := atomic.LoadInt32(&reviews), int32(2); got != want {
+		t.Errorf("got: %d, want: %d", got, want)
+	}
+}
+
+func TestValidateRulesForNamespace(t *testing.T) {
+	clusterRoleRules := []rbacv1.PolicyRule{
+		{Verbs: []string{"get", "list"}, APIGroups: []string{"kubeapps.com"}, Resources: []string{"apprepositories"}},
+		{Verbs: []string{"get"}, NonResourceURLs: []string{"/healthz"}},
+	}
+	allowAll := authorizationapi.SubjectRulesReviewStatus{
+		ResourceRules: []authorizationapi.ResourceRule{
+			{Verbs: []string{"*"}, APIGroups: []string{"*"}, Resources: []string{"*"}},
+		},
+	}
+	testCases := []struct {
+		name        string
+		rules       []rbacv1.PolicyRule
+		namespace   string
+		rulesReview authorizationapi.SubjectRulesReviewStatus
+		canIResult  bool
+		expected    bool
+		expectedErr bool
+	}{
+		{
+			name:      "it allows users with the rules of the role",
+			rules:     clusterRoleRules,
+			namespace: "default",
+			rulesReview: authorizationapi.SubjectRulesReviewStatus{
+				ResourceRules: []authorizationapi.ResourceRule{
+					{Verbs: []string{"get", "list", "watch"}, APIGroups: []string{"kubeapps.com"}, Resources: []string{"apprepositories"}},
+				},
+			},
+			expected: true,
+		},
+		{
+			name:      "it forbids users missing a verb of the role",
+			rules:     clusterRoleRules,
+			namespace: "default",
+			rulesReview: authorizationapi.SubjectRulesReviewStatus{
+				ResourceRules: []authorizationapi.ResourceRule{
+					{Verbs: []string{"get"}, APIGroups: []string{"kubeapps.com"}, Resources: []string{"apprepositories"}},
+				},
+			},
+			canIResult: true,
+			expected:   false,
+		},
+		{
+			name:      "it reviews the actions of the role if the rules review is incomplete",
+			rules:     clusterRoleRules,
+			namespace: "default",
+			rulesReview: authorizationapi.SubjectRulesReviewStatus{
+				Incomplete: true,
+			},
+			canIResult: true,
+			expected:   true,
+		},
+		{
+			name:        "it reviews the actions of the role for all namespaces",
+			rules:       clusterRoleRules,
+			namespace:   "",
+			rulesReview: allowAll,
+			canIResult:  false,
+			expected:    false,
+		},
+		{
+			name:        "it forbids users if the role has no resource rules",
+			rules:       []rbacv1.PolicyRule{{Verbs: []string{"get"}, NonResourceURLs: []string{"/healthz"}}},
+			namespace:   "default",
+			rulesReview: allowAll,
+			canIResult:  true,
+			expected:    false,
+			expectedErr: true,
+		},
+		{
+			name:        "it forbids users if the role has no rules",
+			namespace:   "default",
+			rulesReview: allowAll,
+			canIResult:  true,
+			expected:    false,
+			expectedErr: true,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			auth := newFakeUserAuth(tc.canIResult, nil)
+			auth.k8sAuth = fakeK8sAuth{canIResult: tc.canIResult, rulesReview: tc.rulesReview}
+
+			allowed, err := auth.ValidateRulesForNamespace(tc.namespace, tc.rules)
+			if got, want := err != nil, tc.expectedErr; got != want {
+				t.Fatalf("got: %t, want: %t. err: %+v", got, want, err)
+			}
+			if got, want := allowed, tc.expected; got != want {
+				t.Errorf("got: %t, want: %t", got, want)
+			}
+		})
+	}
+}
+
+func TestValidateActionsForNamespace(t *testing.T) {
+	auth := newFakeUserAuth(true, nil)
+	allowed, err := auth.ValidateActionsForNamespace("default", []rbacv1.PolicyRule{{Verbs: []string{"get"}, NonResourceURLs: []string{"/healthz"}}})
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if allowed {
+		t.Errorf("expected users not to be allowed without actions to review")
+	}
+	allowed, err = auth.ValidateActionsForNamespace("default", []rbacv1.PolicyRule{{Verbs: []string{"list"}, APIGroups: []string{"kubeapps.com"}, Resources: []string{"apprepositories"}}})
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if !allowed {
+		t.Errorf("expected users to be allowed the actions")
+	}
+}
+
 func TestParseForbiddenActions(t *testing.T) {
 	testSuite := []struct {
 		Description     string
@@ -9,7 +9,10 @@ import (
 	"github.com/kubeapps/common/response"
 	"github.com/kubeapps/kubeapps/pkg/dbutils"
 	"github.com/kubeapps/kubeapps/pkg/kube"
+	"github.com/kubeapps/kubeapps/pkg/oidc"
+	log "github.com/sirupsen/logrus"
 	"github.com/urfave/negroni"
+	rbacv1 "k8s.io/api/rbac/v1"
 )
 
 // tokenPrefix is the string preceding the token in the Authorization header.
@@ -28,6 +31,64 @@ func AuthCheckerForRequest(clustersConfig kube.ClustersConfig, req *http.Request
 	return NewAuth(token, clusterName, clustersConfig)
 }
 
+// AuthGateConfig configures the check of whether users can view the charts of a
+// namespace. Without configuration, users should be able to get the secrets of the
+// namespace. With both rules and a ClusterRole, users should be allowed both.
+type AuthGateConfig struct {
+	// Rules are the actions users should be allowed in the namespace, each checked
+	// with an access review.
+	Rules []rbacv1.PolicyRule
+	// ClusterRole is the name of a ClusterRole, possibly aggregated, whose rules
+	// should be included in the rules of the users in the namespace, checked with
+	// a rules review.
+	ClusterRole string
+	// AuditLog logs every decision of the AuthGate.
+	AuditLog bool
+
+	clusterRoleRules func(name string) ([]rbacv1.PolicyRule, error)
+}
+
+// NewAuthGateConfig returns the configuration of an AuthGate from actions in the
+// format of kubectl auth can-i, such as "list apprepositories.kubeapps.com". The
+// ClusterRole is read with the service account of Kubeapps, which should be
+// allowed to get it, on the cluster on which Kubeapps is installed.
+func NewAuthGateConfig(actions []string, clusterRole string, auditLog bool) (AuthGateConfig, error) {
+	config := AuthGateConfig{
+		ClusterRole:      clusterRole,
+		AuditLog:         auditLog,
+		clusterRoleRules: newClusterRoleCache(clusterRoleTTL).rules,
+	}
+	for _, action := range actions {
+		rule, err := ParseAccessRule(action)
+		if err != nil {
+			return AuthGateConfig{}, err
+		}
+		config.Rules = append(config.Rules, rule)
+	}
+	return config, nil
+}
+
+// validate checks if the user can view the charts of the namespace.
+func (c AuthGateConfig) validate(userAuth Checker, namespace string) (bool, error) {
+	if len(c.Rules) == 0 && c.ClusterRole == "" {
+		return userAuth.ValidateForNamespace(namespace)
+	}
+	if len(c.Rules) > 0 {
+		allowed, err := userAuth.ValidateActionsForNamespace(namespace, c.Rules)
+		if err != nil || !allowed {
+			return false, err
+		}
+	}
+	if c.ClusterRole != "" {
+		rules, err := c.clusterRoleRules(c.ClusterRole)
+		if err != nil {
+			return false, err
+		}
+		return userAuth.ValidateRulesForNamespace(namespace, rules)
+	}
+	return true, nil
+}
+
 // AuthGate implements middleware to check if the user has access to charts from
 // the specific namespace before continuing.
 //   * If the path being handled by the
@@ -35,17 +96,22 @@ func AuthCheckerForRequest(clustersConfig kube.ClustersConfig, req *http.Request
 //     is _all, then the check is for cluster-wide access.
 //   * If the namespace is the global chart namespace (ie. kubeappsNamespace) then
 //     we allow read access regardless.
-func AuthGate(clustersConfig kube.ClustersConfig, kubeappsNamespace string) negroni.HandlerFunc {
+func AuthGate(clustersConfig kube.ClustersConfig, kubeappsNamespace string, config AuthGateConfig) negroni.HandlerFunc {
+	return authGate(clustersConfig, kubeappsNamespace, config, AuthCheckerForRequest)
+}
+
+func authGate(clustersConfig kube.ClustersConfig, kubeappsNamespace string, config AuthGateConfig, checkerForRequest CheckerForRequest) negroni.HandlerFunc {
 	return func(w http.ResponseWriter, req *http.Request, next http.HandlerFunc) {
-		userAuth, err := AuthCheckerForRequest(clustersConfig, req)
-		if err != nil {
-			response.NewErrorResponse(http.StatusUnauthorized, err.Error()).Write(w)
-			return
-		}
 		namespace := mux.Vars(req)["namespace"]
 		if namespace == dbutils.AllNamespaces {
 			namespace = ""
 		}
+		userAuth, err := checkerForRequest(clustersConfig, req)
+		if err != nil {
+			config.audit(req, namespace, false, err.Error())
+			response.NewErrorResponse(http.StatusUnauthorized, err.Error()).Write(w)
+			return
+		}
 
 		// The auth-gate is used only for access to the asset-svc and the functionality should be
 		// moved to the assetsvc itself if and when the assetsvc is updated to be cluster aware.
@@ -55,7 +121,7 @@ func AuthGate(clustersConfig kube.ClustersConfig, kubeappsNamespace string) negr
 		if namespace == kubeappsNamespace {
 			authz = true
 		} else {
-			authz, err = userAuth.ValidateForNamespace(namespace)
+			authz, err = config.validate(userAuth, namespace)
 		}
 
 		if err != nil || !authz {
@@ -63,13 +129,39 @@ func AuthGate(clustersConfig kube.ClustersConfig, kubeappsNamespace string) negr
 			if err != nil {
 				msg = fmt.Sprintf("%s: %s", msg, err.Error())
 			}
+			config.audit(req, namespace, false, msg)
 			response.NewErrorResponse(http.StatusForbidden, msg).Write(w)
 			return
 		}
+		config.audit(req, namespace, true, "")
 		next(w, req)
 	}
 }
 
+// audit logs a decision of the AuthGate, if enabled, with the identity of the user
+// when it has been verified. Tokens are not logged.
+func (c AuthGateConfig) audit(req *http.Request, namespace string, allowed bool, reason string) {
+	if !c.AuditLog {
+		return
+	}
+	fields := log.Fields{
+		"cluster":    mux.Vars(req)["cluster"],
+		"namespace":  namespace,
+		"method":     req.Method,
+		"path":       req.URL.Path,
+		"remoteAddr": req.RemoteAddr,
+		"allowed":    allowed,
+	}
+	if reason != "" {
+		fields["reason"] = reason
+	}
+	if identity, ok := oidc.FromContext(req.Context()); ok {
+		fields["username"] = identity.Username
+		fields["groups"] = identity.Groups
+	}
+	log.WithFields(fields).Info("authgate decision")
+}
+
 // ExtractToken extracts the token from a correctly formatted Authorization header.
 func ExtractToken(headerValue string) string {
 	if strings.HasPrefix(headerValue, tokenPrefix) {
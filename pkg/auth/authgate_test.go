@@ -0,0 +1,169 @@
+package auth
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/kubeapps/kubeapps/pkg/kube"
+	"github.com/kubeapps/kubeapps/pkg/oidc"
+	log "github.com/sirupsen/logrus"
+	logtest "github.com/sirupsen/logrus/hooks/test"
+	rbacv1 "k8s.io/api/rbac/v1"
+)
+
+// fakeChecker records the checks of the AuthGate.
+type fakeChecker struct {
+	allowed bool
+	checks  []string
+}
+
+func (c *fakeChecker) ValidateForNamespace(namespace string) (bool, error) {
+	c.checks = append(c.checks, "secrets")
+	return c.allowed, nil
+}
+
+func (c *fakeChecker) ValidateActionsForNamespace(namespace string, rules []rbacv1.PolicyRule) (bool, error) {
+	c.checks = append(c.checks, "actions")
+	return c.allowed, nil
+}
+
+func (c *fakeChecker) ValidateRulesForNamespace(namespace string, rules []rbacv1.PolicyRule) (bool, error) {
+	c.checks = append(c.checks, "rules")
+	return c.allowed, nil
+}
+
+func (c *fakeChecker) GetForbiddenActions(namespace, action, manifest string) ([]Action, error) {
+	return nil, nil
+}
+
+func TestAuthGate(t *testing.T) {
+	clusterRoleRules := func(name string) ([]rbacv1.PolicyRule, error) {
+		if name != "kubeapps-view" {
+			return nil, fmt.Errorf("clusterroles %q not found", name)
+		}
+		return []rbacv1.PolicyRule{{Verbs: []string{"list"}, APIGroups: []string{"kubeapps.com"}, Resources: []string{"apprepositories"}}}, nil
+	}
+	listAppRepos := []rbacv1.PolicyRule{{Verbs: []string{"list"}, APIGroups: []string{"kubeapps.com"}, Resources: []string{"apprepositories"}}}
+
+	testCases := []struct {
+		name           string
+		config         AuthGateConfig
+		namespace      string
+		allowed        bool
+		expectedCode   int
+		expectedChecks []string
+	}{
+		{
+			name:           "it checks the secrets of the namespace by default",
+			namespace:      "team",
+			allowed:        true,
+			expectedCode:   http.StatusOK,
+			expectedChecks: []string{"secrets"},
+		},
+		{
+			name:           "it checks the configured actions",
+			config:         AuthGateConfig{Rules: listAppRepos},
+			namespace:      "team",
+			allowed:        true,
+			expectedCode:   http.StatusOK,
+			expectedChecks: []string{"actions"},
+		},
+		{
+			name:           "it checks the rules of the configured ClusterRole",
+			config:         AuthGateConfig{ClusterRole: "kubeapps-view", clusterRoleRules: clusterRoleRules},
+			namespace:      "team",
+			allowed:        true,
+			expectedCode:   http.StatusOK,
+			expectedChecks: []string{"rules"},
+		},
+		{
+			name:           "it checks both the actions and the ClusterRole",
+			config:         AuthGateConfig{Rules: listAppRepos, ClusterRole: "kubeapps-view", clusterRoleRules: clusterRoleRules},
+			namespace:      "team",
+			allowed:        true,
+			expectedCode:   http.StatusOK,
+			expectedChecks: []string{"actions", "rules"},
+		},
+		{
+			name:           "it forbids users without the configured actions",
+			config:         AuthGateConfig{Rules: listAppRepos, ClusterRole: "kubeapps-view", clusterRoleRules: clusterRoleRules},
+			namespace:      "team",
+			expectedCode:   http.StatusForbidden,
+			expectedChecks: []string{"actions"},
+		},
+		{
+			name:           "it forbids users if the ClusterRole cannot be read",
+			config:         AuthGateConfig{ClusterRole: "other", clusterRoleRules: clusterRoleRules},
+			namespace:      "team",
+			allowed:        true,
+			expectedCode:   http.StatusForbidden,
+			expectedChecks: nil,
+		},
+		{
+			name:           "it allows the kubeapps namespace without check",
+			config:         AuthGateConfig{Rules: listAppRepos},
+			namespace:      "kubeapps",
+			expectedCode:   http.StatusOK,
+			expectedChecks: nil,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			checker := &fakeChecker{allowed: tc.allowed}
+			checkerForRequest := func(kube.ClustersConfig, *http.Request) (Checker, error) { return checker, nil }
+			gate := authGate(kube.ClustersConfig{}, "kubeapps", tc.config, checkerForRequest)
+
+			req := httptest.NewRequest("GET", "https://foo.bar/assetsvc/v1/clusters/default/namespaces/"+tc.namespace+"/charts", nil)
+			req = mux.SetURLVars(req, map[string]string{"cluster": "default", "namespace": tc.namespace})
+			response := httptest.NewRecorder()
+			gate(response, req, func(w http.ResponseWriter, req *http.Request) {})
+
+			if got, want := response.Code, tc.expectedCode; got != want {
+				t.Errorf("got: %d, want: %d. Body: %s", got, want, response.Body)
+			}
+			if got, want := len(checker.checks), len(tc.expectedChecks); got != want {
+				t.Fatalf("got: %v, want: %v", checker.checks, tc.expectedChecks)
+			}
+			for i := range tc.expectedChecks {
+				if got, want := checker.checks[i], tc.expectedChecks[i]; got != want {
+					t.Errorf("got: %q, want: %q", got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestAuthGateAuditLog(t *testing.T) {
+	hook := logtest.NewGlobal()
+	defer hook.Reset()
+
+	checkerForRequest := func(kube.ClustersConfig, *http.Request) (Checker, error) { return &fakeChecker{}, nil }
+	gate := authGate(kube.ClustersConfig{}, "kubeapps", AuthGateConfig{AuditLog: true}, checkerForRequest)
+	req := httptest.NewRequest("GET", "https://foo.bar/assetsvc/v1/clusters/default/namespaces/team/charts", nil)
+	req.Header.Set("Authorization", "Bearer secret-token")
+	req = mux.SetURLVars(req, map[string]string{"cluster": "default", "namespace": "team"})
+	req = req.WithContext(oidc.NewContext(req.Context(), oidc.Identity{Username: "jane@example.com", Groups: []string{"developers"}}))
+	gate(httptest.NewRecorder(), req, func(w http.ResponseWriter, req *http.Request) {})
+
+	entry := hook.LastEntry()
+	if entry == nil {
+		t.Fatalf("expected an audit log entry")
+	}
+	if got, want := entry.Level, log.InfoLevel; got != want {
+		t.Errorf("got: %v, want: %v", got, want)
+	}
+	for key, want := range map[string]interface{}{"cluster": "default", "namespace": "team", "allowed": false, "username": "jane@example.com"} {
+		if got := entry.Data[key]; got != want {
+			t.Errorf("got: %v, want: %v for %q", got, want, key)
+		}
+	}
+	for _, value := range entry.Data {
+		if value == "secret-token" {
+			t.Errorf("the token should not be logged")
+		}
+	}
+}
@@ -0,0 +1,186 @@
+package auth
+
+import (
+	"context"
+	"expvar"
+	"sync"
+	"time"
+
+	rbacv1 "k8s.io/api/rbac/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/discovery/cached/memory"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/restmapper"
+)
+
+const (
+	// maxConcurrentReviews is the maximum number of SelfSubjectAccessReviews
+	// created at the same time when checking a manifest.
+	maxConcurrentReviews = 10
+	// reviewCacheTTL is how long the decision of a review is reused. It is short so
+	// that changes of the RBAC rules of users are taken into account quickly.
+	reviewCacheTTL = 30 * time.Second
+	// restMapperTTL is how long the resources discovered for a cluster are reused,
+	// so that the resources of CRDs installed since then are found.
+	restMapperTTL = 5 * time.Minute
+	// clusterRoleTTL is how long the rules of the ClusterRole of the AuthGate are reused.
+	clusterRoleTTL = time.Minute
+)
+
+var (
+	// The metrics of the review cache, published with expvar.
+	reviewCacheHits   = expvar.NewInt("auth_review_cache_hits")
+	reviewCacheMisses = expvar.NewInt("auth_review_cache_misses")
+
+	decisions = newReviewCache(reviewCacheTTL)
+
+	restMappersMutex sync.Mutex
+	restMappers      = map[string]restMapperEntry{}
+)
+
+// reviewKey identifies a review of a user, by token and cluster.
+type reviewKey struct {
+	user      string
+	verb      string
+	group     string
+	resource  string
+	namespace string
+}
+
+type reviewDecision struct {
+	allowed bool
+	expiry  time.Time
+}
+
+// reviewCache caches the decisions of the reviews for a TTL. The expired decisions
+// are removed at most once per TTL.
+type reviewCache struct {
+	mutex     sync.Mutex
+	ttl       time.Duration
+	decisions map[reviewKey]reviewDecision
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+func newReviewCache(ttl time.Duration) *reviewCache {
+	return &reviewCache{
+		ttl:       ttl,
+		decisions: map[reviewKey]reviewDecision{},
+		now:       time.Now,
+	}
+}
+
+func (c *reviewCache) get(key reviewKey) (bool, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	decision, ok := c.decisions[key]
+	if !ok || c.now().After(decision.expiry) {
+		return false, false
+	}
+	return decision.allowed, true
+}
+
+func (c *reviewCache) set(key reviewKey, allowed bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	now := c.now()
+	if now.Sub(c.lastSweep) > c.ttl {
+		for k, decision := range c.decisions {
+			if now.After(decision.expiry) {
+				delete(c.decisions, k)
+			}
+		}
+		c.lastSweep = now
+	}
+	c.decisions[key] = reviewDecision{allowed: allowed, expiry: now.Add(c.ttl)}
+}
+
+type restMapperEntry struct {
+	mapper meta.RESTMapper
+	expiry time.Time
+}
+
+// restMapperFor returns the cached RESTMapper of a key, such as a cluster, created
+// with a discovery client if there is none or it expired. The discovered resources
+// are cached in memory, so that kinds are not resolved with a request each.
+func restMapperFor(key string, newDiscovery func() (discovery.DiscoveryInterface, error)) (meta.RESTMapper, error) {
+	restMappersMutex.Lock()
+	defer restMappersMutex.Unlock()
+	now := time.Now()
+	if entry, ok := restMappers[key]; ok && now.Before(entry.expiry) {
+		return entry.mapper, nil
+	}
+	discoveryCli, err := newDiscovery()
+	if err != nil {
+		return nil, err
+	}
+	// The expired RESTMappers, such as the ones of users, are removed at the same time.
+	for k, entry := range restMappers {
+		if !now.Before(entry.expiry) {
+			delete(restMappers, k)
+		}
+	}
+	mapper := restmapper.NewDeferredDiscoveryRESTMapper(memory.NewMemCacheClient(discoveryCli))
+	restMappers[key] = restMapperEntry{mapper: mapper, expiry: now.Add(restMapperTTL)}
+	return mapper, nil
+}
+
+// forgetRESTMapper removes the RESTMapper of a key.
+func forgetRESTMapper(key string) {
+	restMappersMutex.Lock()
+	defer restMappersMutex.Unlock()
+	delete(restMappers, key)
+}
+
+// clusterRoleCache caches the rules of ClusterRoles, read with the service account
+// of Kubeapps, for a TTL.
+type clusterRoleCache struct {
+	mutex   sync.Mutex
+	ttl     time.Duration
+	entries map[string]clusterRoleEntry
+	get     func(name string) (*rbacv1.ClusterRole, error)
+}
+
+type clusterRoleEntry struct {
+	rules  []rbacv1.PolicyRule
+	expiry time.Time
+}
+
+func newClusterRoleCache(ttl time.Duration) *clusterRoleCache {
+	return &clusterRoleCache{
+		ttl:     ttl,
+		entries: map[string]clusterRoleEntry{},
+		get:     getClusterRole,
+	}
+}
+
+// rules returns the rules of a ClusterRole. The rules of aggregated ClusterRoles
+// are those aggregated by the controller manager.
+func (c *clusterRoleCache) rules(name string) ([]rbacv1.PolicyRule, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if entry, ok := c.entries[name]; ok && time.Now().Before(entry.expiry) {
+		return entry.rules, nil
+	}
+	clusterRole, err := c.get(name)
+	if err != nil {
+		return nil, err
+	}
+	c.entries[name] = clusterRoleEntry{rules: clusterRole.Rules, expiry: time.Now().Add(c.ttl)}
+	return clusterRole.Rules, nil
+}
+
+func getClusterRole(name string) (*rbacv1.ClusterRole, error) {
+	config, err := rest.InClusterConfig()
+	if err != nil {
+		return nil, err
+	}
+	clientset, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	return clientset.RbacV1().ClusterRoles().Get(context.TODO(), name, metav1.GetOptions{})
+}
@@ -0,0 +1,65 @@
+package auth
+
+import (
+	"testing"
+	"time"
+
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestReviewCache(t *testing.T) {
+	now := time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC)
+	cache := newReviewCache(30 * time.Second)
+	cache.now = func() time.Time { return now }
+	key := reviewKey{user: "default/token", verb: "get", resource: "secrets", namespace: "default"}
+
+	if _, ok := cache.get(key); ok {
+		t.Fatalf("expected no decision for %+v", key)
+	}
+
+	cache.set(key, true)
+	allowed, ok := cache.get(key)
+	if !ok || !allowed {
+		t.Errorf("got: %t, %t, want: true, true", allowed, ok)
+	}
+
+	now = now.Add(31 * time.Second)
+	if _, ok := cache.get(key); ok {
+		t.Errorf("expected the decision to expire")
+	}
+
+	// The expired decisions are removed when setting others.
+	cache.set(reviewKey{user: "default/token", verb: "list", resource: "pods"}, false)
+	if got, want := len(cache.decisions), 1; got != want {
+		t.Errorf("got: %d, want: %d", got, want)
+	}
+}
+
+func TestRESTMapperFor(t *testing.T) {
+	discoveries := 0
+	newDiscovery := func() (discovery.DiscoveryInterface, error) {
+		discoveries++
+		return fake.NewSimpleClientset().Discovery(), nil
+	}
+	defer forgetRESTMapper("default")
+	defer forgetRESTMapper("default/user")
+
+	first, err := restMapperFor("default", newDiscovery)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	second, err := restMapperFor("default", newDiscovery)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if first != second || discoveries != 1 {
+		t.Errorf("expected the RESTMapper of the key to be reused, got %d discoveries", discoveries)
+	}
+	if _, err := restMapperFor("default/user", newDiscovery); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if discoveries != 2 {
+		t.Errorf("expected a RESTMapper for each key, got %d discoveries", discoveries)
+	}
+}
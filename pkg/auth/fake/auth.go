@@ -18,6 +18,7 @@ package fake
 
 import (
 	authUtils "github.com/kubeapps/kubeapps/pkg/auth"
+	rbacv1 "k8s.io/api/rbac/v1"
 )
 
 type FakeAuth struct {
@@ -32,6 +33,14 @@ func (f *FakeAuth) ValidateForNamespace(namespace string) (bool, error) {
 	return true, nil
 }
 
+func (f *FakeAuth) ValidateActionsForNamespace(namespace string, rules []rbacv1.PolicyRule) (bool, error) {
+	return true, nil
+}
+
+func (f *FakeAuth) ValidateRulesForNamespace(namespace string, rules []rbacv1.PolicyRule) (bool, error) {
+	return true, nil
+}
+
 func (f *FakeAuth) GetForbiddenActions(namespace, action, manifest string) ([]authUtils.Action, error) {
 	return f.ForbiddenActions, nil
 }
@@ -0,0 +1,82 @@
+package auth
+
+import (
+	"fmt"
+	"strings"
+
+	authorizationapi "k8s.io/api/authorization/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+)
+
+// ParseAccessRule parses an action in the format of kubectl auth can-i, such as
+// "list apprepositories.kubeapps.com" or "get secrets", into a rule.
+func ParseAccessRule(action string) (rbacv1.PolicyRule, error) {
+	fields := strings.Fields(action)
+	if len(fields) != 2 {
+		return rbacv1.PolicyRule{}, fmt.Errorf("Invalid action %q, it should be a verb and a resource, such as \"list apprepositories.kubeapps.com\"", action)
+	}
+	resource, group := fields[1], ""
+	if i := strings.Index(resource, "."); i != -1 {
+		resource, group = resource[:i], resource[i+1:]
+	}
+	return rbacv1.PolicyRule{
+		Verbs:     []string{fields[0]},
+		APIGroups: []string{group},
+		Resources: []string{resource},
+	}, nil
+}
+
+// RulesAllow returns whether the rules allow every verb of the required rules on
+// their resources. Rules restricted to some resource names only allow required
+// rules restricted to those names. Nothing is allowed if there is no required rule.
+func RulesAllow(rules []authorizationapi.ResourceRule, required []authorizationapi.ResourceRule) bool {
+	if len(required) == 0 {
+		return false
+	}
+	for _, r := range required {
+		for _, group := range r.APIGroups {
+			for _, resource := range r.Resources {
+				for _, verb := range r.Verbs {
+					if !ruleAllows(rules, verb, group, resource, r.ResourceNames) {
+						return false
+					}
+				}
+			}
+		}
+	}
+	return true
+}
+
+func ruleAllows(rules []authorizationapi.ResourceRule, verb, group, resource string, resourceNames []string) bool {
+	for _, rule := range rules {
+		if !matches(rule.Verbs, verb) || !matches(rule.APIGroups, group) || !matches(rule.Resources, resource) {
+			continue
+		}
+		if len(rule.ResourceNames) == 0 {
+			return true
+		}
+		if len(resourceNames) > 0 && includesAll(rule.ResourceNames, resourceNames) {
+			return true
+		}
+	}
+	return false
+}
+
+// matches returns whether the values of a rule include the value or the wildcard.
+func matches(values []string, value string) bool {
+	for _, v := range values {
+		if v == value || v == authorizationapi.ResourceAll {
+			return true
+		}
+	}
+	return false
+}
+
+func includesAll(values []string, required []string) bool {
+	for _, r := range required {
+		if !matches(values, r) {
+			return false
+		}
+	}
+	return true
+}
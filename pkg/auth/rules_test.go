@@ -0,0 +1,105 @@
+package auth
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	authorizationapi "k8s.io/api/authorization/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+)
+
+func TestParseAccessRule(t *testing.T) {
+	testCases := []struct {
+		name      string
+		action    string
+		expected  rbacv1.PolicyRule
+		expectErr bool
+	}{
+		{
+			name:     "it parses a resource of an api group",
+			action:   "list apprepositories.kubeapps.com",
+			expected: rbacv1.PolicyRule{Verbs: []string{"list"}, APIGroups: []string{"kubeapps.com"}, Resources: []string{"apprepositories"}},
+		},
+		{
+			name:     "it parses a resource of the core api group",
+			action:   "get  secrets",
+			expected: rbacv1.PolicyRule{Verbs: []string{"get"}, APIGroups: []string{""}, Resources: []string{"secrets"}},
+		},
+		{
+			name:      "it errors without a verb",
+			action:    "secrets",
+			expectErr: true,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			rule, err := ParseAccessRule(tc.action)
+			if got, want := err != nil, tc.expectErr; got != want {
+				t.Fatalf("got: %t, want: %t. err: %+v", got, want, err)
+			}
+			if got, want := rule, tc.expected; !cmp.Equal(want, got) {
+				t.Errorf("mismatch (-want +got):\n%s", cmp.Diff(want, got))
+			}
+		})
+	}
+}
+
+func TestRulesAllow(t *testing.T) {
+	secretsRule := authorizationapi.ResourceRule{Verbs: []string{"get", "list"}, APIGroups: []string{""}, Resources: []string{"secrets"}}
+	testCases := []struct {
+		name     string
+		rules    []authorizationapi.ResourceRule
+		required []authorizationapi.ResourceRule
+		expected bool
+	}{
+		{
+			name:     "it allows the required verbs",
+			rules:    []authorizationapi.ResourceRule{{Verbs: []string{"get", "list", "watch"}, APIGroups: []string{""}, Resources: []string{"secrets"}}},
+			required: []authorizationapi.ResourceRule{secretsRule},
+			expected: true,
+		},
+		{
+			name:     "it allows the required verbs with wildcards",
+			rules:    []authorizationapi.ResourceRule{{Verbs: []string{"*"}, APIGroups: []string{"*"}, Resources: []string{"*"}}},
+			required: []authorizationapi.ResourceRule{secretsRule},
+			expected: true,
+		},
+		{
+			name:     "it forbids a missing verb",
+			rules:    []authorizationapi.ResourceRule{{Verbs: []string{"get"}, APIGroups: []string{""}, Resources: []string{"secrets"}}},
+			required: []authorizationapi.ResourceRule{secretsRule},
+			expected: false,
+		},
+		{
+			name:     "it forbids the resources of other groups",
+			rules:    []authorizationapi.ResourceRule{{Verbs: []string{"get", "list"}, APIGroups: []string{"example.com"}, Resources: []string{"secrets"}}},
+			required: []authorizationapi.ResourceRule{secretsRule},
+			expected: false,
+		},
+		{
+			name:     "it forbids any resource with rules restricted to resource names",
+			rules:    []authorizationapi.ResourceRule{{Verbs: []string{"get", "list"}, APIGroups: []string{""}, Resources: []string{"secrets"}, ResourceNames: []string{"foo"}}},
+			required: []authorizationapi.ResourceRule{secretsRule},
+			expected: false,
+		},
+		{
+			name:     "it allows the required resource names",
+			rules:    []authorizationapi.ResourceRule{{Verbs: []string{"get", "list"}, APIGroups: []string{""}, Resources: []string{"secrets"}, ResourceNames: []string{"foo", "bar"}}},
+			required: []authorizationapi.ResourceRule{{Verbs: []string{"get"}, APIGroups: []string{""}, Resources: []string{"secrets"}, ResourceNames: []string{"foo"}}},
+			expected: true,
+		},
+		{
+			name:     "it forbids everything without required rules",
+			rules:    []authorizationapi.ResourceRule{{Verbs: []string{"*"}, APIGroups: []string{"*"}, Resources: []string{"*"}}},
+			required: []authorizationapi.ResourceRule{},
+			expected: false,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got, want := RulesAllow(tc.rules, tc.required), tc.expected; got != want {
+				t.Errorf("got: %t, want: %t", got, want)
+			}
+		})
+	}
+}
@@ -73,6 +73,86 @@ type Details struct {
 	Version string `json:"version"`
 	// Values is a string containing (unparsed) YAML values.
 	Values string `json:"values,omitempty"`
+	// ReleaseOptions are the Helm options used to install or upgrade the release.
+	ReleaseOptions
+}
+
+// ReleaseOptions contains the Helm options of an install or upgrade, with the
+// same semantics as the flags of the Helm CLI.
+type ReleaseOptions struct {
+	// Wait waits until the resources of the release are ready.
+	Wait bool `json:"wait,omitempty"`
+	// WaitForJobs waits until the jobs of the release are completed. It requires Wait or Atomic.
+	WaitForJobs bool `json:"waitForJobs,omitempty"`
+	// Atomic removes (on install) or rolls back (on upgrade) the release if it fails. It implies Wait.
+	// When not set, a failed install is removed without waiting for the resources to be ready.
+	Atomic *bool `json:"atomic,omitempty"`
+	// Timeout is the number of seconds to wait for Kubernetes operations, such as hooks.
+	Timeout int64 `json:"timeout,omitempty"`
+	// SkipCRDs skips the installation of the CRDs of the chart.
+	SkipCRDs bool `json:"skipCRDs,omitempty"`
+	// DisableHooks prevents the hooks of the chart from running.
+	DisableHooks bool `json:"disableHooks,omitempty"`
+	// ResetValues resets the values to the ones of the chart on upgrade.
+	ResetValues bool `json:"resetValues,omitempty"`
+	// ReuseValues merges the values of the last release with the given ones on upgrade.
+	ReuseValues bool `json:"reuseValues,omitempty"`
+	// Force replaces the resources of the release on upgrade.
+	Force bool `json:"force,omitempty"`
+	// CreateNamespace creates the namespace of the release on install if it does not exist.
+	CreateNamespace bool `json:"createNamespace,omitempty"`
+	// Description is a custom description of the release.
+	Description string `json:"description,omitempty"`
+}
+
+// IsAtomic returns whether the atomic option is set.
+func (o ReleaseOptions) IsAtomic() bool {
+	return o.Atomic != nil && *o.Atomic
+}
+
+func (o ReleaseOptions) validate() error {
+	if o.Timeout < 0 {
+		return fmt.Errorf("Invalid timeout %d, it must be a positive number of seconds", o.Timeout)
+	}
+	if o.WaitForJobs && !o.Wait && !o.IsAtomic() {
+		return fmt.Errorf("The waitForJobs option requires the wait or atomic options")
+	}
+	return nil
+}
+
+// ValidateForInstall returns an error if the options cannot be used to install a release.
+func (o ReleaseOptions) ValidateForInstall() error {
+	if err := o.validate(); err != nil {
+		return err
+	}
+	upgradeOptions := []struct {
+		name  string
+		isSet bool
+	}{
+		{"resetValues", o.ResetValues},
+		{"reuseValues", o.ReuseValues},
+		{"force", o.Force},
+	}
+	for _, option := range upgradeOptions {
+		if option.isSet {
+			return fmt.Errorf("The %s option can only be used to upgrade a release", option.name)
+		}
+	}
+	return nil
+}
+
+// ValidateForUpgrade returns an error if the options cannot be used to upgrade a release.
+func (o ReleaseOptions) ValidateForUpgrade() error {
+	if err := o.validate(); err != nil {
+		return err
+	}
+	if o.ResetValues && o.ReuseValues {
+		return fmt.Errorf("The resetValues and reuseValues options cannot be used together")
+	}
+	if o.CreateNamespace {
+		return fmt.Errorf("The createNamespace option can only be used to install a release")
+	}
+	return nil
 }
 
 // LoadHelmChart returns a helm3 Chart struct from an IOReader
@@ -247,21 +327,39 @@ func fetchChart(netClient *kube.HTTPClient, chartURL string) (*helm3chart.Chart,
 
 // ParseDetails return Chart details
 func ParseDetails(data []byte) (*Details, error) {
+	details, err := ParseDetailsWithoutAppRepository(data)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := details.ValidateAppRepository(); err != nil {
+		return nil, err
+	}
+
+	return details, nil
+}
+
+// ParseDetailsWithoutAppRepository return Chart details which may not include the app repository
+func ParseDetailsWithoutAppRepository(data []byte) (*Details, error) {
 	details := &Details{}
 	err := json.Unmarshal(data, details)
 	if err != nil {
 		return nil, fmt.Errorf("Unable to parse request body: %v", err)
 	}
+	return details, nil
+}
 
-	if details.AppRepositoryResourceName == "" {
-		return nil, fmt.Errorf("an AppRepositoryResourceName is required")
+// ValidateAppRepository checks that the details include the app repository of the chart.
+func (d *Details) ValidateAppRepository() error {
+	if d.AppRepositoryResourceName == "" {
+		return fmt.Errorf("an AppRepositoryResourceName is required")
 	}
 
-	if details.AppRepositoryResourceNamespace == "" {
-		return nil, fmt.Errorf("an AppRepositoryResourceNamespace is required")
+	if d.AppRepositoryResourceNamespace == "" {
+		return fmt.Errorf("an AppRepositoryResourceNamespace is required")
 	}
 
-	return details, nil
+	return nil
 }
 
 // GetAppRepoAndRelatedSecrets retrieves the given repo from its namespace
@@ -135,6 +135,35 @@ func TestParseDetails(t *testing.T) {
 				Values:                         "foo: bar",
 			},
 		},
+		{
+			name: "parses the release options",
+			data: `{
+				"appRepositoryResourceName": "my-chart-repo",
+				"appRepositoryResourceNamespace": "my-repo-namespace",
+				"chartName": "test",
+				"releaseName": "foo",
+				"version": "1.0.0",
+				"wait": true,
+				"atomic": false,
+				"timeout": 600,
+				"skipCRDs": true,
+				"description": "Installed by CI"
+			}`,
+			expected: &Details{
+				AppRepositoryResourceName:      "my-chart-repo",
+				AppRepositoryResourceNamespace: "my-repo-namespace",
+				ChartName:                      "test",
+				ReleaseName:                    "foo",
+				Version:                        "1.0.0",
+				ReleaseOptions: ReleaseOptions{
+					Wait:        true,
+					Atomic:      new(bool),
+					Timeout:     600,
+					SkipCRDs:    true,
+					Description: "Installed by CI",
+				},
+			},
+		},
 		{
 			name: "errors if appRepositoryResourceName is not present",
 			data: `{
@@ -194,6 +223,70 @@ func TestParseDetails(t *testing.T) {
 	}
 }
 
+func TestValidateReleaseOptions(t *testing.T) {
+	atomic := true
+	testCases := []struct {
+		name       string
+		options    ReleaseOptions
+		installErr string
+		upgradeErr string
+	}{
+		{
+			name:    "accepts the default options",
+			options: ReleaseOptions{},
+		},
+		{
+			name:    "accepts waiting for jobs when atomic",
+			options: ReleaseOptions{Atomic: &atomic, WaitForJobs: true, Timeout: 60},
+		},
+		{
+			name:       "errors with a negative timeout",
+			options:    ReleaseOptions{Timeout: -1},
+			installErr: "Invalid timeout -1, it must be a positive number of seconds",
+			upgradeErr: "Invalid timeout -1, it must be a positive number of seconds",
+		},
+		{
+			name:       "errors when waiting for jobs without waiting",
+			options:    ReleaseOptions{WaitForJobs: true},
+			installErr: "The waitForJobs option requires the wait or atomic options",
+			upgradeErr: "The waitForJobs option requires the wait or atomic options",
+		},
+		{
+			name:       "errors with upgrade options on install",
+			options:    ReleaseOptions{ReuseValues: true, Force: true},
+			installErr: "The reuseValues option can only be used to upgrade a release",
+		},
+		{
+			name:       "errors when resetting and reusing values",
+			options:    ReleaseOptions{ResetValues: true, ReuseValues: true},
+			installErr: "The resetValues option can only be used to upgrade a release",
+			upgradeErr: "The resetValues and reuseValues options cannot be used together",
+		},
+		{
+			name:       "errors when creating the namespace on upgrade",
+			options:    ReleaseOptions{CreateNamespace: true},
+			upgradeErr: "The createNamespace option can only be used to install a release",
+		},
+	}
+
+	errString := func(err error) string {
+		if err == nil {
+			return ""
+		}
+		return err.Error()
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got, want := errString(tc.options.ValidateForInstall()), tc.installErr; got != want {
+				t.Errorf("got: %q, want: %q", got, want)
+			}
+			if got, want := errString(tc.options.ValidateForUpgrade()), tc.upgradeErr; got != want {
+				t.Errorf("got: %q, want: %q", got, want)
+			}
+		})
+	}
+}
+
 // fakeLoadChartV2 implements LoadChartV2 interface.
 func fakeLoadChartV2(in io.Reader) (*chartv2.Chart, error) {
 	return &chartv2.Chart{}, nil
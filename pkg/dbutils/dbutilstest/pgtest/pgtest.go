@@ -38,6 +38,11 @@ func SkipIfNoDB(t *testing.T) {
 	}
 }
 
+// ConnectionString returns the connection string of the local postgres db used by the tests.
+func ConnectionString() string {
+	return "host=localhost port=5432 user=postgres dbname=testdb sslmode=disable"
+}
+
 func openTestManager(t *testing.T) *dbutils.PostgresAssetManager {
 	pam, err := dbutils.NewPGManager(datastore.Config{
 		URL:      "localhost:5432",
@@ -83,6 +83,17 @@ func ParseRequest(req *http.Request) (*chartUtils.Details, error) {
 	return chartDetails, nil
 }
 
+// ParseRequestWithoutAppRepository extract chart info, which may not include the
+// app repository, from the request
+func ParseRequestWithoutAppRepository(req *http.Request) (*chartUtils.Details, error) {
+	defer req.Body.Close()
+	body, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		return nil, err
+	}
+	return chartUtils.ParseDetailsWithoutAppRepository(body)
+}
+
 // ResolverFactory interface to return a resolver
 type ResolverFactory interface {
 	New(repoType, userAgent string) chartUtils.Resolver
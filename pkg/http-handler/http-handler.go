@@ -27,6 +27,7 @@ import (
 	"github.com/kubeapps/kubeapps/pkg/auth"
 	"github.com/kubeapps/kubeapps/pkg/kube"
 	log "github.com/sirupsen/logrus"
+	authorizationapi "k8s.io/api/authorization/v1"
 	corev1 "k8s.io/api/core/v1"
 	k8sErrors "k8s.io/apimachinery/pkg/api/errors"
 )
@@ -50,6 +51,53 @@ type allowedResponse struct {
 	Allowed bool `json:"allowed"`
 }
 
+// permissionsResponse is used to marshal the JSON response
+type permissionsResponse struct {
+	Rules        authorizationapi.SubjectRulesReviewStatus `json:"rules"`
+	Capabilities map[string]bool                           `json:"capabilities"`
+}
+
+// capability is an action of the dashboard, along with the rules required to do it.
+type capability struct {
+	rules []authorizationapi.ResourceRule
+	// clusterWide is set for the actions on cluster-scoped resources, which are
+	// reviewed outside of the namespace.
+	clusterWide bool
+}
+
+// capabilitiesForDriver returns the actions of the dashboard. The rules required to
+// install releases depend on the Helm driver storing them.
+func capabilitiesForDriver(helmDriver string) map[string]capability {
+	// The resources of the charts are unknown, so the most common ones are required.
+	installRules := []authorizationapi.ResourceRule{
+		{APIGroups: []string{"apps"}, Resources: []string{"deployments"}, Verbs: []string{"create"}},
+		{APIGroups: []string{""}, Resources: []string{"services"}, Verbs: []string{"create"}},
+	}
+	releaseVerbs := []string{"get", "list", "create", "update", "delete"}
+	switch helmDriver {
+	case "configmap", "configmaps":
+		installRules = append(installRules, authorizationapi.ResourceRule{APIGroups: []string{""}, Resources: []string{"configmaps"}, Verbs: releaseVerbs})
+	case "memory":
+		// The releases are not stored in the cluster.
+	default:
+		// The secrets driver stores the releases as secrets in the namespace of the
+		// release, and the SQL driver authorizes the access to them as if it did.
+		installRules = append(installRules, authorizationapi.ResourceRule{APIGroups: []string{""}, Resources: []string{"secrets"}, Verbs: releaseVerbs})
+	}
+	return map[string]capability{
+		"installReleases": {rules: installRules},
+		"manageAppRepositories": {rules: []authorizationapi.ResourceRule{
+			{APIGroups: []string{"kubeapps.com"}, Resources: []string{"apprepositories"}, Verbs: []string{"get", "list", "create", "update", "delete"}},
+		}},
+		"readSecrets": {rules: []authorizationapi.ResourceRule{
+			{APIGroups: []string{""}, Resources: []string{"secrets"}, Verbs: []string{"get", "list"}},
+		}},
+		"createNamespaces": {rules: []authorizationapi.ResourceRule{
+			{APIGroups: []string{""}, Resources: []string{"namespaces"}, Verbs: []string{"create"}},
+		}, clusterWide: true},
+	}
+}
+
 // JSONError returns an error code and a JSON response
 func JSONError(w http.ResponseWriter, err interface{}, code int) {
 	w.Header().Set("Content-Type", "application/json; charset=utf-8")
@@ -331,14 +379,94 @@ func CanI(kubeHandler kube.AuthHandler) func(w http.ResponseWriter, req *http.Re
 	}
 }
 
+// GetPermissions returns the rules of the user in the namespace, along with the
+// capabilities that they grant, so that the dashboard can check them at once.
+// The capabilities are reviewed one action at a time if the rules are incomplete,
+// as well as for cluster-scoped resources.
+func GetPermissions(kubeHandler kube.AuthHandler, helmDriver string) func(w http.ResponseWriter, req *http.Request) {
+	capabilities := capabilitiesForDriver(helmDriver)
+	return func(w http.ResponseWriter, req *http.Request) {
+		token := auth.ExtractToken(req.Header.Get("Authorization"))
+		requestNamespace, requestCluster := getNamespaceAndCluster(req)
+
+		clientset, err := kubeHandler.AsUser(token, requestCluster)
+		if err != nil {
+			returnK8sError(err, w)
+			return
+		}
+
+		rules, err := clientset.GetRulesReview(requestNamespace)
+		if err != nil {
+			returnK8sError(err, w)
+			return
+		}
+
+		response := permissionsResponse{
+			Rules:        *rules,
+			Capabilities: map[string]bool{},
+		}
+		for name, c := range capabilities {
+			var allowed bool
+			switch {
+			case c.clusterWide:
+				allowed, err = canIRules(clientset, "", c.rules)
+			case rules.Incomplete:
+				allowed, err = canIRules(clientset, requestNamespace, c.rules)
+			default:
+				allowed = auth.RulesAllow(rules.ResourceRules, c.rules)
+			}
+			if err != nil {
+				returnK8sError(err, w)
+				return
+			}
+			response.Capabilities[name] = allowed
+		}
+		responseBody, err := json.Marshal(response)
+		if err != nil {
+			JSONError(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Write(responseBody)
+	}
+}
+
+// accessReviewer reviews whether an action is allowed to the user.
+type accessReviewer interface {
+	CanI(resourceAttributes *authorizationapi.ResourceAttributes) (bool, error)
+}
+
+// canIRules reviews every action of the rules in the namespace, which is empty for
+// cluster-scoped resources. It returns false as soon as an action is not allowed.
+func canIRules(clientset accessReviewer, namespace string, rules []authorizationapi.ResourceRule) (bool, error) {
+	for _, rule := range rules {
+		for _, group := range rule.APIGroups {
+			for _, resource := range rule.Resources {
+				for _, verb := range rule.Verbs {
+					allowed, err := clientset.CanI(&authorizationapi.ResourceAttributes{
+						Namespace: namespace,
+						Group:     group,
+						Resource:  resource,
+						Verb:      verb,
+					})
+					if err != nil || !allowed {
+						return false, err
+					}
+				}
+			}
+		}
+	}
+	return len(rules) > 0, nil
+}
+
 // SetupDefaultRoutes enables call-sites to use the backend api's default routes with minimal setup.
-func SetupDefaultRoutes(r *mux.Router, clustersConfig kube.ClustersConfig) error {
+func SetupDefaultRoutes(r *mux.Router, clustersConfig kube.ClustersConfig, helmDriver string) error {
 	backendHandler, err := kube.NewHandler(os.Getenv("POD_NAMESPACE"), clustersConfig)
 	if err != nil {
 		return err
 	}
 	r.Methods("POST").Path("/clusters/{cluster}/can-i").Handler(http.HandlerFunc(CanI(backendHandler)))
 	r.Methods("GET").Path("/clusters/{cluster}/namespaces").Handler(http.HandlerFunc(GetNamespaces(backendHandler)))
+	r.Methods("GET").Path("/clusters/{cluster}/namespaces/{namespace}/permissions").Handler(http.HandlerFunc(GetPermissions(backendHandler, helmDriver)))
 	r.Methods("GET").Path("/clusters/{cluster}/apprepositories").Handler(http.HandlerFunc(ListAppRepositories(backendHandler)))
 	r.Methods("GET").Path("/clusters/{cluster}/namespaces/{namespace}/apprepositories").Handler(http.HandlerFunc(ListAppRepositories(backendHandler)))
 	r.Methods("POST").Path("/clusters/{cluster}/namespaces/{namespace}/apprepositories").Handler(http.HandlerFunc(CreateAppRepository(backendHandler)))
@@ -28,6 +28,7 @@ import (
 	"github.com/google/go-cmp/cmp"
 	"github.com/gorilla/mux"
 	"github.com/kubeapps/kubeapps/pkg/kube"
+	authorizationapi "k8s.io/api/authorization/v1"
 	corev1 "k8s.io/api/core/v1"
 	k8sErrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -427,3 +428,164 @@ func TestCanI(t *testing.T) {
 		})
 	}
 }
+
+func TestGetPermissions(t *testing.T) {
+	installRules := []authorizationapi.ResourceRule{
+		{Verbs: []string{"create"}, APIGroups: []string{"apps"}, Resources: []string{"deployments"}},
+		{Verbs: []string{"create"}, APIGroups: []string{""}, Resources: []string{"services"}},
+	}
+	testCases := []struct {
+		name                 string
+		helmDriver           string
+		rules                []authorizationapi.ResourceRule
+		incomplete           bool
+		can                  bool
+		err                  error
+		expectedCode         int
+		expectedCapabilities map[string]bool
+	}{
+		{
+			name: "it returns the capabilities granted by the rules",
+			rules: []authorizationapi.ResourceRule{
+				{Verbs: []string{"get", "list"}, APIGroups: []string{""}, Resources: []string{"secrets"}},
+				{Verbs: []string{"*"}, APIGroups: []string{"kubeapps.com"}, Resources: []string{"apprepositories"}},
+			},
+			expectedCode: 200,
+			expectedCapabilities: map[string]bool{
+				"installReleases":       false,
+				"manageAppRepositories": true,
+				"readSecrets":           true,
+				"createNamespaces":      false,
+			},
+		},
+		{
+			name: "it returns every capability for wildcard rules",
+			rules: []authorizationapi.ResourceRule{
+				{Verbs: []string{"*"}, APIGroups: []string{"*"}, Resources: []string{"*"}},
+			},
+			can:          true,
+			expectedCode: 200,
+			expectedCapabilities: map[string]bool{
+				"installReleases":       true,
+				"manageAppRepositories": true,
+				"readSecrets":           true,
+				"createNamespaces":      true,
+			},
+		},
+		{
+			name: "it reviews the creation of namespaces outside of the namespace",
+			rules: []authorizationapi.ResourceRule{
+				{Verbs: []string{"*"}, APIGroups: []string{"*"}, Resources: []string{"*"}},
+			},
+			can:          false,
+			expectedCode: 200,
+			expectedCapabilities: map[string]bool{
+				"installReleases":       true,
+				"manageAppRepositories": true,
+				"readSecrets":           true,
+				"createNamespaces":      false,
+			},
+		},
+		{
+			name:         "it reviews the actions of the capabilities if the rules are incomplete",
+			incomplete:   true,
+			can:          true,
+			expectedCode: 200,
+			expectedCapabilities: map[string]bool{
+				"installReleases":       true,
+				"manageAppRepositories": true,
+				"readSecrets":           true,
+				"createNamespaces":      true,
+			},
+		},
+		{
+			name: "it ignores the rules restricted to resource names",
+			rules: []authorizationapi.ResourceRule{
+				{Verbs: []string{"get", "list"}, APIGroups: []string{""}, Resources: []string{"secrets"}, ResourceNames: []string{"my-secret"}},
+			},
+			expectedCode: 200,
+			expectedCapabilities: map[string]bool{
+				"installReleases":       false,
+				"manageAppRepositories": false,
+				"readSecrets":           false,
+				"createNamespaces":      false,
+			},
+		},
+		{
+			name:       "it requires the rules of configmaps to install releases with the configmap driver",
+			helmDriver: "configmap",
+			rules: append([]authorizationapi.ResourceRule{
+				{Verbs: []string{"*"}, APIGroups: []string{""}, Resources: []string{"configmaps"}},
+			}, installRules...),
+			expectedCode: 200,
+			expectedCapabilities: map[string]bool{
+				"installReleases":       true,
+				"manageAppRepositories": false,
+				"readSecrets":           false,
+				"createNamespaces":      false,
+			},
+		},
+		{
+			name:       "it requires the rules of secrets to install releases with the sql driver",
+			helmDriver: "sql",
+			rules: append([]authorizationapi.ResourceRule{
+				{Verbs: []string{"*"}, APIGroups: []string{""}, Resources: []string{"configmaps"}},
+			}, installRules...),
+			expectedCode: 200,
+			expectedCapabilities: map[string]bool{
+				"installReleases":       false,
+				"manageAppRepositories": false,
+				"readSecrets":           false,
+				"createNamespaces":      false,
+			},
+		},
+		{
+			name:         "it returns a json 500 error as a plain string for internal backend errors",
+			err:          fmt.Errorf("bang"),
+			expectedCode: 500,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			fakeHandler := &kube.FakeHandler{
+				RulesReview: authorizationapi.SubjectRulesReviewStatus{ResourceRules: tc.rules, Incomplete: tc.incomplete},
+				Can:         tc.can,
+				Err:         tc.err,
+			}
+			function := GetPermissions(fakeHandler, tc.helmDriver)
+			req := httptest.NewRequest("GET", "https://foo.bar/backend/v1/clusters/default/namespaces/kubeapps/permissions", nil)
+			req = mux.SetURLVars(req, map[string]string{"cluster": "default", "namespace": "kubeapps"})
+
+			response := httptest.NewRecorder()
+			function(response, req)
+
+			if got, want := response.Code, tc.expectedCode; got != want {
+				t.Fatalf("got: %d, want: %d\nBody: %s", got, want, response.Body)
+			}
+
+			if response.Code == 200 {
+				permissions := permissionsResponse{}
+				err := json.NewDecoder(response.Body).Decode(&permissions)
+				if err != nil {
+					t.Fatalf("%+v", err)
+				}
+				if got, want := permissions.Rules.ResourceRules, tc.rules; !cmp.Equal(want, got) {
+					t.Errorf("mismatch (-want +got):\n%s", cmp.Diff(want, got))
+				}
+				if got, want := permissions.Capabilities, tc.expectedCapabilities; !cmp.Equal(want, got) {
+					t.Errorf("mismatch (-want +got):\n%s", cmp.Diff(want, got))
+				}
+				for _, reviewed := range fakeHandler.Reviewed {
+					if reviewed.Resource == "namespaces" && reviewed.Namespace != "" {
+						t.Errorf("got: %q, want the creation of namespaces reviewed outside of the namespace", reviewed.Namespace)
+					}
+					if !tc.incomplete && reviewed.Resource != "namespaces" {
+						t.Errorf("got an unexpected review of %q with complete rules", reviewed.Resource)
+					}
+				}
+			} else {
+				checkError(t, response, tc.err)
+			}
+		})
+	}
+}
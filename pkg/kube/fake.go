@@ -35,6 +35,9 @@ type FakeHandler struct {
 	ValRes      *ValidationResponse
 	Err         error
 	Can         bool
+	RulesReview authorizationapi.SubjectRulesReviewStatus
+	// Reviewed records the actions reviewed with CanI.
+	Reviewed []authorizationapi.ResourceAttributes
 }
 
 // AsUser fakes user auth
@@ -114,5 +117,11 @@ func (c *FakeHandler) GetOperatorLogo(namespace, name string) ([]byte, error) {
 
 // CanI fake
 func (c *FakeHandler) CanI(resourceAttributes *authorizationapi.ResourceAttributes) (bool, error) {
+	c.Reviewed = append(c.Reviewed, *resourceAttributes)
 	return c.Can, c.Err
 }
+
+// GetRulesReview fake
+func (c *FakeHandler) GetRulesReview(namespace string) (*authorizationapi.SubjectRulesReviewStatus, error) {
+	return &c.RulesReview, c.Err
+}
@@ -18,13 +18,16 @@ package kube
 
 import (
 	"context"
+	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"net/http"
 	"net/url"
+	"os"
 	"path"
+	"path/filepath"
 	"strings"
 
 	"github.com/kubeapps/kubeapps/cmd/apprepository-controller/pkg/apis/apprepository/v1alpha1"
@@ -146,6 +149,58 @@ func NewClusterConfig(inClusterConfig *rest.Config, userToken string, cluster st
 	return config, nil
 }
 
+// ParseClusterConfig parses the configuration of the clusters, writing their
+// certificate authority data to files in a temporary directory of caFilesPrefix.
+// The returned function removes these files.
+func ParseClusterConfig(configPath, caFilesPrefix, pinnipedProxyURL string) (ClustersConfig, func(), error) {
+	caFilesDir, err := ioutil.TempDir(caFilesPrefix, "")
+	if err != nil {
+		return ClustersConfig{}, func() {}, err
+	}
+	deferFn := func() { os.RemoveAll(caFilesDir) }
+	content, err := ioutil.ReadFile(configPath)
+	if err != nil {
+		return ClustersConfig{}, deferFn, err
+	}
+
+	var clusterConfigs []ClusterConfig
+	if err = json.Unmarshal(content, &clusterConfigs); err != nil {
+		return ClustersConfig{}, deferFn, err
+	}
+
+	configs := ClustersConfig{Clusters: map[string]ClusterConfig{}}
+	configs.PinnipedProxyURL = pinnipedProxyURL
+	for _, c := range clusterConfigs {
+		if c.APIServiceURL == "" {
+			if configs.KubeappsClusterName == "" {
+				configs.KubeappsClusterName = c.Name
+			} else {
+				return ClustersConfig{}, nil, fmt.Errorf("only one cluster can be configured without an apiServiceURL, two defined: %q, %q", configs.KubeappsClusterName, c.Name)
+			}
+		}
+
+		// We need to decode the base64-encoded cadata from the input.
+		if c.CertificateAuthorityData != "" {
+			decodedCAData, err := base64.StdEncoding.DecodeString(c.CertificateAuthorityData)
+			if err != nil {
+				return ClustersConfig{}, deferFn, err
+			}
+			c.CertificateAuthorityDataDecoded = string(decodedCAData)
+
+			// We also need a CAFile field because Helm uses the genericclioptions.ConfigFlags
+			// struct which does not support CAData.
+			// https://github.com/kubernetes/cli-runtime/issues/8
+			c.CAFile = filepath.Join(caFilesDir, c.Name)
+			err = ioutil.WriteFile(c.CAFile, decodedCAData, 0644)
+			if err != nil {
+				return ClustersConfig{}, deferFn, err
+			}
+		}
+		configs.Clusters[c.Name] = c
+	}
+	return configs, deferFn, nil
+}
+
 // combinedClientsetInterface provides both the app repository clientset and the corev1 clientset.
 type combinedClientsetInterface interface {
 	KubeappsV1alpha1() v1alpha1typed.KubeappsV1alpha1Interface
@@ -228,6 +283,7 @@ type handler interface {
 	ValidateAppRepository(appRepoBody io.ReadCloser, requestNamespace string) (*ValidationResponse, error)
 	GetOperatorLogo(namespace, name string) ([]byte, error)
 	CanI(resourceAttributes *authorizationapi.ResourceAttributes) (bool, error)
+	GetRulesReview(namespace string) (*authorizationapi.SubjectRulesReviewStatus, error)
 }
 
 // AuthHandler exposes Handler functionality as a user or the current serviceaccount
@@ -853,3 +909,17 @@ func (a *userHandler) CanI(resourceAttributes *authorizationapi.ResourceAttribut
 
 	return res.Status.Allowed, nil
 }
+
+// GetRulesReview returns the rules of the actions that the user can do in the namespace
+func (a *userHandler) GetRulesReview(namespace string) (*authorizationapi.SubjectRulesReviewStatus, error) {
+	res, err := a.clientset.AuthorizationV1().SelfSubjectRulesReviews().Create(context.TODO(), &authorizationapi.SelfSubjectRulesReview{
+		Spec: authorizationapi.SelfSubjectRulesReviewSpec{
+			Namespace: namespace,
+		},
+	}, metav1.CreateOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	return &res.Status, nil
+}
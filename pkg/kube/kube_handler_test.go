@@ -1407,3 +1407,73 @@ func TestCanI(t *testing.T) {
 		})
 	}
 }
+
+func TestGetRulesReview(t *testing.T) {
+	testCases := []struct {
+		name   string
+		status authorizationv1.SubjectRulesReviewStatus
+		err    error
+	}{
+		{
+			name: "returns the rules of the user",
+			status: authorizationv1.SubjectRulesReviewStatus{
+				ResourceRules: []authorizationv1.ResourceRule{
+					{Verbs: []string{"get", "list"}, APIGroups: []string{""}, Resources: []string{"secrets"}},
+				},
+			},
+		},
+		{
+			name: "returns an error",
+			err:  fmt.Errorf("boom"),
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			userClientSet := fakeCombinedClientset{
+				fakeapprepoclientset.NewSimpleClientset(),
+				fakecoreclientset.NewSimpleClientset(),
+				&fakeRest.RESTClient{},
+			}
+
+			var requestedNamespace string
+			userClientSet.Clientset.Fake.PrependReactor(
+				"create",
+				"selfsubjectrulesreviews",
+				func(action k8stesting.Action) (handled bool, ret runtime.Object, err error) {
+					review := action.(k8stesting.CreateAction).GetObject().(*authorizationv1.SelfSubjectRulesReview)
+					requestedNamespace = review.Spec.Namespace
+					return true, &authorizationv1.SelfSubjectRulesReview{Status: tc.status}, tc.err
+				},
+			)
+
+			handler := kubeHandler{
+				clientsetForConfig: func(*rest.Config) (combinedClientsetInterface, error) { return userClientSet, nil },
+				kubeappsNamespace:  "kubeapps",
+				clustersConfig: ClustersConfig{
+					KubeappsClusterName: "default",
+					Clusters: map[string]ClusterConfig{
+						"default": {},
+					},
+				},
+			}
+
+			userHandler, err := handler.AsUser("token", "default")
+			if err != nil {
+				t.Fatalf("Unexpected error %v", err)
+			}
+			status, err := userHandler.GetRulesReview("test-namespace")
+			if got, want := err, tc.err; got != want {
+				t.Fatalf("got: %v, want: %v", got, want)
+			}
+			if tc.err != nil {
+				return
+			}
+			if got, want := requestedNamespace, "test-namespace"; got != want {
+				t.Errorf("got: %q, want: %q", got, want)
+			}
+			if got, want := *status, tc.status; !cmp.Equal(want, got) {
+				t.Errorf("mismatch (-want +got):\n%s", cmp.Diff(want, got))
+			}
+		})
+	}
+}
@@ -0,0 +1,49 @@
+package oidc
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/kubeapps/common/response"
+	log "github.com/sirupsen/logrus"
+	"github.com/urfave/negroni"
+)
+
+const tokenPrefix = "Bearer "
+
+// Middleware verifies the bearer token of the requests, if any, with the verifier.
+// Requests with an invalid or expired ID token of the issuer are rejected with a
+// 401 before they reach the API server. The identity of the user is added to the
+// context of the request and logged. Requests without token, or with a token which
+// is not an ID token of the issuer, such as a service account token, are left to
+// the API server.
+func Middleware(verifier *Verifier) negroni.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request, next http.HandlerFunc) {
+		authHeader := req.Header.Get("Authorization")
+		if !strings.HasPrefix(authHeader, tokenPrefix) {
+			next(w, req)
+			return
+		}
+		identity, err := verifier.Verify(req.Context(), strings.TrimPrefix(authHeader, tokenPrefix))
+		if err == ErrNotIssued {
+			next(w, req)
+			return
+		}
+		if err == ErrTokenExpired {
+			response.NewErrorResponse(http.StatusUnauthorized, "The token is expired, please log in again").Write(w)
+			return
+		}
+		if err != nil {
+			log.WithFields(log.Fields{"method": req.Method, "path": req.URL.Path}).Infof("Rejected an invalid token: %v", err)
+			response.NewErrorResponse(http.StatusUnauthorized, "Invalid token").Write(w)
+			return
+		}
+		log.WithFields(log.Fields{
+			"method":   req.Method,
+			"path":     req.URL.Path,
+			"username": identity.Username,
+			"groups":   identity.Groups,
+		}).Info("authenticated request")
+		next(w, req.WithContext(NewContext(req.Context(), identity)))
+	}
+}
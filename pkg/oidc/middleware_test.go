@@ -0,0 +1,84 @@
+package oidc
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestMiddleware(t *testing.T) {
+	issuer := newFakeIssuer(t)
+	defer issuer.server.Close()
+	verifier, err := NewVerifier(context.Background(), Config{IssuerURL: issuer.server.URL, Audience: "kubeapps"})
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	now := time.Now()
+	claims := func(expiry time.Time) map[string]interface{} {
+		return map[string]interface{}{"iss": issuer.server.URL, "aud": "kubeapps", "sub": "jane", "groups": []string{"developers"}, "exp": expiry.Unix()}
+	}
+
+	testCases := []struct {
+		name             string
+		authorization    string
+		expectedCode     int
+		expectedIdentity *Identity
+	}{
+		{
+			name:             "it adds the identity of valid tokens to the context",
+			authorization:    "Bearer " + issuer.sign(t, "RS256", "rsa-key", claims(now.Add(time.Hour))),
+			expectedCode:     http.StatusOK,
+			expectedIdentity: &Identity{Username: "jane", Groups: []string{"developers"}},
+		},
+		{
+			name:          "it rejects expired tokens",
+			authorization: "Bearer " + issuer.sign(t, "RS256", "rsa-key", claims(now.Add(-time.Hour))),
+			expectedCode:  http.StatusUnauthorized,
+		},
+		{
+			name:          "it rejects tokens of the issuer with an invalid signature",
+			authorization: "Bearer " + issuer.sign(t, "RS256", "unknown-key", claims(now.Add(time.Hour))),
+			expectedCode:  http.StatusUnauthorized,
+		},
+		{
+			name:          "it leaves tokens which are not JWTs to the API server",
+			authorization: "Bearer not-a-jwt",
+			expectedCode:  http.StatusOK,
+		},
+		{
+			name:          "it leaves the tokens of other issuers, such as service accounts, to the API server",
+			authorization: "Bearer " + issuer.sign(t, "RS256", "rsa-key", map[string]interface{}{"iss": "kubernetes/serviceaccount", "sub": "system:serviceaccount:kubeapps:default"}),
+			expectedCode:  http.StatusOK,
+		},
+		{
+			name:         "it leaves requests without token to the API server",
+			expectedCode: http.StatusOK,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "https://foo.bar/v1/clusters/default/releases", nil)
+			if tc.authorization != "" {
+				req.Header.Set("Authorization", tc.authorization)
+			}
+			response := httptest.NewRecorder()
+			var identity *Identity
+			Middleware(verifier)(response, req, func(w http.ResponseWriter, req *http.Request) {
+				if id, ok := FromContext(req.Context()); ok {
+					identity = &id
+				}
+			})
+
+			if got, want := response.Code, tc.expectedCode; got != want {
+				t.Errorf("got: %d, want: %d. Body: %s", got, want, response.Body)
+			}
+			if got, want := identity, tc.expectedIdentity; !reflect.DeepEqual(got, want) {
+				t.Errorf("got: %+v, want: %+v", got, want)
+			}
+		})
+	}
+}
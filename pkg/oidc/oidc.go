@@ -0,0 +1,163 @@
+// Package oidc verifies the OpenID Connect ID tokens used as bearer tokens, so
+// that the identity of users is known before requests reach the API server.
+package oidc
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	gooidc "github.com/coreos/go-oidc"
+)
+
+var (
+	// ErrTokenExpired is returned when verifying a token which expired.
+	ErrTokenExpired = errors.New("oidc: token is expired")
+	// ErrNotIssued is returned when verifying a token which is not a JWT of the
+	// issuer, such as the token of a service account, so that the API server is
+	// left to authenticate it.
+	ErrNotIssued = errors.New("oidc: the token is not issued by the issuer")
+)
+
+// Config configures the verification of the ID tokens of an issuer.
+type Config struct {
+	// IssuerURL is the URL of the issuer, whose configuration is discovered at
+	// IssuerURL/.well-known/openid-configuration.
+	IssuerURL string
+	// Audience is the client ID which the tokens should be issued for.
+	Audience string
+	// UsernameClaim and GroupsClaim are the claims of the username and groups of
+	// users, defaulting to sub and groups as with the API server.
+	UsernameClaim string
+	GroupsClaim   string
+	// ClockSkew is the tolerated difference between the clocks of the issuer and kubeops.
+	ClockSkew time.Duration
+	// HTTPClient is the client of the requests to the issuer, defaulting to http.DefaultClient.
+	HTTPClient *http.Client
+}
+
+// Identity is the identity of a user, as asserted by the issuer.
+type Identity struct {
+	Username string
+	Groups   []string
+}
+
+// Verifier verifies the ID tokens of an issuer. The keys of the issuer are cached
+// and refreshed by the go-oidc key set, which fetches them without blocking the
+// verification of tokens signed with the cached keys.
+type Verifier struct {
+	config   Config
+	verifier *gooidc.IDTokenVerifier
+	now      func() time.Time
+}
+
+// NewVerifier discovers the configuration of the issuer and returns a verifier of
+// its ID tokens.
+func NewVerifier(ctx context.Context, config Config) (*Verifier, error) {
+	if config.IssuerURL == "" || config.Audience == "" {
+		return nil, fmt.Errorf("oidc: an issuer URL and an audience are required")
+	}
+	if config.UsernameClaim == "" {
+		config.UsernameClaim = "sub"
+	}
+	if config.GroupsClaim == "" {
+		config.GroupsClaim = "groups"
+	}
+	if config.HTTPClient == nil {
+		config.HTTPClient = http.DefaultClient
+	}
+
+	// The provider uses the context, and so the client, for the discovery and the
+	// later requests of the keys of the issuer.
+	provider, err := gooidc.NewProvider(gooidc.ClientContext(ctx, config.HTTPClient), config.IssuerURL)
+	if err != nil {
+		return nil, fmt.Errorf("oidc: unable to discover the issuer: %v", err)
+	}
+	return &Verifier{
+		config: config,
+		// The validity period is checked by Verify, to tolerate the clock skew and
+		// to report expired tokens once their signature is verified.
+		verifier: provider.Verifier(&gooidc.Config{ClientID: config.Audience, SkipExpiryCheck: true}),
+		now:      time.Now,
+	}, nil
+}
+
+// Verify verifies the signature and claims of an ID token and returns the identity
+// of the user. ErrTokenExpired is returned for expired tokens and ErrNotIssued for
+// tokens which are not JWTs of the issuer.
+func (v *Verifier) Verify(ctx context.Context, rawToken string) (Identity, error) {
+	if issuer, ok := unverifiedIssuer(rawToken); !ok || issuer != v.config.IssuerURL {
+		return Identity{}, ErrNotIssued
+	}
+	token, err := v.verifier.Verify(ctx, rawToken)
+	if err != nil {
+		return Identity{}, err
+	}
+
+	var claims map[string]interface{}
+	if err := token.Claims(&claims); err != nil {
+		return Identity{}, fmt.Errorf("oidc: malformed token claims: %v", err)
+	}
+	now := v.now()
+	if now.After(token.Expiry.Add(v.config.ClockSkew)) {
+		return Identity{}, ErrTokenExpired
+	}
+	if notBefore, ok := claims["nbf"].(float64); ok && now.Add(v.config.ClockSkew).Before(time.Unix(int64(notBefore), 0)) {
+		return Identity{}, fmt.Errorf("oidc: token is not valid yet")
+	}
+
+	username, ok := claims[v.config.UsernameClaim].(string)
+	if !ok || username == "" {
+		return Identity{}, fmt.Errorf("oidc: the token has no %q claim", v.config.UsernameClaim)
+	}
+	identity := Identity{Username: username}
+	switch groups := claims[v.config.GroupsClaim].(type) {
+	case string:
+		identity.Groups = []string{groups}
+	case []interface{}:
+		for _, g := range groups {
+			if group, ok := g.(string); ok {
+				identity.Groups = append(identity.Groups, group)
+			}
+		}
+	}
+	return identity, nil
+}
+
+// unverifiedIssuer returns the issuer claim of a JWT, without verifying it. It
+// returns false if the token is not a JWT.
+func unverifiedIssuer(rawToken string) (string, bool) {
+	parts := strings.Split(rawToken, ".")
+	if len(parts) != 3 {
+		return "", false
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return "", false
+	}
+	var claims struct {
+		Issuer string `json:"iss"`
+	}
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return "", false
+	}
+	return claims.Issuer, true
+}
+
+type identityKey struct{}
+
+// NewContext returns a context carrying the identity of the user.
+func NewContext(ctx context.Context, identity Identity) context.Context {
+	return context.WithValue(ctx, identityKey{}, identity)
+}
+
+// FromContext returns the identity of the user of the context, if any.
+func FromContext(ctx context.Context) (Identity, bool) {
+	identity, ok := ctx.Value(identityKey{}).(Identity)
+	return identity, ok
+}
@@ -0,0 +1,318 @@
+package oidc
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeIssuer serves the discovery and keys of an issuer signing tokens with its keys.
+type fakeIssuer struct {
+	server      *httptest.Server
+	rsaKey      *rsa.PrivateKey
+	ecKey       *ecdsa.PrivateKey
+	keyRequests int
+}
+
+func newFakeIssuer(t *testing.T) *fakeIssuer {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	issuer := &fakeIssuer{rsaKey: rsaKey, ecKey: ecKey}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, req *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"issuer":                                issuer.server.URL,
+			"jwks_uri":                              issuer.server.URL + "/keys",
+			"id_token_signing_alg_values_supported": []string{"RS256", "ES256"},
+		})
+	})
+	mux.HandleFunc("/keys", func(w http.ResponseWriter, req *http.Request) {
+		issuer.keyRequests++
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"keys": []map[string]string{
+				{"kty": "RSA", "kid": "rsa-key", "use": "sig", "n": encodeBigInt(rsaKey.N), "e": encodeBigInt(big.NewInt(int64(rsaKey.E)))},
+				{"kty": "EC", "kid": "ec-key", "crv": "P-256", "x": encodeBigInt(ecKey.X), "y": encodeBigInt(ecKey.Y)},
+				{"kty": "oct", "kid": "symmetric-key", "k": "c2VjcmV0"},
+			},
+		})
+	})
+	issuer.server = httptest.NewServer(mux)
+	return issuer
+}
+
+func encodeBigInt(i *big.Int) string {
+	return base64.RawURLEncoding.EncodeToString(i.Bytes())
+}
+
+func (i *fakeIssuer) sign(t *testing.T, algorithm, keyID string, claims map[string]interface{}) string {
+	header, err := json.Marshal(map[string]string{"alg": algorithm, "kid": keyID, "typ": "JWT"})
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := crypto.SHA256.New()
+	digest.Write([]byte(signed))
+
+	var signature []byte
+	switch algorithm {
+	case "RS256":
+		signature, err = rsa.SignPKCS1v15(rand.Reader, i.rsaKey, crypto.SHA256, digest.Sum(nil))
+		if err != nil {
+			t.Fatalf("%+v", err)
+		}
+	case "ES256":
+		r, s, err := ecdsa.Sign(rand.Reader, i.ecKey, digest.Sum(nil))
+		if err != nil {
+			t.Fatalf("%+v", err)
+		}
+		signature = make([]byte, 64)
+		r.FillBytes(signature[:32])
+		s.FillBytes(signature[32:])
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func TestVerify(t *testing.T) {
+	issuer := newFakeIssuer(t)
+	defer issuer.server.Close()
+	now := time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	validClaims := func(changes map[string]interface{}) map[string]interface{} {
+		claims := map[string]interface{}{
+			"iss":    issuer.server.URL,
+			"aud":    "kubeapps",
+			"sub":    "1234",
+			"email":  "jane@example.com",
+			"groups": []string{"developers", "admins"},
+			"exp":    now.Add(time.Hour).Unix(),
+		}
+		for k, v := range changes {
+			if v == nil {
+				delete(claims, k)
+			} else {
+				claims[k] = v
+			}
+		}
+		return claims
+	}
+
+	testCases := []struct {
+		name             string
+		token            func() string
+		expectedIdentity Identity
+		expectedErr      error
+		expectErr        bool
+	}{
+		{
+			name:             "it returns the identity of an RS256 token",
+			token:            func() string { return issuer.sign(t, "RS256", "rsa-key", validClaims(nil)) },
+			expectedIdentity: Identity{Username: "jane@example.com", Groups: []string{"developers", "admins"}},
+		},
+		{
+			name:             "it returns the identity of an ES256 token",
+			token:            func() string { return issuer.sign(t, "ES256", "ec-key", validClaims(nil)) },
+			expectedIdentity: Identity{Username: "jane@example.com", Groups: []string{"developers", "admins"}},
+		},
+		{
+			name: "it accepts a single group and a list of audiences",
+			token: func() string {
+				return issuer.sign(t, "RS256", "rsa-key", validClaims(map[string]interface{}{"groups": "developers", "aud": []string{"other", "kubeapps"}}))
+			},
+			expectedIdentity: Identity{Username: "jane@example.com", Groups: []string{"developers"}},
+		},
+		{
+			name: "it tolerates the clock skew",
+			token: func() string {
+				return issuer.sign(t, "RS256", "rsa-key", validClaims(map[string]interface{}{"exp": now.Add(-time.Minute).Unix(), "nbf": now.Add(time.Minute).Unix()}))
+			},
+			expectedIdentity: Identity{Username: "jane@example.com", Groups: []string{"developers", "admins"}},
+		},
+		{
+			name: "it rejects expired tokens",
+			token: func() string {
+				return issuer.sign(t, "RS256", "rsa-key", validClaims(map[string]interface{}{"exp": now.Add(-time.Hour).Unix()}))
+			},
+			expectedErr: ErrTokenExpired,
+			expectErr:   true,
+		},
+		{
+			name: "it rejects tokens which are not valid yet",
+			token: func() string {
+				return issuer.sign(t, "RS256", "rsa-key", validClaims(map[string]interface{}{"nbf": now.Add(time.Hour).Unix()}))
+			},
+			expectErr: true,
+		},
+		{
+			name: "it rejects tokens of other audiences",
+			token: func() string {
+				return issuer.sign(t, "RS256", "rsa-key", validClaims(map[string]interface{}{"aud": "other"}))
+			},
+			expectErr: true,
+		},
+		{
+			name: "it does not verify tokens of other issuers",
+			token: func() string {
+				return issuer.sign(t, "RS256", "rsa-key", validClaims(map[string]interface{}{"iss": "https://other.example.com"}))
+			},
+			expectedErr: ErrNotIssued,
+			expectErr:   true,
+		},
+		{
+			name: "it rejects tokens without username",
+			token: func() string {
+				return issuer.sign(t, "RS256", "rsa-key", validClaims(map[string]interface{}{"email": nil}))
+			},
+			expectErr: true,
+		},
+		{
+			name: "it rejects tokens with an invalid signature",
+			token: func() string {
+				parts := strings.Split(issuer.sign(t, "RS256", "rsa-key", validClaims(nil)), ".")
+				tampered := issuer.sign(t, "RS256", "rsa-key", validClaims(map[string]interface{}{"email": "admin@example.com"}))
+				return strings.Join([]string{parts[0], strings.Split(tampered, ".")[1], parts[2]}, ".")
+			},
+			expectErr: true,
+		},
+		{
+			name:      "it rejects tokens signed with an unknown key",
+			token:     func() string { return issuer.sign(t, "RS256", "other-key", validClaims(nil)) },
+			expectErr: true,
+		},
+		{
+			name:      "it rejects tokens whose algorithm does not match the key",
+			token:     func() string { return issuer.sign(t, "RS256", "ec-key", validClaims(nil)) },
+			expectErr: true,
+		},
+		{
+			name: "it rejects unsigned tokens",
+			token: func() string {
+				parts := strings.Split(issuer.sign(t, "none", "rsa-key", validClaims(nil)), ".")
+				return parts[0] + "." + parts[1] + "."
+			},
+			expectErr: true,
+		},
+		{
+			name:        "it does not verify tokens which are not JWTs",
+			token:       func() string { return "not-a-jwt" },
+			expectedErr: ErrNotIssued,
+			expectErr:   true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			verifier, err := NewVerifier(context.Background(), Config{
+				IssuerURL:     issuer.server.URL,
+				Audience:      "kubeapps",
+				UsernameClaim: "email",
+				ClockSkew:     2 * time.Minute,
+			})
+			if err != nil {
+				t.Fatalf("%+v", err)
+			}
+			verifier.now = func() time.Time { return now }
+
+			identity, err := verifier.Verify(context.Background(), tc.token())
+			if got, want := err != nil, tc.expectErr; got != want {
+				t.Fatalf("got: %t, want: %t. err: %+v", got, want, err)
+			}
+			if tc.expectedErr != nil && err != tc.expectedErr {
+				t.Errorf("got: %v, want: %v", err, tc.expectedErr)
+			}
+			if got, want := identity, tc.expectedIdentity; !reflect.DeepEqual(got, want) {
+				t.Errorf("got: %+v, want: %+v", got, want)
+			}
+		})
+	}
+}
+
+func TestVerifierCachesKeys(t *testing.T) {
+	issuer := newFakeIssuer(t)
+	defer issuer.server.Close()
+	verifier, err := NewVerifier(context.Background(), Config{IssuerURL: issuer.server.URL, Audience: "kubeapps"})
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	claims := map[string]interface{}{"iss": issuer.server.URL, "aud": "kubeapps", "sub": "1234", "exp": time.Now().Add(time.Hour).Unix()}
+
+	for i := 0; i < 3; i++ {
+		if _, err := verifier.Verify(context.Background(), issuer.sign(t, "RS256", "rsa-key", claims)); err != nil {
+			t.Fatalf("%+v", err)
+		}
+	}
+	if got, want := issuer.keyRequests, 1; got != want {
+		t.Errorf("got: %d, want: %d", got, want)
+	}
+
+	// The keys are fetched again for a token signed with an unknown key, such as
+	// after a rotation of the keys.
+	if _, err := verifier.Verify(context.Background(), issuer.sign(t, "RS256", "rotated-key", claims)); err == nil {
+		t.Errorf("expected an error")
+	}
+	if got, want := issuer.keyRequests, 2; got != want {
+		t.Errorf("got: %d, want: %d", got, want)
+	}
+}
+
+func TestNewVerifier(t *testing.T) {
+	issuer := newFakeIssuer(t)
+	defer issuer.server.Close()
+
+	testCases := []struct {
+		name   string
+		config Config
+	}{
+		{
+			name:   "it errors without audience",
+			config: Config{IssuerURL: issuer.server.URL},
+		},
+		{
+			name:   "it errors if the discovered issuer does not match",
+			config: Config{IssuerURL: issuer.server.URL + "/", Audience: "kubeapps"},
+		},
+		{
+			name:   "it errors if the issuer cannot be discovered",
+			config: Config{IssuerURL: issuer.server.URL + "/other", Audience: "kubeapps"},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := NewVerifier(context.Background(), tc.config); err == nil {
+				t.Errorf("expected an error")
+			}
+		})
+	}
+}
+
+func TestContext(t *testing.T) {
+	if _, ok := FromContext(context.Background()); ok {
+		t.Errorf("expected no identity")
+	}
+	identity := Identity{Username: "jane@example.com", Groups: []string{"developers"}}
+	got, ok := FromContext(NewContext(context.Background(), identity))
+	if !ok || !reflect.DeepEqual(got, identity) {
+		t.Errorf("got: %+v, want: %+v", got, identity)
+	}
+}
@@ -20,6 +20,7 @@ import (
 	"fmt"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/pkg/errors"
 	log "github.com/sirupsen/logrus"
@@ -77,6 +78,16 @@ type AppOverview struct {
 	Status        string         `json:"status"`
 	Chart         string         `json:"chart"`
 	ChartMetadata chart.Metadata `json:"chartMetadata"`
+	// Updated is the time of the last deployment of the release.
+	Updated time.Time `json:"updated"`
+	// LatestVersion and LatestAppVersion are the ones of the chart in the app
+	// repository, if found.
+	LatestVersion    string `json:"latestVersion,omitempty"`
+	LatestAppVersion string `json:"latestAppVersion,omitempty"`
+	UpgradeAvailable bool   `json:"upgradeAvailable"`
+	// AppRepository and AppRepositoryNamespace identify the app repository of the chart, if found.
+	AppRepository          string `json:"appRepository,omitempty"`
+	AppRepositoryNamespace string `json:"appRepositoryNamespace,omitempty"`
 }
 
 func (p *Proxy) getRelease(name, namespace string) (*release.Release, error) {
@@ -85,16 +85,32 @@ func newFakeProxy(existingTillerReleases []AppOverview) *Proxy {
 }
 
 func TestListAllReleases(t *testing.T) {
-	app1 := AppOverview{"foo", "1.0.0", "my_ns", "icon.png", "DEPLOYED", "wordpress", chart.Metadata{
-		Version: "1.0.0",
-		Icon:    "icon.png",
-		Name:    "wordpress",
-	}}
-	app2 := AppOverview{"bar", "1.0.0", "other_ns", "icon2.png", "DELETED", "wordpress", chart.Metadata{
-		Version: "1.0.0",
-		Icon:    "icon2.png",
-		Name:    "wordpress",
-	}}
+	app1 := AppOverview{
+		ReleaseName: "foo",
+		Version:     "1.0.0",
+		Namespace:   "my_ns",
+		Icon:        "icon.png",
+		Status:      "DEPLOYED",
+		Chart:       "wordpress",
+		ChartMetadata: chart.Metadata{
+			Version: "1.0.0",
+			Icon:    "icon.png",
+			Name:    "wordpress",
+		},
+	}
+	app2 := AppOverview{
+		ReleaseName: "bar",
+		Version:     "1.0.0",
+		Namespace:   "other_ns",
+		Icon:        "icon2.png",
+		Status:      "DELETED",
+		Chart:       "wordpress",
+		ChartMetadata: chart.Metadata{
+			Version: "1.0.0",
+			Icon:    "icon2.png",
+			Name:    "wordpress",
+		},
+	}
 	proxy := newFakeProxy([]AppOverview{app1, app2})
 
 	// Should return all the releases if no namespace is given
@@ -113,16 +129,32 @@ func TestListAllReleases(t *testing.T) {
 }
 
 func TestListNamespacedRelease(t *testing.T) {
-	app1 := AppOverview{"foo", "1.0.0", "my_ns", "icon.png", "DEPLOYED", "wordpress", chart.Metadata{
-		Version: "1.0.0",
-		Icon:    "icon.png",
-		Name:    "wordpress",
-	}}
-	app2 := AppOverview{"bar", "1.0.0", "other_ns", "icon2.png", "DELETED", "wordpress", chart.Metadata{
-		Version: "1.0.0",
-		Icon:    "icon2.png",
-		Name:    "wordpress",
-	}}
+	app1 := AppOverview{
+		ReleaseName: "foo",
+		Version:     "1.0.0",
+		Namespace:   "my_ns",
+		Icon:        "icon.png",
+		Status:      "DEPLOYED",
+		Chart:       "wordpress",
+		ChartMetadata: chart.Metadata{
+			Version: "1.0.0",
+			Icon:    "icon.png",
+			Name:    "wordpress",
+		},
+	}
+	app2 := AppOverview{
+		ReleaseName: "bar",
+		Version:     "1.0.0",
+		Namespace:   "other_ns",
+		Icon:        "icon2.png",
+		Status:      "DELETED",
+		Chart:       "wordpress",
+		ChartMetadata: chart.Metadata{
+			Version: "1.0.0",
+			Icon:    "icon2.png",
+			Name:    "wordpress",
+		},
+	}
 	proxy := newFakeProxy([]AppOverview{app1, app2})
 
 	// Should return all the releases if no namespace is given
@@ -139,16 +171,32 @@ func TestListNamespacedRelease(t *testing.T) {
 }
 
 func TestListOldRelease(t *testing.T) {
-	app := AppOverview{"foo", "1.0.0", "my_ns", "icon.png", "DEPLOYED", "wordpress", chart.Metadata{
-		Version: "1.0.0",
-		Icon:    "icon.png",
-		Name:    "wordpress",
-	}}
-	appUpgraded := AppOverview{"foo", "1.0.1", "my_ns", "icon.png", "FAILED", "wordpress", chart.Metadata{
-		Version: "1.0.1",
-		Icon:    "icon.png",
-		Name:    "wordpress",
-	}}
+	app := AppOverview{
+		ReleaseName: "foo",
+		Version:     "1.0.0",
+		Namespace:   "my_ns",
+		Icon:        "icon.png",
+		Status:      "DEPLOYED",
+		Chart:       "wordpress",
+		ChartMetadata: chart.Metadata{
+			Version: "1.0.0",
+			Icon:    "icon.png",
+			Name:    "wordpress",
+		},
+	}
+	appUpgraded := AppOverview{
+		ReleaseName: "foo",
+		Version:     "1.0.1",
+		Namespace:   "my_ns",
+		Icon:        "icon.png",
+		Status:      "FAILED",
+		Chart:       "wordpress",
+		ChartMetadata: chart.Metadata{
+			Version: "1.0.1",
+			Icon:    "icon.png",
+			Name:    "wordpress",
+		},
+	}
 	proxy := newFakeProxy([]AppOverview{app, appUpgraded})
 
 	// Should avoid old release versions
@@ -168,31 +216,71 @@ func TestListOldRelease(t *testing.T) {
 }
 
 func TestMultipleOldReleases(t *testing.T) {
-	app := AppOverview{"foo", "1.0.0", "my_ns", "icon.png", "DEPLOYED", "wordpress", chart.Metadata{
-		Version: "1.0.0",
-		Icon:    "icon.png",
-		Name:    "wordpress",
-	}}
-	appUpgraded := AppOverview{"foo", "1.0.1", "my_ns", "icon.png", "FAILED", "wordpress", chart.Metadata{
-		Version: "1.0.1",
-		Icon:    "icon.png",
-		Name:    "wordpress",
-	}}
-	app2 := AppOverview{"bar", "1.0.0", "my_ns", "icon.png", "DEPLOYED", "wordpress", chart.Metadata{
-		Version: "1.0.0",
-		Icon:    "icon.png",
-		Name:    "wordpress",
-	}}
-	app2Outdated := AppOverview{"bar", "1.0.2", "my_ns", "icon.png", "DEPLOYED", "wordpress", chart.Metadata{
-		Version: "1.0.2",
-		Icon:    "icon.png",
-		Name:    "wordpress",
-	}}
-	app2Upgraded := AppOverview{"bar", "1.0.2", "my_ns", "icon.png", "FAILED", "wordpress", chart.Metadata{
-		Version: "1.0.2",
-		Icon:    "icon.png",
-		Name:    "wordpress",
-	}}
+	app := AppOverview{
+		ReleaseName: "foo",
+		Version:     "1.0.0",
+		Namespace:   "my_ns",
+		Icon:        "icon.png",
+		Status:      "DEPLOYED",
+		Chart:       "wordpress",
+		ChartMetadata: chart.Metadata{
+			Version: "1.0.0",
+			Icon:    "icon.png",
+			Name:    "wordpress",
+		},
+	}
+	appUpgraded := AppOverview{
+		ReleaseName: "foo",
+		Version:     "1.0.1",
+		Namespace:   "my_ns",
+		Icon:        "icon.png",
+		Status:      "FAILED",
+		Chart:       "wordpress",
+		ChartMetadata: chart.Metadata{
+			Version: "1.0.1",
+			Icon:    "icon.png",
+			Name:    "wordpress",
+		},
+	}
+	app2 := AppOverview{
+		ReleaseName: "bar",
+		Version:     "1.0.0",
+		Namespace:   "my_ns",
+		Icon:        "icon.png",
+		Status:      "DEPLOYED",
+		Chart:       "wordpress",
+		ChartMetadata: chart.Metadata{
+			Version: "1.0.0",
+			Icon:    "icon.png",
+			Name:    "wordpress",
+		},
+	}
+	app2Outdated := AppOverview{
+		ReleaseName: "bar",
+		Version:     "1.0.2",
+		Namespace:   "my_ns",
+		Icon:        "icon.png",
+		Status:      "DEPLOYED",
+		Chart:       "wordpress",
+		ChartMetadata: chart.Metadata{
+			Version: "1.0.2",
+			Icon:    "icon.png",
+			Name:    "wordpress",
+		},
+	}
+	app2Upgraded := AppOverview{
+		ReleaseName: "bar",
+		Version:     "1.0.2",
+		Namespace:   "my_ns",
+		Icon:        "icon.png",
+		Status:      "FAILED",
+		Chart:       "wordpress",
+		ChartMetadata: chart.Metadata{
+			Version: "1.0.2",
+			Icon:    "icon.png",
+			Name:    "wordpress",
+		},
+	}
 	proxy := newFakeProxy([]AppOverview{app, appUpgraded, app2, app2Outdated, app2Upgraded})
 
 	// Should avoid old release versions
@@ -257,16 +345,32 @@ func TestResolveManifest(t *testing.T) {
 }
 
 func TestResolveManifestFromRelease(t *testing.T) {
-	app1 := AppOverview{"foo", "1.0.0", "my_ns", "icon.png", "DEPLOYED", "wordpress", chart.Metadata{
-		Version: "1.0.0",
-		Icon:    "icon.png",
-		Name:    "wordpress",
-	}}
-	app2 := AppOverview{"bar", "1.0.0", "other_ns", "icon2.png", "DELETED", "wordpress", chart.Metadata{
-		Version: "1.0.0",
-		Icon:    "icon2.png",
-		Name:    "wordpress",
-	}}
+	app1 := AppOverview{
+		ReleaseName: "foo",
+		Version:     "1.0.0",
+		Namespace:   "my_ns",
+		Icon:        "icon.png",
+		Status:      "DEPLOYED",
+		Chart:       "wordpress",
+		ChartMetadata: chart.Metadata{
+			Version: "1.0.0",
+			Icon:    "icon.png",
+			Name:    "wordpress",
+		},
+	}
+	app2 := AppOverview{
+		ReleaseName: "bar",
+		Version:     "1.0.0",
+		Namespace:   "other_ns",
+		Icon:        "icon2.png",
+		Status:      "DELETED",
+		Chart:       "wordpress",
+		ChartMetadata: chart.Metadata{
+			Version: "1.0.0",
+			Icon:    "icon2.png",
+			Name:    "wordpress",
+		},
+	}
 	type testStruct struct {
 		description      string
 		existingApps     []AppOverviewTest
@@ -354,11 +458,19 @@ func TestCreateConflictingHelmRelease(t *testing.T) {
 		Metadata: &chart.Metadata{Name: chartName, Version: version},
 	}
 	ns2 := "other_ns"
-	app := AppOverview{rs, version, ns2, "icon.png", "DEPLOYED", "wordpress", chart.Metadata{
-		Version: "1.0.0",
-		Icon:    "icon.png",
-		Name:    "wordpress",
-	}}
+	app := AppOverview{
+		ReleaseName: rs,
+		Version:     version,
+		Namespace:   ns2,
+		Icon:        "icon.png",
+		Status:      "DEPLOYED",
+		Chart:       "wordpress",
+		ChartMetadata: chart.Metadata{
+			Version: "1.0.0",
+			Icon:    "icon.png",
+			Name:    "wordpress",
+		},
+	}
 	proxy := newFakeProxy([]AppOverview{app})
 
 	_, err := proxy.CreateRelease(rs, ns, "", ch)
@@ -378,11 +490,19 @@ func TestCreateExistingHelmRelease(t *testing.T) {
 	ch := &chart.Chart{
 		Metadata: &chart.Metadata{Name: chartName, Version: version},
 	}
-	app := AppOverview{rs, version, ns, "icon.png", "DEPLOYED", "wordpress", chart.Metadata{
-		Version: "1.0.0",
-		Icon:    "icon.png",
-		Name:    "wordpress",
-	}}
+	app := AppOverview{
+		ReleaseName: rs,
+		Version:     version,
+		Namespace:   ns,
+		Icon:        "icon.png",
+		Status:      "DEPLOYED",
+		Chart:       "wordpress",
+		ChartMetadata: chart.Metadata{
+			Version: "1.0.0",
+			Icon:    "icon.png",
+			Name:    "wordpress",
+		},
+	}
 	proxy := newFakeProxy([]AppOverview{app})
 
 	_, err := proxy.CreateRelease(rs, ns, "", ch)
@@ -402,11 +522,19 @@ func TestHelmReleaseUpdated(t *testing.T) {
 	ch := &chart.Chart{
 		Metadata: &chart.Metadata{Name: chartName, Version: version},
 	}
-	app := AppOverview{rs, version, ns, "icon.png", "DEPLOYED", "wordpress", chart.Metadata{
-		Version: "1.0.0",
-		Icon:    "icon.png",
-		Name:    "wordpress",
-	}}
+	app := AppOverview{
+		ReleaseName: rs,
+		Version:     version,
+		Namespace:   ns,
+		Icon:        "icon.png",
+		Status:      "DEPLOYED",
+		Chart:       "wordpress",
+		ChartMetadata: chart.Metadata{
+			Version: "1.0.0",
+			Icon:    "icon.png",
+			Name:    "wordpress",
+		},
+	}
 	proxy := newFakeProxy([]AppOverview{app})
 
 	result, err := proxy.UpdateRelease(rs, ns, "", ch)
@@ -441,11 +569,19 @@ func TestUpdateMissingHelmRelease(t *testing.T) {
 
 	ns2 := "other_ns"
 	rs2 := "not_foo"
-	app := AppOverview{rs2, version, ns2, "icon.png", "DEPLOYED", "wordpress", chart.Metadata{
-		Version: "1.0.0",
-		Icon:    "icon.png",
-		Name:    "wordpress",
-	}}
+	app := AppOverview{
+		ReleaseName: rs2,
+		Version:     version,
+		Namespace:   ns2,
+		Icon:        "icon.png",
+		Status:      "DEPLOYED",
+		Chart:       "wordpress",
+		ChartMetadata: chart.Metadata{
+			Version: "1.0.0",
+			Icon:    "icon.png",
+			Name:    "wordpress",
+		},
+	}
 	proxy := newFakeProxy([]AppOverview{app})
 
 	_, err := proxy.UpdateRelease(rs, ns, "", ch)
@@ -462,11 +598,19 @@ func TestRollbackRelease(t *testing.T) {
 	rs := "foo"
 	version := "v1.0.0"
 
-	app := AppOverview{rs, version, ns, "icon.png", "DEPLOYED", "wordpress", chart.Metadata{
-		Version: "1.0.0",
-		Icon:    "icon.png",
-		Name:    "wordpress",
-	}}
+	app := AppOverview{
+		ReleaseName: rs,
+		Version:     version,
+		Namespace:   ns,
+		Icon:        "icon.png",
+		Status:      "DEPLOYED",
+		Chart:       "wordpress",
+		ChartMetadata: chart.Metadata{
+			Version: "1.0.0",
+			Icon:    "icon.png",
+			Name:    "wordpress",
+		},
+	}
 	proxy := newFakeProxy([]AppOverview{app})
 
 	_, err := proxy.RollbackRelease(rs, ns, 1)
@@ -482,11 +626,19 @@ func TestRollbackMissingRelease(t *testing.T) {
 
 	ns2 := "other_ns"
 	rs2 := "not_foo"
-	app := AppOverview{rs2, version, ns2, "icon.png", "DEPLOYED", "wordpress", chart.Metadata{
-		Version: "1.0.0",
-		Icon:    "icon.png",
-		Name:    "wordpress",
-	}}
+	app := AppOverview{
+		ReleaseName: rs2,
+		Version:     version,
+		Namespace:   ns2,
+		Icon:        "icon.png",
+		Status:      "DEPLOYED",
+		Chart:       "wordpress",
+		ChartMetadata: chart.Metadata{
+			Version: "1.0.0",
+			Icon:    "icon.png",
+			Name:    "wordpress",
+		},
+	}
 	proxy := newFakeProxy([]AppOverview{app})
 
 	_, err := proxy.RollbackRelease(rs, ns, 1)
@@ -499,16 +651,32 @@ func TestRollbackMissingRelease(t *testing.T) {
 }
 
 func TestGetHelmRelease(t *testing.T) {
-	app1 := AppOverview{"foo", "1.0.0", "my_ns", "icon.png", "DEPLOYED", "wordpress", chart.Metadata{
-		Version: "1.0.0",
-		Icon:    "icon.png",
-		Name:    "wordpress",
-	}}
-	app2 := AppOverview{"bar", "1.0.0", "other_ns", "icon2.png", "DELETED", "wordpress", chart.Metadata{
-		Version: "1.0.0",
-		Icon:    "icon2.png",
-		Name:    "wordpress",
-	}}
+	app1 := AppOverview{
+		ReleaseName: "foo",
+		Version:     "1.0.0",
+		Namespace:   "my_ns",
+		Icon:        "icon.png",
+		Status:      "DEPLOYED",
+		Chart:       "wordpress",
+		ChartMetadata: chart.Metadata{
+			Version: "1.0.0",
+			Icon:    "icon.png",
+			Name:    "wordpress",
+		},
+	}
+	app2 := AppOverview{
+		ReleaseName: "bar",
+		Version:     "1.0.0",
+		Namespace:   "other_ns",
+		Icon:        "icon2.png",
+		Status:      "DELETED",
+		Chart:       "wordpress",
+		ChartMetadata: chart.Metadata{
+			Version: "1.0.0",
+			Icon:    "icon2.png",
+			Name:    "wordpress",
+		},
+	}
 	type testStruct struct {
 		existingApps    []AppOverview
 		shouldFail      bool
@@ -540,11 +708,19 @@ func TestGetHelmRelease(t *testing.T) {
 }
 
 func TestHelmReleaseDeleted(t *testing.T) {
-	app := AppOverview{"foo", "1.0.0", "my_ns", "icon.png", "DEPLOYED", "wordpress", chart.Metadata{
-		Version: "1.0.0",
-		Icon:    "icon.png",
-		Name:    "wordpress",
-	}}
+	app := AppOverview{
+		ReleaseName: "foo",
+		Version:     "1.0.0",
+		Namespace:   "my_ns",
+		Icon:        "icon.png",
+		Status:      "DEPLOYED",
+		Chart:       "wordpress",
+		ChartMetadata: chart.Metadata{
+			Version: "1.0.0",
+			Icon:    "icon.png",
+			Name:    "wordpress",
+		},
+	}
 	proxy := newFakeProxy([]AppOverview{app})
 
 	// TODO: Add a test for a non-purged release when the fake helm cli supports it
@@ -581,11 +757,19 @@ func TestTestRelease(t *testing.T) {
 	scenarios[ScenarioParameter{"foo", "other_ns"}] = ScenarioResult{nil, errors.New("Unable to locate release: Release \"foo\" not found in namespace \"other_ns\"")}
 	scenarios[ScenarioParameter{"bar", "my_ns"}] = ScenarioResult{nil, errors.New("Unable to locate release: release: \"bar\" not found")}
 
-	app := AppOverview{"foo", "1.0.0", "my_ns", "icon.png", "DEPLOYED", "wordpress", chart.Metadata{
-		Version: "1.0.0",
-		Icon:    "icon.png",
-		Name:    "wordpress",
-	}}
+	app := AppOverview{
+		ReleaseName: "foo",
+		Version:     "1.0.0",
+		Namespace:   "my_ns",
+		Icon:        "icon.png",
+		Status:      "DEPLOYED",
+		Chart:       "wordpress",
+		ChartMetadata: chart.Metadata{
+			Version: "1.0.0",
+			Icon:    "icon.png",
+			Name:    "wordpress",
+		},
+	}
 
 	// instantiating a fake proxy
 	proxy := newFakeProxy([]AppOverview{app})
@@ -609,11 +793,19 @@ func TestTestRelease(t *testing.T) {
 }
 
 func TestDeleteMissingHelmRelease(t *testing.T) {
-	app := AppOverview{"foo", "1.0.0", "my_ns", "icon.png", "DEPLOYED", "wordpress", chart.Metadata{
-		Version: "1.0.0",
-		Icon:    "icon.png",
-		Name:    "wordpress",
-	}}
+	app := AppOverview{
+		ReleaseName: "foo",
+		Version:     "1.0.0",
+		Namespace:   "my_ns",
+		Icon:        "icon.png",
+		Status:      "DEPLOYED",
+		Chart:       "wordpress",
+		ChartMetadata: chart.Metadata{
+			Version: "1.0.0",
+			Icon:    "icon.png",
+			Name:    "wordpress",
+		},
+	}
 	proxy := newFakeProxy([]AppOverview{app})
 
 	err := proxy.DeleteRelease("not_foo", "other_ns", true)